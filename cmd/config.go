@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"auth/internal/config"
+	"auth/internal/email/templates"
+	"auth/internal/lib/tokens"
+)
+
+const configUsage = `usage: auth config <command>
+
+commands:
+  check [PATH]      validate the config file, CONFIG_PATH by default, along with
+                    the environment, and list the values not set by the file`
+
+// runConfig runs the config subcommand and returns the exit code.
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "check" || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, configUsage)
+		return 2
+	}
+
+	configPath := os.Getenv("CONFIG_PATH")
+	if len(args) == 2 {
+		configPath = args[1]
+	}
+
+	if configPath == "" {
+		fmt.Fprintln(os.Stderr, "CONFIG_PATH is not set")
+		return 2
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// The files the config points to are loaded the way the server does.
+	code := 0
+
+	if _, err := tokens.LoadKeySet(cfg.JWT); err != nil {
+		fmt.Fprintf(os.Stderr, "jwt: %s\n", err)
+		code = 1
+	}
+
+	if _, err := templates.New(cfg.Email.TemplatesDir, cfg.Email.DefaultLocale); err != nil {
+		fmt.Fprintf(os.Stderr, "email.templates_dir: %s\n", err)
+		code = 1
+	}
+
+	if code != 0 {
+		return code
+	}
+
+	fmt.Printf("%s is valid\n", configPath)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\nKEY\tSOURCE\tVARIABLE")
+
+	for _, origin := range cfg.Origins() {
+		if origin.Source != config.SourceFile {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", origin.Key, origin.Source, origin.Env)
+		}
+	}
+
+	w.Flush()
+
+	return 0
+}
@@ -3,29 +3,39 @@ package main
 import (
 	"context"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
 	"syscall"
 
-	"github.com/go-chi/chi"
-	"github.com/go-chi/chi/middleware"
-
+	"auth/internal/app"
 	"auth/internal/config"
-	"auth/internal/database/postgresql"
-	"auth/internal/email/mockmail"
-	"auth/internal/http/handlers/get"
-	"auth/internal/http/handlers/refresh"
+	"auth/internal/http/handlers/devmail"
 	"auth/internal/lib/logger/sl"
+	"auth/internal/lib/tokens"
 )
 
 const (
-	envDev  = "Development"
-	envProd = "Production"
+	envDev  = config.EnvDevelopment
+	envProd = config.EnvProduction
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			os.Exit(runMigrate(os.Args[2:]))
+		case "outbox":
+			os.Exit(runOutbox(os.Args[2:]))
+		case "config":
+			os.Exit(runConfig(os.Args[2:]))
+		case "password":
+			os.Exit(runPassword(os.Args[2:]))
+		}
+	}
+
 	configPath := os.Getenv("CONFIG_PATH")
 	cfg := config.MustLoad(configPath)
 
@@ -35,33 +45,80 @@ func main() {
 	log.Info("Starting server", slog.String("Address",
 		cfg.Server.Host+":"+strconv.Itoa(cfg.Server.Port)))
 	log.Debug("Logger debug mode enabled")
+	logConfigOrigins(log, cfg)
 
-	database, err := postgresql.New(cfg.Database)
+	storage, err := app.NewStorage(cfg.Database)
 	if err != nil {
 		log.Error("Failed to initialize database", sl.Err(err))
+		os.Exit(1)
+	}
+	defer storage.Close()
+
+	if cfg.JWT.RefreshTokenPepper == "" {
+		log.Warn("Refresh token pepper is not set, stored refresh tokens are hashed without a secret")
+	}
+
+	if cfg.Authentication.Insecure {
+		log.Warn("Insecure authentication is enabled, tokens are issued for any GUID without credentials")
+	}
+
+	if cfg.Email.LinkSecret == "" || cfg.Server.PublicURL == "" {
+		log.Warn("Public URL or link secret is not set, emails are sent without links")
+	}
+
+	mailer, err := app.NewMailer(log, cfg.Email)
+	if err != nil {
+		log.Error("Failed to initialize mailer", sl.Err(err))
+		os.Exit(1)
 	}
+	defer mailer.Close()
 
-	mailer := mockmail.New(cfg.Email, log)
+	jobs, err := app.NewScheduler(log, cfg, storage, mailer)
+	if err != nil {
+		log.Error("Failed to initialize background jobs", sl.Err(err))
+		os.Exit(1)
+	}
+	jobs.Start()
+	defer jobs.Stop()
 
-	router := chi.NewRouter()
+	keys, err := tokens.LoadKeySet(cfg.JWT)
+	if err != nil {
+		log.Error("Failed to load signing keys", sl.Err(err))
+		os.Exit(1)
+	}
+	logKeys(log, keys)
 
-	router.Use(middleware.RequestID)
-	router.Use(middleware.Logger)
-	router.Use(middleware.Recoverer)
-	router.Use(middleware.URLFormat)
+	go reloadKeysOnHangup(log, configPath, keys)
 
-	router.Get("/{user_guid}", get.New(log, database, cfg.JWT))
-	router.Post("/", refresh.New(log, database, mailer, cfg.JWT))
+	// The captured emails are only served in development, they hold links
+	// revoking the sessions of users.
+	var inbox devmail.Inbox
+	if cfg.Env == envDev {
+		inbox, _ = mailer.(devmail.Inbox)
+	}
+
+	router, err := app.NewRouter(log, cfg, storage, keys, inbox)
+	if err != nil {
+		log.Error("Failed to initialize router", sl.Err(err))
+		os.Exit(1)
+	}
 
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
+	// Requests still running when the shutdown times out are canceled.
+	requestsCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
 	srv := &http.Server{
 		Addr:         cfg.Server.Host + ":" + strconv.Itoa(cfg.Server.Port),
 		Handler:      router,
 		ReadTimeout:  cfg.HTTP.Timeout,
 		WriteTimeout: cfg.HTTP.Timeout,
 		IdleTimeout:  cfg.HTTP.IdleTimeout,
+		BaseContext: func(net.Listener) context.Context {
+			return requestsCtx
+		},
 	}
 
 	go func() {
@@ -80,6 +137,7 @@ func main() {
 
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Error("Failed to stop server", sl.Err(err))
+		cancelRequests()
 		return
 	}
 
@@ -100,3 +158,58 @@ func setupLogger(env string) *slog.Logger {
 
 	return log
 }
+
+// logConfigOrigins logs the config values not set by the config file, the
+// values themselves are left out as they may be secrets.
+func logConfigOrigins(log *slog.Logger, cfg *config.Config) {
+	for _, origin := range cfg.Origins() {
+		if origin.Source == config.SourceFile {
+			continue
+		}
+
+		log.Debug("Config value is not set by the file", slog.String("key", origin.Key),
+			slog.String("source", origin.Source), slog.String("variable", origin.Env))
+	}
+}
+
+// reloadKeysOnHangup re-reads the signing keys from the config file on SIGHUP,
+// so keys can be rotated without a restart.
+func reloadKeysOnHangup(log *slog.Logger, configPath string, keys *tokens.KeySet) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	for range hangup {
+		log.Info("Reloading signing keys")
+
+		cfg, err := config.Load(configPath)
+		if err != nil {
+			log.Error("Failed to reload config", sl.Err(err))
+			continue
+		}
+
+		if err := keys.Reload(cfg.JWT); err != nil {
+			log.Error("Failed to reload signing keys", sl.Err(err))
+			continue
+		}
+
+		logKeys(log, keys)
+	}
+}
+
+func logKeys(log *slog.Logger, keys *tokens.KeySet) {
+	for _, key := range keys.Status() {
+		attrs := []any{
+			slog.String("kid", key.ID),
+			slog.String("alg", key.Algorithm),
+			slog.String("state", key.State),
+		}
+		if !key.ActiveFrom.IsZero() {
+			attrs = append(attrs, slog.Time("active_from", key.ActiveFrom))
+		}
+		if !key.RetireAt.IsZero() {
+			attrs = append(attrs, slog.Time("retire_at", key.RetireAt))
+		}
+
+		log.Info("Signing key", attrs...)
+	}
+}
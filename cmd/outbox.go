@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"auth/internal/app"
+	"auth/internal/config"
+	"auth/internal/database"
+)
+
+const outboxUsage = `usage: auth outbox <command>
+
+commands:
+  list [STATUS]     list the latest emails, optionally only pending, sent or dead ones
+  replay ID...      queue dead emails again
+  replay all        queue all dead emails again`
+
+const outboxListLimit = 100
+
+// runOutbox runs the outbox subcommand and returns the exit code.
+func runOutbox(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, outboxUsage)
+		return 2
+	}
+
+	var ids []int64
+	replayAll := false
+
+	switch args[0] {
+	case "list":
+		if len(args) > 2 {
+			fmt.Fprintln(os.Stderr, outboxUsage)
+			return 2
+		}
+
+		if len(args) == 2 {
+			switch args[1] {
+			case database.EmailPending, database.EmailSent, database.EmailDead:
+			default:
+				fmt.Fprintf(os.Stderr, "Invalid status: %s\n", args[1])
+				return 2
+			}
+		}
+	case "replay":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, outboxUsage)
+			return 2
+		}
+
+		if len(args) == 2 && args[1] == "all" {
+			replayAll = true
+			break
+		}
+
+		for _, arg := range args[1:] {
+			id, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil || id < 1 {
+				fmt.Fprintf(os.Stderr, "Invalid email ID: %s\n", arg)
+				return 2
+			}
+			ids = append(ids, id)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, outboxUsage)
+		return 2
+	}
+
+	cfg := config.MustLoad(os.Getenv("CONFIG_PATH"))
+
+	if cfg.Database.Driver == app.DriverMemory {
+		fmt.Fprintln(os.Stderr, "The memory driver keeps the outbox inside the running service")
+		return 1
+	}
+
+	storage, err := app.NewStorage(cfg.Database)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer storage.Close()
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "list":
+		status := ""
+		if len(args) == 2 {
+			status = args[1]
+		}
+
+		emails, err := storage.ListEmails(ctx, status, outboxListLimit)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tKIND\tTO\tSTATUS\tATTEMPTS\tCREATED AT\tNEXT ATTEMPT AT\tLAST ERROR")
+
+		for _, email := range emails {
+			nextAttemptAt := "-"
+			if email.Status == database.EmailPending {
+				nextAttemptAt = email.NextAttemptAt.Format(time.RFC3339)
+			}
+
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n", email.ID, email.Kind, email.To, email.Status,
+				email.Attempts, email.CreatedAt.Format(time.RFC3339), nextAttemptAt, email.LastError)
+		}
+
+		w.Flush()
+	case "replay":
+		if replayAll {
+			dead, err := storage.ListEmails(ctx, database.EmailDead, math.MaxInt32)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+
+			for _, email := range dead {
+				ids = append(ids, email.ID)
+			}
+
+			if len(ids) == 0 {
+				fmt.Println("No dead emails to replay")
+			}
+		}
+
+		code := 0
+
+		for _, id := range ids {
+			err := storage.ReplayEmail(ctx, id)
+			if errors.Is(err, database.ErrEmailNotFound) {
+				fmt.Fprintf(os.Stderr, "Email %d is not dead\n", id)
+				code = 1
+				continue
+			}
+
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+
+			fmt.Printf("Queued %d\n", id)
+		}
+
+		return code
+	}
+
+	return 0
+}
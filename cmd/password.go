@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"auth/internal/lib/password"
+)
+
+const passwordUsage = `usage: auth password <command>
+
+commands:
+  hash              read a password from stdin and print its Argon2id hash,
+                    to be stored in users.password_hash`
+
+// runPassword runs the password subcommand and returns the exit code.
+func runPassword(args []string) int {
+	if len(args) != 1 || args[0] != "hash" {
+		fmt.Fprintln(os.Stderr, passwordUsage)
+		return 2
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		fmt.Fprintf(os.Stderr, "Failed to read password: %s\n", err)
+		return 1
+	}
+
+	secret := strings.TrimRight(line, "\r\n")
+	if secret == "" {
+		fmt.Fprintln(os.Stderr, "Password is empty")
+		return 1
+	}
+
+	hash, err := password.Hash(secret, password.DefaultParams)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Println(hash)
+
+	return 0
+}
@@ -0,0 +1,315 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"expvar"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+
+	"auth/internal/config"
+	"auth/internal/database"
+	"auth/internal/database/memory"
+	"auth/internal/database/migrate"
+	"auth/internal/database/postgresql"
+	"auth/internal/database/sqlite"
+	"auth/internal/email/gomail"
+	"auth/internal/email/mockmail"
+	"auth/internal/email/outbox"
+	"auth/internal/email/templates"
+	"auth/internal/http/handlers/devmail"
+	"auth/internal/http/handlers/get"
+	"auth/internal/http/handlers/introspect"
+	"auth/internal/http/handlers/jwks"
+	"auth/internal/http/handlers/logout"
+	"auth/internal/http/handlers/refresh"
+	"auth/internal/http/handlers/revoke"
+	"auth/internal/http/handlers/revokelink"
+	"auth/internal/http/handlers/sessions"
+	"auth/internal/http/handlers/users"
+	"auth/internal/http/handlers/verifylink"
+	"auth/internal/http/middleware/bearer"
+	"auth/internal/http/middleware/clientauth"
+	"auth/internal/http/middleware/realip"
+	"auth/internal/http/middleware/throttle"
+	"auth/internal/lib/authn"
+	"auth/internal/lib/clientip"
+	"auth/internal/lib/ipbinding"
+	"auth/internal/lib/password"
+	"auth/internal/lib/ratelimit"
+	"auth/internal/lib/tokens"
+	"auth/internal/scheduler"
+)
+
+const (
+	DriverPostgres = "postgres"
+	DriverMemory   = "memory"
+	DriverSQLite   = "sqlite"
+
+	MailerSMTP = "smtp"
+	MailerMock = "mock"
+
+	RateLimitMemory   = "memory"
+	RateLimitPostgres = "postgres"
+
+	// DevMailPrefix is the path the inbox of the development mailer is
+	// served at.
+	DevMailPrefix = "/dev/mail"
+)
+
+// Storage is implemented by every database driver and covers the needs
+// of all handlers.
+type Storage interface {
+	get.RefreshTokenStorage
+	get.UserDirectory
+	authn.UserDirectory
+	refresh.RefreshTokenStorage
+	refresh.UserDirectory
+	revoke.RefreshTokenStorage
+	logout.RefreshTokenStorage
+	introspect.RefreshTokenStorage
+	introspect.UserDirectory
+	sessions.SessionStorage
+	users.UserStorage
+	verifylink.UserStorage
+	scheduler.RefreshTokenPurger
+	outbox.EmailStorage
+
+	// ListEmails and ReplayEmail serve the outbox command.
+	ListEmails(ctx context.Context, status string, limit int) ([]database.Email, error)
+	ReplayEmail(ctx context.Context, id int64) error
+
+	Close() error
+}
+
+func NewStorage(configDb config.Database) (Storage, error) {
+	const op = "app.NewStorage"
+
+	switch configDb.Driver {
+	case DriverPostgres, "":
+		database, err := postgresql.New(configDb)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		return database, nil
+	case DriverMemory:
+		return memory.New(configDb), nil
+	case DriverSQLite:
+		database, err := sqlite.New(configDb)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		return database, nil
+	default:
+		return nil, fmt.Errorf("%s: Unknown database driver: %q", op, configDb.Driver)
+	}
+}
+
+// NewMigrator returns the schema migrator of the configured driver along
+// with the connection it uses.
+func NewMigrator(configDb config.Database) (*migrate.Migrator, io.Closer, error) {
+	const op = "app.NewMigrator"
+
+	var open func(config.Database) (*sql.DB, error)
+	var newMigrator func(*sql.DB) (*migrate.Migrator, error)
+
+	switch configDb.Driver {
+	case DriverPostgres, "":
+		open, newMigrator = postgresql.Open, postgresql.NewMigrator
+	case DriverSQLite:
+		open, newMigrator = sqlite.Open, sqlite.NewMigrator
+	case DriverMemory:
+		return nil, nil, fmt.Errorf("%s: The memory driver has no schema", op)
+	default:
+		return nil, nil, fmt.Errorf("%s: Unknown database driver: %q", op, configDb.Driver)
+	}
+
+	db, err := open(configDb)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	migrator, err := newMigrator(db)
+	if err != nil {
+		db.Close()
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return migrator, db, nil
+}
+
+// Mailer is implemented by every mailer backend.
+type Mailer interface {
+	outbox.EmailSender
+	io.Closer
+}
+
+func NewMailer(log *slog.Logger, configEmail config.Email) (Mailer, error) {
+	const op = "app.NewMailer"
+
+	switch configEmail.Backend {
+	case MailerSMTP:
+		mailer, err := gomail.New(configEmail)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		return mailer, nil
+	case MailerMock:
+		return mockmail.New(configEmail, log), nil
+	default:
+		return nil, fmt.Errorf("%s: Unknown mailer backend: %q", op, configEmail.Backend)
+	}
+}
+
+// NewLimiter returns the rate limiter of the token endpoints, nil if rate
+// limiting is disabled.
+func NewLimiter(cfg config.RateLimit, storage Storage) (*ratelimit.Limiter, error) {
+	const op = "app.NewLimiter"
+
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	switch cfg.Store {
+	case RateLimitMemory, "":
+		return ratelimit.New(ratelimit.NewMemoryStore(), cfg), nil
+	case RateLimitPostgres:
+		store, ok := storage.(ratelimit.Store)
+		if !ok {
+			return nil, fmt.Errorf("%s: The database driver can not store rate limits", op)
+		}
+
+		return ratelimit.New(store, cfg), nil
+	default:
+		return nil, fmt.Errorf("%s: Unknown rate limit store: %q", op, cfg.Store)
+	}
+}
+
+// NewScheduler returns the scheduler of background jobs. Storages shared by
+// several replicas lock every run, so a job runs on one replica at a time.
+func NewScheduler(log *slog.Logger, cfg *config.Config, storage Storage,
+	mailer outbox.EmailSender) (*scheduler.Scheduler, error) {
+	const op = "app.NewScheduler"
+
+	renderer, err := templates.New(cfg.Email.TemplatesDir, cfg.Email.DefaultLocale)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var locker scheduler.Locker
+	if l, ok := storage.(scheduler.Locker); ok {
+		locker = l
+	}
+
+	s := scheduler.New(log, locker)
+	s.Add(scheduler.NewPurgeJob(log, storage, cfg.Scheduler.Purge))
+	s.Add(outbox.NewDispatchJob(log, storage, mailer, renderer, outbox.NewLinks(cfg), cfg.Email.Outbox))
+
+	// The memory store sweeps itself, the one of every replica.
+	if store, ok := storage.(ratelimit.Store); ok && cfg.RateLimit.Enabled && cfg.RateLimit.Store == RateLimitPostgres {
+		s.Add(ratelimit.NewPurgeJob(log, store, cfg.RateLimit.PurgeInterval))
+	}
+
+	return s, nil
+}
+
+// NewRouter returns the router of the service. A non-nil inbox serves the
+// development mailer under /dev/mail, it must only be passed in development.
+func NewRouter(log *slog.Logger, cfg *config.Config, storage Storage, keys *tokens.KeySet,
+	inbox devmail.Inbox) (http.Handler, error) {
+	const op = "app.NewRouter"
+
+	resolver, err := clientip.NewResolver(cfg.HTTP.TrustedProxies, cfg.HTTP.ClientIPHeader)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	hasher := password.NewHasher(password.NewParams(cfg.Accounts.PasswordHashing),
+		cfg.Accounts.PasswordHashing.MaxConcurrent)
+
+	authenticator, err := authn.New(log, cfg.Authentication, hasher, storage)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	policies := ipbinding.New(cfg.IPBinding)
+
+	limiter, err := NewLimiter(cfg.RateLimit, storage)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	byIP := throttle.New(log, limiter, ratelimit.KindIP, throttle.ClientIP)
+
+	router := chi.NewRouter()
+
+	router.Use(middleware.RequestID)
+	router.Use(realip.New(log, resolver))
+	router.Use(middleware.Logger)
+	router.Use(middleware.Recoverer)
+	router.Use(middleware.URLFormat)
+
+	// Requests outliving the write timeout can not be answered anyway,
+	// so their context is canceled to stop the queries they wait for.
+	if cfg.HTTP.Timeout > 0 {
+		router.Use(middleware.Timeout(cfg.HTTP.Timeout))
+	}
+
+	if cfg.HTTP.DebugVars {
+		router.Get("/debug/vars", expvar.Handler().ServeHTTP)
+	}
+
+	if inbox != nil {
+		router.Route(DevMailPrefix, func(r chi.Router) {
+			r.Get("/", devmail.NewIndex(log, inbox, DevMailPrefix))
+			r.Get("/{id}", devmail.NewView(log, inbox, DevMailPrefix))
+			r.Post("/clear", devmail.NewClearForm(log, inbox, DevMailPrefix))
+			r.Get("/api/messages", devmail.NewList(log, inbox))
+			r.Get("/api/messages/{id}", devmail.NewGet(log, inbox))
+			r.Delete("/api/messages", devmail.NewClear(log, inbox))
+		})
+	}
+
+	// URLFormat strips the extension, so this serves /.well-known/jwks.json
+	router.Get("/.well-known/jwks", jwks.New(log, keys))
+	router.With(byIP).Get("/{user_guid}", get.New(log, authenticator, storage, storage, keys, policies, limiter,
+		cfg.JWT))
+	router.With(byIP).Post("/", refresh.New(log, storage, storage, keys, policies, limiter, cfg.JWT))
+	router.Post("/revoke", revoke.New(log, storage, keys, cfg.JWT))
+	router.With(bearer.New(log, keys)).Post("/revoke/all", logout.New(log, storage))
+	if cfg.Email.LinkSecret != "" {
+		router.Get("/revoke/link", revokelink.NewForm(log, cfg.Email.LinkSecret))
+		router.Post("/revoke/link", revokelink.NewConfirm(log, storage, cfg.Email.LinkSecret))
+	}
+	router.With(clientauth.New(log, "introspection", cfg.Introspection.Clients)).
+		Post("/introspect", introspect.New(log, storage, storage, keys))
+	router.Route("/sessions", func(r chi.Router) {
+		r.Use(bearer.New(log, keys))
+		r.Get("/", sessions.NewList(log, storage))
+		r.Delete("/{id}", sessions.NewDelete(log, storage))
+	})
+	router.Route("/users", func(r chi.Router) {
+		r.With(byIP).Post("/", users.NewRegister(log, storage, hasher, cfg.Accounts))
+		r.With(byIP, bearer.New(log, keys)).Post("/password", users.NewChangePassword(log, storage, hasher, limiter,
+			cfg.Accounts))
+		if cfg.Email.LinkSecret != "" {
+			r.Get("/verify", verifylink.NewForm(log, cfg.Email.LinkSecret))
+			r.Post("/verify", verifylink.NewConfirm(log, storage, cfg.Email.LinkSecret))
+		}
+		r.Group(func(r chi.Router) {
+			r.Use(clientauth.New(log, "accounts", cfg.Accounts.AdminClients))
+			r.Post("/{user_guid}/disable", users.NewSetDisabled(log, storage, true))
+			r.Post("/{user_guid}/enable", users.NewSetDisabled(log, storage, false))
+		})
+	})
+
+	return router, nil
+}
@@ -1,43 +1,261 @@
 package config
 
 import (
-	"log"
-	"os"
 	"time"
-
-	"gopkg.in/yaml.v2"
 )
 
 type Server struct {
 	Host    string        `yaml:"host"`
 	Port    int           `yaml:"port"`
 	Timeout time.Duration `yaml:"timeout" env-default:"10s"`
+
+	// PublicURL is where users reach the service, links in emails lead
+	// there. Emails have no links if it is empty.
+	PublicURL string `yaml:"public_url"`
 }
 
 type Database struct {
-	Host     string `yaml:"host"`
-	Port     uint16 `yaml:"port"`
-	User     string `yaml:"user"`
-	Password string `yaml:"password"`
-	Name     string `yaml:"name"`
+	Driver        string        `yaml:"driver" env-default:"postgres"`
+	Host          string        `yaml:"host"`
+	Port          uint16        `yaml:"port"`
+	User          string        `yaml:"user"`
+	Password      string        `yaml:"password"`
+	Name          string        `yaml:"name"`
+	Path          string        `yaml:"path"`
+	SweepInterval time.Duration `yaml:"sweep_interval" env-default:"1m"`
+
+	// ReadTimeout and WriteTimeout bound every query and every statement
+	// that modifies data, on top of the deadline of the request.
+	ReadTimeout  time.Duration `yaml:"read_timeout" env-default:"2s"`
+	WriteTimeout time.Duration `yaml:"write_timeout" env-default:"3s"`
+
+	// ManualMigrations disables applying migrations at startup, the server
+	// then refuses to start until they are applied with the migrate command.
+	ManualMigrations bool `yaml:"manual_migrations"`
 }
 
 type HTTP struct {
 	Timeout     time.Duration `yaml:"timeout" env-default:"5s"`
 	IdleTimeout time.Duration `yaml:"idle_timeout" env-default:"60s"`
+
+	// DebugVars serves the expvar metrics at /debug/vars.
+	DebugVars bool `yaml:"debug_vars"`
+
+	// TrustedProxies are the CIDRs of the proxies whose ClientIPHeader
+	// tells the client IP.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// ClientIPHeader is the one header the trusted proxies set: Forwarded,
+	// X-Forwarded-For or X-Real-IP. The others are ignored, the proxies pass
+	// them on from the client.
+	ClientIPHeader string `yaml:"client_ip_header" env-default:"X-Forwarded-For"`
+}
+
+type JWTKey struct {
+	ID             string    `yaml:"id"`
+	Algorithm      string    `yaml:"algorithm"`
+	PrivateKeyPath string    `yaml:"private_key_path"`
+	Secret         string    `yaml:"secret"`
+	ActiveFrom     time.Time `yaml:"active_from"`
+	RetireAt       time.Time `yaml:"retire_at"`
 }
 
 type JWT struct {
 	SecretKey      string        `yaml:"secret_key"`
+	Keys           []JWTKey      `yaml:"keys"`
 	AccessExpires  time.Duration `yaml:"access_token_expires" env-default:"300s"`
 	RefreshExpires time.Duration `yaml:"refresh_token_expires" env-default:"3600s"`
+
+	// RefreshTokenPepper keys the hashes of stored refresh tokens. Changing
+	// it invalidates every refresh token issued before.
+	RefreshTokenPepper string `yaml:"refresh_token_pepper"`
+
+	// RefreshGracePeriod is how long after a rotation a retried refresh with
+	// the rotated token gets the same successor instead of being taken for
+	// token reuse. Zero disables retries.
+	RefreshGracePeriod time.Duration `yaml:"refresh_grace_period"`
+}
+
+// IPBinding configures what a refresh from another IP than the one the
+// access token was issued to does. Policy is "ignore", "warn", which emails
+// the user, "require-same-subnet", which rejects refreshes from outside the
+// subnet of IPv4Prefix or IPv6Prefix bits, or "reject".
+type IPBinding struct {
+	Policy     string `yaml:"policy" env-default:"warn"`
+	IPv4Prefix int    `yaml:"ipv4_prefix" env-default:"24"`
+	IPv6Prefix int    `yaml:"ipv6_prefix" env-default:"64"`
+
+	// Clients override the policy for the tokens issued with their ID.
+	Clients []IPBindingClient `yaml:"clients"`
+}
+
+// IPBindingClient overrides the policy for a client, the fields left empty
+// are taken from the default policy.
+type IPBindingClient struct {
+	ID         string `yaml:"id"`
+	Policy     string `yaml:"policy"`
+	IPv4Prefix int    `yaml:"ipv4_prefix"`
+	IPv6Prefix int    `yaml:"ipv6_prefix"`
+}
+
+// RateLimit configures the token buckets limiting the token endpoints and
+// the lockout after repeated invalid refreshes. Store is "memory", which
+// keeps the buckets per replica, or "postgres", which shares them between
+// the replicas through the database.
+type RateLimit struct {
+	Enabled bool   `yaml:"enabled" env-default:"true"`
+	Store   string `yaml:"store" env-default:"memory"`
+
+	// IP limits the token requests of a client IP, GUID the tokens issued
+	// for a user GUID and BindKey the refreshes of a token.
+	IP      Limit `yaml:"ip"`
+	GUID    Limit `yaml:"guid"`
+	BindKey Limit `yaml:"bind_key"`
+
+	Lockout Lockout `yaml:"lockout"`
+
+	// PurgeInterval is how often idle buckets are deleted from the store.
+	PurgeInterval time.Duration `yaml:"purge_interval" env-default:"10m"`
+}
+
+// Limit is a bucket of Burst tokens, Requests by default, refilled with
+// Requests tokens every Period. Every request takes a token.
+type Limit struct {
+	Requests int           `yaml:"requests" env-default:"60"`
+	Period   time.Duration `yaml:"period" env-default:"1m"`
+	Burst    int           `yaml:"burst"`
+}
+
+// Lockout locks a client IP or a token out for Duration after MaxFailures
+// invalid refreshes within Window.
+type Lockout struct {
+	MaxFailures int           `yaml:"max_failures" env-default:"5"`
+	Window      time.Duration `yaml:"window" env-default:"15m"`
+	Duration    time.Duration `yaml:"duration" env-default:"15m"`
 }
 
 type Email struct {
-	Host     string `yaml:"host"`
-	Port     int    `yaml:"port"`
-	From     string `yaml:"from"`
+	// Backend is "smtp" or "mock", the development mailer capturing emails.
+	// Load defaults it to "mock" in development and to "smtp" otherwise.
+	Backend string `yaml:"backend"`
+
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	// From is the sender address of emails.
+	From string `yaml:"from"`
+	// Username is the SMTP login, From is used if it is empty.
+	Username string `yaml:"username"`
 	Password string `yaml:"password"`
+	// TLS is "starttls", "implicit" or "none". It defaults to "implicit"
+	// on port 465 and to "starttls" otherwise, which fails if the server
+	// does not offer it.
+	TLS string `yaml:"tls"`
+	// CAFile is a PEM bundle of the certificates the server certificate is
+	// verified with instead of the system ones.
+	CAFile string `yaml:"ca_file"`
+	// Auth is "plain", "login", "cram-md5" or "none". It defaults to "plain"
+	// if a login is set.
+	Auth string `yaml:"auth"`
+	// PoolSize is how many SMTP sessions are kept open and used at once.
+	// Sessions idle for PoolIdleTimeout are closed before reuse.
+	PoolSize        int           `yaml:"pool_size" env-default:"4"`
+	PoolIdleTimeout time.Duration `yaml:"pool_idle_timeout" env-default:"30s"`
+
+	Outbox Outbox `yaml:"outbox"`
+
+	// TemplatesDir holds templates replacing the embedded ones, laid out
+	// as <locale>/<kind>.{subject,text,html}.tmpl.
+	TemplatesDir  string `yaml:"templates_dir"`
+	DefaultLocale string `yaml:"default_locale" env-default:"en"`
+
+	// LinkSecret signs the links in emails, they are left out if it is
+	// empty. Changing it invalidates every link sent before.
+	LinkSecret        string        `yaml:"link_secret"`
+	RevokeLinkExpires time.Duration `yaml:"revoke_link_expires" env-default:"72h"`
+	VerifyLinkExpires time.Duration `yaml:"verify_link_expires" env-default:"24h"`
+
+	// CaptureLimit is how many messages the development mailer keeps for
+	// the /dev/mail inbox, the oldest ones are dropped first.
+	CaptureLimit int `yaml:"capture_limit" env-default:"100"`
+}
+
+// Outbox configures the dispatcher of queued emails. A failed delivery is
+// retried after Backoff, doubled with every attempt up to MaxBackoff, and
+// the email is moved to dead letters after MaxAttempts.
+type Outbox struct {
+	Interval    time.Duration `yaml:"interval" env-default:"10s"`
+	BatchSize   int           `yaml:"batch_size" env-default:"100"`
+	SendTimeout time.Duration `yaml:"send_timeout" env-default:"30s"`
+	MaxAttempts int           `yaml:"max_attempts" env-default:"8"`
+	Backoff     time.Duration `yaml:"backoff" env-default:"30s"`
+	MaxBackoff  time.Duration `yaml:"max_backoff" env-default:"1h"`
+}
+
+// Authentication configures how the users tokens are issued to prove who
+// they are. Methods are tried in order: "password" checks HTTP Basic
+// credentials against the users table, "api_key" checks the X-API-Key
+// header against APIKeys and "header" takes the user GUID from Header, set
+// by a proxy of HeaderProxies that authenticated the user. Insecure issues
+// tokens for any GUID without credentials, it is only allowed in
+// Development.
+type Authentication struct {
+	Methods []string `yaml:"methods" env-default:"password"`
+	APIKeys []APIKey `yaml:"api_keys"`
+	Header  string   `yaml:"header" env-default:"X-Authenticated-User"`
+	// HeaderProxies are the CIDRs of the proxies whose Header is believed.
+	// They are apart from the HTTP trusted proxies, which are only believed
+	// about the client IP.
+	HeaderProxies []string `yaml:"header_proxies"`
+	Insecure      bool     `yaml:"insecure"`
+}
+
+// APIKey is the static key of a service account, it authenticates the
+// account as the user of UserGUID.
+type APIKey struct {
+	Name     string `yaml:"name"`
+	Key      string `yaml:"key"`
+	UserGUID string `yaml:"user_guid"`
+}
+
+// Accounts configures the user accounts. Passwords are hashed with Argon2id
+// and PasswordHashing, hashes made with other parameters are rehashed on
+// the next login. AdminClients may disable and enable users.
+type Accounts struct {
+	MinPasswordLength int             `yaml:"min_password_length" env-default:"8"`
+	PasswordHashing   PasswordHashing `yaml:"password_hashing"`
+	AdminClients      []Client        `yaml:"admin_clients"`
+}
+
+// PasswordHashing holds the Argon2id parameters, Memory is in KiB.
+// MaxConcurrent bounds the hashes computed at a time, each of which takes
+// Memory, requests finding them all taken get 503.
+type PasswordHashing struct {
+	Memory        uint32 `yaml:"memory" env-default:"65536"`
+	Iterations    uint32 `yaml:"iterations" env-default:"3"`
+	Parallelism   uint8  `yaml:"parallelism" env-default:"2"`
+	SaltLength    uint32 `yaml:"salt_length" env-default:"16"`
+	KeyLength     uint32 `yaml:"key_length" env-default:"32"`
+	MaxConcurrent int    `yaml:"max_concurrent" env-default:"4"`
+}
+
+type Client struct {
+	ID     string `yaml:"id"`
+	Secret string `yaml:"secret"`
+}
+
+type Introspection struct {
+	Clients []Client `yaml:"clients"`
+}
+
+// Purge configures the job deleting refresh tokens that expired longer
+// than Retention ago, at most BatchSize rows per statement.
+type Purge struct {
+	Interval  time.Duration `yaml:"interval" env-default:"1h"`
+	Retention time.Duration `yaml:"retention" env-default:"168h"`
+	BatchSize int           `yaml:"batch_size" env-default:"1000"`
+}
+
+type Scheduler struct {
+	Purge Purge `yaml:"purge"`
 }
 
 type Config struct {
@@ -47,31 +265,19 @@ type Config struct {
 	Email    Email    `yaml:"email"`
 	HTTP     HTTP     `yaml:"http"`
 	JWT      JWT      `yaml:"jwt"`
-}
-
-func MustLoad(configPath string) *Config {
-	if configPath == "" {
-		log.Fatalf("CONFIG_PATH is not set")
-	}
-
-	if _, err := os.Stat(configPath); err != nil {
-		log.Fatalf("Can not to find config file: %s", err)
-	}
-
-	file, err := os.Open(configPath)
 
-	if err != nil {
-		log.Fatalf("Can not to open config file: %s", err)
-	}
-	defer file.Close()
+	Authentication Authentication `yaml:"authentication"`
+	Accounts       Accounts       `yaml:"accounts"`
+	IPBinding      IPBinding      `yaml:"ip_binding"`
+	RateLimit      RateLimit      `yaml:"rate_limit"`
+	Introspection  Introspection  `yaml:"introspection"`
+	Scheduler      Scheduler      `yaml:"scheduler"`
 
-	var cfg Config
-
-	decoder := yaml.NewDecoder(file)
-
-	if err := decoder.Decode(&cfg); err != nil {
-		log.Fatalf("Can not to read config file: %s", err)
-	}
+	origins []Origin
+}
 
-	return &cfg
+// Origins tells where every value was set from, in the order of the fields.
+// Values left unset are not listed.
+func (c *Config) Origins() []Origin {
+	return c.origins
 }
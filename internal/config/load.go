@@ -0,0 +1,306 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix starts the environment variables overriding the config file.
+// The variable of a field is the prefix followed by the path of its YAML
+// keys joined with "_" in upper case, e.g. AUTH_JWT_SECRET_KEY for
+// jwt.secret_key and AUTH_JWT_KEYS_0_SECRET for the secret of the first key.
+// Lists of strings are set as comma separated values.
+// The variable suffixed with _FILE names a file holding the value instead.
+const EnvPrefix = "AUTH_"
+
+// Sources of config values, from the lowest precedence to the highest.
+const (
+	SourceDefault    = "default"
+	SourceFile       = "file"
+	SourceEnv        = "env"
+	SourceSecretFile = "secret file"
+)
+
+// Origin tells where the value of the field with the key came from.
+type Origin struct {
+	Key    string
+	Source string
+	// Env is the variable holding the value or the path to it.
+	Env string
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+	stringsType  = reflect.TypeOf([]string(nil))
+)
+
+func MustLoad(configPath string) *Config {
+	if configPath == "" {
+		log.Fatalf("CONFIG_PATH is not set")
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	return cfg
+}
+
+// Load reads the config file, then applies the environment variables and
+// the env-default tags of the fields missing from both, and validates the
+// result. Unknown keys in the file are an error.
+func Load(configPath string) (*Config, error) {
+	if _, err := os.Stat(configPath); err != nil {
+		return nil, fmt.Errorf("Can not to find config file: %w", err)
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("Can not to open config file: %w", err)
+	}
+
+	var cfg Config
+
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, fmt.Errorf("Can not to read config file: %w", err)
+	}
+
+	// The file is read again as a tree, to tell the keys it sets from the
+	// values it leaves zero.
+	var tree map[interface{}]interface{}
+
+	if err := yaml.Unmarshal(data, &tree); err != nil {
+		return nil, fmt.Errorf("Can not to read config file: %w", err)
+	}
+
+	l := loader{environ: environ()}
+	l.walkStruct(reflect.ValueOf(&cfg).Elem(), "", EnvPrefix, tree)
+
+	if err := errors.Join(l.errs...); err != nil {
+		return nil, fmt.Errorf("Can not to apply environment: %w", err)
+	}
+
+	// The mailer defaults to capturing emails in development only.
+	if cfg.Email.Backend == "" {
+		cfg.Email.Backend = "smtp"
+		if cfg.Env == EnvDevelopment {
+			cfg.Email.Backend = "mock"
+		}
+		l.origins = append(l.origins, Origin{Key: "email.backend", Source: SourceDefault})
+	}
+
+	cfg.origins = l.origins
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid config:\n%w", err)
+	}
+
+	return &cfg, nil
+}
+
+type loader struct {
+	environ map[string]string
+	origins []Origin
+	errs    []error
+}
+
+func (l *loader) walkStruct(v reflect.Value, key string, env string, node interface{}) {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if !field.IsExported() || name == "" || name == "-" {
+			continue
+		}
+
+		fieldKey := name
+		if key != "" {
+			fieldKey = key + "." + name
+		}
+
+		child, present := lookup(node, name)
+
+		l.walkField(v.Field(i), field, fieldKey, env+strings.ToUpper(name), child, present)
+	}
+}
+
+func (l *loader) walkField(v reflect.Value, field reflect.StructField, key string, env string,
+	node interface{}, present bool) {
+	switch {
+	case v.Type() == timeType:
+		l.scalar(v, field.Tag.Get("env-default"), key, env, present)
+	case v.Kind() == reflect.Struct:
+		l.walkStruct(v, key, env+"_", node)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Struct:
+		// Variables may set items past the ones of the file.
+		for n := l.indexes(env + "_"); v.Len() < n; {
+			v.Set(reflect.Append(v, reflect.New(v.Type().Elem()).Elem()))
+		}
+
+		items, _ := node.([]interface{})
+
+		for i := 0; i < v.Len(); i++ {
+			var item interface{}
+			if i < len(items) {
+				item = items[i]
+			}
+
+			index := strconv.Itoa(i)
+			l.walkStruct(v.Index(i), key+"."+index, env+"_"+index+"_", item)
+		}
+	default:
+		l.scalar(v, field.Tag.Get("env-default"), key, env, present)
+	}
+}
+
+// scalar sets the value from the variable, the file named by the variable
+// with the _FILE suffix, the config file or the default, whichever comes
+// first.
+func (l *loader) scalar(v reflect.Value, def string, key string, env string, present bool) {
+	value, fromEnv := l.environ[env]
+	path, fromFile := l.environ[env+"_FILE"]
+
+	var origin Origin
+
+	switch {
+	case fromEnv && fromFile:
+		l.errs = append(l.errs, fmt.Errorf("%s: Both %s and %s_FILE are set", key, env, env))
+		return
+	case fromEnv:
+		origin = Origin{Key: key, Source: SourceEnv, Env: env}
+	case fromFile:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			l.errs = append(l.errs, fmt.Errorf("%s: Can not to read %s_FILE: %w", key, env, err))
+			return
+		}
+
+		// Secret files usually end with a newline that is not a part of
+		// the secret.
+		value = strings.TrimRight(string(data), "\r\n")
+		origin = Origin{Key: key, Source: SourceSecretFile, Env: env + "_FILE"}
+	case present:
+		l.origins = append(l.origins, Origin{Key: key, Source: SourceFile})
+		return
+	case def != "":
+		value = def
+		origin = Origin{Key: key, Source: SourceDefault}
+	default:
+		return
+	}
+
+	if err := setScalar(v, value); err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s: Invalid %s value: %w", key, origin.Source, err))
+		return
+	}
+
+	l.origins = append(l.origins, origin)
+}
+
+// indexes returns the number of slice items the variables starting with
+// the prefix set.
+func (l *loader) indexes(prefix string) int {
+	n := 0
+
+	for name := range l.environ {
+		rest, ok := strings.CutPrefix(name, prefix)
+		if !ok {
+			continue
+		}
+
+		digits, _, _ := strings.Cut(rest, "_")
+
+		index, err := strconv.Atoi(digits)
+		if err == nil && index >= n {
+			n = index + 1
+		}
+	}
+
+	return n
+}
+
+func setScalar(v reflect.Value, value string) error {
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Type() == timeType:
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+	case v.Kind() == reflect.String:
+		v.SetString(value)
+	case v.Type() == stringsType:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.CanInt():
+		i, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case v.CanUint():
+		u, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
+
+// lookup returns the value of the key in a mapping of the YAML tree.
+func lookup(node interface{}, key string) (interface{}, bool) {
+	mapping, ok := node.(map[interface{}]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	value, ok := mapping[key]
+
+	return value, ok
+}
+
+func environ() map[string]string {
+	env := make(map[string]string)
+
+	for _, pair := range os.Environ() {
+		name, value, _ := strings.Cut(pair, "=")
+		if strings.HasPrefix(name, EnvPrefix) {
+			env[name] = value
+		}
+	}
+
+	return env
+}
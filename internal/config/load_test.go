@@ -0,0 +1,410 @@
+package config_test
+
+import (
+	"auth/internal/config"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const configFile = `
+env: "Production"
+server:
+  port: 8080
+database:
+  host: "localhost"
+  port: 5432
+  user: "postgres"
+  password: "file password"
+  name: "postgres"
+jwt:
+  secret_key: "file secret of at least 32 characters"
+  keys:
+    - id: "first"
+      secret: "first secret of at least 32 characters"
+email:
+  host: "smtp.example.com"
+  port: 587
+  from: "noreply@example.com"
+scheduler:
+  purge:
+    retention: 0s
+`
+
+func writeFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
+
+func origin(cfg *config.Config, key string) config.Origin {
+	for _, origin := range cfg.Origins() {
+		if origin.Key == key {
+			return origin
+		}
+	}
+
+	return config.Origin{}
+}
+
+func TestLoad(t *testing.T) {
+	t.Setenv("AUTH_SERVER_PORT", "9090")
+	t.Setenv("AUTH_DATABASE_PASSWORD_FILE", writeFile(t, "password", "secret password\n"))
+	t.Setenv("AUTH_JWT_KEYS_0_SECRET", "env secret of at least 32 characters")
+	t.Setenv("AUTH_JWT_KEYS_1_ID", "second")
+	t.Setenv("AUTH_JWT_KEYS_1_PRIVATE_KEY_PATH", "second.pem")
+	t.Setenv("AUTH_EMAIL_OUTBOX_MAX_ATTEMPTS", "3")
+	t.Setenv("AUTH_HTTP_DEBUG_VARS", "true")
+	t.Setenv("AUTH_HTTP_TRUSTED_PROXIES", "10.0.0.0/8, 192.168.0.1")
+	t.Setenv("AUTH_ACCOUNTS_PASSWORD_HASHING_PARALLELISM", "4")
+
+	cfg, err := config.Load(writeFile(t, "config.yaml", configFile))
+	require.NoError(t, err)
+
+	require.Equal(t, "Production", cfg.Env)
+	require.Equal(t, config.Origin{Key: "env", Source: config.SourceFile}, origin(cfg, "env"))
+
+	require.Equal(t, 9090, cfg.Server.Port)
+	require.Equal(t, config.Origin{Key: "server.port", Source: config.SourceEnv, Env: "AUTH_SERVER_PORT"},
+		origin(cfg, "server.port"))
+
+	require.Equal(t, "secret password", cfg.Database.Password, "Trailing newline is trimmed")
+	require.Equal(t, config.SourceSecretFile, origin(cfg, "database.password").Source)
+	require.Equal(t, "AUTH_DATABASE_PASSWORD_FILE", origin(cfg, "database.password").Env)
+
+	require.Equal(t, 10*time.Second, cfg.Server.Timeout)
+	require.Equal(t, config.SourceDefault, origin(cfg, "server.timeout").Source)
+	require.Equal(t, time.Duration(0), cfg.Scheduler.Purge.Retention, "Zero set in the file is kept")
+	require.Equal(t, 5*time.Second, cfg.HTTP.Timeout)
+	require.Equal(t, 60*time.Second, cfg.HTTP.IdleTimeout)
+	require.Equal(t, "smtp", cfg.Email.Backend)
+	require.True(t, cfg.HTTP.DebugVars)
+	require.Equal(t, []string{"10.0.0.0/8", "192.168.0.1"}, cfg.HTTP.TrustedProxies)
+	require.Equal(t, "X-Forwarded-For", cfg.HTTP.ClientIPHeader)
+	require.Equal(t, "warn", cfg.IPBinding.Policy)
+	require.Equal(t, "postgres", cfg.Database.Driver)
+	require.Equal(t, 3, cfg.Email.Outbox.MaxAttempts)
+	require.Equal(t, 30*time.Second, cfg.Email.Outbox.Backoff)
+	require.Equal(t, uint32(65536), cfg.Accounts.PasswordHashing.Memory)
+	require.Equal(t, uint8(4), cfg.Accounts.PasswordHashing.Parallelism)
+	require.Equal(t, 4, cfg.Accounts.PasswordHashing.MaxConcurrent)
+
+	require.Len(t, cfg.JWT.Keys, 2)
+	require.Equal(t, "first", cfg.JWT.Keys[0].ID)
+	require.Equal(t, "env secret of at least 32 characters", cfg.JWT.Keys[0].Secret)
+	require.Equal(t, "second", cfg.JWT.Keys[1].ID)
+	require.Equal(t, "jwt.keys.1.id", origin(cfg, "jwt.keys.1.id").Key)
+
+	require.Empty(t, origin(cfg, "email.password").Key, "Unset value has no origin")
+}
+
+func TestLoadErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		env  map[string]string
+	}{
+		{
+			name: "Invalid duration",
+			env:  map[string]string{"AUTH_HTTP_TIMEOUT": "some string"},
+		},
+		{
+			name: "Invalid number",
+			env:  map[string]string{"AUTH_DATABASE_PORT": "70000"},
+		},
+		{
+			name: "Invalid bool",
+			env:  map[string]string{"AUTH_HTTP_DEBUG_VARS": "some string"},
+		},
+		{
+			name: "Missing secret file",
+			env:  map[string]string{"AUTH_JWT_SECRET_KEY_FILE": "missing"},
+		},
+		{
+			name: "Invalid value",
+			env:  map[string]string{"AUTH_ENV": "some string"},
+		},
+		{
+			name: "Variable and secret file",
+			env: map[string]string{
+				"AUTH_JWT_SECRET_KEY":      "secret",
+				"AUTH_JWT_SECRET_KEY_FILE": writeFile(t, "secret", "secret"),
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for name, value := range tc.env {
+				t.Setenv(name, value)
+			}
+
+			_, err := config.Load(writeFile(t, "config.yaml", configFile))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	_, err := config.Load(writeFile(t, "config.yaml", configFile+"Email:\n  host: \"smtp.example.com\"\n"))
+	require.ErrorContains(t, err, "field Email not found")
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() *config.Config {
+		return &config.Config{
+			Env:    config.EnvProduction,
+			Server: config.Server{Port: 8080, Timeout: time.Second},
+			HTTP:   config.HTTP{Timeout: time.Second, IdleTimeout: time.Second, ClientIPHeader: "X-Forwarded-For"},
+			Database: config.Database{
+				Driver:        "memory",
+				ReadTimeout:   time.Second,
+				WriteTimeout:  time.Second,
+				SweepInterval: time.Minute,
+			},
+			JWT: config.JWT{
+				SecretKey:      "secret of at least 32 characters!",
+				AccessExpires:  time.Minute,
+				RefreshExpires: time.Hour,
+			},
+			Email: config.Email{
+				Backend:           "mock",
+				PoolSize:          1,
+				PoolIdleTimeout:   time.Second,
+				DefaultLocale:     "en",
+				RevokeLinkExpires: time.Hour,
+				VerifyLinkExpires: time.Hour,
+				CaptureLimit:      1,
+				Outbox: config.Outbox{
+					Interval:    time.Second,
+					BatchSize:   1,
+					SendTimeout: time.Second,
+					MaxAttempts: 1,
+					Backoff:     time.Second,
+					MaxBackoff:  time.Second,
+				},
+			},
+			Authentication: config.Authentication{Methods: []string{"password"}, Header: "X-Authenticated-User"},
+			Accounts: config.Accounts{
+				MinPasswordLength: 8,
+				PasswordHashing: config.PasswordHashing{
+					Memory:        64,
+					Iterations:    1,
+					Parallelism:   1,
+					SaltLength:    16,
+					KeyLength:     32,
+					MaxConcurrent: 2,
+				},
+			},
+			IPBinding: config.IPBinding{Policy: "warn", IPv4Prefix: 24, IPv6Prefix: 64},
+			Scheduler: config.Scheduler{Purge: config.Purge{Interval: time.Hour, BatchSize: 1}},
+		}
+	}
+
+	require.NoError(t, valid().Validate())
+
+	insecure := valid()
+	insecure.Env = config.EnvDevelopment
+	insecure.Authentication = config.Authentication{Insecure: true}
+	require.NoError(t, insecure.Validate(), "Insecure authentication is allowed in development")
+
+	stricter := valid()
+	stricter.IPBinding.Policy = "require-same-subnet"
+	stricter.IPBinding.Clients = []config.IPBindingClient{{ID: "kiosk", Policy: "reject"}, {ID: "laptop", IPv4Prefix: 28}}
+	require.NoError(t, stricter.Validate(), "Clients may be stricter than the default policy")
+
+	cases := []struct {
+		name   string
+		modify func(cfg *config.Config)
+		errors []string
+	}{
+		{
+			name:   "Unknown env",
+			modify: func(cfg *config.Config) { cfg.Env = "Local" },
+			errors: []string{"env: must be one of"},
+		},
+		{
+			name:   "Zero port",
+			modify: func(cfg *config.Config) { cfg.Server.Port = 0 },
+			errors: []string{"server.port: must be a port"},
+		},
+		{
+			name:   "Invalid host",
+			modify: func(cfg *config.Config) { cfg.Server.Host = "some host" },
+			errors: []string{"server.host: must be a hostname"},
+		},
+		{
+			name:   "Relative public URL",
+			modify: func(cfg *config.Config) { cfg.Server.PublicURL = "auth.example.com" },
+			errors: []string{"server.public_url: must be an absolute"},
+		},
+		{
+			name:   "Short secret key",
+			modify: func(cfg *config.Config) { cfg.JWT.SecretKey = "secret" },
+			errors: []string{"jwt.secret_key: must be at least 32 characters long, got 6"},
+		},
+		{
+			name:   "No signing key",
+			modify: func(cfg *config.Config) { cfg.JWT.SecretKey = "" },
+			errors: []string{"jwt: secret_key or keys must be set"},
+		},
+		{
+			name:   "Refresh expires before access",
+			modify: func(cfg *config.Config) { cfg.JWT.RefreshExpires = time.Second },
+			errors: []string{"jwt.refresh_token_expires: must be longer than access_token_expires"},
+		},
+		{
+			name:   "Grace period without pepper",
+			modify: func(cfg *config.Config) { cfg.JWT.RefreshGracePeriod = time.Second },
+			errors: []string{"jwt.refresh_grace_period: requires jwt.refresh_token_pepper"},
+		},
+		{
+			name:   "Zero timeout",
+			modify: func(cfg *config.Config) { cfg.HTTP.Timeout = 0 },
+			errors: []string{"http.timeout: must be a positive duration"},
+		},
+		{
+			name:   "Invalid trusted proxy",
+			modify: func(cfg *config.Config) { cfg.HTTP.TrustedProxies = []string{"10.0.0.1", "10.0.0.0/33"} },
+			errors: []string{"http.trusted_proxies.1: must be a CIDR"},
+		},
+		{
+			name:   "Unknown client IP header",
+			modify: func(cfg *config.Config) { cfg.HTTP.ClientIPHeader = "X-Client-IP" },
+			errors: []string{"http.client_ip_header: must be one of"},
+		},
+		{
+			name: "Insecure authentication in production",
+			modify: func(cfg *config.Config) {
+				cfg.Authentication = config.Authentication{Insecure: true}
+			},
+			errors: []string{"authentication.insecure: is only allowed in env Development"},
+		},
+		{
+			name: "Invalid authentication",
+			modify: func(cfg *config.Config) {
+				cfg.Authentication = config.Authentication{
+					Methods: []string{"password", "api_key", "header", "password", "oauth"},
+					APIKeys: []config.APIKey{
+						{Name: "billing", Key: "short", UserGUID: "d952af16-4251-4ab8-818f-3f3aca064256"},
+						{Name: "billing", Key: "verysecretapikey!", UserGUID: "some string"},
+					},
+				}
+			},
+			errors: []string{"authentication.methods.3: duplicates method", "authentication.methods.4: must be one of",
+				"authentication.header: must be set",
+				"authentication.header_proxies: must be set for authentication method header",
+				"authentication.api_keys.0.key: must be at least 16 characters long",
+				"authentication.api_keys.1.name: duplicates API key", "authentication.api_keys.1.user_guid: must be a GUID"},
+		},
+		{
+			name: "Invalid header proxies",
+			modify: func(cfg *config.Config) {
+				cfg.Authentication.Methods = []string{"header"}
+				cfg.Authentication.HeaderProxies = []string{"10.0.0.1", "10.0.0.0/33"}
+			},
+			errors: []string{"authentication.header_proxies.1: must be a CIDR"},
+		},
+		{
+			name:   "API key method without keys",
+			modify: func(cfg *config.Config) { cfg.Authentication.Methods = []string{"api_key"} },
+			errors: []string{"authentication.api_keys: must be set for method api_key"},
+		},
+		{
+			name: "Invalid rate limit",
+			modify: func(cfg *config.Config) {
+				cfg.RateLimit = config.RateLimit{
+					Enabled:       true,
+					Store:         "postgres",
+					IP:            config.Limit{Requests: 60, Period: time.Minute, Burst: -1},
+					GUID:          config.Limit{Requests: 10, Period: time.Minute},
+					BindKey:       config.Limit{Period: time.Minute},
+					Lockout:       config.Lockout{MaxFailures: 5, Window: time.Minute, Duration: time.Minute},
+					PurgeInterval: time.Minute,
+				}
+			},
+			errors: []string{"rate_limit.store: postgres requires database.driver postgres",
+				"rate_limit.ip.burst: must not be negative", "rate_limit.bind_key.requests: must be positive"},
+		},
+		{
+			name: "SMTP without server",
+			modify: func(cfg *config.Config) {
+				cfg.Email.Backend = "smtp"
+				cfg.Email.TLS = "ssl"
+			},
+			errors: []string{"email.host: must be set", "email.port: must be a port", "email.from: must be set",
+				"email.tls: must be one of"},
+		},
+		{
+			name: "Duplicate client",
+			modify: func(cfg *config.Config) {
+				cfg.Introspection.Clients = []config.Client{
+					{ID: "client", Secret: "client secret 16"},
+					{ID: "client", Secret: "secret"},
+				}
+			},
+			errors: []string{"introspection.clients.1.id: duplicates client", "introspection.clients.1.secret"},
+		},
+		{
+			name: "Invalid accounts",
+			modify: func(cfg *config.Config) {
+				cfg.Accounts.MinPasswordLength = 4
+				cfg.Accounts.PasswordHashing = config.PasswordHashing{
+					Memory:      16,
+					Iterations:  0,
+					Parallelism: 4,
+					SaltLength:  4,
+					KeyLength:   8,
+				}
+				cfg.Accounts.AdminClients = []config.Client{{Secret: "client secret 16"}}
+			},
+			errors: []string{"accounts.min_password_length: must be at least 8",
+				"accounts.password_hashing.iterations", "accounts.password_hashing.memory",
+				"accounts.password_hashing.salt_length", "accounts.password_hashing.key_length",
+				"accounts.password_hashing.max_concurrent", "accounts.admin_clients.0.id: must be set"},
+		},
+		{
+			name: "Invalid IP binding",
+			modify: func(cfg *config.Config) {
+				cfg.IPBinding.IPv4Prefix = 33
+				cfg.IPBinding.Clients = []config.IPBindingClient{{ID: "mobile", Policy: "same-subnet"}}
+			},
+			errors: []string{"ip_binding.ipv4_prefix: must be a prefix length", "ip_binding.clients.0.policy"},
+		},
+		{
+			name: "IP binding client laxer than default",
+			modify: func(cfg *config.Config) {
+				cfg.IPBinding.Policy = "require-same-subnet"
+				cfg.IPBinding.Clients = []config.IPBindingClient{
+					{ID: "mobile", Policy: "warn"},
+					{ID: "tablet", IPv4Prefix: 16},
+					{ID: "desktop", Policy: "require-same-subnet", IPv6Prefix: 48},
+				}
+			},
+			errors: []string{"ip_binding.clients.0.policy: must not be laxer than ip_binding.policy",
+				"ip_binding.clients.1.ipv4_prefix: must not be shorter", "ip_binding.clients.2.ipv6_prefix"},
+		},
+		{
+			name: "Postgres without database",
+			modify: func(cfg *config.Config) {
+				cfg.Database.Driver = "postgres"
+			},
+			errors: []string{"database.host: must be set", "database.port", "database.user", "database.name"},
+		},
+	}
+
+	for _, tc := range cases {
+		cfg := valid()
+		tc.modify(cfg)
+
+		err := cfg.Validate()
+		require.Error(t, err, "Case: %s", tc.name)
+
+		for _, message := range tc.errors {
+			require.ErrorContains(t, err, message, "Case: %s", tc.name)
+		}
+	}
+}
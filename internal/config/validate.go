@@ -0,0 +1,479 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Environments, they pick the log level and the defaults of some values.
+const (
+	EnvDevelopment = "Development"
+	EnvProduction  = "Production"
+)
+
+const (
+	// MinPasswordLength is the least min_password_length may be.
+	MinPasswordLength = 8
+	// MinSecretLength is the minimum length of the keys of HMAC signatures,
+	// shorter ones are weaker than the SHA-256 they are used with.
+	MinSecretLength = 32
+	// MinClientSecretLength is the minimum length of client secrets, and of
+	// API keys.
+	MinClientSecretLength = 16
+)
+
+// Authentication methods.
+const (
+	AuthPassword = "password"
+	AuthAPIKey   = "api_key"
+	AuthHeader   = "header"
+)
+
+var hostnamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*$`)
+
+// Validate reports every invalid value at once, each error naming the key
+// of the value.
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	v.oneOf("env", c.Env, EnvDevelopment, EnvProduction)
+
+	if c.Server.Host != "" {
+		v.host("server.host", c.Server.Host)
+	}
+	v.port("server.port", c.Server.Port)
+	v.positive("server.timeout", c.Server.Timeout)
+	if c.Server.PublicURL != "" {
+		v.url("server.public_url", c.Server.PublicURL)
+	}
+
+	v.positive("http.timeout", c.HTTP.Timeout)
+	v.positive("http.idle_timeout", c.HTTP.IdleTimeout)
+	for i, proxy := range c.HTTP.TrustedProxies {
+		v.cidr(fmt.Sprintf("http.trusted_proxies.%d", i), proxy)
+	}
+	v.oneOf("http.client_ip_header", c.HTTP.ClientIPHeader, "Forwarded", "X-Forwarded-For", "X-Real-IP")
+
+	c.validateDatabase(v)
+	c.validateJWT(v)
+	c.validateEmail(v)
+	c.validateAuthentication(v)
+	c.validateAccounts(v)
+	c.validateIPBinding(v)
+	c.validateRateLimit(v)
+
+	v.clients("introspection.clients", c.Introspection.Clients)
+
+	v.positive("scheduler.purge.interval", c.Scheduler.Purge.Interval)
+	v.nonNegative("scheduler.purge.retention", c.Scheduler.Purge.Retention)
+	v.positiveInt("scheduler.purge.batch_size", c.Scheduler.Purge.BatchSize)
+
+	return errors.Join(v.errs...)
+}
+
+func (c *Config) validateDatabase(v *validator) {
+	db := c.Database
+
+	v.oneOf("database.driver", db.Driver, "postgres", "sqlite", "memory")
+
+	switch db.Driver {
+	case "postgres":
+		v.host("database.host", db.Host)
+		v.port("database.port", int(db.Port))
+		v.set("database.user", db.User)
+		v.set("database.name", db.Name)
+	case "sqlite":
+		v.set("database.path", db.Path)
+	case "memory":
+		v.positive("database.sweep_interval", db.SweepInterval)
+	}
+
+	v.positive("database.read_timeout", db.ReadTimeout)
+	v.positive("database.write_timeout", db.WriteTimeout)
+}
+
+func (c *Config) validateJWT(v *validator) {
+	jwt := c.JWT
+
+	if jwt.SecretKey == "" && len(jwt.Keys) == 0 {
+		v.fail("jwt", "secret_key or keys must be set")
+	}
+
+	if jwt.SecretKey != "" {
+		v.secret("jwt.secret_key", jwt.SecretKey, MinSecretLength)
+	}
+
+	ids := make(map[string]bool)
+	for i, key := range jwt.Keys {
+		prefix := fmt.Sprintf("jwt.keys.%d", i)
+
+		if key.ID == "" {
+			v.fail(prefix+".id", "must be set")
+		} else if ids[key.ID] {
+			v.fail(prefix+".id", "duplicates key %q", key.ID)
+		}
+		ids[key.ID] = true
+
+		switch {
+		case key.Secret != "" && key.PrivateKeyPath != "":
+			v.fail(prefix, "secret and private_key_path are mutually exclusive")
+		case key.Secret != "":
+			v.secret(prefix+".secret", key.Secret, MinSecretLength)
+		case key.PrivateKeyPath == "":
+			v.fail(prefix, "secret or private_key_path must be set")
+		}
+
+		if !key.RetireAt.IsZero() && !key.RetireAt.After(key.ActiveFrom) {
+			v.fail(prefix+".retire_at", "must be after active_from")
+		}
+	}
+
+	v.positive("jwt.access_token_expires", jwt.AccessExpires)
+	v.positive("jwt.refresh_token_expires", jwt.RefreshExpires)
+	if jwt.RefreshExpires > 0 && jwt.RefreshExpires <= jwt.AccessExpires {
+		v.fail("jwt.refresh_token_expires", "must be longer than access_token_expires (%s), got %s",
+			jwt.AccessExpires, jwt.RefreshExpires)
+	}
+
+	if jwt.RefreshTokenPepper != "" {
+		v.secret("jwt.refresh_token_pepper", jwt.RefreshTokenPepper, MinSecretLength)
+	}
+
+	v.nonNegative("jwt.refresh_grace_period", jwt.RefreshGracePeriod)
+	if jwt.RefreshGracePeriod > 0 && jwt.RefreshTokenPepper == "" {
+		v.fail("jwt.refresh_grace_period", "requires jwt.refresh_token_pepper")
+	}
+}
+
+func (c *Config) validateEmail(v *validator) {
+	email := c.Email
+
+	v.oneOf("email.backend", email.Backend, "smtp", "mock")
+
+	if email.Backend == "smtp" {
+		v.host("email.host", email.Host)
+		v.port("email.port", email.Port)
+		v.set("email.from", email.From)
+	}
+
+	if email.TLS != "" {
+		v.oneOf("email.tls", email.TLS, "starttls", "implicit", "none")
+	}
+	if email.Auth != "" {
+		v.oneOf("email.auth", email.Auth, "plain", "login", "cram-md5", "none")
+	}
+
+	v.positiveInt("email.pool_size", email.PoolSize)
+	v.positive("email.pool_idle_timeout", email.PoolIdleTimeout)
+	v.set("email.default_locale", email.DefaultLocale)
+
+	if email.LinkSecret != "" {
+		v.secret("email.link_secret", email.LinkSecret, MinSecretLength)
+	}
+	v.positive("email.revoke_link_expires", email.RevokeLinkExpires)
+	v.positive("email.verify_link_expires", email.VerifyLinkExpires)
+	v.positiveInt("email.capture_limit", email.CaptureLimit)
+
+	outbox := email.Outbox
+
+	v.positive("email.outbox.interval", outbox.Interval)
+	v.positiveInt("email.outbox.batch_size", outbox.BatchSize)
+	v.positive("email.outbox.send_timeout", outbox.SendTimeout)
+	v.positiveInt("email.outbox.max_attempts", outbox.MaxAttempts)
+	v.positive("email.outbox.backoff", outbox.Backoff)
+	if outbox.MaxBackoff < outbox.Backoff {
+		v.fail("email.outbox.max_backoff", "must not be shorter than backoff (%s), got %s",
+			outbox.Backoff, outbox.MaxBackoff)
+	}
+}
+
+func (c *Config) validateIPBinding(v *validator) {
+	binding := c.IPBinding
+
+	// The policies from the laxest to the strictest.
+	policies := []string{"ignore", "warn", "require-same-subnet", "reject"}
+
+	v.oneOf("ip_binding.policy", binding.Policy, policies...)
+	v.prefix("ip_binding.ipv4_prefix", binding.IPv4Prefix, 32)
+	v.prefix("ip_binding.ipv6_prefix", binding.IPv6Prefix, 128)
+
+	ids := make(map[string]bool)
+	for i, client := range binding.Clients {
+		key := fmt.Sprintf("ip_binding.clients.%d", i)
+
+		if client.ID == "" {
+			v.fail(key+".id", "must be set")
+		} else if ids[client.ID] {
+			v.fail(key+".id", "duplicates client %q", client.ID)
+		}
+		ids[client.ID] = true
+
+		if client.Policy != "" {
+			v.oneOf(key+".policy", client.Policy, policies...)
+		}
+		if client.IPv4Prefix != 0 {
+			v.prefix(key+".ipv4_prefix", client.IPv4Prefix, 32)
+		}
+		if client.IPv6Prefix != 0 {
+			v.prefix(key+".ipv6_prefix", client.IPv6Prefix, 128)
+		}
+
+		// Anyone may name a client by the client_id query parameter, so a
+		// client policy may only be stricter than the default one.
+		def := slices.Index(policies, binding.Policy)
+		policy := def
+		if client.Policy != "" {
+			policy = slices.Index(policies, client.Policy)
+		}
+
+		switch {
+		case def < 0 || policy < 0:
+			// Reported above.
+		case policy < def:
+			v.fail(key+".policy", "must not be laxer than ip_binding.policy (%s), got %s",
+				binding.Policy, client.Policy)
+		case policy == def && binding.Policy == "require-same-subnet":
+			if client.IPv4Prefix != 0 && client.IPv4Prefix < binding.IPv4Prefix {
+				v.fail(key+".ipv4_prefix", "must not be shorter than ip_binding.ipv4_prefix (%d), got %d",
+					binding.IPv4Prefix, client.IPv4Prefix)
+			}
+			if client.IPv6Prefix != 0 && client.IPv6Prefix < binding.IPv6Prefix {
+				v.fail(key+".ipv6_prefix", "must not be shorter than ip_binding.ipv6_prefix (%d), got %d",
+					binding.IPv6Prefix, client.IPv6Prefix)
+			}
+		}
+	}
+}
+
+func (c *Config) validateAuthentication(v *validator) {
+	auth := c.Authentication
+
+	if auth.Insecure && c.Env != EnvDevelopment {
+		v.fail("authentication.insecure", "is only allowed in env %s, got %s", EnvDevelopment, c.Env)
+	}
+	if len(auth.Methods) == 0 && !auth.Insecure {
+		v.fail("authentication.methods", "must be set")
+	}
+
+	methods := make(map[string]bool)
+	for i, method := range auth.Methods {
+		key := fmt.Sprintf("authentication.methods.%d", i)
+
+		v.oneOf(key, method, AuthPassword, AuthAPIKey, AuthHeader)
+		if methods[method] {
+			v.fail(key, "duplicates method %q", method)
+		}
+		methods[method] = true
+	}
+
+	if methods[AuthAPIKey] && len(auth.APIKeys) == 0 {
+		v.fail("authentication.api_keys", "must be set for method %s", AuthAPIKey)
+	}
+
+	if methods[AuthHeader] {
+		v.set("authentication.header", auth.Header)
+		// Anyone could set the header of a request that is not sent by an
+		// authenticating proxy.
+		if len(auth.HeaderProxies) == 0 {
+			v.fail("authentication.header_proxies", "must be set for authentication method %s", AuthHeader)
+		}
+	}
+	for i, proxy := range auth.HeaderProxies {
+		v.cidr(fmt.Sprintf("authentication.header_proxies.%d", i), proxy)
+	}
+
+	names := make(map[string]bool)
+	for i, apiKey := range auth.APIKeys {
+		key := fmt.Sprintf("authentication.api_keys.%d", i)
+
+		if apiKey.Name == "" {
+			v.fail(key+".name", "must be set")
+		} else if names[apiKey.Name] {
+			v.fail(key+".name", "duplicates API key %q", apiKey.Name)
+		}
+		names[apiKey.Name] = true
+
+		v.secret(key+".key", apiKey.Key, MinClientSecretLength)
+		if _, err := uuid.Parse(apiKey.UserGUID); err != nil {
+			v.fail(key+".user_guid", "must be a GUID, got %q", apiKey.UserGUID)
+		}
+	}
+}
+
+func (c *Config) validateAccounts(v *validator) {
+	accounts := c.Accounts
+
+	if accounts.MinPasswordLength < MinPasswordLength {
+		v.fail("accounts.min_password_length", "must be at least %d, got %d",
+			MinPasswordLength, accounts.MinPasswordLength)
+	}
+
+	hashing := accounts.PasswordHashing
+
+	if hashing.Iterations < 1 {
+		v.fail("accounts.password_hashing.iterations", "must be positive, got %d", hashing.Iterations)
+	}
+	if hashing.Parallelism < 1 {
+		v.fail("accounts.password_hashing.parallelism", "must be positive, got %d", hashing.Parallelism)
+	}
+	// Argon2 needs 8 KiB for every lane.
+	if hashing.Memory < 8*uint32(hashing.Parallelism) || hashing.Memory < 8 {
+		v.fail("accounts.password_hashing.memory", "must be at least 8 KiB per lane of parallelism, got %d",
+			hashing.Memory)
+	}
+	if hashing.SaltLength < 8 {
+		v.fail("accounts.password_hashing.salt_length", "must be at least 8 bytes, got %d", hashing.SaltLength)
+	}
+	if hashing.KeyLength < 16 {
+		v.fail("accounts.password_hashing.key_length", "must be at least 16 bytes, got %d", hashing.KeyLength)
+	}
+	v.positiveInt("accounts.password_hashing.max_concurrent", hashing.MaxConcurrent)
+
+	v.clients("accounts.admin_clients", accounts.AdminClients)
+}
+
+func (c *Config) validateRateLimit(v *validator) {
+	limit := c.RateLimit
+	if !limit.Enabled {
+		return
+	}
+
+	v.oneOf("rate_limit.store", limit.Store, "memory", "postgres")
+	if limit.Store == "postgres" && c.Database.Driver != "postgres" {
+		v.fail("rate_limit.store", "postgres requires database.driver postgres, got %q", c.Database.Driver)
+	}
+
+	v.limit("rate_limit.ip", limit.IP)
+	v.limit("rate_limit.guid", limit.GUID)
+	v.limit("rate_limit.bind_key", limit.BindKey)
+
+	v.positiveInt("rate_limit.lockout.max_failures", limit.Lockout.MaxFailures)
+	v.positive("rate_limit.lockout.window", limit.Lockout.Window)
+	v.positive("rate_limit.lockout.duration", limit.Lockout.Duration)
+	v.positive("rate_limit.purge_interval", limit.PurgeInterval)
+}
+
+type validator struct {
+	errs []error
+}
+
+func (v *validator) fail(key string, format string, args ...any) {
+	v.errs = append(v.errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+}
+
+func (v *validator) set(key string, value string) {
+	if value == "" {
+		v.fail(key, "must be set")
+	}
+}
+
+func (v *validator) oneOf(key string, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+
+	v.fail(key, "must be one of %q, got %q", allowed, value)
+}
+
+func (v *validator) positive(key string, d time.Duration) {
+	if d <= 0 {
+		v.fail(key, "must be a positive duration such as \"10s\", got %s", d)
+	}
+}
+
+func (v *validator) nonNegative(key string, d time.Duration) {
+	if d < 0 {
+		v.fail(key, "must not be negative, got %s", d)
+	}
+}
+
+func (v *validator) positiveInt(key string, n int) {
+	if n <= 0 {
+		v.fail(key, "must be positive, got %d", n)
+	}
+}
+
+func (v *validator) port(key string, port int) {
+	if port < 1 || port > 65535 {
+		v.fail(key, "must be a port between 1 and 65535, got %d", port)
+	}
+}
+
+func (v *validator) host(key string, host string) {
+	if host == "" {
+		v.fail(key, "must be set")
+		return
+	}
+
+	if net.ParseIP(host) == nil && !hostnamePattern.MatchString(host) {
+		v.fail(key, "must be a hostname or an IP address, got %q", host)
+	}
+}
+
+func (v *validator) url(key string, value string) {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.fail(key, "must be an absolute http or https URL, got %q", value)
+	}
+}
+
+func (v *validator) limit(key string, l Limit) {
+	v.positiveInt(key+".requests", l.Requests)
+	v.positive(key+".period", l.Period)
+	if l.Burst < 0 {
+		v.fail(key+".burst", "must not be negative, got %d", l.Burst)
+	}
+}
+
+func (v *validator) prefix(key string, bits int, maxBits int) {
+	if bits < 1 || bits > maxBits {
+		v.fail(key, "must be a prefix length between 1 and %d, got %d", maxBits, bits)
+	}
+}
+
+// cidr accepts a CIDR or a bare IP.
+func (v *validator) cidr(key string, value string) {
+	if _, err := netip.ParsePrefix(value); err == nil {
+		return
+	}
+
+	if _, err := netip.ParseAddr(value); err != nil {
+		v.fail(key, "must be a CIDR such as \"10.0.0.0/8\" or an IP address, got %q", value)
+	}
+}
+
+// clients reports clients without an ID, with a duplicate one or with a
+// short secret.
+func (v *validator) clients(key string, clients []Client) {
+	ids := make(map[string]bool)
+	for i, client := range clients {
+		key := fmt.Sprintf("%s.%d", key, i)
+
+		if client.ID == "" {
+			v.fail(key+".id", "must be set")
+		} else if ids[client.ID] {
+			v.fail(key+".id", "duplicates client %q", client.ID)
+		}
+		ids[client.ID] = true
+
+		v.secret(key+".secret", client.Secret, MinClientSecretLength)
+	}
+}
+
+// secret reports short secrets by their length only, so the error can be
+// logged.
+func (v *validator) secret(key string, secret string, minLength int) {
+	if len(secret) < minLength {
+		v.fail(key, "must be at least %d characters long, got %d", minLength, len(secret))
+	}
+}
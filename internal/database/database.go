@@ -1,21 +1,154 @@
 package database
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
+
+	"auth/internal/config"
 )
 
 type RefreshClaims struct {
-	UserGUID  uuid.UUID
+	UserGUID      uuid.UUID
+	BindKey       string
+	FamilyID      uuid.UUID
+	ParentBindKey string
+	Hash          string
+	ExpiresAt     time.Time
+	IsRevoked     bool
+	// RotatedAt is when the token was revoked by rotation, zero if it was
+	// not rotated.
+	RotatedAt time.Time
+}
+
+// Family links a refresh token to the chain of rotations descended from
+// the token issued at login. The first token of a family has no parent.
+type Family struct {
+	ID            uuid.UUID
+	ParentBindKey string
+}
+
+func NewFamily() Family {
+	return Family{ID: uuid.New()}
+}
+
+// Child returns the family of the token that replaces this one on rotation.
+func (c RefreshClaims) Child() Family {
+	return Family{ID: c.FamilyID, ParentBindKey: c.BindKey}
+}
+
+// Client describes the client a refresh token was issued to.
+type Client struct {
+	IP        string
+	UserAgent string
+}
+
+// Session is a refresh token that is neither revoked nor expired.
+type Session struct {
+	ID        int64
 	BindKey   string
-	Hash      string
+	Client    Client
+	CreatedAt time.Time
 	ExpiresAt time.Time
-	IsRevoked bool
+}
+
+// User is an entry of the user directory: how the user signs in and where
+// and how the user wants to be notified.
+type User struct {
+	GUID           uuid.UUID
+	Email          string
+	EmailVerified  bool
+	Locale         string
+	NotifyIPChange bool
+
+	// Username and PasswordHash, an Argon2id hash, are the password
+	// credentials of the user. Users without a username have none.
+	Username     string
+	PasswordHash string
+	// Disabled users get no tokens and can not refresh the ones they have.
+	Disabled bool
+}
+
+// Email kinds.
+const (
+	EmailIPWarning   = "ip_warning"
+	EmailVerifyEmail = "verify_email"
+)
+
+// Email statuses.
+const (
+	EmailPending = "pending"
+	EmailSent    = "sent"
+	EmailDead    = "dead"
+)
+
+// Email is a message in the outbox. It is queued along with the change it
+// reports and delivered later by the dispatcher; Params hold what the
+// message of its Kind is rendered from.
+type Email struct {
+	ID            int64
+	Kind          string
+	To            string
+	Params        map[string]string
+	Status        string
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+	SentAt        time.Time
 }
 
 var (
 	ErrTokenNotFound = errors.New("token not found")
 	ErrTokenExists   = errors.New("token exists")
+	ErrTokenRevoked  = errors.New("token revoked")
+	ErrUserNotFound  = errors.New("user not found")
+	ErrUsernameTaken = errors.New("username taken")
+	ErrEmailNotFound = errors.New("email not found")
 )
+
+// Timeouts bound the duration of storage operations. A zero timeout leaves
+// the deadline to the caller's context.
+type Timeouts struct {
+	Read  time.Duration
+	Write time.Duration
+}
+
+func NewTimeouts(configDb config.Database) Timeouts {
+	return Timeouts{Read: configDb.ReadTimeout, Write: configDb.WriteTimeout}
+}
+
+// ReadContext returns the context a query runs with.
+func (t Timeouts) ReadContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, t.Read)
+}
+
+// WriteContext returns the context a statement that modifies data runs with.
+func (t Timeouts) WriteContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, t.Write)
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+func GenerateBindKey() (string, error) {
+	const op = "database.GenerateBindKey"
+
+	rb := make([]byte, 16)
+	_, err := rand.Read(rb)
+	if err != nil {
+		return "", fmt.Errorf("%s: Failed to get random bytes: %w", op, err)
+	}
+
+	return base64.URLEncoding.EncodeToString(rb), nil
+}
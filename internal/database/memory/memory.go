@@ -0,0 +1,470 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"auth/internal/config"
+	"auth/internal/database"
+	"auth/internal/lib/tokens"
+)
+
+const defaultSweepInterval = time.Minute
+
+type refreshToken struct {
+	id        int64
+	claims    database.RefreshClaims
+	client    database.Client
+	createdAt time.Time
+}
+
+// Database keeps refresh tokens and users in memory. Expired tokens are swept
+// periodically, so it is meant for development and tests.
+type Database struct {
+	mu     sync.RWMutex
+	nextID int64
+	tokens map[string]*refreshToken
+	// hashes indexes the bind keys of tokens by their hashes.
+	hashes map[string]string
+	users  map[uuid.UUID]database.User
+	// outbox holds queued emails in the order of their IDs.
+	outbox      []*database.Email
+	nextEmailID int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func New(configDb config.Database) *Database {
+	interval := configDb.SweepInterval
+	if interval <= 0 {
+		interval = defaultSweepInterval
+	}
+
+	d := &Database{
+		tokens: make(map[string]*refreshToken),
+		hashes: make(map[string]string),
+		users:  make(map[uuid.UUID]database.User),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	go d.sweep(interval)
+
+	return d
+}
+
+func (d *Database) Close() error {
+	close(d.stop)
+	<-d.done
+
+	return nil
+}
+
+func (d *Database) sweep(interval time.Duration) {
+	defer close(d.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case now := <-ticker.C:
+			d.deleteExpired(now)
+		}
+	}
+}
+
+func (d *Database) deleteExpired(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for bindKey, token := range d.tokens {
+		if token.claims.ExpiresAt.Before(now) {
+			delete(d.tokens, bindKey)
+			delete(d.hashes, token.claims.Hash)
+		}
+	}
+}
+
+func (d *Database) SaveRefreshToken(ctx context.Context, userGUID uuid.UUID, token string, family database.Family,
+	client database.Client, jwtConfig config.JWT) (string, error) {
+	const op = "database.memory.SaveRefreshToken"
+
+	hash := tokens.HashRefreshToken(token, jwtConfig.RefreshTokenPepper)
+
+	bindKey, err := database.GenerateBindKey()
+	if err != nil {
+		return "", fmt.Errorf("%s: Generating bind key error: %w", op, err)
+	}
+
+	createdAt := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.tokens[bindKey]; ok {
+		return "", fmt.Errorf("%s: %w", op, database.ErrTokenExists)
+	}
+
+	if _, ok := d.hashes[hash]; ok {
+		return "", fmt.Errorf("%s: %w", op, database.ErrTokenExists)
+	}
+
+	d.nextID++
+	d.tokens[bindKey] = &refreshToken{
+		id: d.nextID,
+		claims: database.RefreshClaims{
+			UserGUID:      userGUID,
+			BindKey:       bindKey,
+			FamilyID:      family.ID,
+			ParentBindKey: family.ParentBindKey,
+			Hash:          hash,
+			ExpiresAt:     createdAt.Add(jwtConfig.RefreshExpires),
+		},
+		client:    client,
+		createdAt: createdAt,
+	}
+	d.hashes[hash] = bindKey
+
+	return bindKey, nil
+}
+
+func (d *Database) GetRefreshToken(ctx context.Context, bindKey string) (database.RefreshClaims, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	token, ok := d.tokens[bindKey]
+	if !ok {
+		return database.RefreshClaims{}, database.ErrTokenNotFound
+	}
+
+	return token.claims, nil
+}
+
+func (d *Database) GetRefreshTokenByHash(ctx context.Context, hash string) (database.RefreshClaims, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	bindKey, ok := d.hashes[hash]
+	if !ok {
+		return database.RefreshClaims{}, database.ErrTokenNotFound
+	}
+
+	return d.tokens[bindKey].claims, nil
+}
+
+// RotateRefreshToken revokes the refresh token, saves its successor and
+// queues the emails under one lock, so of concurrent rotations only the
+// first one succeeds.
+func (d *Database) RotateRefreshToken(ctx context.Context, bindKey string, token string, client database.Client,
+	jwtConfig config.JWT, emails []database.Email) (string, error) {
+	const op = "database.memory.RotateRefreshToken"
+
+	hash := tokens.HashRefreshToken(token, jwtConfig.RefreshTokenPepper)
+
+	newBindKey, err := database.GenerateBindKey()
+	if err != nil {
+		return "", fmt.Errorf("%s: Generating bind key error: %w", op, err)
+	}
+
+	createdAt := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	parent, ok := d.tokens[bindKey]
+	if !ok {
+		return "", fmt.Errorf("%s: %w", op, database.ErrTokenNotFound)
+	}
+
+	if parent.claims.IsRevoked {
+		return "", fmt.Errorf("%s: %w", op, database.ErrTokenRevoked)
+	}
+
+	if _, ok := d.tokens[newBindKey]; ok {
+		return "", fmt.Errorf("%s: %w", op, database.ErrTokenExists)
+	}
+
+	if _, ok := d.hashes[hash]; ok {
+		return "", fmt.Errorf("%s: %w", op, database.ErrTokenExists)
+	}
+
+	d.nextID++
+	d.tokens[newBindKey] = &refreshToken{
+		id: d.nextID,
+		claims: database.RefreshClaims{
+			UserGUID:      parent.claims.UserGUID,
+			BindKey:       newBindKey,
+			FamilyID:      parent.claims.FamilyID,
+			ParentBindKey: bindKey,
+			Hash:          hash,
+			ExpiresAt:     createdAt.Add(jwtConfig.RefreshExpires),
+		},
+		client:    client,
+		createdAt: createdAt,
+	}
+	d.hashes[hash] = newBindKey
+
+	parent.claims.IsRevoked = true
+	parent.claims.RotatedAt = createdAt
+
+	d.queueEmails(emails, createdAt)
+
+	return newBindKey, nil
+}
+
+func (d *Database) RevokeRefreshToken(ctx context.Context, bindKey string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if token, ok := d.tokens[bindKey]; ok {
+		token.claims.IsRevoked = true
+	}
+
+	return nil
+}
+
+func (d *Database) RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	d.revoke(func(token *refreshToken) bool {
+		return token.claims.FamilyID == familyID
+	})
+
+	return nil
+}
+
+func (d *Database) RevokeUserRefreshTokens(ctx context.Context, userGUID uuid.UUID) error {
+	d.revoke(func(token *refreshToken) bool {
+		return token.claims.UserGUID == userGUID
+	})
+
+	return nil
+}
+
+func (d *Database) ListSessions(ctx context.Context, userGUID uuid.UUID) ([]database.Session, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	now := time.Now()
+	sessions := []database.Session{}
+
+	for _, token := range d.tokens {
+		if token.claims.UserGUID != userGUID || token.claims.IsRevoked || !token.claims.ExpiresAt.After(now) {
+			continue
+		}
+
+		sessions = append(sessions, database.Session{
+			ID:        token.id,
+			BindKey:   token.claims.BindKey,
+			Client:    token.client,
+			CreatedAt: token.createdAt,
+			ExpiresAt: token.claims.ExpiresAt,
+		})
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ID > sessions[j].ID
+	})
+
+	return sessions, nil
+}
+
+func (d *Database) RevokeSession(ctx context.Context, userGUID uuid.UUID, id int64) error {
+	const op = "database.memory.RevokeSession"
+
+	revoked := d.revoke(func(token *refreshToken) bool {
+		return token.id == id && token.claims.UserGUID == userGUID
+	})
+
+	if revoked == 0 {
+		return fmt.Errorf("%s: %w", op, database.ErrTokenNotFound)
+	}
+
+	return nil
+}
+
+// PurgeRefreshTokens deletes at most limit refresh tokens that expired before
+// expiredBefore and returns how many were deleted.
+func (d *Database) PurgeRefreshTokens(ctx context.Context, expiredBefore time.Time, limit int) (int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var deleted int64
+
+	for bindKey, token := range d.tokens {
+		if deleted >= int64(limit) {
+			break
+		}
+
+		if token.claims.ExpiresAt.Before(expiredBefore) {
+			delete(d.tokens, bindKey)
+			delete(d.hashes, token.claims.Hash)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+func (d *Database) GetUser(ctx context.Context, userGUID uuid.UUID) (database.User, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	user, ok := d.users[userGUID]
+	if !ok {
+		return database.User{}, database.ErrUserNotFound
+	}
+
+	return user, nil
+}
+
+func (d *Database) GetUserByUsername(ctx context.Context, username string) (database.User, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for _, user := range d.users {
+		if username != "" && user.Username == username {
+			return user, nil
+		}
+	}
+
+	return database.User{}, database.ErrUserNotFound
+}
+
+// SaveUser creates the user or replaces the entry with the same GUID.
+func (d *Database) SaveUser(ctx context.Context, user database.User) error {
+	const op = "database.memory.SaveUser"
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for guid, other := range d.users {
+		if user.Username != "" && other.Username == user.Username && guid != user.GUID {
+			return fmt.Errorf("%s: %w", op, database.ErrUsernameTaken)
+		}
+	}
+
+	d.users[user.GUID] = user
+
+	return nil
+}
+
+// CreateUser saves a new user and queues the emails, such as the email
+// verification, along with it.
+func (d *Database) CreateUser(ctx context.Context, user database.User, emails []database.Email) error {
+	const op = "database.memory.CreateUser"
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for guid, other := range d.users {
+		if guid == user.GUID || user.Username != "" && other.Username == user.Username {
+			return fmt.Errorf("%s: %w", op, database.ErrUsernameTaken)
+		}
+	}
+
+	d.users[user.GUID] = user
+	d.queueEmails(emails, time.Now())
+
+	return nil
+}
+
+// SetPasswordHash replaces the password hash of the user, as long as it is
+// still oldHash, and revokes every refresh token of the user if asked to.
+// ErrUserNotFound is returned if the user is missing or the hash has changed
+// meanwhile.
+func (d *Database) SetPasswordHash(ctx context.Context, userGUID uuid.UUID, oldHash string, newHash string,
+	revokeTokens bool) error {
+	const op = "database.memory.SetPasswordHash"
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	user, ok := d.users[userGUID]
+	if !ok || user.PasswordHash != oldHash {
+		return fmt.Errorf("%s: %w", op, database.ErrUserNotFound)
+	}
+
+	user.PasswordHash = newHash
+	d.users[userGUID] = user
+
+	if revokeTokens {
+		for _, token := range d.tokens {
+			if token.claims.UserGUID == userGUID {
+				token.claims.IsRevoked = true
+			}
+		}
+	}
+
+	return nil
+}
+
+// VerifyEmail marks the email of the user verified. It succeeds once:
+// ErrUserNotFound is returned if the user is missing, has another email or
+// has verified it already.
+func (d *Database) VerifyEmail(ctx context.Context, userGUID uuid.UUID, email string) error {
+	const op = "database.memory.VerifyEmail"
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	user, ok := d.users[userGUID]
+	if !ok || user.Email != email || user.EmailVerified {
+		return fmt.Errorf("%s: %w", op, database.ErrUserNotFound)
+	}
+
+	user.EmailVerified = true
+	d.users[userGUID] = user
+
+	return nil
+}
+
+func (d *Database) SetUserDisabled(ctx context.Context, userGUID uuid.UUID, disabled bool) error {
+	const op = "database.memory.SetUserDisabled"
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	user, ok := d.users[userGUID]
+	if !ok {
+		return fmt.Errorf("%s: %w", op, database.ErrUserNotFound)
+	}
+
+	user.Disabled = disabled
+	d.users[userGUID] = user
+
+	return nil
+}
+
+// IsUserDisabled tells whether the user is disabled. Users missing from the
+// directory are not.
+func (d *Database) IsUserDisabled(ctx context.Context, userGUID uuid.UUID) (bool, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.users[userGUID].Disabled, nil
+}
+
+// revoke marks the matching tokens revoked and returns how many matched.
+func (d *Database) revoke(match func(token *refreshToken) bool) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	matched := 0
+
+	for _, token := range d.tokens {
+		if match(token) {
+			token.claims.IsRevoked = true
+			matched++
+		}
+	}
+
+	return matched
+}
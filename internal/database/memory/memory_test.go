@@ -0,0 +1,15 @@
+package memory_test
+
+import (
+	"auth/internal/config"
+	"auth/internal/database/memory"
+	"auth/internal/database/storagetest"
+	"testing"
+)
+
+func TestDatabase(t *testing.T) {
+	database := memory.New(config.Database{})
+	t.Cleanup(func() { database.Close() })
+
+	storagetest.Run(t, database)
+}
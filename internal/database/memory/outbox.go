@@ -0,0 +1,163 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"auth/internal/database"
+)
+
+// queueEmails adds the emails to the outbox, the caller holds the lock.
+func (d *Database) queueEmails(emails []database.Email, now time.Time) {
+	for _, email := range emails {
+		d.nextEmailID++
+		d.outbox = append(d.outbox, &database.Email{
+			ID:            d.nextEmailID,
+			Kind:          email.Kind,
+			To:            email.To,
+			Params:        email.Params,
+			Status:        database.EmailPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+	}
+}
+
+// PendingEmails returns at most limit pending emails due at now, the ones
+// waiting longest first.
+func (d *Database) PendingEmails(ctx context.Context, now time.Time, limit int) ([]database.Email, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	var emails []database.Email
+
+	for _, email := range d.outbox {
+		if email.Status == database.EmailPending && !email.NextAttemptAt.After(now) {
+			emails = append(emails, *email)
+		}
+	}
+
+	sort.Slice(emails, func(i, j int) bool {
+		if !emails[i].NextAttemptAt.Equal(emails[j].NextAttemptAt) {
+			return emails[i].NextAttemptAt.Before(emails[j].NextAttemptAt)
+		}
+
+		return emails[i].ID < emails[j].ID
+	})
+
+	if len(emails) > limit {
+		emails = emails[:limit]
+	}
+
+	return emails, nil
+}
+
+// ListEmails returns at most limit emails with the status, all of them if
+// status is empty, the latest first.
+func (d *Database) ListEmails(ctx context.Context, status string, limit int) ([]database.Email, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	var emails []database.Email
+
+	for i := len(d.outbox) - 1; i >= 0 && len(emails) < limit; i-- {
+		if status == "" || d.outbox[i].Status == status {
+			emails = append(emails, *d.outbox[i])
+		}
+	}
+
+	return emails, nil
+}
+
+func (d *Database) MarkEmailSent(ctx context.Context, id int64) error {
+	const op = "database.memory.MarkEmailSent"
+
+	err := d.updateEmail(id, func(email *database.Email) bool {
+		email.Status = database.EmailSent
+		email.Attempts++
+		email.LastError = ""
+		email.SentAt = time.Now()
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RetryEmail records a failed delivery and schedules the next one.
+func (d *Database) RetryEmail(ctx context.Context, id int64, lastError string, nextAttemptAt time.Time) error {
+	const op = "database.memory.RetryEmail"
+
+	err := d.updateEmail(id, func(email *database.Email) bool {
+		email.Attempts++
+		email.LastError = lastError
+		email.NextAttemptAt = nextAttemptAt
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// MarkEmailDead records a failed delivery and gives up on the email.
+func (d *Database) MarkEmailDead(ctx context.Context, id int64, lastError string) error {
+	const op = "database.memory.MarkEmailDead"
+
+	err := d.updateEmail(id, func(email *database.Email) bool {
+		email.Status = database.EmailDead
+		email.Attempts++
+		email.LastError = lastError
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ReplayEmail queues a dead email again with a fresh set of attempts.
+func (d *Database) ReplayEmail(ctx context.Context, id int64) error {
+	const op = "database.memory.ReplayEmail"
+
+	err := d.updateEmail(id, func(email *database.Email) bool {
+		if email.Status != database.EmailDead {
+			return false
+		}
+
+		email.Status = database.EmailPending
+		email.Attempts = 0
+		email.NextAttemptAt = time.Now()
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// updateEmail applies update to the email with the ID, update reports
+// whether the email matched.
+func (d *Database) updateEmail(id int64, update func(email *database.Email) bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, email := range d.outbox {
+		if email.ID == id {
+			if !update(email) {
+				break
+			}
+
+			return nil
+		}
+	}
+
+	return database.ErrEmailNotFound
+}
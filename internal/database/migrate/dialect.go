@@ -0,0 +1,39 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+)
+
+// postgresLockID is the advisory lock key held while migrating, so replicas
+// started at the same time apply migrations one after another.
+const postgresLockID = 7244052871939042101
+
+var Postgres = Dialect{
+	Placeholder: func(n int) string {
+		return "$" + strconv.Itoa(n)
+	},
+	Lock: func(ctx context.Context, conn *sql.Conn) error {
+		_, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1);`, int64(postgresLockID))
+		return err
+	},
+	Unlock: func(ctx context.Context, conn *sql.Conn) error {
+		_, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1);`, int64(postgresLockID))
+		return err
+	},
+}
+
+// SQLite serializes writers itself and is used by a single node, so the
+// migrator takes no extra lock.
+var SQLite = Dialect{
+	Placeholder: func(n int) string {
+		return "?"
+	},
+	Lock: func(ctx context.Context, conn *sql.Conn) error {
+		return nil
+	},
+	Unlock: func(ctx context.Context, conn *sql.Conn) error {
+		return nil
+	},
+}
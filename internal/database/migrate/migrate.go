@@ -0,0 +1,308 @@
+// Package migrate applies versioned schema migrations. Migrations are SQL
+// files named <version>_<name>.up.sql and <version>_<name>.down.sql; the
+// applied versions are recorded in the schema_migrations table.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var ErrSchemaOutdated = errors.New("database schema is outdated, run migrate up")
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Migration
+	AppliedAt time.Time
+	Applied   bool
+}
+
+// Dialect holds the database specific parts of the migrator.
+type Dialect struct {
+	// Placeholder returns the placeholder of the n-th query argument.
+	Placeholder func(n int) string
+	// Lock and Unlock guard migrations against concurrent migrators,
+	// both are called on the connection the migrations run on.
+	Lock   func(ctx context.Context, conn *sql.Conn) error
+	Unlock func(ctx context.Context, conn *sql.Conn) error
+}
+
+type Migrator struct {
+	db         *sql.DB
+	dialect    Dialect
+	migrations []Migration
+}
+
+// Load reads the migrations from the directory of fsys.
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	const op = "database.migrate.Load"
+
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("%s: Reading migrations error: %w", op, err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("%s: Unexpected migration file: %s", op, entry.Name())
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: Invalid migration version: %s: %w", op, entry.Name(), err)
+		}
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("%s: Reading migration error: %w", op, err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("%s: Migrations %s and %s share version %d", op,
+				migration.Name, match[2], version)
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(data)
+		} else {
+			migration.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("%s: Migration %d_%s has no up script", op, migration.Version, migration.Name)
+		}
+
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+func New(db *sql.DB, dialect Dialect, migrations []Migration) *Migrator {
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}
+}
+
+// Up applies every pending migration and returns the applied ones.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	const op = "database.migrate.Up"
+
+	var applied []Migration
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		versions, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := versions[migration.Version]; ok {
+				continue
+			}
+
+			err = m.run(ctx, conn, migration.Up,
+				`INSERT INTO schema_migrations (version, name, applied_at) VALUES (`+
+					m.dialect.Placeholder(1)+`, `+m.dialect.Placeholder(2)+`, `+m.dialect.Placeholder(3)+`);`,
+				migration.Version, migration.Name, time.Now().UTC())
+			if err != nil {
+				return fmt.Errorf("Applying migration %d_%s error: %w", migration.Version, migration.Name, err)
+			}
+
+			applied = append(applied, migration)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return applied, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return applied, nil
+}
+
+// Down rolls back the given number of the latest applied migrations and
+// returns the rolled back ones.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	const op = "database.migrate.Down"
+
+	var rolledBack []Migration
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		versions, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && len(rolledBack) < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := versions[migration.Version]; !ok {
+				continue
+			}
+
+			if migration.Down == "" {
+				return fmt.Errorf("Migration %d_%s can not be rolled back", migration.Version, migration.Name)
+			}
+
+			err = m.run(ctx, conn, migration.Down,
+				`DELETE FROM schema_migrations WHERE version = `+m.dialect.Placeholder(1)+`;`,
+				migration.Version)
+			if err != nil {
+				return fmt.Errorf("Rolling back migration %d_%s error: %w", migration.Version, migration.Name, err)
+			}
+
+			rolledBack = append(rolledBack, migration)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return rolledBack, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return rolledBack, nil
+}
+
+// Status reports every known migration and whether it is applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	const op = "database.migrate.Status"
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: Connecting error: %w", op, err)
+	}
+	defer conn.Close()
+
+	versions, err := m.applied(ctx, conn)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	status := make([]Status, 0, len(m.migrations))
+
+	for _, migration := range m.migrations {
+		appliedAt, ok := versions[migration.Version]
+		status = append(status, Status{Migration: migration, AppliedAt: appliedAt, Applied: ok})
+	}
+
+	return status, nil
+}
+
+// Check returns ErrSchemaOutdated if any migration is pending.
+func (m *Migrator) Check(ctx context.Context) error {
+	const op = "database.migrate.Check"
+
+	status, err := m.Status(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, migration := range status {
+		if !migration.Applied {
+			return fmt.Errorf("%s: %w", op, ErrSchemaOutdated)
+		}
+	}
+
+	return nil
+}
+
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("Connecting error: %w", err)
+	}
+	defer conn.Close()
+
+	err = m.dialect.Lock(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("Acquiring migration lock error: %w", err)
+	}
+	defer m.dialect.Unlock(context.WithoutCancel(ctx), conn)
+
+	return fn(conn)
+}
+
+// applied returns the applied versions and when they were applied.
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	_, err := conn.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations(
+		version BIGINT PRIMARY KEY,
+		name VARCHAR NOT NULL,
+		applied_at TIMESTAMP NOT NULL);`)
+	if err != nil {
+		return nil, fmt.Errorf("Creating schema_migrations error: %w", err)
+	}
+
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations;`)
+	if err != nil {
+		return nil, fmt.Errorf("Reading schema_migrations error: %w", err)
+	}
+	defer rows.Close()
+
+	versions := make(map[int64]time.Time)
+
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("Scanning schema_migrations error: %w", err)
+		}
+
+		versions[version] = appliedAt
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Reading schema_migrations error: %w", err)
+	}
+
+	return versions, nil
+}
+
+// run executes the script and records it in one transaction.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, script string, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
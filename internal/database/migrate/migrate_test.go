@@ -0,0 +1,99 @@
+package migrate_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+
+	"auth/internal/database/migrate"
+)
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+
+	fsys := fstest.MapFS{
+		"migrations/0001_create_users.up.sql":    {Data: []byte(`CREATE TABLE users(id INTEGER PRIMARY KEY);`)},
+		"migrations/0001_create_users.down.sql":  {Data: []byte(`DROP TABLE users;`)},
+		"migrations/0002_add_user_name.up.sql":   {Data: []byte(`ALTER TABLE users ADD COLUMN name TEXT;`)},
+		"migrations/0002_add_user_name.down.sql": {Data: []byte(`ALTER TABLE users DROP COLUMN name;`)},
+	}
+
+	migrations, err := migrate.Load(fsys, "migrations")
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+	require.Equal(t, int64(1), migrations[0].Version)
+	require.Equal(t, "add_user_name", migrations[1].Name)
+
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "auth.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	migrator := migrate.New(db, migrate.SQLite, migrations)
+
+	err = migrator.Check(ctx)
+	require.True(t, errors.Is(err, migrate.ErrSchemaOutdated))
+
+	applied, err := migrator.Up(ctx)
+	require.NoError(t, err)
+	require.Len(t, applied, 2)
+	require.NoError(t, migrator.Check(ctx))
+
+	_, err = db.Exec(`INSERT INTO users (id, name) VALUES (1, 'user');`)
+	require.NoError(t, err)
+
+	applied, err = migrator.Up(ctx)
+	require.NoError(t, err)
+	require.Empty(t, applied)
+
+	rolledBack, err := migrator.Down(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, rolledBack, 1)
+	require.Equal(t, int64(2), rolledBack[0].Version)
+
+	status, err := migrator.Status(ctx)
+	require.NoError(t, err)
+	require.True(t, status[0].Applied)
+	require.False(t, status[0].AppliedAt.IsZero())
+	require.False(t, status[1].Applied)
+
+	_, err = db.Exec(`INSERT INTO users (id, name) VALUES (2, 'user');`)
+	require.Error(t, err)
+
+	rolledBack, err = migrator.Down(ctx, 5)
+	require.NoError(t, err)
+	require.Len(t, rolledBack, 1)
+}
+
+func TestLoadRejectsInvalidFiles(t *testing.T) {
+	cases := []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{
+			name: "Unexpected file name",
+			fsys: fstest.MapFS{"migrations/create_users.sql": {}},
+		},
+		{
+			name: "Shared version",
+			fsys: fstest.MapFS{
+				"migrations/0001_create_users.up.sql":  {Data: []byte(`SELECT 1;`)},
+				"migrations/0001_create_groups.up.sql": {Data: []byte(`SELECT 1;`)},
+			},
+		},
+		{
+			name: "Missing up script",
+			fsys: fstest.MapFS{"migrations/0001_create_users.down.sql": {Data: []byte(`SELECT 1;`)}},
+		},
+	}
+
+	for _, tc := range cases {
+		_, err := migrate.Load(tc.fsys, "migrations")
+		require.Error(t, err, "Case: %s", tc.name)
+	}
+}
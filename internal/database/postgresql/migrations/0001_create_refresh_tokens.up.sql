@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS refresh_tokens(
+    id SERIAL PRIMARY KEY,
+    user_GUID UUID NOT NULL,
+    bind_key VARCHAR NOT NULL UNIQUE,
+    hash VARCHAR NOT NULL UNIQUE,
+    expires_at timestamp with time zone NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    is_revoked boolean default(false));
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS refresh_tokens_family_id_idx;
+ALTER TABLE refresh_tokens DROP COLUMN parent_bind_key;
+ALTER TABLE refresh_tokens DROP COLUMN family_id;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS refresh_tokens_user_guid_idx ON refresh_tokens (user_GUID);
@@ -0,0 +1,2 @@
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS ip VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS user_agent VARCHAR NOT NULL DEFAULT '';
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS users(
+    guid UUID PRIMARY KEY,
+    email VARCHAR NOT NULL DEFAULT '',
+    email_verified boolean NOT NULL DEFAULT false,
+    locale VARCHAR NOT NULL DEFAULT '',
+    notify_ip_change boolean NOT NULL DEFAULT true);
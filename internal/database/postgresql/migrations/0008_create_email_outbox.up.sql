@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS email_outbox(
+    id BIGSERIAL PRIMARY KEY,
+    kind VARCHAR NOT NULL,
+    recipient VARCHAR NOT NULL,
+    params TEXT NOT NULL DEFAULT '{}',
+    status VARCHAR NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    next_attempt_at timestamp with time zone NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    sent_at timestamp with time zone);
+CREATE INDEX IF NOT EXISTS email_outbox_pending_idx ON email_outbox (next_attempt_at) WHERE status = 'pending';
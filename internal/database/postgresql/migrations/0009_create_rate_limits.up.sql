@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS rate_limits(
+    key VARCHAR PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at timestamp with time zone,
+    locked_until timestamp with time zone,
+    expires_at timestamp with time zone NOT NULL);
+CREATE INDEX IF NOT EXISTS rate_limits_expires_at_idx ON rate_limits (expires_at);
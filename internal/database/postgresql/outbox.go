@@ -0,0 +1,198 @@
+package postgresql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"auth/internal/database"
+)
+
+const emailColumns = `id, kind, recipient, params, status, attempts, last_error, next_attempt_at, created_at, sent_at`
+
+// insertEmails queues the emails within tx, so they are sent only if the
+// change they report is committed.
+func insertEmails(ctx context.Context, tx *sql.Tx, emails []database.Email, now time.Time) error {
+	for _, email := range emails {
+		params, err := json.Marshal(email.Params)
+		if err != nil {
+			return fmt.Errorf("Encoding params error: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx, `
+		INSERT INTO email_outbox (kind, recipient, params, next_attempt_at, created_at)
+		VALUES ($1, $2, $3, $4, $4);`, email.Kind, email.To, string(params), now)
+		if err != nil {
+			return fmt.Errorf("Queueing email error: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// PendingEmails returns at most limit pending emails due at now, the ones
+// waiting longest first.
+func (d *Database) PendingEmails(ctx context.Context, now time.Time, limit int) ([]database.Email, error) {
+	const op = "database.postgresql.PendingEmails"
+
+	ctx, cancel := d.timeouts.ReadContext(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, `SELECT `+emailColumns+`
+	FROM email_outbox
+	WHERE status = 'pending' AND next_attempt_at <= $1
+	ORDER BY next_attempt_at, id
+	LIMIT $2;`, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: Executing statement error: %w", op, err)
+	}
+
+	emails, err := scanEmails(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return emails, nil
+}
+
+// ListEmails returns at most limit emails with the status, all of them if
+// status is empty, the latest first.
+func (d *Database) ListEmails(ctx context.Context, status string, limit int) ([]database.Email, error) {
+	const op = "database.postgresql.ListEmails"
+
+	ctx, cancel := d.timeouts.ReadContext(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, `SELECT `+emailColumns+`
+	FROM email_outbox
+	WHERE $1::text = '' OR status = $1::text
+	ORDER BY id DESC
+	LIMIT $2;`, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: Executing statement error: %w", op, err)
+	}
+
+	emails, err := scanEmails(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return emails, nil
+}
+
+func (d *Database) MarkEmailSent(ctx context.Context, id int64) error {
+	const op = "database.postgresql.MarkEmailSent"
+
+	err := d.updateEmail(ctx, `
+	UPDATE email_outbox
+	SET status = 'sent', attempts = attempts + 1, last_error = '', sent_at = $2
+	WHERE id = $1;`, id, time.Now())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RetryEmail records a failed delivery and schedules the next one.
+func (d *Database) RetryEmail(ctx context.Context, id int64, lastError string, nextAttemptAt time.Time) error {
+	const op = "database.postgresql.RetryEmail"
+
+	err := d.updateEmail(ctx, `
+	UPDATE email_outbox
+	SET attempts = attempts + 1, last_error = $2, next_attempt_at = $3
+	WHERE id = $1;`, id, lastError, nextAttemptAt)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// MarkEmailDead records a failed delivery and gives up on the email.
+func (d *Database) MarkEmailDead(ctx context.Context, id int64, lastError string) error {
+	const op = "database.postgresql.MarkEmailDead"
+
+	err := d.updateEmail(ctx, `
+	UPDATE email_outbox
+	SET status = 'dead', attempts = attempts + 1, last_error = $2
+	WHERE id = $1;`, id, lastError)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ReplayEmail queues a dead email again with a fresh set of attempts.
+func (d *Database) ReplayEmail(ctx context.Context, id int64) error {
+	const op = "database.postgresql.ReplayEmail"
+
+	err := d.updateEmail(ctx, `
+	UPDATE email_outbox
+	SET status = 'pending', attempts = 0, next_attempt_at = $2
+	WHERE id = $1 AND status = 'dead';`, id, time.Now())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// updateEmail runs the statement updating a single email.
+func (d *Database) updateEmail(ctx context.Context, query string, args ...any) error {
+	ctx, cancel := d.timeouts.WriteContext(ctx)
+	defer cancel()
+
+	result, err := d.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("Executing statement error: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Reading result error: %w", err)
+	}
+
+	if affected == 0 {
+		return database.ErrEmailNotFound
+	}
+
+	return nil
+}
+
+func scanEmails(rows *sql.Rows) ([]database.Email, error) {
+	defer rows.Close()
+
+	var emails []database.Email
+
+	for rows.Next() {
+		var email database.Email
+		var params string
+		var sentAt sql.NullTime
+
+		err := rows.Scan(&email.ID, &email.Kind, &email.To, &params, &email.Status, &email.Attempts,
+			&email.LastError, &email.NextAttemptAt, &email.CreatedAt, &sentAt)
+		if err != nil {
+			return nil, fmt.Errorf("Reading row error: %w", err)
+		}
+
+		if err := json.Unmarshal([]byte(params), &email.Params); err != nil {
+			return nil, fmt.Errorf("Decoding params error: %w", err)
+		}
+
+		email.NextAttemptAt = email.NextAttemptAt.Local()
+		email.CreatedAt = email.CreatedAt.Local()
+		email.SentAt = localTime(sentAt)
+
+		emails = append(emails, email)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Reading rows error: %w", err)
+	}
+
+	return emails, nil
+}
@@ -1,28 +1,62 @@
 package postgresql
 
 import (
-	"crypto/rand"
+	"context"
 	"database/sql"
-	"encoding/base64"
+	"database/sql/driver"
+	"embed"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/lib/pq"
-	"golang.org/x/crypto/bcrypt"
 
 	"auth/internal/config"
 	"auth/internal/database"
+	"auth/internal/database/migrate"
+	"auth/internal/lib/tokens"
 )
 
 type Database struct {
-	db *sql.DB
+	db       *sql.DB
+	timeouts database.Timeouts
 }
 
+//go:embed migrations/*.sql
+var migrations embed.FS
+
 func New(configDb config.Database) (*Database, error) {
 	const op = "database.postgresql.New"
 
+	db, err := Open(configDb)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if configDb.ManualMigrations {
+		err = migrator.Check(context.Background())
+	} else {
+		_, err = migrator.Up(context.Background())
+	}
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Database{db: db, timeouts: database.NewTimeouts(configDb)}, nil
+}
+
+func Open(configDb config.Database) (*sql.DB, error) {
+	const op = "database.postgresql.Open"
+
 	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s "+"password=%s dbname=%s sslmode=disable",
 		configDb.Host, configDb.Port, configDb.User, configDb.Password, configDb.Name)
 
@@ -31,45 +65,43 @@ func New(configDb config.Database) (*Database, error) {
 		return nil, fmt.Errorf("%s: Unable to connect: %w", op, err)
 	}
 
-	stmt, err := db.Prepare(`
-    CREATE TABLE IF NOT EXISTS refresh_tokens(
-        id SERIAL PRIMARY KEY,
-		user_GUID UUID NOT NULL,
-		bind_key VARCHAR NOT NULL UNIQUE,
-        hash VARCHAR NOT NULL UNIQUE,
-		expires_at timestamp with time zone NOT NULL,
-        created_at timestamp with time zone NOT NULL,
-		is_revoked boolean default(false));`)
+	return db, nil
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("%s: Preparing statement error: %w", op, err)
-	}
+func NewMigrator(db *sql.DB) (*migrate.Migrator, error) {
+	const op = "database.postgresql.NewMigrator"
 
-	_, err = stmt.Exec()
+	list, err := migrate.Load(migrations, "migrations")
 	if err != nil {
-		return nil, fmt.Errorf("%s: Executing statement error: %w", op, err)
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return &Database{db: db}, nil
+	return migrate.New(db, migrate.Postgres, list), nil
+}
+
+func (d *Database) Close() error {
+	return d.db.Close()
 }
 
-func (d *Database) SaveRefreshToken(userGUID uuid.UUID, token string, jwtConfig config.JWT) (string, error) {
+func (d *Database) SaveRefreshToken(ctx context.Context, userGUID uuid.UUID, token string, family database.Family,
+	client database.Client, jwtConfig config.JWT) (string, error) {
 	const op = "database.postgresql.SaveRefreshToken"
 
-	stmt, err := d.db.Prepare(`
-	INSERT INTO refresh_tokens (user_GUID, hash, bind_key, expires_at, created_at) 
-	VALUES ($1, $2, $3, $4, $5);`)
+	ctx, cancel := d.timeouts.WriteContext(ctx)
+	defer cancel()
+
+	stmt, err := d.db.PrepareContext(ctx, `
+	INSERT INTO refresh_tokens (user_GUID, hash, bind_key, family_id, parent_bind_key, ip, user_agent, 
+		expires_at, created_at) 
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);`)
 
 	if err != nil {
 		return "", fmt.Errorf("%s: Preparing statement error: %w", op, err)
 	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(token), 10)
-	if err != nil {
-		return "", fmt.Errorf("%s: Creating token hash error: %w", op, err)
-	}
+	hash := tokens.HashRefreshToken(token, jwtConfig.RefreshTokenPepper)
 
-	bind_key, err := GenerateBindKey()
+	bind_key, err := database.GenerateBindKey()
 	if err != nil {
 		return "", fmt.Errorf("%s: Generating bind key error: %w", op, err)
 	}
@@ -77,7 +109,10 @@ func (d *Database) SaveRefreshToken(userGUID uuid.UUID, token string, jwtConfig
 	created_at := time.Now()
 	expires_at := created_at.Add(jwtConfig.RefreshExpires)
 
-	_, err = stmt.Exec(userGUID, hash, bind_key, expires_at, created_at)
+	parentBindKey := sql.NullString{String: family.ParentBindKey, Valid: family.ParentBindKey != ""}
+
+	_, err = stmt.ExecContext(ctx, userGUID, hash, bind_key, family.ID, parentBindKey, client.IP, client.UserAgent,
+		expires_at, created_at)
 	if err != nil {
 		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
 			return "", fmt.Errorf("%s: %w", op, database.ErrTokenExists)
@@ -89,21 +124,76 @@ func (d *Database) SaveRefreshToken(userGUID uuid.UUID, token string, jwtConfig
 	return bind_key, nil
 }
 
-func (d *Database) GetRefreshToken(bindKey string) (database.RefreshClaims, error) {
+func (d *Database) GetRefreshToken(ctx context.Context, bindKey string) (database.RefreshClaims, error) {
 	const op = "database.postgresql.GetRefreshToken"
 
-	stmt, err := d.db.Prepare(`SELECT user_GUID, hash, expires_at, is_revoked 
+	ctx, cancel := d.timeouts.ReadContext(ctx)
+	defer cancel()
+
+	stmt, err := d.db.PrepareContext(ctx, `SELECT user_GUID, family_id, parent_bind_key, hash, expires_at, is_revoked,
+		rotated_at
 		FROM refresh_tokens WHERE bind_key = $1;`)
 	if err != nil {
 		return database.RefreshClaims{}, fmt.Errorf("%s: Preparing statement error: %w", op, err)
 	}
 
 	var userGUID uuid.UUID
+	var familyID uuid.UUID
+	var parentBindKey sql.NullString
 	var hash string
 	var expiresAt time.Time
 	var isRevoked bool
+	var rotatedAt sql.NullTime
+
+	err = stmt.QueryRowContext(ctx, bindKey).Scan(&userGUID, &familyID, &parentBindKey, &hash, &expiresAt, &isRevoked,
+		&rotatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.RefreshClaims{}, database.ErrTokenNotFound
+	}
+
+	if err != nil {
+		return database.RefreshClaims{}, fmt.Errorf("%s: Executing statement error: %w", op, err)
+	}
+
+	refreshToken := database.RefreshClaims{
+		UserGUID:      userGUID,
+		FamilyID:      familyID,
+		ParentBindKey: parentBindKey.String,
+		Hash:          hash,
+		BindKey:       bindKey,
+		ExpiresAt:     expiresAt.Local(),
+		IsRevoked:     isRevoked,
+		RotatedAt:     localTime(rotatedAt),
+	}
+
+	return refreshToken, nil
+}
+
+// GetRefreshTokenByHash finds a refresh token by its keyed hash. Tokens
+// with legacy bcrypt hashes can not be found this way.
+func (d *Database) GetRefreshTokenByHash(ctx context.Context, hash string) (database.RefreshClaims, error) {
+	const op = "database.postgresql.GetRefreshTokenByHash"
+
+	ctx, cancel := d.timeouts.ReadContext(ctx)
+	defer cancel()
+
+	stmt, err := d.db.PrepareContext(ctx, `SELECT user_GUID, bind_key, family_id, parent_bind_key, expires_at, is_revoked,
+		rotated_at
+		FROM refresh_tokens WHERE hash = $1;`)
+	if err != nil {
+		return database.RefreshClaims{}, fmt.Errorf("%s: Preparing statement error: %w", op, err)
+	}
+
+	var userGUID uuid.UUID
+	var bindKey string
+	var familyID uuid.UUID
+	var parentBindKey sql.NullString
+	var expiresAt time.Time
+	var isRevoked bool
+	var rotatedAt sql.NullTime
 
-	err = stmt.QueryRow(bindKey).Scan(&userGUID, &hash, &expiresAt, &isRevoked)
+	err = stmt.QueryRowContext(ctx, hash).Scan(&userGUID, &bindKey, &familyID, &parentBindKey, &expiresAt, &isRevoked,
+		&rotatedAt)
 	if errors.Is(err, sql.ErrNoRows) {
 		return database.RefreshClaims{}, database.ErrTokenNotFound
 	}
@@ -113,20 +203,101 @@ func (d *Database) GetRefreshToken(bindKey string) (database.RefreshClaims, erro
 	}
 
 	refreshToken := database.RefreshClaims{
-		UserGUID:  userGUID,
-		Hash:      hash,
-		BindKey:   bindKey,
-		ExpiresAt: expiresAt.Local(),
-		IsRevoked: isRevoked,
+		UserGUID:      userGUID,
+		FamilyID:      familyID,
+		ParentBindKey: parentBindKey.String,
+		Hash:          hash,
+		BindKey:       bindKey,
+		ExpiresAt:     expiresAt.Local(),
+		IsRevoked:     isRevoked,
+		RotatedAt:     localTime(rotatedAt),
 	}
 
 	return refreshToken, nil
 }
 
-func (d *Database) RevokeRefreshToken(bindKey string) error {
+// RotateRefreshToken revokes the refresh token, saves its successor and
+// queues the emails in one transaction. The row of the token stays locked
+// until the transaction ends, so of concurrent rotations only the first one
+// succeeds, the others get ErrTokenRevoked.
+func (d *Database) RotateRefreshToken(ctx context.Context, bindKey string, token string, client database.Client,
+	jwtConfig config.JWT, emails []database.Email) (string, error) {
+	const op = "database.postgresql.RotateRefreshToken"
+
+	ctx, cancel := d.timeouts.WriteContext(ctx)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("%s: Beginning transaction error: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var userGUID uuid.UUID
+	var familyID uuid.UUID
+	var isRevoked bool
+
+	err = tx.QueryRowContext(ctx, `SELECT user_GUID, family_id, is_revoked
+		FROM refresh_tokens WHERE bind_key = $1 FOR UPDATE;`, bindKey).Scan(&userGUID, &familyID, &isRevoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("%s: %w", op, database.ErrTokenNotFound)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("%s: Locking refresh token error: %w", op, err)
+	}
+
+	if isRevoked {
+		return "", fmt.Errorf("%s: %w", op, database.ErrTokenRevoked)
+	}
+
+	newBindKey, err := database.GenerateBindKey()
+	if err != nil {
+		return "", fmt.Errorf("%s: Generating bind key error: %w", op, err)
+	}
+
+	createdAt := time.Now()
+
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO refresh_tokens (user_GUID, hash, bind_key, family_id, parent_bind_key, ip, user_agent,
+		expires_at, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);`,
+		userGUID, tokens.HashRefreshToken(token, jwtConfig.RefreshTokenPepper), newBindKey, familyID, bindKey,
+		client.IP, client.UserAgent, createdAt.Add(jwtConfig.RefreshExpires), createdAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return "", fmt.Errorf("%s: %w", op, database.ErrTokenExists)
+		}
+
+		return "", fmt.Errorf("%s: Saving refresh token error: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	UPDATE refresh_tokens
+	SET is_revoked = true, rotated_at = $2
+	WHERE bind_key = $1;`, bindKey, createdAt)
+	if err != nil {
+		return "", fmt.Errorf("%s: Revoking refresh token error: %w", op, err)
+	}
+
+	if err = insertEmails(ctx, tx, emails, createdAt); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", fmt.Errorf("%s: Committing transaction error: %w", op, err)
+	}
+
+	return newBindKey, nil
+}
+
+func (d *Database) RevokeRefreshToken(ctx context.Context, bindKey string) error {
 	const op = "database.postgresql.GetRefreshToken"
 
-	stmt, err := d.db.Prepare(`
+	ctx, cancel := d.timeouts.WriteContext(ctx)
+	defer cancel()
+
+	stmt, err := d.db.PrepareContext(ctx, `
 	UPDATE refresh_tokens
 	SET is_revoked = true
 	WHERE bind_key = $1;`)
@@ -135,7 +306,7 @@ func (d *Database) RevokeRefreshToken(bindKey string) error {
 		"%s": Preparing statement error: %w`, op, bindKey, err)
 	}
 
-	_, err = stmt.Exec(bindKey)
+	_, err = stmt.ExecContext(ctx, bindKey)
 	if errors.Is(err, sql.ErrNoRows) {
 		return database.ErrTokenNotFound
 	}
@@ -148,14 +319,446 @@ func (d *Database) RevokeRefreshToken(bindKey string) error {
 	return nil
 }
 
-func GenerateBindKey() (string, error) {
-	const op = "database.postgresql.GenerateBindKey"
+func (d *Database) RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	const op = "database.postgresql.RevokeRefreshTokenFamily"
+
+	ctx, cancel := d.timeouts.WriteContext(ctx)
+	defer cancel()
+
+	stmt, err := d.db.PrepareContext(ctx, `
+	UPDATE refresh_tokens
+	SET is_revoked = true
+	WHERE family_id = $1 AND NOT is_revoked;`)
+	if err != nil {
+		return fmt.Errorf(`%s: Unable to revoke refresh token family: 
+		"%s": Preparing statement error: %w`, op, familyID, err)
+	}
+
+	_, err = stmt.ExecContext(ctx, familyID)
+	if err != nil {
+		return fmt.Errorf(`%s: Unable to revoke refresh token family: 
+		"%s": Executing statement error: %w`, op, familyID, err)
+	}
+
+	return nil
+}
+
+func (d *Database) RevokeUserRefreshTokens(ctx context.Context, userGUID uuid.UUID) error {
+	const op = "database.postgresql.RevokeUserRefreshTokens"
+
+	ctx, cancel := d.timeouts.WriteContext(ctx)
+	defer cancel()
+
+	stmt, err := d.db.PrepareContext(ctx, `
+	UPDATE refresh_tokens
+	SET is_revoked = true
+	WHERE user_GUID = $1 AND NOT is_revoked;`)
+	if err != nil {
+		return fmt.Errorf(`%s: Unable to revoke refresh tokens of user: 
+		"%s": Preparing statement error: %w`, op, userGUID, err)
+	}
+
+	_, err = stmt.ExecContext(ctx, userGUID)
+	if err != nil {
+		return fmt.Errorf(`%s: Unable to revoke refresh tokens of user: 
+		"%s": Executing statement error: %w`, op, userGUID, err)
+	}
+
+	return nil
+}
+
+func (d *Database) ListSessions(ctx context.Context, userGUID uuid.UUID) ([]database.Session, error) {
+	const op = "database.postgresql.ListSessions"
+
+	ctx, cancel := d.timeouts.ReadContext(ctx)
+	defer cancel()
+
+	stmt, err := d.db.PrepareContext(ctx, `
+	SELECT id, bind_key, ip, user_agent, created_at, expires_at
+	FROM refresh_tokens
+	WHERE user_GUID = $1 AND NOT is_revoked AND expires_at > now()
+	ORDER BY created_at DESC;`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: Preparing statement error: %w", op, err)
+	}
+
+	rows, err := stmt.QueryContext(ctx, userGUID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: Executing statement error: %w", op, err)
+	}
+	defer rows.Close()
+
+	sessions := []database.Session{}
+
+	for rows.Next() {
+		var session database.Session
+
+		err = rows.Scan(&session.ID, &session.BindKey, &session.Client.IP, &session.Client.UserAgent,
+			&session.CreatedAt, &session.ExpiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("%s: Scanning row error: %w", op, err)
+		}
+
+		session.CreatedAt = session.CreatedAt.Local()
+		session.ExpiresAt = session.ExpiresAt.Local()
+
+		sessions = append(sessions, session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: Reading rows error: %w", op, err)
+	}
+
+	return sessions, nil
+}
+
+func (d *Database) RevokeSession(ctx context.Context, userGUID uuid.UUID, id int64) error {
+	const op = "database.postgresql.RevokeSession"
+
+	ctx, cancel := d.timeouts.WriteContext(ctx)
+	defer cancel()
+
+	stmt, err := d.db.PrepareContext(ctx, `
+	UPDATE refresh_tokens
+	SET is_revoked = true
+	WHERE id = $1 AND user_GUID = $2;`)
+	if err != nil {
+		return fmt.Errorf("%s: Preparing statement error: %w", op, err)
+	}
+
+	result, err := stmt.ExecContext(ctx, id, userGUID)
+	if err != nil {
+		return fmt.Errorf("%s: Executing statement error: %w", op, err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: Reading result error: %w", op, err)
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, database.ErrTokenNotFound)
+	}
+
+	return nil
+}
+
+// PurgeRefreshTokens deletes at most limit refresh tokens that expired before
+// expiredBefore and returns how many were deleted.
+func (d *Database) PurgeRefreshTokens(ctx context.Context, expiredBefore time.Time, limit int) (int64, error) {
+	const op = "database.postgresql.PurgeRefreshTokens"
+
+	ctx, cancel := d.timeouts.WriteContext(ctx)
+	defer cancel()
+
+	result, err := d.db.ExecContext(ctx, `
+	DELETE FROM refresh_tokens
+	WHERE id IN (
+		SELECT id FROM refresh_tokens
+		WHERE expires_at < $1
+		ORDER BY expires_at
+		LIMIT $2);`, expiredBefore, limit)
+	if err != nil {
+		return 0, fmt.Errorf("%s: Executing statement error: %w", op, err)
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: Reading result error: %w", op, err)
+	}
+
+	return deleted, nil
+}
+
+// TryLock takes the advisory lock called name without waiting. The lock is
+// held by a dedicated connection until unlock is called, so replicas
+// sharing the database do not run the same job at once.
+func (d *Database) TryLock(ctx context.Context, name string) (unlock func(), locked bool, err error) {
+	const op = "database.postgresql.TryLock"
+
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: Connecting error: %w", op, err)
+	}
+
+	key := lockKey(name)
+
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1);`, key).Scan(&locked)
+	if err != nil || !locked {
+		conn.Close()
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: Executing statement error: %w", op, err)
+		}
+
+		return nil, false, nil
+	}
+
+	unlock = func() {
+		ctx, cancel := d.timeouts.WriteContext(context.Background())
+		defer cancel()
+
+		// Closing the connection releases the lock anyway, unless the pool
+		// keeps it: then it is dropped instead of being reused locked.
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1);`, key); err != nil {
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}
+
+	return unlock, true, nil
+}
+
+func (d *Database) GetUser(ctx context.Context, userGUID uuid.UUID) (database.User, error) {
+	const op = "database.postgresql.GetUser"
+
+	ctx, cancel := d.timeouts.ReadContext(ctx)
+	defer cancel()
+
+	user := database.User{GUID: userGUID}
+
+	var username sql.NullString
+
+	err := d.db.QueryRowContext(ctx, `SELECT email, email_verified, locale, notify_ip_change, username, password_hash,
+		disabled FROM users WHERE guid = $1;`, userGUID).
+		Scan(&user.Email, &user.EmailVerified, &user.Locale, &user.NotifyIPChange, &username, &user.PasswordHash,
+			&user.Disabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, database.ErrUserNotFound
+	}
+
+	if err != nil {
+		return database.User{}, fmt.Errorf("%s: Executing statement error: %w", op, err)
+	}
+
+	user.Username = username.String
+
+	return user, nil
+}
+
+func (d *Database) GetUserByUsername(ctx context.Context, username string) (database.User, error) {
+	const op = "database.postgresql.GetUserByUsername"
+
+	ctx, cancel := d.timeouts.ReadContext(ctx)
+	defer cancel()
+
+	user := database.User{Username: username}
+
+	err := d.db.QueryRowContext(ctx, `SELECT guid, email, email_verified, locale, notify_ip_change, password_hash,
+		disabled FROM users WHERE username = $1;`, username).
+		Scan(&user.GUID, &user.Email, &user.EmailVerified, &user.Locale, &user.NotifyIPChange, &user.PasswordHash,
+			&user.Disabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, database.ErrUserNotFound
+	}
+
+	if err != nil {
+		return database.User{}, fmt.Errorf("%s: Executing statement error: %w", op, err)
+	}
+
+	return user, nil
+}
+
+// SaveUser creates the user or replaces the entry with the same GUID.
+func (d *Database) SaveUser(ctx context.Context, user database.User) error {
+	const op = "database.postgresql.SaveUser"
+
+	ctx, cancel := d.timeouts.WriteContext(ctx)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, `
+	INSERT INTO users (guid, email, email_verified, locale, notify_ip_change, username, password_hash, disabled)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	ON CONFLICT (guid) DO UPDATE
+	SET email = EXCLUDED.email, email_verified = EXCLUDED.email_verified, locale = EXCLUDED.locale,
+		notify_ip_change = EXCLUDED.notify_ip_change, username = EXCLUDED.username,
+		password_hash = EXCLUDED.password_hash, disabled = EXCLUDED.disabled;`,
+		user.GUID, user.Email, user.EmailVerified, user.Locale, user.NotifyIPChange, nullString(user.Username),
+		user.PasswordHash, user.Disabled)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return fmt.Errorf("%s: %w", op, database.ErrUsernameTaken)
+		}
+
+		return fmt.Errorf("%s: Unable to save user: \"%s\": Executing statement error: %w", op, user.GUID, err)
+	}
+
+	return nil
+}
+
+// CreateUser saves a new user and queues the emails, such as the email
+// verification, within the same transaction.
+func (d *Database) CreateUser(ctx context.Context, user database.User, emails []database.Email) error {
+	const op = "database.postgresql.CreateUser"
+
+	ctx, cancel := d.timeouts.WriteContext(ctx)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: Beginning transaction error: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO users (guid, email, email_verified, locale, notify_ip_change, username, password_hash, disabled)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8);`,
+		user.GUID, user.Email, user.EmailVerified, user.Locale, user.NotifyIPChange, nullString(user.Username),
+		user.PasswordHash, user.Disabled)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return fmt.Errorf("%s: %w", op, database.ErrUsernameTaken)
+		}
+
+		return fmt.Errorf("%s: Saving user error: %w", op, err)
+	}
+
+	if err = insertEmails(ctx, tx, emails, time.Now()); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("%s: Committing transaction error: %w", op, err)
+	}
+
+	return nil
+}
+
+// SetPasswordHash replaces the password hash of the user, as long as it is
+// still oldHash, and revokes every refresh token of the user if asked to,
+// within the same transaction. ErrUserNotFound is returned if the user is
+// missing or the hash has changed meanwhile.
+func (d *Database) SetPasswordHash(ctx context.Context, userGUID uuid.UUID, oldHash string, newHash string,
+	revokeTokens bool) error {
+	const op = "database.postgresql.SetPasswordHash"
+
+	ctx, cancel := d.timeouts.WriteContext(ctx)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: Beginning transaction error: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `UPDATE users SET password_hash = $3
+		WHERE guid = $1 AND password_hash = $2;`, userGUID, oldHash, newHash)
+	if err != nil {
+		return fmt.Errorf("%s: Updating password hash error: %w", op, err)
+	}
+
+	if err = affectedOne(result); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if revokeTokens {
+		_, err = tx.ExecContext(ctx, `UPDATE refresh_tokens SET is_revoked = true
+			WHERE user_GUID = $1 AND NOT is_revoked;`, userGUID)
+		if err != nil {
+			return fmt.Errorf("%s: Revoking refresh tokens error: %w", op, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("%s: Committing transaction error: %w", op, err)
+	}
+
+	return nil
+}
+
+// VerifyEmail marks the email of the user verified. It succeeds once:
+// ErrUserNotFound is returned if the user is missing, has another email or
+// has verified it already.
+func (d *Database) VerifyEmail(ctx context.Context, userGUID uuid.UUID, email string) error {
+	const op = "database.postgresql.VerifyEmail"
+
+	ctx, cancel := d.timeouts.WriteContext(ctx)
+	defer cancel()
+
+	result, err := d.db.ExecContext(ctx, `UPDATE users SET email_verified = true
+		WHERE guid = $1 AND email = $2 AND NOT email_verified;`, userGUID, email)
+	if err != nil {
+		return fmt.Errorf("%s: Executing statement error: %w", op, err)
+	}
+
+	if err = affectedOne(result); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (d *Database) SetUserDisabled(ctx context.Context, userGUID uuid.UUID, disabled bool) error {
+	const op = "database.postgresql.SetUserDisabled"
+
+	ctx, cancel := d.timeouts.WriteContext(ctx)
+	defer cancel()
+
+	result, err := d.db.ExecContext(ctx, `UPDATE users SET disabled = $2 WHERE guid = $1;`, userGUID, disabled)
+	if err != nil {
+		return fmt.Errorf("%s: Executing statement error: %w", op, err)
+	}
+
+	if err = affectedOne(result); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// IsUserDisabled tells whether the user is disabled. Users missing from the
+// directory are not.
+func (d *Database) IsUserDisabled(ctx context.Context, userGUID uuid.UUID) (bool, error) {
+	const op = "database.postgresql.IsUserDisabled"
+
+	ctx, cancel := d.timeouts.ReadContext(ctx)
+	defer cancel()
+
+	var disabled bool
+
+	err := d.db.QueryRowContext(ctx, `SELECT disabled FROM users WHERE guid = $1;`, userGUID).Scan(&disabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("%s: Executing statement error: %w", op, err)
+	}
 
-	rb := make([]byte, 16)
-	_, err := rand.Read(rb)
+	return disabled, nil
+}
+
+// affectedOne returns ErrUserNotFound unless the statement updated a row.
+func affectedOne(result sql.Result) error {
+	affected, err := result.RowsAffected()
 	if err != nil {
-		return "", fmt.Errorf("%s: Failed to get random bytes: %w", op, err)
+		return fmt.Errorf("Reading result error: %w", err)
+	}
+
+	if affected == 0 {
+		return database.ErrUserNotFound
+	}
+
+	return nil
+}
+
+// nullString stores an empty string as NULL, so unique columns may be left
+// empty by any number of rows.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// lockKey maps a lock name to an advisory lock key.
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("auth.scheduler." + name))
+
+	return int64(h.Sum64())
+}
+
+func localTime(t sql.NullTime) time.Time {
+	if !t.Valid {
+		return time.Time{}
 	}
 
-	return base64.URLEncoding.EncodeToString(rb), nil
+	return t.Time.Local()
 }
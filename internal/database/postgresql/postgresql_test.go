@@ -0,0 +1,45 @@
+package postgresql_test
+
+import (
+	"auth/internal/config"
+	"auth/internal/database/postgresql"
+	"auth/internal/database/storagetest"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestDatabase runs against the server set by AUTH_TEST_POSTGRES_HOST,
+// e.g. the one started by docker compose.
+func TestDatabase(t *testing.T) {
+	host := os.Getenv("AUTH_TEST_POSTGRES_HOST")
+	if host == "" {
+		t.Skip("AUTH_TEST_POSTGRES_HOST is not set")
+	}
+
+	port, err := strconv.ParseUint(getenv("AUTH_TEST_POSTGRES_PORT", "5432"), 10, 16)
+	require.NoError(t, err)
+
+	database, err := postgresql.New(config.Database{
+		Host:     host,
+		Port:     uint16(port),
+		User:     getenv("AUTH_TEST_POSTGRES_USER", "postgres"),
+		Password: getenv("AUTH_TEST_POSTGRES_PASSWORD", "password"),
+		Name:     getenv("AUTH_TEST_POSTGRES_NAME", "postgres"),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+
+	storagetest.Run(t, database)
+	storagetest.RunRateLimits(t, database)
+}
+
+func getenv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+
+	return fallback
+}
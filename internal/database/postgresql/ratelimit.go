@@ -0,0 +1,87 @@
+package postgresql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"auth/internal/lib/ratelimit"
+)
+
+// UpdateRateLimit updates the state of the key within a transaction. The
+// row stays locked until the transaction ends, so replicas updating the same
+// key wait for each other.
+func (d *Database) UpdateRateLimit(ctx context.Context, key string,
+	fn func(ratelimit.State) ratelimit.State) (ratelimit.State, error) {
+	const op = "database.postgresql.UpdateRateLimit"
+
+	ctx, cancel := d.timeouts.WriteContext(ctx)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ratelimit.State{}, fmt.Errorf("%s: Beginning transaction error: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var state ratelimit.State
+	var updatedAt sql.NullTime
+	var lockedUntil sql.NullTime
+
+	err = tx.QueryRowContext(ctx, `SELECT tokens, updated_at, locked_until, expires_at
+		FROM rate_limits WHERE key = $1 FOR UPDATE;`, key).
+		Scan(&state.Tokens, &updatedAt, &lockedUntil, &state.ExpiresAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return ratelimit.State{}, fmt.Errorf("%s: Locking rate limit error: %w", op, err)
+	}
+
+	state.UpdatedAt = localTime(updatedAt)
+	state.LockedUntil = localTime(lockedUntil)
+	state = fn(state)
+
+	// Concurrent first updates of a key both start from the zero State and
+	// the later one overwrites the row, a new bucket is off by a token at
+	// most.
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO rate_limits (key, tokens, updated_at, locked_until, expires_at)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (key) DO UPDATE
+	SET tokens = EXCLUDED.tokens, updated_at = EXCLUDED.updated_at, locked_until = EXCLUDED.locked_until,
+		expires_at = EXCLUDED.expires_at;`,
+		key, state.Tokens, nullTime(state.UpdatedAt), nullTime(state.LockedUntil), state.ExpiresAt)
+	if err != nil {
+		return ratelimit.State{}, fmt.Errorf("%s: Executing statement error: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ratelimit.State{}, fmt.Errorf("%s: Committing transaction error: %w", op, err)
+	}
+
+	return state, nil
+}
+
+// PurgeRateLimits deletes the states expired before the time.
+func (d *Database) PurgeRateLimits(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	const op = "database.postgresql.PurgeRateLimits"
+
+	ctx, cancel := d.timeouts.WriteContext(ctx)
+	defer cancel()
+
+	result, err := d.db.ExecContext(ctx, `DELETE FROM rate_limits WHERE expires_at < $1;`, expiredBefore)
+	if err != nil {
+		return 0, fmt.Errorf("%s: Executing statement error: %w", op, err)
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: Counting deleted rows error: %w", op, err)
+	}
+
+	return deleted, nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
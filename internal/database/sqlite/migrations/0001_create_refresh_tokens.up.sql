@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS refresh_tokens(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_GUID TEXT NOT NULL,
+    bind_key TEXT NOT NULL UNIQUE,
+    hash TEXT NOT NULL UNIQUE,
+    family_id TEXT NOT NULL,
+    parent_bind_key TEXT,
+    ip TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    expires_at INTEGER NOT NULL,
+    created_at INTEGER NOT NULL,
+    is_revoked BOOLEAN NOT NULL DEFAULT false);
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+CREATE INDEX IF NOT EXISTS refresh_tokens_user_guid_idx ON refresh_tokens (user_GUID);
@@ -0,0 +1 @@
+ALTER TABLE refresh_tokens ADD COLUMN rotated_at INTEGER;
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS users(
+    guid TEXT PRIMARY KEY,
+    email TEXT NOT NULL DEFAULT '',
+    email_verified BOOLEAN NOT NULL DEFAULT false,
+    locale TEXT NOT NULL DEFAULT '',
+    notify_ip_change BOOLEAN NOT NULL DEFAULT true);
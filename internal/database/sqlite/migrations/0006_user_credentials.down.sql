@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS users_username_idx;
+ALTER TABLE users DROP COLUMN password_hash;
+ALTER TABLE users DROP COLUMN username;
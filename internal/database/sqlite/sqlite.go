@@ -0,0 +1,695 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	"auth/internal/config"
+	"auth/internal/database"
+	"auth/internal/database/migrate"
+	"auth/internal/lib/tokens"
+)
+
+// Database stores refresh tokens in a SQLite file. Times are stored as Unix
+// nanoseconds, so they compare correctly regardless of time zones.
+type Database struct {
+	db       *sql.DB
+	timeouts database.Timeouts
+}
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+func New(configDb config.Database) (*Database, error) {
+	const op = "database.sqlite.New"
+
+	db, err := Open(configDb)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if configDb.ManualMigrations {
+		err = migrator.Check(context.Background())
+	} else {
+		_, err = migrator.Up(context.Background())
+	}
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Database{db: db, timeouts: database.NewTimeouts(configDb)}, nil
+}
+
+func Open(configDb config.Database) (*sql.DB, error) {
+	const op = "database.sqlite.Open"
+
+	// Transactions take the write lock when they begin, so a token read in
+	// a transaction can not be changed by another process before it ends.
+	dsn := "file:" + configDb.Path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("%s: Unable to open database: %w", op, err)
+	}
+
+	// SQLite allows a single writer, serializing access avoids busy errors.
+	db.SetMaxOpenConns(1)
+
+	return db, nil
+}
+
+func NewMigrator(db *sql.DB) (*migrate.Migrator, error) {
+	const op = "database.sqlite.NewMigrator"
+
+	list, err := migrate.Load(migrations, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return migrate.New(db, migrate.SQLite, list), nil
+}
+
+func (d *Database) Close() error {
+	return d.db.Close()
+}
+
+func (d *Database) SaveRefreshToken(ctx context.Context, userGUID uuid.UUID, token string, family database.Family,
+	client database.Client, jwtConfig config.JWT) (string, error) {
+	const op = "database.sqlite.SaveRefreshToken"
+
+	ctx, cancel := d.timeouts.WriteContext(ctx)
+	defer cancel()
+
+	hash := tokens.HashRefreshToken(token, jwtConfig.RefreshTokenPepper)
+
+	bindKey, err := database.GenerateBindKey()
+	if err != nil {
+		return "", fmt.Errorf("%s: Generating bind key error: %w", op, err)
+	}
+
+	createdAt := time.Now()
+	expiresAt := createdAt.Add(jwtConfig.RefreshExpires)
+
+	parentBindKey := sql.NullString{String: family.ParentBindKey, Valid: family.ParentBindKey != ""}
+
+	_, err = d.db.ExecContext(ctx, `
+	INSERT INTO refresh_tokens (user_GUID, hash, bind_key, family_id, parent_bind_key, ip, user_agent,
+		expires_at, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+		userGUID.String(), hash, bindKey, family.ID.String(), parentBindKey, client.IP, client.UserAgent,
+		expiresAt.UnixNano(), createdAt.UnixNano())
+	if err != nil {
+		var sqliteErr *sqlite.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+			return "", fmt.Errorf("%s: %w", op, database.ErrTokenExists)
+		}
+
+		return "", fmt.Errorf("%s: Executing statement error: %w", op, err)
+	}
+
+	return bindKey, nil
+}
+
+func (d *Database) GetRefreshToken(ctx context.Context, bindKey string) (database.RefreshClaims, error) {
+	const op = "database.sqlite.GetRefreshToken"
+
+	ctx, cancel := d.timeouts.ReadContext(ctx)
+	defer cancel()
+
+	var userGUID, familyID string
+	var parentBindKey sql.NullString
+	var hash string
+	var expiresAt int64
+	var isRevoked bool
+	var rotatedAt sql.NullInt64
+
+	err := d.db.QueryRowContext(ctx, `SELECT user_GUID, family_id, parent_bind_key, hash, expires_at, is_revoked,
+		rotated_at
+		FROM refresh_tokens WHERE bind_key = ?;`, bindKey).
+		Scan(&userGUID, &familyID, &parentBindKey, &hash, &expiresAt, &isRevoked, &rotatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.RefreshClaims{}, database.ErrTokenNotFound
+	}
+
+	if err != nil {
+		return database.RefreshClaims{}, fmt.Errorf("%s: Executing statement error: %w", op, err)
+	}
+
+	parsedUserGUID, err := uuid.Parse(userGUID)
+	if err != nil {
+		return database.RefreshClaims{}, fmt.Errorf("%s: Parsing user GUID error: %w", op, err)
+	}
+
+	parsedFamilyID, err := uuid.Parse(familyID)
+	if err != nil {
+		return database.RefreshClaims{}, fmt.Errorf("%s: Parsing family ID error: %w", op, err)
+	}
+
+	refreshToken := database.RefreshClaims{
+		UserGUID:      parsedUserGUID,
+		FamilyID:      parsedFamilyID,
+		ParentBindKey: parentBindKey.String,
+		Hash:          hash,
+		BindKey:       bindKey,
+		ExpiresAt:     time.Unix(0, expiresAt),
+		IsRevoked:     isRevoked,
+		RotatedAt:     unixTime(rotatedAt),
+	}
+
+	return refreshToken, nil
+}
+
+// GetRefreshTokenByHash finds a refresh token by its keyed hash. Tokens
+// with legacy bcrypt hashes can not be found this way.
+func (d *Database) GetRefreshTokenByHash(ctx context.Context, hash string) (database.RefreshClaims, error) {
+	const op = "database.sqlite.GetRefreshTokenByHash"
+
+	ctx, cancel := d.timeouts.ReadContext(ctx)
+	defer cancel()
+
+	var userGUID, bindKey, familyID string
+	var parentBindKey sql.NullString
+	var expiresAt int64
+	var isRevoked bool
+	var rotatedAt sql.NullInt64
+
+	err := d.db.QueryRowContext(ctx, `SELECT user_GUID, bind_key, family_id, parent_bind_key, expires_at, is_revoked,
+		rotated_at
+		FROM refresh_tokens WHERE hash = ?;`, hash).
+		Scan(&userGUID, &bindKey, &familyID, &parentBindKey, &expiresAt, &isRevoked, &rotatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.RefreshClaims{}, database.ErrTokenNotFound
+	}
+
+	if err != nil {
+		return database.RefreshClaims{}, fmt.Errorf("%s: Executing statement error: %w", op, err)
+	}
+
+	parsedUserGUID, err := uuid.Parse(userGUID)
+	if err != nil {
+		return database.RefreshClaims{}, fmt.Errorf("%s: Parsing user GUID error: %w", op, err)
+	}
+
+	parsedFamilyID, err := uuid.Parse(familyID)
+	if err != nil {
+		return database.RefreshClaims{}, fmt.Errorf("%s: Parsing family ID error: %w", op, err)
+	}
+
+	refreshToken := database.RefreshClaims{
+		UserGUID:      parsedUserGUID,
+		FamilyID:      parsedFamilyID,
+		ParentBindKey: parentBindKey.String,
+		Hash:          hash,
+		BindKey:       bindKey,
+		ExpiresAt:     time.Unix(0, expiresAt),
+		IsRevoked:     isRevoked,
+		RotatedAt:     unixTime(rotatedAt),
+	}
+
+	return refreshToken, nil
+}
+
+// RotateRefreshToken revokes the refresh token, saves its successor and
+// queues the emails in one transaction. Of concurrent rotations only the
+// first one succeeds, the others get ErrTokenRevoked.
+func (d *Database) RotateRefreshToken(ctx context.Context, bindKey string, token string, client database.Client,
+	jwtConfig config.JWT, emails []database.Email) (string, error) {
+	const op = "database.sqlite.RotateRefreshToken"
+
+	ctx, cancel := d.timeouts.WriteContext(ctx)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("%s: Beginning transaction error: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var userGUID, familyID string
+	var isRevoked bool
+
+	err = tx.QueryRowContext(ctx, `SELECT user_GUID, family_id, is_revoked
+		FROM refresh_tokens WHERE bind_key = ?;`, bindKey).Scan(&userGUID, &familyID, &isRevoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("%s: %w", op, database.ErrTokenNotFound)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("%s: Reading refresh token error: %w", op, err)
+	}
+
+	if isRevoked {
+		return "", fmt.Errorf("%s: %w", op, database.ErrTokenRevoked)
+	}
+
+	newBindKey, err := database.GenerateBindKey()
+	if err != nil {
+		return "", fmt.Errorf("%s: Generating bind key error: %w", op, err)
+	}
+
+	createdAt := time.Now()
+
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO refresh_tokens (user_GUID, hash, bind_key, family_id, parent_bind_key, ip, user_agent,
+		expires_at, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+		userGUID, tokens.HashRefreshToken(token, jwtConfig.RefreshTokenPepper), newBindKey, familyID, bindKey,
+		client.IP, client.UserAgent, createdAt.Add(jwtConfig.RefreshExpires).UnixNano(), createdAt.UnixNano())
+	if err != nil {
+		var sqliteErr *sqlite.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+			return "", fmt.Errorf("%s: %w", op, database.ErrTokenExists)
+		}
+
+		return "", fmt.Errorf("%s: Saving refresh token error: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE refresh_tokens SET is_revoked = true, rotated_at = ?
+		WHERE bind_key = ?;`, createdAt.UnixNano(), bindKey)
+	if err != nil {
+		return "", fmt.Errorf("%s: Revoking refresh token error: %w", op, err)
+	}
+
+	if err = insertEmails(ctx, tx, emails, createdAt); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", fmt.Errorf("%s: Committing transaction error: %w", op, err)
+	}
+
+	return newBindKey, nil
+}
+
+func (d *Database) RevokeRefreshToken(ctx context.Context, bindKey string) error {
+	const op = "database.sqlite.RevokeRefreshToken"
+
+	ctx, cancel := d.timeouts.WriteContext(ctx)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, `UPDATE refresh_tokens SET is_revoked = true WHERE bind_key = ?;`, bindKey)
+	if err != nil {
+		return fmt.Errorf(`%s: Unable to revoke refresh token using bind key: 
+		"%s": Executing statement error: %w`, op, bindKey, err)
+	}
+
+	return nil
+}
+
+func (d *Database) RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	const op = "database.sqlite.RevokeRefreshTokenFamily"
+
+	ctx, cancel := d.timeouts.WriteContext(ctx)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, `UPDATE refresh_tokens SET is_revoked = true
+		WHERE family_id = ? AND NOT is_revoked;`, familyID.String())
+	if err != nil {
+		return fmt.Errorf(`%s: Unable to revoke refresh token family: 
+		"%s": Executing statement error: %w`, op, familyID, err)
+	}
+
+	return nil
+}
+
+func (d *Database) RevokeUserRefreshTokens(ctx context.Context, userGUID uuid.UUID) error {
+	const op = "database.sqlite.RevokeUserRefreshTokens"
+
+	ctx, cancel := d.timeouts.WriteContext(ctx)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, `UPDATE refresh_tokens SET is_revoked = true
+		WHERE user_GUID = ? AND NOT is_revoked;`, userGUID.String())
+	if err != nil {
+		return fmt.Errorf(`%s: Unable to revoke refresh tokens of user: 
+		"%s": Executing statement error: %w`, op, userGUID, err)
+	}
+
+	return nil
+}
+
+func (d *Database) ListSessions(ctx context.Context, userGUID uuid.UUID) ([]database.Session, error) {
+	const op = "database.sqlite.ListSessions"
+
+	ctx, cancel := d.timeouts.ReadContext(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, `
+	SELECT id, bind_key, ip, user_agent, created_at, expires_at
+	FROM refresh_tokens
+	WHERE user_GUID = ? AND NOT is_revoked AND expires_at > ?
+	ORDER BY created_at DESC, id DESC;`, userGUID.String(), time.Now().UnixNano())
+	if err != nil {
+		return nil, fmt.Errorf("%s: Executing statement error: %w", op, err)
+	}
+	defer rows.Close()
+
+	sessions := []database.Session{}
+
+	for rows.Next() {
+		var session database.Session
+		var createdAt, expiresAt int64
+
+		err = rows.Scan(&session.ID, &session.BindKey, &session.Client.IP, &session.Client.UserAgent,
+			&createdAt, &expiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("%s: Scanning row error: %w", op, err)
+		}
+
+		session.CreatedAt = time.Unix(0, createdAt)
+		session.ExpiresAt = time.Unix(0, expiresAt)
+
+		sessions = append(sessions, session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: Reading rows error: %w", op, err)
+	}
+
+	return sessions, nil
+}
+
+func (d *Database) RevokeSession(ctx context.Context, userGUID uuid.UUID, id int64) error {
+	const op = "database.sqlite.RevokeSession"
+
+	ctx, cancel := d.timeouts.WriteContext(ctx)
+	defer cancel()
+
+	result, err := d.db.ExecContext(ctx, `UPDATE refresh_tokens SET is_revoked = true
+		WHERE id = ? AND user_GUID = ?;`, id, userGUID.String())
+	if err != nil {
+		return fmt.Errorf("%s: Executing statement error: %w", op, err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: Reading result error: %w", op, err)
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, database.ErrTokenNotFound)
+	}
+
+	return nil
+}
+
+// PurgeRefreshTokens deletes at most limit refresh tokens that expired before
+// expiredBefore and returns how many were deleted.
+func (d *Database) PurgeRefreshTokens(ctx context.Context, expiredBefore time.Time, limit int) (int64, error) {
+	const op = "database.sqlite.PurgeRefreshTokens"
+
+	ctx, cancel := d.timeouts.WriteContext(ctx)
+	defer cancel()
+
+	result, err := d.db.ExecContext(ctx, `
+	DELETE FROM refresh_tokens
+	WHERE id IN (
+		SELECT id FROM refresh_tokens
+		WHERE expires_at < ?
+		ORDER BY expires_at
+		LIMIT ?);`, expiredBefore.UnixNano(), limit)
+	if err != nil {
+		return 0, fmt.Errorf("%s: Executing statement error: %w", op, err)
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: Reading result error: %w", op, err)
+	}
+
+	return deleted, nil
+}
+
+func (d *Database) GetUser(ctx context.Context, userGUID uuid.UUID) (database.User, error) {
+	const op = "database.sqlite.GetUser"
+
+	ctx, cancel := d.timeouts.ReadContext(ctx)
+	defer cancel()
+
+	user := database.User{GUID: userGUID}
+
+	var username sql.NullString
+
+	err := d.db.QueryRowContext(ctx, `SELECT email, email_verified, locale, notify_ip_change, username, password_hash,
+		disabled FROM users WHERE guid = ?;`, userGUID.String()).
+		Scan(&user.Email, &user.EmailVerified, &user.Locale, &user.NotifyIPChange, &username, &user.PasswordHash,
+			&user.Disabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, database.ErrUserNotFound
+	}
+
+	if err != nil {
+		return database.User{}, fmt.Errorf("%s: Executing statement error: %w", op, err)
+	}
+
+	user.Username = username.String
+
+	return user, nil
+}
+
+func (d *Database) GetUserByUsername(ctx context.Context, username string) (database.User, error) {
+	const op = "database.sqlite.GetUserByUsername"
+
+	ctx, cancel := d.timeouts.ReadContext(ctx)
+	defer cancel()
+
+	user := database.User{Username: username}
+
+	var userGUID string
+
+	err := d.db.QueryRowContext(ctx, `SELECT guid, email, email_verified, locale, notify_ip_change, password_hash,
+		disabled FROM users WHERE username = ?;`, username).
+		Scan(&userGUID, &user.Email, &user.EmailVerified, &user.Locale, &user.NotifyIPChange, &user.PasswordHash,
+			&user.Disabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, database.ErrUserNotFound
+	}
+
+	if err != nil {
+		return database.User{}, fmt.Errorf("%s: Executing statement error: %w", op, err)
+	}
+
+	user.GUID, err = uuid.Parse(userGUID)
+	if err != nil {
+		return database.User{}, fmt.Errorf("%s: Parsing user GUID error: %w", op, err)
+	}
+
+	return user, nil
+}
+
+// SaveUser creates the user or replaces the entry with the same GUID.
+func (d *Database) SaveUser(ctx context.Context, user database.User) error {
+	const op = "database.sqlite.SaveUser"
+
+	ctx, cancel := d.timeouts.WriteContext(ctx)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, `
+	INSERT INTO users (guid, email, email_verified, locale, notify_ip_change, username, password_hash, disabled)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (guid) DO UPDATE
+	SET email = EXCLUDED.email, email_verified = EXCLUDED.email_verified, locale = EXCLUDED.locale,
+		notify_ip_change = EXCLUDED.notify_ip_change, username = EXCLUDED.username,
+		password_hash = EXCLUDED.password_hash, disabled = EXCLUDED.disabled;`,
+		user.GUID.String(), user.Email, user.EmailVerified, user.Locale, user.NotifyIPChange,
+		nullString(user.Username), user.PasswordHash, user.Disabled)
+	if err != nil {
+		var sqliteErr *sqlite.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+			return fmt.Errorf("%s: %w", op, database.ErrUsernameTaken)
+		}
+
+		return fmt.Errorf("%s: Unable to save user: \"%s\": Executing statement error: %w", op, user.GUID, err)
+	}
+
+	return nil
+}
+
+// CreateUser saves a new user and queues the emails, such as the email
+// verification, within the same transaction.
+func (d *Database) CreateUser(ctx context.Context, user database.User, emails []database.Email) error {
+	const op = "database.sqlite.CreateUser"
+
+	ctx, cancel := d.timeouts.WriteContext(ctx)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: Beginning transaction error: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO users (guid, email, email_verified, locale, notify_ip_change, username, password_hash, disabled)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?);`,
+		user.GUID.String(), user.Email, user.EmailVerified, user.Locale, user.NotifyIPChange,
+		nullString(user.Username), user.PasswordHash, user.Disabled)
+	if err != nil {
+		var sqliteErr *sqlite.Error
+		if errors.As(err, &sqliteErr) && (sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE ||
+			sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY) {
+			return fmt.Errorf("%s: %w", op, database.ErrUsernameTaken)
+		}
+
+		return fmt.Errorf("%s: Saving user error: %w", op, err)
+	}
+
+	if err = insertEmails(ctx, tx, emails, time.Now()); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("%s: Committing transaction error: %w", op, err)
+	}
+
+	return nil
+}
+
+// SetPasswordHash replaces the password hash of the user, as long as it is
+// still oldHash, and revokes every refresh token of the user if asked to,
+// within the same transaction. ErrUserNotFound is returned if the user is
+// missing or the hash has changed meanwhile.
+func (d *Database) SetPasswordHash(ctx context.Context, userGUID uuid.UUID, oldHash string, newHash string,
+	revokeTokens bool) error {
+	const op = "database.sqlite.SetPasswordHash"
+
+	ctx, cancel := d.timeouts.WriteContext(ctx)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: Beginning transaction error: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `UPDATE users SET password_hash = ?
+		WHERE guid = ? AND password_hash = ?;`, newHash, userGUID.String(), oldHash)
+	if err != nil {
+		return fmt.Errorf("%s: Updating password hash error: %w", op, err)
+	}
+
+	if err = affectedOne(result); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if revokeTokens {
+		_, err = tx.ExecContext(ctx, `UPDATE refresh_tokens SET is_revoked = true
+			WHERE user_GUID = ? AND NOT is_revoked;`, userGUID.String())
+		if err != nil {
+			return fmt.Errorf("%s: Revoking refresh tokens error: %w", op, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("%s: Committing transaction error: %w", op, err)
+	}
+
+	return nil
+}
+
+// VerifyEmail marks the email of the user verified. It succeeds once:
+// ErrUserNotFound is returned if the user is missing, has another email or
+// has verified it already.
+func (d *Database) VerifyEmail(ctx context.Context, userGUID uuid.UUID, email string) error {
+	const op = "database.sqlite.VerifyEmail"
+
+	ctx, cancel := d.timeouts.WriteContext(ctx)
+	defer cancel()
+
+	result, err := d.db.ExecContext(ctx, `UPDATE users SET email_verified = true
+		WHERE guid = ? AND email = ? AND NOT email_verified;`, userGUID.String(), email)
+	if err != nil {
+		return fmt.Errorf("%s: Executing statement error: %w", op, err)
+	}
+
+	if err = affectedOne(result); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (d *Database) SetUserDisabled(ctx context.Context, userGUID uuid.UUID, disabled bool) error {
+	const op = "database.sqlite.SetUserDisabled"
+
+	ctx, cancel := d.timeouts.WriteContext(ctx)
+	defer cancel()
+
+	result, err := d.db.ExecContext(ctx, `UPDATE users SET disabled = ? WHERE guid = ?;`,
+		disabled, userGUID.String())
+	if err != nil {
+		return fmt.Errorf("%s: Executing statement error: %w", op, err)
+	}
+
+	if err = affectedOne(result); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// IsUserDisabled tells whether the user is disabled. Users missing from the
+// directory are not.
+func (d *Database) IsUserDisabled(ctx context.Context, userGUID uuid.UUID) (bool, error) {
+	const op = "database.sqlite.IsUserDisabled"
+
+	ctx, cancel := d.timeouts.ReadContext(ctx)
+	defer cancel()
+
+	var disabled bool
+
+	err := d.db.QueryRowContext(ctx, `SELECT disabled FROM users WHERE guid = ?;`, userGUID.String()).
+		Scan(&disabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("%s: Executing statement error: %w", op, err)
+	}
+
+	return disabled, nil
+}
+
+// affectedOne returns ErrUserNotFound unless the statement updated a row.
+func affectedOne(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Reading result error: %w", err)
+	}
+
+	if affected == 0 {
+		return database.ErrUserNotFound
+	}
+
+	return nil
+}
+
+// nullString stores an empty string as NULL, so unique columns may be left
+// empty by any number of rows.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func unixTime(nanoseconds sql.NullInt64) time.Time {
+	if !nanoseconds.Valid {
+		return time.Time{}
+	}
+
+	return time.Unix(0, nanoseconds.Int64)
+}
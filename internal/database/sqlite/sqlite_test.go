@@ -0,0 +1,68 @@
+package sqlite_test
+
+import (
+	"auth/internal/config"
+	"auth/internal/database"
+	"auth/internal/database/sqlite"
+	"auth/internal/database/storagetest"
+	"auth/internal/lib/tokens"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase(t *testing.T) {
+	database, err := sqlite.New(config.Database{Path: filepath.Join(t.TempDir(), "auth.db")})
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+
+	storagetest.Run(t, database)
+}
+
+func TestCanceledContext(t *testing.T) {
+	database, err := sqlite.New(config.Database{Path: filepath.Join(t.TempDir(), "auth.db")})
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = database.ListSessions(ctx, uuid.New())
+	require.True(t, errors.Is(err, context.Canceled))
+
+	err = database.RevokeUserRefreshTokens(ctx, uuid.New())
+	require.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestCorruptGUID(t *testing.T) {
+	cfg := config.Database{Path: filepath.Join(t.TempDir(), "auth.db")}
+
+	storage, err := sqlite.New(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { storage.Close() })
+
+	jwtConfig := config.JWT{RefreshExpires: time.Hour, RefreshTokenPepper: "verysecretpepper"}
+
+	bindKey, err := storage.SaveRefreshToken(context.Background(), uuid.New(), "some token", database.NewFamily(),
+		database.Client{}, jwtConfig)
+	require.NoError(t, err)
+
+	db, err := sqlite.Open(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`UPDATE refresh_tokens SET user_guid = 'some string' WHERE bind_key = ?;`, bindKey)
+	require.NoError(t, err)
+
+	_, err = storage.GetRefreshToken(context.Background(), bindKey)
+	require.Error(t, err, "Corrupt rows are errors, not panics")
+
+	_, err = storage.GetRefreshTokenByHash(context.Background(),
+		tokens.HashRefreshToken("some token", jwtConfig.RefreshTokenPepper))
+	require.Error(t, err, "Corrupt rows are errors, not panics")
+}
@@ -0,0 +1,125 @@
+package storagetest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"auth/internal/lib/ratelimit"
+)
+
+// RunRateLimits runs the behavioral tests against the rate limit store.
+// Tests only touch fresh keys, so the store may be shared.
+func RunRateLimits(t *testing.T, store ratelimit.Store) {
+	t.Run("UpdateRateLimit", func(t *testing.T) { testUpdateRateLimit(t, store) })
+	t.Run("ConcurrentRateLimit", func(t *testing.T) { testConcurrentRateLimit(t, store) })
+	t.Run("PurgeRateLimits", func(t *testing.T) { testPurgeRateLimits(t, store) })
+}
+
+func testUpdateRateLimit(t *testing.T, store ratelimit.Store) {
+	key := "ip:" + uuid.NewString()
+	now := time.Now().Truncate(time.Second)
+
+	want := ratelimit.State{
+		Tokens:    2.5,
+		UpdatedAt: now,
+		ExpiresAt: now.Add(time.Minute),
+	}
+
+	state, err := store.UpdateRateLimit(ctx, key, func(s ratelimit.State) ratelimit.State {
+		require.Equal(t, ratelimit.State{}, s, "New key")
+		return want
+	})
+	require.NoError(t, err)
+	require.Equal(t, want, state)
+
+	_, err = store.UpdateRateLimit(ctx, key, func(s ratelimit.State) ratelimit.State {
+		require.Equal(t, want.Tokens, s.Tokens)
+		require.True(t, want.UpdatedAt.Equal(s.UpdatedAt))
+		require.True(t, want.ExpiresAt.Equal(s.ExpiresAt))
+		require.True(t, s.LockedUntil.IsZero())
+
+		s.LockedUntil = now.Add(time.Hour)
+		return s
+	})
+	require.NoError(t, err)
+
+	_, err = store.UpdateRateLimit(ctx, key, func(s ratelimit.State) ratelimit.State {
+		require.True(t, now.Add(time.Hour).Equal(s.LockedUntil))
+		return s
+	})
+	require.NoError(t, err)
+}
+
+func testConcurrentRateLimit(t *testing.T, store ratelimit.Store) {
+	key := "ip:" + uuid.NewString()
+	expiresAt := time.Now().Add(time.Hour)
+
+	_, err := store.UpdateRateLimit(ctx, key, func(s ratelimit.State) ratelimit.State {
+		s.ExpiresAt = expiresAt
+		return s
+	})
+	require.NoError(t, err)
+
+	const updates = 20
+
+	var wg sync.WaitGroup
+	errs := make(chan error, updates)
+
+	for i := 0; i < updates; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := store.UpdateRateLimit(ctx, key, func(s ratelimit.State) ratelimit.State {
+				s.Tokens++
+				return s
+			})
+			errs <- err
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	state, err := store.UpdateRateLimit(ctx, key, func(s ratelimit.State) ratelimit.State { return s })
+	require.NoError(t, err)
+	require.Equal(t, float64(updates), state.Tokens, "No update is lost")
+}
+
+func testPurgeRateLimits(t *testing.T, store ratelimit.Store) {
+	expired := "ip:" + uuid.NewString()
+	active := "ip:" + uuid.NewString()
+	now := time.Now()
+
+	for key, expiresAt := range map[string]time.Time{
+		expired: now.Add(-time.Hour),
+		active:  now.Add(time.Hour),
+	} {
+		_, err := store.UpdateRateLimit(ctx, key, func(s ratelimit.State) ratelimit.State {
+			s.Tokens = 1
+			s.ExpiresAt = expiresAt
+			return s
+		})
+		require.NoError(t, err)
+	}
+
+	deleted, err := store.PurgeRateLimits(ctx, now)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, deleted, int64(1))
+
+	for key, tokens := range map[string]float64{expired: 0, active: 1} {
+		_, err := store.UpdateRateLimit(ctx, key, func(s ratelimit.State) ratelimit.State {
+			require.Equal(t, tokens, s.Tokens, "Key: %s", key)
+			return s
+		})
+		require.NoError(t, err)
+	}
+}
@@ -0,0 +1,576 @@
+// Package storagetest holds the behavioral tests every database driver
+// has to pass.
+package storagetest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"auth/internal/config"
+	"auth/internal/database"
+	"auth/internal/lib/tokens"
+)
+
+type Storage interface {
+	SaveRefreshToken(ctx context.Context, userGUID uuid.UUID, token string, family database.Family,
+		client database.Client, jwtConfig config.JWT) (string, error)
+	GetRefreshToken(ctx context.Context, bindKey string) (database.RefreshClaims, error)
+	GetRefreshTokenByHash(ctx context.Context, hash string) (database.RefreshClaims, error)
+	RotateRefreshToken(ctx context.Context, bindKey string, token string, client database.Client,
+		jwtConfig config.JWT, emails []database.Email) (string, error)
+	RevokeRefreshToken(ctx context.Context, bindKey string) error
+	RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error
+	RevokeUserRefreshTokens(ctx context.Context, userGUID uuid.UUID) error
+	ListSessions(ctx context.Context, userGUID uuid.UUID) ([]database.Session, error)
+	RevokeSession(ctx context.Context, userGUID uuid.UUID, id int64) error
+	PurgeRefreshTokens(ctx context.Context, expiredBefore time.Time, limit int) (int64, error)
+	GetUser(ctx context.Context, userGUID uuid.UUID) (database.User, error)
+	GetUserByUsername(ctx context.Context, username string) (database.User, error)
+	SaveUser(ctx context.Context, user database.User) error
+	CreateUser(ctx context.Context, user database.User, emails []database.Email) error
+	SetPasswordHash(ctx context.Context, userGUID uuid.UUID, oldHash string, newHash string, revokeTokens bool) error
+	VerifyEmail(ctx context.Context, userGUID uuid.UUID, email string) error
+	SetUserDisabled(ctx context.Context, userGUID uuid.UUID, disabled bool) error
+	IsUserDisabled(ctx context.Context, userGUID uuid.UUID) (bool, error)
+	PendingEmails(ctx context.Context, now time.Time, limit int) ([]database.Email, error)
+	ListEmails(ctx context.Context, status string, limit int) ([]database.Email, error)
+	MarkEmailSent(ctx context.Context, id int64) error
+	RetryEmail(ctx context.Context, id int64, lastError string, nextAttemptAt time.Time) error
+	MarkEmailDead(ctx context.Context, id int64, lastError string) error
+	ReplayEmail(ctx context.Context, id int64) error
+}
+
+var (
+	jwtCfg = config.JWT{
+		AccessExpires:      300 * time.Second,
+		RefreshExpires:     3600 * time.Second,
+		RefreshTokenPepper: "pepper",
+	}
+	expJwtCfg = config.JWT{
+		AccessExpires:      300 * time.Second,
+		RefreshExpires:     -time.Second,
+		RefreshTokenPepper: "pepper",
+	}
+	client = database.Client{IP: "172.0.0.1", UserAgent: "curl/8.0"}
+	ctx    = context.Background()
+)
+
+// Run runs the behavioral tests against the storage. Tests only touch
+// tokens of fresh user GUIDs, so the storage may be shared.
+func Run(t *testing.T, storage Storage) {
+	t.Run("SaveRefreshToken", func(t *testing.T) { testSave(t, storage) })
+	t.Run("RotateRefreshToken", func(t *testing.T) { testRotate(t, storage) })
+	t.Run("ConcurrentRotation", func(t *testing.T) { testConcurrentRotation(t, storage) })
+	t.Run("RevokeRefreshToken", func(t *testing.T) { testRevoke(t, storage) })
+	t.Run("RevokeRefreshTokenFamily", func(t *testing.T) { testRevokeFamily(t, storage) })
+	t.Run("RevokeUserRefreshTokens", func(t *testing.T) { testRevokeUser(t, storage) })
+	t.Run("Sessions", func(t *testing.T) { testSessions(t, storage) })
+	t.Run("PurgeRefreshTokens", func(t *testing.T) { testPurge(t, storage) })
+	t.Run("Users", func(t *testing.T) { testUsers(t, storage) })
+	t.Run("Accounts", func(t *testing.T) { testAccounts(t, storage) })
+	t.Run("Outbox", func(t *testing.T) { testOutbox(t, storage) })
+}
+
+func save(t *testing.T, storage Storage, userGUID uuid.UUID, family database.Family, jwtConfig config.JWT) (string, string) {
+	token, err := tokens.GenerateRefreshToken()
+	require.NoError(t, err)
+
+	bindKey, err := storage.SaveRefreshToken(ctx, userGUID, token, family, client, jwtConfig)
+	require.NoError(t, err)
+	require.NotEmpty(t, bindKey)
+
+	return token, bindKey
+}
+
+func testSave(t *testing.T, storage Storage) {
+	userGUID := uuid.New()
+	family := database.NewFamily()
+
+	token, bindKey := save(t, storage, userGUID, family, jwtCfg)
+
+	claims, err := storage.GetRefreshToken(ctx, bindKey)
+	require.NoError(t, err)
+	require.Equal(t, userGUID, claims.UserGUID)
+	require.Equal(t, bindKey, claims.BindKey)
+	require.Equal(t, family.ID, claims.FamilyID)
+	require.Empty(t, claims.ParentBindKey)
+	require.False(t, claims.IsRevoked)
+	require.WithinDuration(t, time.Now().Add(jwtCfg.RefreshExpires), claims.ExpiresAt, time.Minute)
+	require.NoError(t, tokens.ValidateRefreshToken(token, claims.Hash, jwtCfg.RefreshTokenPepper))
+	require.Error(t, tokens.ValidateRefreshToken("some string", claims.Hash, jwtCfg.RefreshTokenPepper))
+
+	byHash, err := storage.GetRefreshTokenByHash(ctx, tokens.HashRefreshToken(token, jwtCfg.RefreshTokenPepper))
+	require.NoError(t, err)
+	require.Equal(t, claims, byHash)
+
+	_, err = storage.GetRefreshTokenByHash(ctx, tokens.HashRefreshToken("some string", jwtCfg.RefreshTokenPepper))
+	require.ErrorIs(t, err, database.ErrTokenNotFound)
+
+	_, childBindKey := save(t, storage, userGUID, claims.Child(), jwtCfg)
+
+	child, err := storage.GetRefreshToken(ctx, childBindKey)
+	require.NoError(t, err)
+	require.Equal(t, family.ID, child.FamilyID)
+	require.Equal(t, bindKey, child.ParentBindKey)
+
+	_, err = storage.GetRefreshToken(ctx, "some string")
+	require.ErrorIs(t, err, database.ErrTokenNotFound)
+}
+
+func testRotate(t *testing.T, storage Storage) {
+	userGUID := uuid.New()
+	family := database.NewFamily()
+
+	_, bindKey := save(t, storage, userGUID, family, jwtCfg)
+
+	newToken, err := tokens.GenerateRefreshToken()
+	require.NoError(t, err)
+
+	newBindKey, err := storage.RotateRefreshToken(ctx, bindKey, newToken, client, jwtCfg, nil)
+	require.NoError(t, err)
+	require.NotEqual(t, bindKey, newBindKey)
+
+	claims, err := storage.GetRefreshToken(ctx, bindKey)
+	require.NoError(t, err)
+	require.True(t, claims.IsRevoked)
+	require.WithinDuration(t, time.Now(), claims.RotatedAt, time.Minute)
+
+	child, err := storage.GetRefreshTokenByHash(ctx, tokens.HashRefreshToken(newToken, jwtCfg.RefreshTokenPepper))
+	require.NoError(t, err)
+	require.Equal(t, newBindKey, child.BindKey)
+	require.Equal(t, userGUID, child.UserGUID)
+	require.Equal(t, family.ID, child.FamilyID)
+	require.Equal(t, bindKey, child.ParentBindKey)
+	require.False(t, child.IsRevoked)
+	require.True(t, child.RotatedAt.IsZero())
+
+	_, err = storage.RotateRefreshToken(ctx, bindKey, "some string", client, jwtCfg, nil)
+	require.ErrorIs(t, err, database.ErrTokenRevoked)
+
+	_, err = storage.RotateRefreshToken(ctx, "some string", "some string", client, jwtCfg, nil)
+	require.ErrorIs(t, err, database.ErrTokenNotFound)
+
+	// A token revoked by logout is not rotated.
+	_, otherBindKey := save(t, storage, userGUID, database.NewFamily(), jwtCfg)
+	require.NoError(t, storage.RevokeRefreshToken(ctx, otherBindKey))
+
+	claims, err = storage.GetRefreshToken(ctx, otherBindKey)
+	require.NoError(t, err)
+	require.True(t, claims.RotatedAt.IsZero())
+}
+
+func testConcurrentRotation(t *testing.T, storage Storage) {
+	const refreshes = 8
+
+	_, bindKey := save(t, storage, uuid.New(), database.NewFamily(), jwtCfg)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, refreshes)
+
+	for i := 0; i < refreshes; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			token, err := tokens.GenerateRefreshToken()
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			_, err = storage.RotateRefreshToken(ctx, bindKey, token, client, jwtCfg, nil)
+			errs <- err
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	rotated := 0
+	for err := range errs {
+		if err == nil {
+			rotated++
+			continue
+		}
+
+		require.ErrorIs(t, err, database.ErrTokenRevoked)
+	}
+
+	require.Equal(t, 1, rotated)
+}
+
+func testRevoke(t *testing.T, storage Storage) {
+	userGUID := uuid.New()
+
+	_, bindKey := save(t, storage, userGUID, database.NewFamily(), jwtCfg)
+	_, otherBindKey := save(t, storage, userGUID, database.NewFamily(), jwtCfg)
+
+	require.NoError(t, storage.RevokeRefreshToken(ctx, bindKey))
+	require.NoError(t, storage.RevokeRefreshToken(ctx, bindKey))
+	require.NoError(t, storage.RevokeRefreshToken(ctx, "some string"))
+
+	claims, err := storage.GetRefreshToken(ctx, bindKey)
+	require.NoError(t, err)
+	require.True(t, claims.IsRevoked)
+
+	claims, err = storage.GetRefreshToken(ctx, otherBindKey)
+	require.NoError(t, err)
+	require.False(t, claims.IsRevoked)
+}
+
+func testRevokeFamily(t *testing.T, storage Storage) {
+	userGUID := uuid.New()
+	family := database.NewFamily()
+
+	_, bindKey := save(t, storage, userGUID, family, jwtCfg)
+	_, childBindKey := save(t, storage, userGUID, database.Family{ID: family.ID, ParentBindKey: bindKey}, jwtCfg)
+	_, otherBindKey := save(t, storage, userGUID, database.NewFamily(), jwtCfg)
+
+	require.NoError(t, storage.RevokeRefreshTokenFamily(ctx, family.ID))
+
+	for _, key := range []string{bindKey, childBindKey} {
+		claims, err := storage.GetRefreshToken(ctx, key)
+		require.NoError(t, err)
+		require.True(t, claims.IsRevoked)
+	}
+
+	claims, err := storage.GetRefreshToken(ctx, otherBindKey)
+	require.NoError(t, err)
+	require.False(t, claims.IsRevoked)
+}
+
+func testRevokeUser(t *testing.T, storage Storage) {
+	userGUID := uuid.New()
+	otherGUID := uuid.New()
+
+	_, bindKey := save(t, storage, userGUID, database.NewFamily(), jwtCfg)
+	_, secondBindKey := save(t, storage, userGUID, database.NewFamily(), jwtCfg)
+	_, otherBindKey := save(t, storage, otherGUID, database.NewFamily(), jwtCfg)
+
+	require.NoError(t, storage.RevokeUserRefreshTokens(ctx, userGUID))
+	require.NoError(t, storage.RevokeUserRefreshTokens(ctx, userGUID))
+
+	for _, key := range []string{bindKey, secondBindKey} {
+		claims, err := storage.GetRefreshToken(ctx, key)
+		require.NoError(t, err)
+		require.True(t, claims.IsRevoked)
+	}
+
+	claims, err := storage.GetRefreshToken(ctx, otherBindKey)
+	require.NoError(t, err)
+	require.False(t, claims.IsRevoked)
+}
+
+func testSessions(t *testing.T, storage Storage) {
+	userGUID := uuid.New()
+
+	_, firstBindKey := save(t, storage, userGUID, database.NewFamily(), jwtCfg)
+	_, secondBindKey := save(t, storage, userGUID, database.NewFamily(), jwtCfg)
+	_, revokedBindKey := save(t, storage, userGUID, database.NewFamily(), jwtCfg)
+	save(t, storage, userGUID, database.NewFamily(), expJwtCfg)
+	save(t, storage, uuid.New(), database.NewFamily(), jwtCfg)
+
+	require.NoError(t, storage.RevokeRefreshToken(ctx, revokedBindKey))
+
+	sessions, err := storage.ListSessions(ctx, userGUID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	require.Equal(t, secondBindKey, sessions[0].BindKey)
+	require.Equal(t, firstBindKey, sessions[1].BindKey)
+	require.Equal(t, client, sessions[0].Client)
+	require.True(t, sessions[0].ExpiresAt.After(sessions[0].CreatedAt))
+
+	require.ErrorIs(t, storage.RevokeSession(ctx, uuid.New(), sessions[0].ID), database.ErrTokenNotFound)
+	require.NoError(t, storage.RevokeSession(ctx, userGUID, sessions[0].ID))
+	require.NoError(t, storage.RevokeSession(ctx, userGUID, sessions[0].ID))
+
+	sessions, err = storage.ListSessions(ctx, userGUID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	require.Equal(t, firstBindKey, sessions[0].BindKey)
+
+	sessions, err = storage.ListSessions(ctx, uuid.New())
+	require.NoError(t, err)
+	require.Empty(t, sessions)
+}
+
+func testPurge(t *testing.T, storage Storage) {
+	userGUID := uuid.New()
+	family := database.NewFamily()
+
+	oldJwtCfg := jwtCfg
+	oldJwtCfg.RefreshExpires = -time.Hour
+
+	var oldBindKeys []string
+	for i := 0; i < 3; i++ {
+		_, bindKey := save(t, storage, userGUID, family, oldJwtCfg)
+		oldBindKeys = append(oldBindKeys, bindKey)
+	}
+
+	_, recentBindKey := save(t, storage, userGUID, family, expJwtCfg)
+	_, liveBindKey := save(t, storage, userGUID, family, jwtCfg)
+
+	// Other tests sharing the storage may have left expired tokens behind,
+	// so only the batch size is checked.
+	deleted, err := storage.PurgeRefreshTokens(ctx, time.Now().Add(-30*time.Minute), 2)
+	require.NoError(t, err)
+	require.LessOrEqual(t, deleted, int64(2))
+
+	for deleted == 2 {
+		deleted, err = storage.PurgeRefreshTokens(ctx, time.Now().Add(-30*time.Minute), 2)
+		require.NoError(t, err)
+	}
+
+	for _, bindKey := range oldBindKeys {
+		_, err = storage.GetRefreshToken(ctx, bindKey)
+		require.ErrorIs(t, err, database.ErrTokenNotFound)
+	}
+
+	_, err = storage.GetRefreshToken(ctx, recentBindKey)
+	require.NoError(t, err)
+
+	_, err = storage.GetRefreshToken(ctx, liveBindKey)
+	require.NoError(t, err)
+}
+
+func testUsers(t *testing.T, storage Storage) {
+	user := database.User{
+		GUID:           uuid.New(),
+		Email:          "user@mail",
+		Locale:         "en",
+		NotifyIPChange: true,
+	}
+
+	_, err := storage.GetUser(ctx, user.GUID)
+	require.ErrorIs(t, err, database.ErrUserNotFound)
+
+	require.NoError(t, storage.SaveUser(ctx, user))
+
+	saved, err := storage.GetUser(ctx, user.GUID)
+	require.NoError(t, err)
+	require.Equal(t, user, saved)
+
+	user.EmailVerified = true
+	user.NotifyIPChange = false
+	require.NoError(t, storage.SaveUser(ctx, user))
+
+	saved, err = storage.GetUser(ctx, user.GUID)
+	require.NoError(t, err)
+	require.Equal(t, user, saved)
+
+	_, err = storage.GetUserByUsername(ctx, "")
+	require.ErrorIs(t, err, database.ErrUserNotFound, "Users without a username are not found by it")
+
+	user.Username = "user-" + user.GUID.String()
+	user.PasswordHash = "$argon2id$hash"
+	require.NoError(t, storage.SaveUser(ctx, user))
+
+	saved, err = storage.GetUserByUsername(ctx, user.Username)
+	require.NoError(t, err)
+	require.Equal(t, user, saved)
+
+	saved, err = storage.GetUser(ctx, user.GUID)
+	require.NoError(t, err)
+	require.Equal(t, user, saved)
+
+	other := database.User{GUID: uuid.New()}
+	require.NoError(t, storage.SaveUser(ctx, other), "Any number of users have no username")
+
+	other.Username = user.Username
+	err = storage.SaveUser(ctx, other)
+	require.ErrorIs(t, err, database.ErrUsernameTaken)
+}
+
+func testAccounts(t *testing.T, storage Storage) {
+	user := database.User{
+		GUID:         uuid.New(),
+		Email:        uuid.NewString() + "@mail",
+		Locale:       "en",
+		PasswordHash: "$argon2id$old",
+	}
+	user.Username = user.Email
+
+	require.NoError(t, storage.CreateUser(ctx, user, []database.Email{
+		{Kind: database.EmailVerifyEmail, To: user.Email, Params: map[string]string{"url": "https://auth/verify"}},
+	}))
+
+	saved, err := storage.GetUser(ctx, user.GUID)
+	require.NoError(t, err)
+	require.Equal(t, user, saved)
+
+	emails, err := storage.PendingEmails(ctx, time.Now(), 1000)
+	require.NoError(t, err)
+
+	var queued bool
+	for _, email := range emails {
+		if email.To == user.Email {
+			queued = true
+			require.Equal(t, database.EmailVerifyEmail, email.Kind)
+		}
+	}
+	require.True(t, queued, "The verification is queued along with the user")
+
+	err = storage.CreateUser(ctx, database.User{GUID: uuid.New(), Username: user.Username}, nil)
+	require.ErrorIs(t, err, database.ErrUsernameTaken)
+
+	err = storage.CreateUser(ctx, database.User{GUID: user.GUID, Username: "other-" + user.Username}, nil)
+	require.ErrorIs(t, err, database.ErrUsernameTaken, "Existing users are not replaced")
+
+	// Email verification succeeds once.
+	err = storage.VerifyEmail(ctx, user.GUID, "other@mail")
+	require.ErrorIs(t, err, database.ErrUserNotFound)
+	require.NoError(t, storage.VerifyEmail(ctx, user.GUID, user.Email))
+	err = storage.VerifyEmail(ctx, user.GUID, user.Email)
+	require.ErrorIs(t, err, database.ErrUserNotFound)
+
+	saved, err = storage.GetUser(ctx, user.GUID)
+	require.NoError(t, err)
+	require.True(t, saved.EmailVerified)
+
+	_, bindKey := save(t, storage, user.GUID, database.NewFamily(), jwtCfg)
+
+	// A rehash keeps the tokens.
+	require.NoError(t, storage.SetPasswordHash(ctx, user.GUID, "$argon2id$old", "$argon2id$rehashed", false))
+
+	claims, err := storage.GetRefreshToken(ctx, bindKey)
+	require.NoError(t, err)
+	require.False(t, claims.IsRevoked)
+
+	err = storage.SetPasswordHash(ctx, user.GUID, "$argon2id$old", "$argon2id$new", true)
+	require.ErrorIs(t, err, database.ErrUserNotFound, "A stale hash is not replaced")
+
+	claims, err = storage.GetRefreshToken(ctx, bindKey)
+	require.NoError(t, err)
+	require.False(t, claims.IsRevoked)
+
+	require.NoError(t, storage.SetPasswordHash(ctx, user.GUID, "$argon2id$rehashed", "$argon2id$new", true))
+
+	claims, err = storage.GetRefreshToken(ctx, bindKey)
+	require.NoError(t, err)
+	require.True(t, claims.IsRevoked, "A password change revokes the tokens")
+
+	saved, err = storage.GetUser(ctx, user.GUID)
+	require.NoError(t, err)
+	require.Equal(t, "$argon2id$new", saved.PasswordHash)
+
+	err = storage.SetPasswordHash(ctx, uuid.New(), "", "$argon2id$new", true)
+	require.ErrorIs(t, err, database.ErrUserNotFound)
+
+	// Disabling.
+	disabled, err := storage.IsUserDisabled(ctx, user.GUID)
+	require.NoError(t, err)
+	require.False(t, disabled)
+
+	require.NoError(t, storage.SetUserDisabled(ctx, user.GUID, true))
+
+	disabled, err = storage.IsUserDisabled(ctx, user.GUID)
+	require.NoError(t, err)
+	require.True(t, disabled)
+
+	saved, err = storage.GetUserByUsername(ctx, user.Username)
+	require.NoError(t, err)
+	require.True(t, saved.Disabled)
+
+	require.NoError(t, storage.SetUserDisabled(ctx, user.GUID, false))
+
+	disabled, err = storage.IsUserDisabled(ctx, user.GUID)
+	require.NoError(t, err)
+	require.False(t, disabled)
+
+	err = storage.SetUserDisabled(ctx, uuid.New(), true)
+	require.ErrorIs(t, err, database.ErrUserNotFound)
+
+	disabled, err = storage.IsUserDisabled(ctx, uuid.New())
+	require.NoError(t, err)
+	require.False(t, disabled, "Users missing from the directory are not disabled")
+}
+
+func testOutbox(t *testing.T, storage Storage) {
+	userGUID := uuid.New()
+	to := userGUID.String() + "@mail"
+
+	_, bindKey := save(t, storage, userGUID, database.NewFamily(), jwtCfg)
+
+	newToken, err := tokens.GenerateRefreshToken()
+	require.NoError(t, err)
+
+	_, err = storage.RotateRefreshToken(ctx, bindKey, newToken, client, jwtCfg, []database.Email{
+		{Kind: database.EmailIPWarning, To: to, Params: map[string]string{"ip": "192.168.0.1"}},
+		{Kind: database.EmailIPWarning, To: to, Params: map[string]string{"ip": "192.168.0.2"}},
+	})
+	require.NoError(t, err)
+
+	// A failed rotation queues nothing.
+	_, err = storage.RotateRefreshToken(ctx, bindKey, "some string", client, jwtCfg, []database.Email{
+		{Kind: database.EmailIPWarning, To: to, Params: map[string]string{"ip": "192.168.0.3"}},
+	})
+	require.ErrorIs(t, err, database.ErrTokenRevoked)
+
+	// Storages may be shared, so only the emails of this test are looked at.
+	pending := func() []database.Email {
+		emails, err := storage.PendingEmails(ctx, time.Now(), 1000)
+		require.NoError(t, err)
+
+		var own []database.Email
+		for _, email := range emails {
+			if email.To == to {
+				own = append(own, email)
+			}
+		}
+
+		return own
+	}
+
+	emails := pending()
+	require.Len(t, emails, 2)
+	require.Equal(t, database.EmailPending, emails[0].Status)
+	require.Equal(t, "192.168.0.1", emails[0].Params["ip"])
+	require.Equal(t, "192.168.0.2", emails[1].Params["ip"])
+
+	sent, dead := emails[0], emails[1]
+
+	require.NoError(t, storage.MarkEmailSent(ctx, sent.ID))
+
+	require.NoError(t, storage.RetryEmail(ctx, dead.ID, "some error", time.Now().Add(time.Hour)))
+	require.Empty(t, pending())
+
+	require.NoError(t, storage.MarkEmailDead(ctx, dead.ID, "some error"))
+
+	deadEmails, err := storage.ListEmails(ctx, database.EmailDead, 1000)
+	require.NoError(t, err)
+
+	var found bool
+	for _, email := range deadEmails {
+		if email.ID == dead.ID {
+			found = true
+			require.Equal(t, 2, email.Attempts)
+			require.Equal(t, "some error", email.LastError)
+		}
+	}
+	require.True(t, found)
+
+	sentEmails, err := storage.ListEmails(ctx, database.EmailSent, 1000)
+	require.NoError(t, err)
+
+	found = false
+	for _, email := range sentEmails {
+		if email.ID == sent.ID {
+			found = true
+			require.False(t, email.SentAt.IsZero())
+		}
+	}
+	require.True(t, found)
+
+	require.ErrorIs(t, storage.ReplayEmail(ctx, sent.ID), database.ErrEmailNotFound)
+	require.NoError(t, storage.ReplayEmail(ctx, dead.ID))
+
+	emails = pending()
+	require.Len(t, emails, 1)
+	require.Equal(t, dead.ID, emails[0].ID)
+	require.Equal(t, 0, emails[0].Attempts)
+}
@@ -0,0 +1,10 @@
+package email
+
+// Message is a rendered email, sent as multipart with plain-text and HTML
+// alternatives.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
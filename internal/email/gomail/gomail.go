@@ -1,38 +1,269 @@
 package gomail
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net/smtp"
+	"os"
+	"sync"
+	"time"
 
 	"gopkg.in/mail.v2"
 
 	"auth/internal/config"
+	"auth/internal/email"
+)
+
+// TLS modes.
+const (
+	TLSStartTLS = "starttls"
+	TLSImplicit = "implicit"
+	TLSNone     = "none"
+)
+
+// Auth mechanisms.
+const (
+	AuthPlain   = "plain"
+	AuthLogin   = "login"
+	AuthCRAMMD5 = "cram-md5"
+	AuthNone    = "none"
+)
+
+const (
+	defaultPoolSize        = 4
+	defaultPoolIdleTimeout = 30 * time.Second
 )
 
 type Email struct {
 	Dialer *mail.Dialer
+
+	from        string
+	idleTimeout time.Duration
+
+	// slots bounds the open sessions, idle holds the sessions not in use.
+	slots chan struct{}
+	idle  chan *session
+
+	mu     sync.Mutex
+	closed bool
+}
+
+type session struct {
+	sender   mail.SendCloser
+	lastUsed time.Time
 }
 
-func New(config config.Email) *Email {
-	d := mail.NewDialer(config.Host, config.Port, config.From, config.Password)
-	d.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+// New returns the SMTP mailer. Sessions are opened on demand and kept for
+// the following emails, so a burst of emails does not log in for each.
+func New(config config.Email) (*Email, error) {
+	const op = "email.gomail.New"
+
+	username := config.Username
+	if username == "" {
+		username = config.From
+	}
+
+	from := config.From
+	if from == "" {
+		from = username
+	}
+
+	d := mail.NewDialer(config.Host, config.Port, username, config.Password)
+
+	tlsConfig := &tls.Config{ServerName: config.Host, MinVersion: tls.VersionTLS12}
+
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: Can not to read CA bundle: %w", op, err)
+		}
+
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: CA bundle %s has no certificates", op, config.CAFile)
+		}
+
+		tlsConfig.RootCAs = roots
+	}
+
+	d.TLSConfig = tlsConfig
+
+	tlsMode := config.TLS
+	if tlsMode == "" {
+		tlsMode = TLSStartTLS
+		if config.Port == 465 {
+			tlsMode = TLSImplicit
+		}
+	}
+
+	switch tlsMode {
+	case TLSStartTLS:
+		d.SSL = false
+		d.StartTLSPolicy = mail.MandatoryStartTLS
+	case TLSImplicit:
+		d.SSL = true
+	case TLSNone:
+		d.SSL = false
+		d.StartTLSPolicy = mail.NoStartTLS
+	default:
+		return nil, fmt.Errorf("%s: Unknown TLS mode: %q", op, config.TLS)
+	}
+
+	authMechanism := config.Auth
+	if authMechanism == "" {
+		authMechanism = AuthNone
+		if username != "" {
+			authMechanism = AuthPlain
+		}
+	}
+
+	// The mechanism is always set, as the dialer picks one on first use
+	// otherwise, which races when sessions are opened concurrently.
+	switch authMechanism {
+	case AuthPlain:
+		d.Auth = smtp.PlainAuth("", username, config.Password, config.Host)
+	case AuthLogin:
+		d.Auth = &loginAuth{username: username, password: config.Password, host: config.Host}
+	case AuthCRAMMD5:
+		d.Auth = smtp.CRAMMD5Auth(username, config.Password)
+	case AuthNone:
+		d.Username = ""
+	default:
+		return nil, fmt.Errorf("%s: Unknown auth mechanism: %q", op, config.Auth)
+	}
+
+	poolSize := config.PoolSize
+	if poolSize <= 0 {
+		poolSize = defaultPoolSize
+	}
+
+	idleTimeout := config.PoolIdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = defaultPoolIdleTimeout
+	}
 
-	return &Email{Dialer: d}
+	return &Email{
+		Dialer:      d,
+		from:        from,
+		idleTimeout: idleTimeout,
+		slots:       make(chan struct{}, poolSize),
+		idle:        make(chan *session, poolSize),
+	}, nil
 }
 
-func (e *Email) SendIpWarnig(to, ip string) error {
-	const op = "email.gomail.SendIpWarnig"
+// Send sends the message as multipart/alternative with the plain-text and
+// HTML bodies. It returns as soon as ctx is done. The SMTP client can not be
+// interrupted, so the message may still be sent afterwards.
+func (e *Email) Send(ctx context.Context, rendered email.Message) error {
+	const op = "email.gomail.Send"
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
 
 	message := mail.NewMessage()
-	message.SetHeader("From", e.Dialer.Username)
-	message.SetHeader("To", to)
-	message.SetHeader("Subject", "New IP warning")
-	message.SetBody("text/plain", "IP: "+ip)
+	message.SetHeader("From", e.from)
+	message.SetHeader("To", rendered.To)
+	message.SetHeader("Subject", rendered.Subject)
+	message.SetBody("text/plain", rendered.Text)
+	if rendered.HTML != "" {
+		message.AddAlternative("text/html", rendered.HTML)
+	}
+
+	select {
+	case e.slots <- struct{}{}:
+	case <-ctx.Done():
+		return fmt.Errorf("%s: %w", op, ctx.Err())
+	}
 
-	err := e.Dialer.DialAndSend(message)
+	sent := make(chan error, 1)
+	go func() {
+		defer func() { <-e.slots }()
+		sent <- e.send(message)
+	}()
+
+	select {
+	case err := <-sent:
+		if err != nil {
+			return fmt.Errorf("%s: Sending email error: %w", op, err)
+		}
+	case <-ctx.Done():
+		return fmt.Errorf("%s: %w", op, ctx.Err())
+	}
+
+	return nil
+}
+
+// Close ends the idle sessions. Sessions in use are ended when their
+// emails are sent.
+func (e *Email) Close() error {
+	e.mu.Lock()
+	e.closed = true
+	e.mu.Unlock()
+
+	for {
+		select {
+		case s := <-e.idle:
+			s.sender.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// send sends the message over an idle session or a new one. A session that
+// failed is ended, as the server may have left it in any state.
+func (e *Email) send(message *mail.Message) error {
+	s, err := e.session()
 	if err != nil {
-		return fmt.Errorf("%s: Sending email error: %w", op, err)
+		return err
+	}
+
+	if err := mail.Send(s.sender, message); err != nil {
+		s.sender.Close()
+		return err
 	}
 
+	s.lastUsed = time.Now()
+	e.release(s)
+
 	return nil
 }
+
+func (e *Email) session() (*session, error) {
+	for {
+		select {
+		case s := <-e.idle:
+			// Servers drop idle sessions, so old ones are not worth a try.
+			if time.Since(s.lastUsed) < e.idleTimeout {
+				return s, nil
+			}
+			s.sender.Close()
+		default:
+			sender, err := e.Dialer.Dial()
+			if err != nil {
+				return nil, err
+			}
+
+			return &session{sender: sender}, nil
+		}
+	}
+}
+
+func (e *Email) release(s *session) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		s.sender.Close()
+		return
+	}
+
+	select {
+	case e.idle <- s:
+	default:
+		s.sender.Close()
+	}
+}
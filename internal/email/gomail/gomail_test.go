@@ -0,0 +1,436 @@
+package gomail_test
+
+import (
+	"auth/internal/config"
+	"auth/internal/email"
+	"auth/internal/email/gomail"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	username = "smtp-user"
+	password = "smtp-password"
+	from     = "noreply@auth.example"
+)
+
+// stubServer is an SMTP server accepting one login and keeping the data
+// of the messages it receives.
+type stubServer struct {
+	t         *testing.T
+	listener  net.Listener
+	tlsConfig *tls.Config
+	implicit  bool
+	startTLS  bool
+	auth      string
+
+	mu       sync.Mutex
+	sessions int
+	logins   int
+	messages []string
+}
+
+func newStubServer(t *testing.T, cert tls.Certificate, implicit bool, startTLS bool, auth string) *stubServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &stubServer{
+		t:         t,
+		listener:  listener,
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		implicit:  implicit,
+		startTLS:  startTLS,
+		auth:      auth,
+	}
+
+	if implicit {
+		s.listener = tls.NewListener(listener, s.tlsConfig)
+	}
+
+	go s.serve()
+	t.Cleanup(func() { s.listener.Close() })
+
+	return s
+}
+
+func (s *stubServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *stubServer) stats() (int, int, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sessions, s.logins, append([]string(nil), s.messages...)
+}
+
+func (s *stubServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.sessions++
+		s.mu.Unlock()
+
+		go s.handle(conn)
+	}
+}
+
+func (s *stubServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	text := textproto.NewConn(conn)
+	encrypted := s.implicit
+	authenticated := s.auth == ""
+
+	text.PrintfLine("220 stub ESMTP")
+
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		command, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(command) {
+		case "EHLO", "HELO":
+			lines := []string{"stub"}
+			if s.startTLS && !encrypted {
+				lines = append(lines, "STARTTLS")
+			}
+			if s.auth != "" {
+				lines = append(lines, "AUTH "+s.auth)
+			}
+			for i, l := range lines {
+				sep := "-"
+				if i == len(lines)-1 {
+					sep = " "
+				}
+				text.PrintfLine("250%s%s", sep, l)
+			}
+		case "STARTTLS":
+			text.PrintfLine("220 Ready")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn = tlsConn
+			text = textproto.NewConn(conn)
+			encrypted = true
+		case "AUTH":
+			if s.login(text, arg) {
+				s.mu.Lock()
+				s.logins++
+				s.mu.Unlock()
+				authenticated = true
+				text.PrintfLine("235 Authenticated")
+			} else {
+				text.PrintfLine("535 Invalid credentials")
+			}
+		case "MAIL":
+			if !authenticated {
+				text.PrintfLine("530 Authentication required")
+				continue
+			}
+			text.PrintfLine("250 OK")
+		case "RCPT", "RSET", "NOOP":
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 Go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, string(data))
+			s.mu.Unlock()
+			text.PrintfLine("250 Queued")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("502 Unknown command")
+		}
+	}
+}
+
+func (s *stubServer) login(text *textproto.Conn, arg string) bool {
+	mechanism, initial, _ := strings.Cut(arg, " ")
+
+	challenge := func(prompt string) string {
+		text.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(prompt)))
+		line, _ := text.ReadLine()
+		decoded, _ := base64.StdEncoding.DecodeString(line)
+		return string(decoded)
+	}
+
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		decoded, _ := base64.StdEncoding.DecodeString(initial)
+		return string(decoded) == "\x00"+username+"\x00"+password
+	case "LOGIN":
+		return challenge("Username:") == username && challenge("Password:") == password
+	case "CRAM-MD5":
+		nonce := "<" + strconv.FormatInt(time.Now().UnixNano(), 10) + "@stub>"
+		mac := hmac.New(md5.New, []byte(password))
+		mac.Write([]byte(nonce))
+		return challenge(nonce) == username+" "+hex.EncodeToString(mac.Sum(nil))
+	default:
+		return false
+	}
+}
+
+// newCert returns a self-signed certificate for 127.0.0.1 and the path of
+// its PEM bundle.
+func newCert(t *testing.T) (tls.Certificate, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "stub"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, caFile
+}
+
+var message = email.Message{
+	To:      "user@mail",
+	Subject: "New sign-in from 192.168.0.1",
+	Text:    "IP: 192.168.0.1",
+	HTML:    "<p>IP: 192.168.0.1</p>",
+}
+
+func TestSend(t *testing.T) {
+	cert, caFile := newCert(t)
+
+	cases := []struct {
+		name      string
+		implicit  bool
+		startTLS  bool
+		serveAuth string
+		tls       string
+		caFile    string
+		auth      string
+		password  string
+		wantError bool
+	}{
+		{
+			name:      "STARTTLS with PLAIN",
+			startTLS:  true,
+			serveAuth: "PLAIN LOGIN",
+			caFile:    caFile,
+			auth:      gomail.AuthPlain,
+		},
+		{
+			name:      "Implicit TLS with LOGIN",
+			implicit:  true,
+			serveAuth: "LOGIN",
+			tls:       gomail.TLSImplicit,
+			caFile:    caFile,
+			auth:      gomail.AuthLogin,
+		},
+		{
+			name:      "Plaintext with CRAM-MD5",
+			serveAuth: "CRAM-MD5",
+			tls:       gomail.TLSNone,
+			auth:      gomail.AuthCRAMMD5,
+		},
+		{
+			name: "Plaintext without auth",
+			tls:  gomail.TLSNone,
+			auth: gomail.AuthNone,
+		},
+		{
+			name:      "Untrusted certificate",
+			startTLS:  true,
+			serveAuth: "PLAIN",
+			auth:      gomail.AuthPlain,
+			wantError: true,
+		},
+		{
+			name:      "STARTTLS is not offered",
+			serveAuth: "PLAIN",
+			caFile:    caFile,
+			auth:      gomail.AuthPlain,
+			wantError: true,
+		},
+		{
+			name:      "Invalid password",
+			startTLS:  true,
+			serveAuth: "PLAIN",
+			caFile:    caFile,
+			auth:      gomail.AuthPlain,
+			password:  "some string",
+			wantError: true,
+		},
+	}
+
+	for _, tc := range cases {
+		server := newStubServer(t, cert, tc.implicit, tc.startTLS, tc.serveAuth)
+
+		pass := password
+		if tc.password != "" {
+			pass = tc.password
+		}
+
+		mailer, err := gomail.New(config.Email{
+			Host:     "127.0.0.1",
+			Port:     server.port(),
+			From:     from,
+			Username: username,
+			Password: pass,
+			TLS:      tc.tls,
+			CAFile:   tc.caFile,
+			Auth:     tc.auth,
+		})
+		require.NoError(t, err, "Case: %s", tc.name)
+
+		err = mailer.Send(context.Background(), message)
+		require.Equal(t, tc.wantError, err != nil, "Case: %s: %v", tc.name, err)
+		mailer.Close()
+
+		if tc.wantError {
+			continue
+		}
+
+		_, _, messages := server.stats()
+		require.Len(t, messages, 1, "Case: %s", tc.name)
+		require.Contains(t, messages[0], "From: "+from, "Case: %s", tc.name)
+		require.Contains(t, messages[0], "To: "+message.To, "Case: %s", tc.name)
+		require.Contains(t, messages[0], "text/plain", "Case: %s", tc.name)
+		require.Contains(t, messages[0], "text/html", "Case: %s", tc.name)
+	}
+}
+
+func TestPool(t *testing.T) {
+	cert, caFile := newCert(t)
+
+	newMailer := func(server *stubServer, poolSize int, idleTimeout time.Duration) *gomail.Email {
+		mailer, err := gomail.New(config.Email{
+			Host:            "127.0.0.1",
+			Port:            server.port(),
+			From:            from,
+			Username:        username,
+			Password:        password,
+			CAFile:          caFile,
+			PoolSize:        poolSize,
+			PoolIdleTimeout: idleTimeout,
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { mailer.Close() })
+
+		return mailer
+	}
+
+	t.Run("Sequential emails share a session", func(t *testing.T) {
+		server := newStubServer(t, cert, false, true, "PLAIN")
+		mailer := newMailer(server, 2, time.Minute)
+
+		for range 5 {
+			require.NoError(t, mailer.Send(context.Background(), message))
+		}
+
+		sessions, logins, messages := server.stats()
+		require.Equal(t, 1, sessions)
+		require.Equal(t, 1, logins)
+		require.Len(t, messages, 5)
+	})
+
+	t.Run("Burst is bounded by pool size", func(t *testing.T) {
+		server := newStubServer(t, cert, false, true, "PLAIN")
+		mailer := newMailer(server, 3, time.Minute)
+
+		var wg sync.WaitGroup
+		for i := range 30 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				m := message
+				m.To = fmt.Sprintf("user%d@mail", i)
+				require.NoError(t, mailer.Send(context.Background(), m))
+			}()
+		}
+		wg.Wait()
+
+		sessions, _, messages := server.stats()
+		require.LessOrEqual(t, sessions, 3)
+		require.Len(t, messages, 30)
+	})
+
+	t.Run("Idle session is replaced", func(t *testing.T) {
+		server := newStubServer(t, cert, false, true, "PLAIN")
+		mailer := newMailer(server, 2, 10*time.Millisecond)
+
+		require.NoError(t, mailer.Send(context.Background(), message))
+		time.Sleep(20 * time.Millisecond)
+		require.NoError(t, mailer.Send(context.Background(), message))
+
+		sessions, _, _ := server.stats()
+		require.Equal(t, 2, sessions)
+	})
+}
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		name   string
+		config config.Email
+	}{
+		{name: "Unknown TLS mode", config: config.Email{TLS: "some string"}},
+		{name: "Unknown auth mechanism", config: config.Email{Auth: "some string"}},
+		{name: "Missing CA bundle", config: config.Email{CAFile: "missing.pem"}},
+		{name: "CA bundle without certificates", config: config.Email{CAFile: writeFile(t, "not a certificate")}},
+	}
+
+	for _, tc := range cases {
+		_, err := gomail.New(tc.config)
+		require.Error(t, err, "Case: %s", tc.name)
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
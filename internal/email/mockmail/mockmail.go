@@ -1,13 +1,20 @@
 package mockmail
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
+	"strings"
+	"sync"
+	"time"
 
 	"auth/internal/config"
+	"auth/internal/email"
 )
 
+const defaultCaptureLimit = 100
+
 type Dialer struct {
 	Host     string
 	Port     int
@@ -17,36 +24,58 @@ type Dialer struct {
 }
 
 type Message struct {
-	From    string
-	To      string
-	Subject string
-	Body    string
+	ID      int64     `json:"id"`
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Subject string    `json:"subject"`
+	Text    string    `json:"text"`
+	HTML    string    `json:"html"`
+	SentAt  time.Time `json:"sent_at"`
 }
 
 type Email struct {
 	Dialer Dialer
+
+	mu       sync.RWMutex
+	limit    int
+	lastID   int64
+	messages []Message
 }
 
 func New(config config.Email, log *slog.Logger) *Email {
 	d := Dialer{config.Host, config.Port, config.From, config.Password, log}
 
-	return &Email{Dialer: d}
+	limit := config.CaptureLimit
+	if limit <= 0 {
+		limit = defaultCaptureLimit
+	}
+
+	return &Email{Dialer: d, limit: limit}
 }
 
-func (email *Email) SendIpWarnig(to, ip string) error {
-	const op = "email.mockmail.SendIpWarnig"
+// Send logs the rendered message instead of sending it and keeps it in the
+// inbox, so development shows the same emails production sends.
+func (e *Email) Send(ctx context.Context, rendered email.Message) error {
+	const op = "email.mockmail.Send"
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
 
 	message := Message{}
-	message.From = email.Dialer.From
-	message.To = to
-	message.Subject = "New IP warning"
-	message.Body = "IP: " + ip
+	message.From = e.Dialer.From
+	message.To = rendered.To
+	message.Subject = rendered.Subject
+	message.Text = rendered.Text
+	message.HTML = rendered.HTML
 
-	err := email.Dialer.Send(message)
+	err := e.Dialer.Send(message)
 	if err != nil {
 		return fmt.Errorf("%s: Sending message error: %w", op, err)
 	}
 
+	e.capture(message)
+
 	return nil
 }
 
@@ -59,7 +88,68 @@ func (dialer *Dialer) Send(message Message) error {
 
 	log := dialer.log
 	log.Debug("Message was sent", slog.String("To", message.To),
-		slog.String("Subject", message.Subject), slog.String("Body", message.Body))
+		slog.String("Subject", message.Subject), slog.String("Text", message.Text),
+		slog.String("HTML", message.HTML))
 
 	return nil
 }
+
+func (e *Email) capture(message Message) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.lastID++
+	message.ID = e.lastID
+	message.SentAt = time.Now()
+
+	if len(e.messages) >= e.limit {
+		e.messages = append(e.messages[:0], e.messages[len(e.messages)-e.limit+1:]...)
+	}
+	e.messages = append(e.messages, message)
+}
+
+// Messages returns the captured messages, newest first. A non-empty to
+// keeps the messages whose recipient contains it, ignoring case.
+func (e *Email) Messages(to string) []Message {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	to = strings.ToLower(to)
+	messages := []Message{}
+
+	for i := len(e.messages) - 1; i >= 0; i-- {
+		if strings.Contains(strings.ToLower(e.messages[i].To), to) {
+			messages = append(messages, e.messages[i])
+		}
+	}
+
+	return messages
+}
+
+// Message returns the captured message with the ID, false if it was never
+// captured or has been dropped.
+func (e *Email) Message(id int64) (Message, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for _, message := range e.messages {
+		if message.ID == id {
+			return message, true
+		}
+	}
+
+	return Message{}, false
+}
+
+// Close does nothing, the captured messages are kept for the inbox.
+func (e *Email) Close() error {
+	return nil
+}
+
+// Clear drops all captured messages.
+func (e *Email) Clear() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.messages = nil
+}
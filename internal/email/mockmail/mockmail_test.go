@@ -0,0 +1,41 @@
+package mockmail_test
+
+import (
+	"auth/internal/config"
+	"auth/internal/email"
+	"auth/internal/email/mockmail"
+	sl "auth/internal/lib/logger/sl/sldiscard"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInbox(t *testing.T) {
+	mailer := mockmail.New(config.Email{From: "auth@mail", CaptureLimit: 2}, sl.NewDiscardLogger())
+
+	for _, to := range []string{"first@mail", "Second@mail", "third@mail"} {
+		require.NoError(t, mailer.Send(context.Background(), email.Message{To: to, Subject: "Subject"}))
+	}
+
+	require.Error(t, mailer.Send(context.Background(), email.Message{To: "invalid@mail"}))
+
+	messages := mailer.Messages("")
+	require.Len(t, messages, 2, "Oldest message is dropped")
+	require.Equal(t, "third@mail", messages[0].To)
+	require.Equal(t, "Second@mail", messages[1].To)
+	require.Equal(t, "auth@mail", messages[0].From)
+
+	messages = mailer.Messages("second")
+	require.Len(t, messages, 1, "Search ignores case")
+
+	message, ok := mailer.Message(messages[0].ID)
+	require.True(t, ok)
+	require.Equal(t, "Second@mail", message.To)
+
+	_, ok = mailer.Message(1)
+	require.False(t, ok, "Dropped message")
+
+	mailer.Clear()
+	require.Empty(t, mailer.Messages(""))
+}
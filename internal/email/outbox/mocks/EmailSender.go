@@ -2,20 +2,26 @@
 
 package mocks
 
-import mock "github.com/stretchr/testify/mock"
+import (
+	context "context"
+
+	email "auth/internal/email"
+
+	mock "github.com/stretchr/testify/mock"
+)
 
 // EmailSender is an autogenerated mock type for the EmailSender type
 type EmailSender struct {
 	mock.Mock
 }
 
-// SendIpWarnig provides a mock function with given fields: to, ip
-func (_m *EmailSender) SendIpWarnig(to string, ip string) error {
-	ret := _m.Called(to, ip)
+// Send provides a mock function with given fields: ctx, message
+func (_m *EmailSender) Send(ctx context.Context, message email.Message) error {
+	ret := _m.Called(ctx, message)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string, string) error); ok {
-		r0 = rf(to, ip)
+	if rf, ok := ret.Get(0).(func(context.Context, email.Message) error); ok {
+		r0 = rf(ctx, message)
 	} else {
 		r0 = ret.Error(0)
 	}
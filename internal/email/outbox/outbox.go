@@ -0,0 +1,265 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"auth/internal/config"
+	"auth/internal/database"
+	"auth/internal/email"
+	"auth/internal/email/templates"
+	"auth/internal/lib/logger/sl"
+	"auth/internal/lib/tokens"
+	"auth/internal/scheduler"
+)
+
+const (
+	JobName = "dispatch_emails"
+
+	defaultInterval    = 10 * time.Second
+	defaultBatchSize   = 100
+	defaultSendTimeout = 30 * time.Second
+	defaultMaxAttempts = 8
+	defaultBackoff     = 30 * time.Second
+	defaultMaxBackoff  = time.Hour
+
+	defaultRevokeLinkExpires = 72 * time.Hour
+	defaultVerifyLinkExpires = 24 * time.Hour
+)
+
+// metrics counts sent, retried and dead emails, they are published with
+// expvar under "email_outbox".
+var metrics = expvar.NewMap("email_outbox")
+
+// ErrUndeliverable is returned for emails that can not be rendered, they
+// are moved to dead letters at once.
+var ErrUndeliverable = errors.New("email can not be rendered")
+
+//go:generate go run github.com/vektra/mockery/v3 --name=EmailStorage
+type EmailStorage interface {
+	PendingEmails(ctx context.Context, now time.Time, limit int) ([]database.Email, error)
+	MarkEmailSent(ctx context.Context, id int64) error
+	RetryEmail(ctx context.Context, id int64, lastError string, nextAttemptAt time.Time) error
+	MarkEmailDead(ctx context.Context, id int64, lastError string) error
+}
+
+// EmailSender is implemented by the mailer backends the outbox delivers to.
+//
+//go:generate go run github.com/vektra/mockery/v3 --name=EmailSender
+type EmailSender interface {
+	Send(ctx context.Context, message email.Message) error
+}
+
+// Links builds the links put in emails. Links are left out unless both
+// BaseURL and Secret are set.
+type Links struct {
+	BaseURL       string
+	Secret        string
+	RevokeExpires time.Duration
+	VerifyExpires time.Duration
+}
+
+func NewLinks(cfg *config.Config) Links {
+	revokeExpires := cfg.Email.RevokeLinkExpires
+	if revokeExpires <= 0 {
+		revokeExpires = defaultRevokeLinkExpires
+	}
+
+	verifyExpires := cfg.Email.VerifyLinkExpires
+	if verifyExpires <= 0 {
+		verifyExpires = defaultVerifyLinkExpires
+	}
+
+	return Links{
+		BaseURL:       strings.TrimSuffix(cfg.Server.PublicURL, "/"),
+		Secret:        cfg.Email.LinkSecret,
+		RevokeExpires: revokeExpires,
+		VerifyExpires: verifyExpires,
+	}
+}
+
+// Revoke returns the "this wasn't me" link revoking the token family,
+// served by the revokelink handlers.
+func (l Links) Revoke(userGUID string, familyID string, now time.Time) string {
+	if l.BaseURL == "" || l.Secret == "" || familyID == "" {
+		return ""
+	}
+
+	token := tokens.SignLink(l.Secret, tokens.LinkRevokeFamily, now.Add(l.RevokeExpires), familyID, userGUID)
+
+	return l.BaseURL + "/revoke/link?token=" + url.QueryEscape(token)
+}
+
+// Verify returns the link confirming the email of the user, served by the
+// verifylink handlers.
+func (l Links) Verify(userGUID string, address string, now time.Time) string {
+	if l.BaseURL == "" || l.Secret == "" || userGUID == "" {
+		return ""
+	}
+
+	token := tokens.SignLink(l.Secret, tokens.LinkVerifyEmail, now.Add(l.VerifyExpires), userGUID, address)
+
+	return l.BaseURL + "/users/verify?token=" + url.QueryEscape(token)
+}
+
+type dispatcher struct {
+	log       *slog.Logger
+	storage   EmailStorage
+	sender    EmailSender
+	templates *templates.Renderer
+	links     Links
+	cfg       config.Outbox
+}
+
+// NewDispatchJob returns the job rendering and delivering due emails of
+// the outbox.
+func NewDispatchJob(log *slog.Logger, storage EmailStorage, sender EmailSender, renderer *templates.Renderer,
+	links Links, configOutbox config.Outbox) scheduler.Job {
+	if configOutbox.Interval <= 0 {
+		configOutbox.Interval = defaultInterval
+	}
+	if configOutbox.BatchSize <= 0 {
+		configOutbox.BatchSize = defaultBatchSize
+	}
+	if configOutbox.SendTimeout <= 0 {
+		configOutbox.SendTimeout = defaultSendTimeout
+	}
+	if configOutbox.MaxAttempts <= 0 {
+		configOutbox.MaxAttempts = defaultMaxAttempts
+	}
+	if configOutbox.Backoff <= 0 {
+		configOutbox.Backoff = defaultBackoff
+	}
+	if configOutbox.MaxBackoff <= 0 {
+		configOutbox.MaxBackoff = defaultMaxBackoff
+	}
+
+	d := &dispatcher{
+		log:       log,
+		storage:   storage,
+		sender:    sender,
+		templates: renderer,
+		links:     links,
+		cfg:       configOutbox,
+	}
+
+	return scheduler.Job{
+		Name:     JobName,
+		Interval: configOutbox.Interval,
+		Run:      d.run,
+	}
+}
+
+// Backoff returns the delay before the next delivery of an email that
+// failed attempts times.
+func Backoff(attempts int, base, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+
+	return min(delay, max)
+}
+
+func (d *dispatcher) run(ctx context.Context) error {
+	const op = "email.outbox.Dispatch"
+
+	for {
+		emails, err := d.storage.PendingEmails(ctx, time.Now(), d.cfg.BatchSize)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		for _, queued := range emails {
+			if err := d.dispatch(ctx, queued); err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+		}
+
+		if len(emails) < d.cfg.BatchSize {
+			return nil
+		}
+	}
+}
+
+// dispatch delivers the email and records the outcome.
+func (d *dispatcher) dispatch(ctx context.Context, queued database.Email) error {
+	log := d.log.With(
+		slog.Int64("email_id", queued.ID),
+		slog.String("kind", queued.Kind),
+	)
+
+	message, err := d.render(queued)
+	if err == nil {
+		sendCtx, cancel := context.WithTimeout(ctx, d.cfg.SendTimeout)
+		err = d.sender.Send(sendCtx, message)
+		cancel()
+	}
+
+	if err == nil {
+		metrics.Add("sent", 1)
+		return d.storage.MarkEmailSent(ctx, queued.ID)
+	}
+
+	// The dispatcher is stopping, the email is tried again on next run.
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	attempts := queued.Attempts + 1
+
+	if attempts >= d.cfg.MaxAttempts || errors.Is(err, ErrUndeliverable) {
+		log.Error("Email moved to dead letters", sl.Err(err), slog.Int("attempts", attempts))
+		metrics.Add("dead", 1)
+		return d.storage.MarkEmailDead(ctx, queued.ID, err.Error())
+	}
+
+	nextAttemptAt := time.Now().Add(Backoff(attempts, d.cfg.Backoff, d.cfg.MaxBackoff))
+
+	log.Warn("Failed to send email", sl.Err(err), slog.Int("attempts", attempts),
+		slog.Time("next_attempt_at", nextAttemptAt))
+	metrics.Add("retried", 1)
+
+	return d.storage.RetryEmail(ctx, queued.ID, err.Error(), nextAttemptAt)
+}
+
+// render renders the email in the locale of the user.
+func (d *dispatcher) render(queued database.Email) (email.Message, error) {
+	var data any
+
+	switch queued.Kind {
+	case database.EmailIPWarning:
+		data = templates.IPWarning{
+			IP:         queued.Params["ip"],
+			PreviousIP: queued.Params["previous_ip"],
+			UserAgent:  queued.Params["user_agent"],
+			Time:       queued.CreatedAt,
+			RevokeURL:  d.links.Revoke(queued.Params["user_guid"], queued.Params["family_id"], time.Now()),
+		}
+	case database.EmailVerifyEmail:
+		// The verification is of no use without the link.
+		verifyURL := d.links.Verify(queued.Params["user_guid"], queued.To, time.Now())
+		if verifyURL == "" {
+			return email.Message{}, fmt.Errorf("%w: Links are disabled", ErrUndeliverable)
+		}
+
+		data = templates.VerifyEmail{URL: verifyURL}
+	default:
+		return email.Message{}, fmt.Errorf("%w: Unknown kind %q", ErrUndeliverable, queued.Kind)
+	}
+
+	message, err := d.templates.Render(queued.Kind, queued.Params["locale"], data)
+	if err != nil {
+		return email.Message{}, fmt.Errorf("%w: %w", ErrUndeliverable, err)
+	}
+
+	message.To = queued.To
+
+	return message, nil
+}
@@ -0,0 +1,219 @@
+package outbox_test
+
+import (
+	"auth/internal/config"
+	"auth/internal/database"
+	"auth/internal/email"
+	"auth/internal/email/outbox"
+	"auth/internal/email/outbox/mocks"
+	"auth/internal/email/templates"
+	sl "auth/internal/lib/logger/sl/sldiscard"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+var outboxCfg = config.Outbox{
+	BatchSize:   10,
+	MaxAttempts: 3,
+	Backoff:     time.Minute,
+	MaxBackoff:  time.Hour,
+}
+
+func TestDispatchJob(t *testing.T) {
+	renderer, err := templates.New("", "")
+	require.NoError(t, err)
+
+	links := outbox.Links{BaseURL: "https://auth.example", Secret: "link secret", RevokeExpires: time.Hour}
+
+	ipWarning := database.Email{
+		ID:   1,
+		Kind: database.EmailIPWarning,
+		To:   "user@mail",
+		Params: map[string]string{
+			"ip":          "192.168.0.1",
+			"previous_ip": "192.168.0.2",
+			"locale":      "ru-RU",
+			"user_guid":   "8c6a0e3c-5ef9-4c47-9d1e-7d5d0f3f0b71",
+			"family_id":   "family",
+		},
+		Status:   database.EmailPending,
+		Attempts: 0,
+	}
+
+	lastAttempt := ipWarning
+	lastAttempt.Attempts = outboxCfg.MaxAttempts - 1
+
+	unknownKind := ipWarning
+	unknownKind.Kind = "some string"
+
+	cases := []struct {
+		name         string
+		email        database.Email
+		pendingError error
+		sendMock     bool
+		sendError    error
+		result       string
+		wantError    bool
+	}{
+		{
+			name:     "Sent",
+			email:    ipWarning,
+			sendMock: true,
+			result:   "MarkEmailSent",
+		},
+		{
+			name:      "Failed to send",
+			email:     ipWarning,
+			sendError: errors.New("some error"),
+			result:    "RetryEmail",
+		},
+		{
+			name:      "Failed to send on last attempt",
+			email:     lastAttempt,
+			sendError: errors.New("some error"),
+			result:    "MarkEmailDead",
+		},
+		{
+			name:   "Undeliverable kind",
+			email:  unknownKind,
+			result: "MarkEmailDead",
+		},
+		{
+			name:         "Failed to find pending emails",
+			pendingError: errors.New("some error"),
+			wantError:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		EmailStorageMock := mocks.NewEmailStorage(t)
+		EmailSenderMock := mocks.NewEmailSender(t)
+
+		var emails []database.Email
+		if tc.pendingError == nil {
+			emails = []database.Email{tc.email}
+		}
+
+		EmailStorageMock.On("PendingEmails", mock.Anything, mock.AnythingOfType("time.Time"), outboxCfg.BatchSize).
+			Return(emails, tc.pendingError).
+			Once()
+
+		if tc.sendMock || tc.sendError != nil {
+			EmailSenderMock.On("Send", mock.Anything, mock.MatchedBy(func(message email.Message) bool {
+				return message.To == tc.email.To && message.Subject != "" &&
+					strings.Contains(message.Text, tc.email.Params["ip"]) &&
+					strings.Contains(message.HTML, tc.email.Params["ip"]) &&
+					strings.Contains(message.HTML, "https://auth.example/revoke/link?token=")
+			})).
+				Return(tc.sendError).
+				Once()
+		}
+
+		switch tc.result {
+		case "MarkEmailSent":
+			EmailStorageMock.On("MarkEmailSent", mock.Anything, tc.email.ID).
+				Return(nil).
+				Once()
+		case "RetryEmail":
+			EmailStorageMock.On("RetryEmail", mock.Anything, tc.email.ID, tc.sendError.Error(),
+				mock.AnythingOfType("time.Time")).
+				Return(nil).
+				Once()
+		case "MarkEmailDead":
+			EmailStorageMock.On("MarkEmailDead", mock.Anything, tc.email.ID, mock.AnythingOfType("string")).
+				Return(nil).
+				Once()
+		}
+
+		job := outbox.NewDispatchJob(sl.NewDiscardLogger(), EmailStorageMock, EmailSenderMock, renderer, links,
+			outboxCfg)
+
+		err := job.Run(context.Background())
+		require.Equal(t, tc.wantError, err != nil, "Case: %s", tc.name)
+	}
+}
+
+func TestDispatchVerifyEmail(t *testing.T) {
+	renderer, err := templates.New("", "")
+	require.NoError(t, err)
+
+	verifyEmail := database.Email{
+		ID:     1,
+		Kind:   database.EmailVerifyEmail,
+		To:     "user@mail",
+		Params: map[string]string{"user_guid": "8c6a0e3c-5ef9-4c47-9d1e-7d5d0f3f0b71", "locale": "en"},
+		Status: database.EmailPending,
+	}
+
+	cases := []struct {
+		name   string
+		links  outbox.Links
+		result string
+	}{
+		{
+			name:   "Sent",
+			links:  outbox.Links{BaseURL: "https://auth.example", Secret: "link secret", VerifyExpires: time.Hour},
+			result: "MarkEmailSent",
+		},
+		{
+			name:   "Links are disabled",
+			links:  outbox.Links{BaseURL: "https://auth.example", VerifyExpires: time.Hour},
+			result: "MarkEmailDead",
+		},
+	}
+
+	for _, tc := range cases {
+		EmailStorageMock := mocks.NewEmailStorage(t)
+		EmailSenderMock := mocks.NewEmailSender(t)
+
+		EmailStorageMock.On("PendingEmails", mock.Anything, mock.AnythingOfType("time.Time"), outboxCfg.BatchSize).
+			Return([]database.Email{verifyEmail}, nil).
+			Once()
+
+		switch tc.result {
+		case "MarkEmailSent":
+			EmailSenderMock.On("Send", mock.Anything, mock.MatchedBy(func(message email.Message) bool {
+				return message.To == verifyEmail.To &&
+					strings.Contains(message.Text, "https://auth.example/users/verify?token=") &&
+					strings.Contains(message.HTML, "https://auth.example/users/verify?token=")
+			})).
+				Return(nil).
+				Once()
+			EmailStorageMock.On("MarkEmailSent", mock.Anything, verifyEmail.ID).
+				Return(nil).
+				Once()
+		case "MarkEmailDead":
+			EmailStorageMock.On("MarkEmailDead", mock.Anything, verifyEmail.ID, mock.AnythingOfType("string")).
+				Return(nil).
+				Once()
+		}
+
+		job := outbox.NewDispatchJob(sl.NewDiscardLogger(), EmailStorageMock, EmailSenderMock, renderer, tc.links,
+			outboxCfg)
+
+		require.NoError(t, job.Run(context.Background()), "Case: %s", tc.name)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	cases := []struct {
+		attempts int
+		delay    time.Duration
+	}{
+		{attempts: 1, delay: time.Minute},
+		{attempts: 2, delay: 2 * time.Minute},
+		{attempts: 4, delay: 8 * time.Minute},
+		{attempts: 7, delay: time.Hour},
+		{attempts: 100, delay: time.Hour},
+	}
+
+	for _, tc := range cases {
+		require.Equal(t, tc.delay, outbox.Backoff(tc.attempts, time.Minute, time.Hour), "Attempts: %d", tc.attempts)
+	}
+}
@@ -0,0 +1,247 @@
+package templates
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"auth/internal/email"
+)
+
+const DefaultLocale = "en"
+
+// A kind is rendered from three files in the directory of the locale:
+// <kind>.subject.tmpl, <kind>.text.tmpl and <kind>.html.tmpl.
+const (
+	subjectSuffix = ".subject.tmpl"
+	textSuffix    = ".text.tmpl"
+	htmlSuffix    = ".html.tmpl"
+)
+
+//go:embed locales
+var embedded embed.FS
+
+var ErrUnknownKind = errors.New("unknown template")
+
+// IPWarning is the data of the ip_warning templates.
+type IPWarning struct {
+	IP         string
+	PreviousIP string
+	UserAgent  string
+	Time       time.Time
+	// RevokeURL is the "this wasn't me" link, empty if links are disabled.
+	RevokeURL string
+}
+
+// VerifyEmail is the data of the verify_email templates.
+type VerifyEmail struct {
+	URL string
+}
+
+type set struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+// Renderer renders emails from the embedded templates. Templates found in
+// the override directory replace the embedded ones file by file and may add
+// locales.
+type Renderer struct {
+	defaultLocale string
+	// sets holds the templates by locale and kind.
+	sets map[string]map[string]set
+}
+
+// New parses all templates, so broken overrides are reported at startup.
+// An empty dir uses the embedded templates only.
+func New(dir string, defaultLocale string) (*Renderer, error) {
+	const op = "email.templates.New"
+
+	if defaultLocale == "" {
+		defaultLocale = DefaultLocale
+	}
+
+	fsys, err := fs.Sub(embedded, "locales")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if dir != "" {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("%s: Can not to find templates directory: %w", op, err)
+		}
+
+		fsys = overlay{os.DirFS(dir), fsys}
+	}
+
+	r := &Renderer{
+		defaultLocale: normalizeLocale(defaultLocale),
+		sets:          make(map[string]map[string]set),
+	}
+
+	files, err := fs.Glob(fsys, "*/*"+subjectSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, file := range files {
+		locale := normalizeLocale(path.Dir(file))
+		kind := strings.TrimSuffix(path.Base(file), subjectSuffix)
+
+		s, err := parse(fsys, path.Join(path.Dir(file), kind))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if r.sets[locale] == nil {
+			r.sets[locale] = make(map[string]set)
+		}
+		r.sets[locale][kind] = s
+	}
+
+	for _, sets := range r.sets {
+		for kind := range sets {
+			if _, ok := r.sets[r.defaultLocale][kind]; !ok {
+				return nil, fmt.Errorf("%s: Template %q has no default locale %q", op, kind, r.defaultLocale)
+			}
+		}
+	}
+
+	return r, nil
+}
+
+// Render renders the email of the kind in the locale. A locale without
+// the templates falls back to its language, then to the default locale.
+func (r *Renderer) Render(kind string, locale string, data any) (email.Message, error) {
+	const op = "email.templates.Render"
+
+	s, ok := r.lookup(kind, locale)
+	if !ok {
+		return email.Message{}, fmt.Errorf("%s: %w: %q", op, ErrUnknownKind, kind)
+	}
+
+	var subject, text, html bytes.Buffer
+
+	if err := s.subject.Execute(&subject, data); err != nil {
+		return email.Message{}, fmt.Errorf("%s: Rendering subject error: %w", op, err)
+	}
+
+	if err := s.text.Execute(&text, data); err != nil {
+		return email.Message{}, fmt.Errorf("%s: Rendering text error: %w", op, err)
+	}
+
+	if err := s.html.Execute(&html, data); err != nil {
+		return email.Message{}, fmt.Errorf("%s: Rendering HTML error: %w", op, err)
+	}
+
+	return email.Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
+
+func (r *Renderer) lookup(kind string, locale string) (set, bool) {
+	locale = normalizeLocale(locale)
+	language, _, _ := strings.Cut(locale, "-")
+
+	for _, candidate := range []string{locale, language, r.defaultLocale} {
+		if s, ok := r.sets[candidate][kind]; ok {
+			return s, true
+		}
+	}
+
+	return set{}, false
+}
+
+func parse(fsys fs.FS, base string) (set, error) {
+	var s set
+
+	subject, err := fs.ReadFile(fsys, base+subjectSuffix)
+	if err != nil {
+		return set{}, err
+	}
+
+	text, err := fs.ReadFile(fsys, base+textSuffix)
+	if err != nil {
+		return set{}, err
+	}
+
+	html, err := fs.ReadFile(fsys, base+htmlSuffix)
+	if err != nil {
+		return set{}, err
+	}
+
+	if s.subject, err = texttemplate.New(base + subjectSuffix).Parse(string(subject)); err != nil {
+		return set{}, err
+	}
+
+	if s.text, err = texttemplate.New(base + textSuffix).Parse(string(text)); err != nil {
+		return set{}, err
+	}
+
+	if s.html, err = htmltemplate.New(base + htmlSuffix).Parse(string(html)); err != nil {
+		return set{}, err
+	}
+
+	return s, nil
+}
+
+// normalizeLocale turns "pt_BR" and "pt-BR" into "pt-br".
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
+
+// overlay reads files from the first file system that has them.
+type overlay []fs.FS
+
+func (o overlay) Open(name string) (fs.File, error) {
+	for _, fsys := range o[:len(o)-1] {
+		file, err := fsys.Open(name)
+		if err == nil {
+			return file, nil
+		}
+	}
+
+	return o[len(o)-1].Open(name)
+}
+
+// ReadDir merges the directory across the file systems, fs.Glob relies on it.
+func (o overlay) ReadDir(name string) ([]fs.DirEntry, error) {
+	seen := make(map[string]bool)
+
+	var entries []fs.DirEntry
+	var firstErr error
+
+	for _, fsys := range o {
+		list, err := fs.ReadDir(fsys, name)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		for _, entry := range list {
+			if !seen[entry.Name()] {
+				seen[entry.Name()] = true
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	if entries == nil && firstErr != nil {
+		return nil, firstErr
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,101 @@
+package templates_test
+
+import (
+	"auth/internal/database"
+	"auth/internal/email/templates"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var ipWarning = templates.IPWarning{
+	IP:         "192.168.0.1",
+	PreviousIP: "192.168.0.2",
+	UserAgent:  "<script>alert(1)</script>",
+	Time:       time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC),
+	RevokeURL:  "https://auth.example/revoke/link?token=a.b",
+}
+
+func TestRender(t *testing.T) {
+	renderer, err := templates.New("", "")
+	require.NoError(t, err)
+
+	cases := []struct {
+		name    string
+		locale  string
+		subject string
+	}{
+		{name: "Default locale", locale: "", subject: "New sign-in from 192.168.0.1"},
+		{name: "Exact locale", locale: "ru", subject: "Новый вход с адреса 192.168.0.1"},
+		{name: "Region falls back to language", locale: "ru_RU", subject: "Новый вход с адреса 192.168.0.1"},
+		{name: "Unknown locale falls back to default", locale: "de-DE", subject: "New sign-in from 192.168.0.1"},
+	}
+
+	for _, tc := range cases {
+		message, err := renderer.Render(database.EmailIPWarning, tc.locale, ipWarning)
+		require.NoError(t, err, "Case: %s", tc.name)
+		require.Equal(t, tc.subject, message.Subject, "Case: %s", tc.name)
+		require.Contains(t, message.Text, ipWarning.PreviousIP, "Case: %s", tc.name)
+		require.Contains(t, message.Text, ipWarning.RevokeURL, "Case: %s", tc.name)
+		require.Contains(t, message.HTML, ipWarning.RevokeURL, "Case: %s", tc.name)
+		require.NotContains(t, message.HTML, ipWarning.UserAgent, "Case: %s", tc.name)
+	}
+
+	_, err = renderer.Render("some string", "", ipWarning)
+	require.ErrorIs(t, err, templates.ErrUnknownKind)
+
+	verifyEmail := templates.VerifyEmail{URL: "https://auth.example/users/verify?token=a.b"}
+
+	for _, locale := range []string{"en", "ru"} {
+		message, err := renderer.Render(database.EmailVerifyEmail, locale, verifyEmail)
+		require.NoError(t, err, "Locale: %s", locale)
+		require.NotEmpty(t, message.Subject, "Locale: %s", locale)
+		require.Contains(t, message.Text, verifyEmail.URL, "Locale: %s", locale)
+		require.Contains(t, message.HTML, verifyEmail.URL, "Locale: %s", locale)
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	dir := t.TempDir()
+
+	write := func(name string, content string) {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+
+	// Only the English subject is overridden, and German is added.
+	write("en/ip_warning.subject.tmpl", "Custom {{.IP}}")
+	write("de/ip_warning.subject.tmpl", "Neue Anmeldung {{.IP}}")
+	write("de/ip_warning.text.tmpl", "IP: {{.IP}}")
+	write("de/ip_warning.html.tmpl", "<p>{{.IP}}</p>")
+
+	renderer, err := templates.New(dir, "en")
+	require.NoError(t, err)
+
+	message, err := renderer.Render(database.EmailIPWarning, "en", ipWarning)
+	require.NoError(t, err)
+	require.Equal(t, "Custom 192.168.0.1", message.Subject)
+	require.Contains(t, message.Text, "Your session was refreshed")
+
+	message, err = renderer.Render(database.EmailIPWarning, "de-AT", ipWarning)
+	require.NoError(t, err)
+	require.Equal(t, "Neue Anmeldung 192.168.0.1", message.Subject)
+	require.Equal(t, "<p>192.168.0.1</p>", message.HTML)
+
+	_, err = templates.New(dir, "fr")
+	require.Error(t, err, "Default locale without templates")
+
+	write("fr/ip_warning.subject.tmpl", "{{.IP")
+	write("fr/ip_warning.text.tmpl", "")
+	write("fr/ip_warning.html.tmpl", "")
+
+	_, err = templates.New(dir, "en")
+	require.Error(t, err, "Broken template")
+
+	_, err = templates.New(filepath.Join(dir, "missing"), "en")
+	require.Error(t, err, "Missing directory")
+}
@@ -0,0 +1,89 @@
+package devmail
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/go-chi/render"
+
+	"auth/internal/email/mockmail"
+	resp "auth/internal/lib/api/response"
+	"auth/internal/lib/logger/sl"
+)
+
+type ListResponse struct {
+	resp.Response
+	Messages []mockmail.Message `json:"messages"`
+}
+
+type MessageResponse struct {
+	resp.Response
+	Message mockmail.Message `json:"message"`
+}
+
+// Inbox holds the messages captured by the development mailer.
+//
+//go:generate go run github.com/vektra/mockery/v3 --name=Inbox
+type Inbox interface {
+	Messages(to string) []mockmail.Message
+	Message(id int64) (mockmail.Message, bool)
+	Clear()
+}
+
+// NewList lists the captured messages, newest first. The "to" query
+// parameter keeps the messages whose recipient contains it.
+func NewList(log *slog.Logger, inbox Inbox) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		render.JSON(w, r, ListResponse{
+			Response: resp.OK(),
+			Messages: inbox.Messages(r.URL.Query().Get("to")),
+		})
+	}
+}
+
+func NewGet(log *slog.Logger, inbox Inbox) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.devmail.NewGet"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			log.Error("Failed to parse message ID", sl.Err(err))
+			render.Status(r, 400)
+			render.JSON(w, r, resp.Error("Invalid message ID"))
+			return
+		}
+
+		message, ok := inbox.Message(id)
+		if !ok {
+			render.Status(r, 404)
+			render.JSON(w, r, resp.Error("Message does not exist"))
+			return
+		}
+
+		render.JSON(w, r, MessageResponse{
+			Response: resp.OK(),
+			Message:  message,
+		})
+	}
+}
+
+func NewClear(log *slog.Logger, inbox Inbox) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.devmail.NewClear"
+
+		inbox.Clear()
+
+		log.Info("Captured messages cleared", slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		render.JSON(w, r, resp.OK())
+	}
+}
@@ -0,0 +1,218 @@
+package devmail_test
+
+import (
+	"auth/internal/email/mockmail"
+	"auth/internal/http/handlers/devmail"
+	"auth/internal/http/handlers/devmail/mocks"
+	sl "auth/internal/lib/logger/sl/sldiscard"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/require"
+)
+
+var message = mockmail.Message{
+	ID:      1,
+	To:      "user@mail",
+	Subject: "New sign-in from 192.168.0.1",
+	Text:    "IP: 192.168.0.1",
+	HTML:    "<p>IP: 192.168.0.1</p>",
+}
+
+func TestInboxHandlers(t *testing.T) {
+	cases := []struct {
+		name     string
+		method   string
+		path     string
+		to       string
+		id       int64
+		found    bool
+		clear    bool
+		messages int
+		code     int
+	}{
+		{
+			name:     "List",
+			method:   http.MethodGet,
+			path:     "/api/messages",
+			messages: 1,
+			code:     200,
+		},
+		{
+			name:     "Search by recipient",
+			method:   http.MethodGet,
+			path:     "/api/messages?to=user",
+			to:       "user",
+			messages: 1,
+			code:     200,
+		},
+		{
+			name:   "Get",
+			method: http.MethodGet,
+			path:   "/api/messages/1",
+			id:     1,
+			found:  true,
+			code:   200,
+		},
+		{
+			name:   "Get missing message",
+			method: http.MethodGet,
+			path:   "/api/messages/2",
+			id:     2,
+			code:   404,
+		},
+		{
+			name:   "Invalid message ID",
+			method: http.MethodGet,
+			path:   "/api/messages/some",
+			code:   400,
+		},
+		{
+			name:   "Clear",
+			method: http.MethodDelete,
+			path:   "/api/messages",
+			clear:  true,
+			code:   200,
+		},
+		{
+			name:   "View",
+			method: http.MethodGet,
+			path:   "/1",
+			id:     1,
+			found:  true,
+			code:   200,
+		},
+		{
+			name:   "Clear from page",
+			method: http.MethodPost,
+			path:   "/clear",
+			clear:  true,
+			code:   303,
+		},
+	}
+
+	for _, tc := range cases {
+		InboxMock := mocks.NewInbox(t)
+
+		if tc.messages > 0 {
+			InboxMock.On("Messages", tc.to).
+				Return([]mockmail.Message{message}).
+				Once()
+		}
+
+		if tc.id != 0 {
+			found := mockmail.Message{}
+			if tc.found {
+				found = message
+			}
+
+			InboxMock.On("Message", tc.id).
+				Return(found, tc.found).
+				Once()
+		}
+
+		if tc.clear {
+			InboxMock.On("Clear").Once()
+		}
+
+		req, err := http.NewRequest(tc.method, tc.path, nil)
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+
+		log := sl.NewDiscardLogger()
+		router := chi.NewRouter()
+		router.Get("/{id}", devmail.NewView(log, InboxMock, "/dev/mail"))
+		router.Post("/clear", devmail.NewClearForm(log, InboxMock, "/dev/mail"))
+		router.Get("/api/messages", devmail.NewList(log, InboxMock))
+		router.Get("/api/messages/{id}", devmail.NewGet(log, InboxMock))
+		router.Delete("/api/messages", devmail.NewClear(log, InboxMock))
+		router.ServeHTTP(rr, req)
+
+		require.Equal(t, tc.code, rr.Code, "Case: %s", tc.name)
+
+		if tc.messages > 0 {
+			var resp devmail.ListResponse
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+			require.Equal(t, []mockmail.Message{message}, resp.Messages, "Case: %s", tc.name)
+		}
+	}
+}
+
+func TestViewEscapesHTML(t *testing.T) {
+	InboxMock := mocks.NewInbox(t)
+	InboxMock.On("Message", int64(1)).
+		Return(mockmail.Message{ID: 1, Subject: "Subject", HTML: `<script>alert(1)</script>`}, true).
+		Once()
+
+	req, err := http.NewRequest(http.MethodGet, "/1", nil)
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router := chi.NewRouter()
+	router.Get("/{id}", devmail.NewView(sl.NewDiscardLogger(), InboxMock, "/dev/mail"))
+	router.ServeHTTP(rr, req)
+
+	require.Equal(t, 200, rr.Code)
+	require.Contains(t, rr.Body.String(), `sandbox=""`)
+	require.NotContains(t, rr.Body.String(), "<script>")
+}
+
+func TestPageLinks(t *testing.T) {
+	message := mockmail.Message{ID: 1, Subject: "Subject"}
+
+	cases := []struct {
+		name  string
+		path  string
+		view  bool
+		links []string
+	}{
+		{
+			name:  "Inbox",
+			path:  "/dev/mail",
+			links: []string{`action="/dev/mail/"`, `action="/dev/mail/clear"`, `href="/dev/mail/1"`},
+		},
+		{
+			name:  "Inbox with trailing slash",
+			path:  "/dev/mail/",
+			links: []string{`action="/dev/mail/"`, `action="/dev/mail/clear"`, `href="/dev/mail/1"`},
+		},
+		{
+			name:  "Message",
+			path:  "/dev/mail/1",
+			view:  true,
+			links: []string{`href="/dev/mail/"`},
+		},
+	}
+
+	for _, tc := range cases {
+		InboxMock := mocks.NewInbox(t)
+		if tc.view {
+			InboxMock.On("Message", int64(1)).Return(message, true).Once()
+		} else {
+			InboxMock.On("Messages", "").Return([]mockmail.Message{message}).Once()
+		}
+
+		req, err := http.NewRequest(http.MethodGet, tc.path, nil)
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+
+		log := sl.NewDiscardLogger()
+		router := chi.NewRouter()
+		router.Route("/dev/mail", func(r chi.Router) {
+			r.Get("/", devmail.NewIndex(log, InboxMock, "/dev/mail"))
+			r.Get("/{id}", devmail.NewView(log, InboxMock, "/dev/mail"))
+		})
+		router.ServeHTTP(rr, req)
+
+		require.Equal(t, 200, rr.Code, "Case: %s", tc.name)
+		for _, link := range tc.links {
+			require.Contains(t, rr.Body.String(), link, "Case: %s", tc.name)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package devmail
+
+import (
+	"bytes"
+	_ "embed"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/go-chi/render"
+
+	"auth/internal/email/mockmail"
+	"auth/internal/lib/logger/sl"
+)
+
+//go:embed ui.html.tmpl
+var uiSource string
+
+var ui = template.Must(template.New("ui").Parse(uiSource))
+
+// The pages link to each other with absolute paths under prefix, the path
+// the handlers are mounted at, so the links do not depend on whether the
+// page was loaded with a trailing slash.
+
+type indexData struct {
+	Prefix   string
+	To       string
+	Messages []mockmail.Message
+}
+
+type viewData struct {
+	Prefix string
+	mockmail.Message
+}
+
+// NewIndex serves the page listing the captured messages.
+func NewIndex(log *slog.Logger, inbox Inbox, prefix string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		to := r.URL.Query().Get("to")
+
+		page(w, r, log, 200, "index", indexData{Prefix: prefix, To: to, Messages: inbox.Messages(to)})
+	}
+}
+
+// NewView serves the page of one captured message. The HTML body is shown
+// in a sandboxed frame, so its scripts and links do not run in the inbox.
+func NewView(log *slog.Logger, inbox Inbox, prefix string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		message, ok := inbox.Message(id)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		page(w, r, log, 200, "view", viewData{Prefix: prefix, Message: message})
+	}
+}
+
+// NewClearForm clears the inbox from the page and goes back to it.
+func NewClearForm(log *slog.Logger, inbox Inbox, prefix string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.devmail.NewClearForm"
+
+		inbox.Clear()
+
+		log.Info("Captured messages cleared", slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		http.Redirect(w, r, prefix+"/", http.StatusSeeOther)
+	}
+}
+
+func page(w http.ResponseWriter, r *http.Request, log *slog.Logger, status int, name string, data any) {
+	var buf bytes.Buffer
+	if err := ui.ExecuteTemplate(&buf, name, data); err != nil {
+		log.Error("Failed to render page", slog.String("page", name), sl.Err(err))
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	render.Status(r, status)
+	render.HTML(w, r, buf.String())
+}
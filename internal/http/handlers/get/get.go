@@ -1,8 +1,9 @@
 package get
 
 import (
+	"context"
+	"errors"
 	"log/slog"
-	"net"
 	"net/http"
 
 	"github.com/go-chi/chi"
@@ -11,8 +12,14 @@ import (
 	"github.com/google/uuid"
 
 	"auth/internal/config"
+	"auth/internal/database"
 	resp "auth/internal/lib/api/response"
+	"auth/internal/lib/authn"
+	"auth/internal/lib/clientip"
+	"auth/internal/lib/ipbinding"
 	"auth/internal/lib/logger/sl"
+	"auth/internal/lib/password"
+	"auth/internal/lib/ratelimit"
 	"auth/internal/lib/tokens"
 )
 
@@ -24,15 +31,37 @@ type Response struct {
 
 //go:generate go run github.com/vektra/mockery/v3 --name=RefreshTokenStorage
 type RefreshTokenStorage interface {
-	SaveRefreshToken(userGUID uuid.UUID, token string, jwtConfig config.JWT) (string, error)
-	RevokeRefreshToken(bindKey string) error
+	SaveRefreshToken(ctx context.Context, userGUID uuid.UUID, token string, family database.Family,
+		client database.Client, jwtConfig config.JWT) (string, error)
+	RevokeRefreshToken(ctx context.Context, bindKey string) error
 }
 
-func New(log *slog.Logger, refreshTokenStorage RefreshTokenStorage, jwtConfig config.JWT) http.HandlerFunc {
+//go:generate go run github.com/vektra/mockery/v3 --name=Authenticator
+type Authenticator interface {
+	Authenticate(r *http.Request) (uuid.UUID, error)
+}
+
+//go:generate go run github.com/vektra/mockery/v3 --name=UserDirectory
+type UserDirectory interface {
+	IsUserDisabled(ctx context.Context, userGUID uuid.UUID) (bool, error)
+}
+
+// New issues the tokens of the user, once the authenticator proves the
+// request was sent by the user. Invalid credentials count as failures of
+// the client IP for the limiter, if any, and only authenticated requests
+// take from the bucket of the user GUID, so others can not drain it to
+// lock the user out. The optional client_id query
+// parameter must name a client of the IP binding config, its policy then
+// applies to the refreshes of the tokens. Anyone may send the parameter, so
+// the config only allows client policies stricter than the default one.
+// Disabled users get no tokens.
+func New(log *slog.Logger, authenticator Authenticator, users UserDirectory, refreshTokenStorage RefreshTokenStorage,
+	keys *tokens.KeySet, policies *ipbinding.Policies, limiter *ratelimit.Limiter,
+	jwtConfig config.JWT) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.auth.get.New"
 
-		log = log.With(
+		log := log.With(
 			slog.String("op", op),
 			slog.String("request_id", middleware.GetReqID(r.Context())),
 		)
@@ -45,20 +74,95 @@ func New(log *slog.Logger, refreshTokenStorage RefreshTokenStorage, jwtConfig co
 			return
 		}
 
-		userIp := r.RemoteAddr
-		if userIp == "" {
-			log.Error("Remote address is empty")
-			render.Status(r, 401)
-			render.JSON(w, r, resp.Error("Unable to get client IP"))
+		clientID := r.URL.Query().Get("client_id")
+		if clientID != "" && !policies.Known(clientID) {
+			log.Error("Unknown client", slog.String("client_id", clientID))
+			render.Status(r, 400)
+			render.JSON(w, r, resp.Error("Unknown client"))
 			return
-		} else {
-			userIp, _, err = net.SplitHostPort(userIp)
-			if err != nil {
-				log.Error("Failed to parse client IP", sl.Err(err))
-				render.Status(r, 401)
+		}
+
+		userIp, err := clientip.FromRequest(r)
+		if err != nil {
+			log.Error("Failed to get client IP", sl.Err(err))
+			render.Status(r, 401)
+			if errors.Is(err, clientip.ErrNoClientIP) {
+				render.JSON(w, r, resp.Error("Unable to get client IP"))
+			} else {
 				render.JSON(w, r, resp.Error("Invalid client IP"))
-				return
 			}
+			return
+		}
+
+		authGUID, err := authenticator.Authenticate(r)
+		switch {
+		case errors.Is(err, authn.ErrNoCredentials):
+			log.Error("Credentials are missing")
+			w.Header().Set("WWW-Authenticate", `Basic realm="auth"`)
+			render.Status(r, 401)
+			render.JSON(w, r, resp.Error("Authentication required"))
+			return
+		case errors.Is(err, authn.ErrInvalidCredentials):
+			log.Warn("Authentication failed", sl.Event("authentication_failed"),
+				slog.String("user_guid", userGUID.String()), slog.String("ip", userIp), sl.Err(err))
+			failed(r.Context(), log, limiter, userIp)
+			w.Header().Set("WWW-Authenticate", `Basic realm="auth"`)
+			render.Status(r, 401)
+			render.JSON(w, r, resp.Error("Invalid credentials"))
+			return
+		case errors.Is(err, authn.ErrEmailUnverified):
+			log.Warn("Login with unverified email", slog.String("user_guid", userGUID.String()))
+			render.Status(r, 403)
+			render.JSON(w, r, resp.ErrorCode(resp.CodeEmailUnverified, "Email is not verified"))
+			return
+		case errors.Is(err, password.ErrBusy):
+			log.Warn("Password hashing is busy", sl.Err(err))
+			w.Header().Set("Retry-After", "1")
+			render.Status(r, 503)
+			render.JSON(w, r, resp.Error("Too many logins in progress"))
+			return
+		case err != nil:
+			log.Error("Failed to authenticate", sl.Err(err))
+			render.Status(r, resp.StorageStatus(err, 500))
+			render.JSON(w, r, resp.Error("Unable to authenticate"))
+			return
+		}
+
+		if authGUID != userGUID {
+			log.Warn("Credentials of another user", sl.Event("authentication_mismatch"),
+				slog.String("user_guid", userGUID.String()), slog.String("authenticated_guid", authGUID.String()))
+			render.Status(r, 403)
+			render.JSON(w, r, resp.Error("Credentials do not match the user"))
+			return
+		}
+
+		result, err := limiter.Allow(r.Context(), ratelimit.KindGUID, userGUID.String())
+		if err != nil {
+			log.Error("Failed to take rate limit token", sl.Err(err))
+		} else if !result.Allowed {
+			log.Warn("Token request is rate limited", sl.Event("rate_limited"),
+				slog.String("kind", ratelimit.KindGUID),
+				slog.String("user_guid", userGUID.String()),
+				slog.Bool("locked", result.Locked))
+			ratelimit.Deny(w, r, result)
+			return
+		}
+		ratelimit.WriteHeaders(w, result)
+
+		disabled, err := users.IsUserDisabled(r.Context(), userGUID)
+		if err != nil {
+			log.Error("Failed to check user", sl.Err(err))
+			render.Status(r, resp.StorageStatus(err, 500))
+			render.JSON(w, r, resp.Error("Unable to check user"))
+			return
+		}
+
+		if disabled {
+			log.Warn("Tokens requested for disabled user", sl.Event("disabled_user_login"),
+				slog.String("user_guid", userGUID.String()))
+			render.Status(r, 403)
+			render.JSON(w, r, resp.ErrorCode(resp.CodeUserDisabled, "User is disabled"))
+			return
 		}
 
 		refreshToken, err := tokens.GenerateRefreshToken()
@@ -69,19 +173,20 @@ func New(log *slog.Logger, refreshTokenStorage RefreshTokenStorage, jwtConfig co
 			return
 		}
 
-		bindKey, err := refreshTokenStorage.SaveRefreshToken(userGUID, refreshToken, jwtConfig)
+		bindKey, err := refreshTokenStorage.SaveRefreshToken(r.Context(), userGUID, refreshToken, database.NewFamily(),
+			database.Client{IP: userIp, UserAgent: r.UserAgent()}, jwtConfig)
 		if err != nil {
 			log.Error("Failed to save refresh token", sl.Err(err))
-			render.Status(r, 500)
+			render.Status(r, resp.StorageStatus(err, 500))
 			render.JSON(w, r, resp.Error("Failed to save refresh token"))
 			return
 		}
 
-		accessToken, err := tokens.GenerateAccessToken(userGUID, userIp, bindKey,
-			jwtConfig.AccessExpires, jwtConfig.SecretKey)
+		accessToken, err := tokens.GenerateAccessToken(userGUID, userIp, bindKey, clientID,
+			jwtConfig.AccessExpires, keys.SigningKey())
 		if err != nil {
 			log.Error("Failed to save access token", sl.Err(err))
-			err = refreshTokenStorage.RevokeRefreshToken(bindKey)
+			err = refreshTokenStorage.RevokeRefreshToken(r.Context(), bindKey)
 			if err != nil {
 				log.Error("Failed to revoke refresh token", sl.Err(err))
 			}
@@ -94,6 +199,21 @@ func New(log *slog.Logger, refreshTokenStorage RefreshTokenStorage, jwtConfig co
 	}
 }
 
+// failed records invalid credentials from the client IP, so guessing
+// passwords and keys is locked out.
+func failed(ctx context.Context, log *slog.Logger, limiter *ratelimit.Limiter, userIp string) {
+	lockedUntil, err := limiter.Fail(ctx, ratelimit.KindIP, userIp)
+	if err != nil {
+		log.Error("Failed to record invalid credentials", sl.Err(err))
+		return
+	}
+
+	if !lockedUntil.IsZero() {
+		log.Warn("Locked out after invalid credentials", sl.Event("authentication_lockout"),
+			slog.String("ip", userIp), slog.Time("locked_until", lockedUntil))
+	}
+}
+
 func responseOK(w http.ResponseWriter, r *http.Request, accessToken string, refreshToken string) {
 	render.JSON(w, r, Response{
 		Response:     resp.OK(),
@@ -4,12 +4,20 @@ import (
 	"auth/internal/config"
 	"auth/internal/http/handlers/get"
 	"auth/internal/http/handlers/get/mocks"
+	"auth/internal/lib/authn"
+	"auth/internal/lib/ipbinding"
 	sl "auth/internal/lib/logger/sl/sldiscard"
+	"auth/internal/lib/password"
+	"auth/internal/lib/ratelimit"
+	"auth/internal/lib/tokens"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -25,10 +33,12 @@ var (
 		AccessExpires:  300 * time.Second,
 		RefreshExpires: 3600 * time.Second,
 	}
+	keys, _  = tokens.NewKeySet(tokens.NewHMACKey("", []byte(jwtCfg.SecretKey)))
 	goodGUID = "d952af16-4251-4ab8-818f-3f3aca064256"
 	badGUID  = "some string"
 	goodIP   = "172.0.0.0:8080"
 	badIP    = "some string"
+	policies = ipbinding.New(config.IPBinding{Clients: []config.IPBindingClient{{ID: "mobile"}}})
 )
 
 func TestGetHandler(t *testing.T) {
@@ -36,6 +46,12 @@ func TestGetHandler(t *testing.T) {
 		name      string
 		userGUID  string
 		userIP    string
+		clientID  string
+		auth      bool
+		authGUID  string
+		authError error
+		disabled  bool
+		userError error
 		respError string
 		saveError error
 		code      int
@@ -44,8 +60,27 @@ func TestGetHandler(t *testing.T) {
 			name:     "Success",
 			userGUID: goodGUID,
 			userIP:   "172.0.0.0:8080",
+			auth:     true,
+			authGUID: goodGUID,
 			code:     200,
 		},
+		{
+			name:     "Known client",
+			userGUID: goodGUID,
+			userIP:   goodIP,
+			clientID: "mobile",
+			auth:     true,
+			authGUID: goodGUID,
+			code:     200,
+		},
+		{
+			name:      "Unknown client",
+			userGUID:  goodGUID,
+			userIP:    goodIP,
+			clientID:  "desktop",
+			respError: "Unknown client",
+			code:      400,
+		},
 		{
 			name:      "Invalid GUID",
 			userGUID:  badGUID,
@@ -67,10 +102,86 @@ func TestGetHandler(t *testing.T) {
 			respError: "Invalid client IP",
 			code:      401,
 		},
+		{
+			name:      "No credentials",
+			userGUID:  goodGUID,
+			userIP:    goodIP,
+			auth:      true,
+			authError: authn.ErrNoCredentials,
+			respError: "Authentication required",
+			code:      401,
+		},
+		{
+			name:      "Invalid credentials",
+			userGUID:  goodGUID,
+			userIP:    goodIP,
+			auth:      true,
+			authError: fmt.Errorf("%w: X-Authenticated-User set by an untrusted client", authn.ErrInvalidCredentials),
+			respError: "Invalid credentials",
+			code:      401,
+		},
+		{
+			name:      "Credentials of another user",
+			userGUID:  goodGUID,
+			userIP:    goodIP,
+			auth:      true,
+			authGUID:  uuid.NewString(),
+			respError: "Credentials do not match the user",
+			code:      403,
+		},
+		{
+			name:      "Email is not verified",
+			userGUID:  goodGUID,
+			userIP:    goodIP,
+			auth:      true,
+			authError: authn.ErrEmailUnverified,
+			respError: "Email is not verified",
+			code:      403,
+		},
+		{
+			name:      "Password hashing is busy",
+			userGUID:  goodGUID,
+			userIP:    goodIP,
+			auth:      true,
+			authError: fmt.Errorf("lib.authn.Password.Authenticate: %w", password.ErrBusy),
+			respError: "Too many logins in progress",
+			code:      503,
+		},
+		{
+			name:      "Failed to authenticate",
+			userGUID:  goodGUID,
+			userIP:    goodIP,
+			auth:      true,
+			authError: errors.New("Some error"),
+			respError: "Unable to authenticate",
+			code:      500,
+		},
+		{
+			name:      "User is disabled",
+			userGUID:  goodGUID,
+			userIP:    goodIP,
+			auth:      true,
+			authGUID:  goodGUID,
+			disabled:  true,
+			respError: "User is disabled",
+			code:      403,
+		},
+		{
+			name:      "Failed to check user",
+			userGUID:  goodGUID,
+			userIP:    goodIP,
+			auth:      true,
+			authGUID:  goodGUID,
+			userError: errors.New("Some error"),
+			respError: "Unable to check user",
+			code:      500,
+		},
 		{
 			name:      "Failed to save refresh token",
 			userGUID:  goodGUID,
 			userIP:    goodIP,
+			auth:      true,
+			authGUID:  goodGUID,
 			respError: "Failed to save refresh token",
 			saveError: errors.New("Some error"),
 			code:      500,
@@ -80,27 +191,48 @@ func TestGetHandler(t *testing.T) {
 	for _, tc := range cases {
 		RefreshTokenStorageMock := mocks.NewRefreshTokenStorage(t)
 
+		AuthenticatorMock := mocks.NewAuthenticator(t)
+		if tc.auth {
+			authGUID, _ := uuid.Parse(tc.authGUID)
+			AuthenticatorMock.On("Authenticate", mock.AnythingOfType("*http.Request")).
+				Return(authGUID, tc.authError).
+				Once()
+		}
+
+		UserDirectoryMock := mocks.NewUserDirectory(t)
+		if tc.auth && tc.authError == nil && tc.authGUID == tc.userGUID {
+			guid, _ := uuid.Parse(tc.userGUID)
+			UserDirectoryMock.On("IsUserDisabled", mock.Anything, guid).
+				Return(tc.disabled, tc.userError).
+				Once()
+		}
+
 		if tc.respError == "" || tc.saveError != nil {
 			guid, _ := uuid.Parse(tc.userGUID)
-			RefreshTokenStorageMock.On("SaveRefreshToken", guid, mock.AnythingOfType("string"), jwtCfg).
+			RefreshTokenStorageMock.On("SaveRefreshToken", mock.Anything, guid, mock.AnythingOfType("string"),
+				mock.AnythingOfType("database.Family"), mock.AnythingOfType("database.Client"), jwtCfg).
 				Return(string("some_string"), tc.saveError).
 				Once()
 		}
 
 		url := fmt.Sprintf(`/%s`, tc.userGUID)
+		if tc.clientID != "" {
+			url += "?client_id=" + tc.clientID
+		}
 		req, err := http.NewRequest(http.MethodGet, url, nil)
 		require.NoError(t, err)
 		req.RemoteAddr = tc.userIP
 
 		rr := httptest.NewRecorder()
 
-		handler := get.New(sl.NewDiscardLogger(), RefreshTokenStorageMock, jwtCfg)
+		handler := get.New(sl.NewDiscardLogger(), AuthenticatorMock, UserDirectoryMock, RefreshTokenStorageMock, keys,
+			policies, nil, jwtCfg)
 		router := chi.NewRouter()
 		router.Get("/{user_guid}", handler)
 
 		router.ServeHTTP(rr, req)
 
-		require.Equal(t, tc.code, rr.Code)
+		require.Equal(t, tc.code, rr.Code, "Case: %s", tc.name)
 
 		body := rr.Body.String()
 
@@ -108,6 +240,98 @@ func TestGetHandler(t *testing.T) {
 
 		require.NoError(t, json.Unmarshal([]byte(body), &resp))
 
-		require.Equal(t, tc.respError, resp.Error)
+		require.Equal(t, tc.respError, resp.Error, "Case: %s", tc.name)
+
+		if tc.code == 200 {
+			claims, err := tokens.ValidateAccessToken(resp.AccessToken, keys)
+			require.NoError(t, err)
+
+			clientID, _ := claims["client_id"].(string)
+			require.Equal(t, tc.clientID, clientID, "Case: %s", tc.name)
+		}
+	}
+}
+
+// TestGUIDLimit floods the GUID with invalid credentials from many IPs,
+// which must not take from the bucket of the GUID.
+func TestGUIDLimit(t *testing.T) {
+	guid := uuid.MustParse(goodGUID)
+
+	limiter := ratelimit.New(ratelimit.NewMemoryStore(), config.RateLimit{
+		IP:      config.Limit{Requests: 100, Period: time.Hour},
+		GUID:    config.Limit{Requests: 1, Period: time.Hour},
+		Lockout: config.Lockout{MaxFailures: 100, Window: time.Hour, Duration: time.Hour},
+	})
+
+	AuthenticatorMock := mocks.NewAuthenticator(t)
+	AuthenticatorMock.On("Authenticate", mock.AnythingOfType("*http.Request")).
+		Return(uuid.Nil, authn.ErrInvalidCredentials).
+		Times(10)
+	AuthenticatorMock.On("Authenticate", mock.AnythingOfType("*http.Request")).
+		Return(guid, nil).
+		Twice()
+
+	UserDirectoryMock := mocks.NewUserDirectory(t)
+	UserDirectoryMock.On("IsUserDisabled", mock.Anything, guid).
+		Return(false, nil).
+		Once()
+
+	RefreshTokenStorageMock := mocks.NewRefreshTokenStorage(t)
+	RefreshTokenStorageMock.On("SaveRefreshToken", mock.Anything, guid, mock.AnythingOfType("string"),
+		mock.AnythingOfType("database.Family"), mock.AnythingOfType("database.Client"), jwtCfg).
+		Return(string("some_string"), nil).
+		Once()
+
+	router := chi.NewRouter()
+	router.Get("/{user_guid}", get.New(sl.NewDiscardLogger(), AuthenticatorMock, UserDirectoryMock,
+		RefreshTokenStorageMock, keys, policies, limiter, jwtCfg))
+
+	cases := []struct {
+		name     string
+		path     string
+		requests int
+		code     int
+	}{
+		{name: "Flood of invalid credentials", path: "/" + goodGUID, requests: 10, code: 401},
+		{name: "Authenticated request", path: "/" + goodGUID, requests: 1, code: 200},
+		{name: "GUID in another form", path: "/" + strings.ToUpper(goodGUID), requests: 1, code: 429},
+	}
+
+	ip := 0
+	for _, tc := range cases {
+		for i := 0; i < tc.requests; i++ {
+			ip++
+
+			req, err := http.NewRequest(http.MethodGet, tc.path, nil)
+			require.NoError(t, err)
+			req.RemoteAddr = fmt.Sprintf("10.0.0.%d:8080", ip)
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.code, rr.Code, "Case: %s", tc.name)
+		}
+	}
+}
+
+// TestRequestLogger checks the attributes of a request are not added to the
+// log lines of the following ones.
+func TestRequestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	router := chi.NewRouter()
+	router.Get("/{user_guid}", get.New(log, mocks.NewAuthenticator(t), mocks.NewUserDirectory(t),
+		mocks.NewRefreshTokenStorage(t), keys, policies, nil, jwtCfg))
+
+	for i := 0; i < 2; i++ {
+		buf.Reset()
+
+		req, err := http.NewRequest(http.MethodGet, "/"+badGUID, nil)
+		require.NoError(t, err)
+
+		router.ServeHTTP(httptest.NewRecorder(), req)
+
+		require.Equal(t, 1, strings.Count(buf.String(), `"op":`), "Request %d", i+1)
 	}
 }
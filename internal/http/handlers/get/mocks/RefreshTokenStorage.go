@@ -3,7 +3,10 @@
 package mocks
 
 import (
+	context "context"
+
 	config "auth/internal/config"
+	database "auth/internal/database"
 
 	mock "github.com/stretchr/testify/mock"
 
@@ -15,13 +18,13 @@ type RefreshTokenStorage struct {
 	mock.Mock
 }
 
-// RevokeRefreshToken provides a mock function with given fields: bindKey
-func (_m *RefreshTokenStorage) RevokeRefreshToken(bindKey string) error {
-	ret := _m.Called(bindKey)
+// RevokeRefreshToken provides a mock function with given fields: ctx, bindKey
+func (_m *RefreshTokenStorage) RevokeRefreshToken(ctx context.Context, bindKey string) error {
+	ret := _m.Called(ctx, bindKey)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string) error); ok {
-		r0 = rf(bindKey)
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, bindKey)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -29,20 +32,20 @@ func (_m *RefreshTokenStorage) RevokeRefreshToken(bindKey string) error {
 	return r0
 }
 
-// SaveRefreshToken provides a mock function with given fields: userGUID, token, jwtConfig
-func (_m *RefreshTokenStorage) SaveRefreshToken(userGUID uuid.UUID, token string, jwtConfig config.JWT) (string, error) {
-	ret := _m.Called(userGUID, token, jwtConfig)
+// SaveRefreshToken provides a mock function with given fields: ctx, userGUID, token, family, client, jwtConfig
+func (_m *RefreshTokenStorage) SaveRefreshToken(ctx context.Context, userGUID uuid.UUID, token string, family database.Family, client database.Client, jwtConfig config.JWT) (string, error) {
+	ret := _m.Called(ctx, userGUID, token, family, client, jwtConfig)
 
 	var r0 string
-	if rf, ok := ret.Get(0).(func(uuid.UUID, string, config.JWT) string); ok {
-		r0 = rf(userGUID, token, jwtConfig)
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, string, database.Family, database.Client, config.JWT) string); ok {
+		r0 = rf(ctx, userGUID, token, family, client, jwtConfig)
 	} else {
 		r0 = ret.Get(0).(string)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(uuid.UUID, string, config.JWT) error); ok {
-		r1 = rf(userGUID, token, jwtConfig)
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID, string, database.Family, database.Client, config.JWT) error); ok {
+		r1 = rf(ctx, userGUID, token, family, client, jwtConfig)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -0,0 +1,141 @@
+package introspect
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+
+	"auth/internal/database"
+	resp "auth/internal/lib/api/response"
+	"auth/internal/lib/logger/sl"
+	"auth/internal/lib/tokens"
+)
+
+const (
+	SessionActive = "active"
+
+	ErrInvalidRequest = "invalid_request"
+)
+
+// Response is the RFC 7662 introspection response. Inactive tokens carry
+// only the active field.
+type Response struct {
+	Active        bool   `json:"active"`
+	TokenType     string `json:"token_type,omitempty"`
+	Scope         string `json:"scope,omitempty"`
+	ClientID      string `json:"client_id,omitempty"`
+	Sub           string `json:"sub,omitempty"`
+	Exp           int64  `json:"exp,omitempty"`
+	Iat           int64  `json:"iat,omitempty"`
+	IP            string `json:"ip,omitempty"`
+	SessionStatus string `json:"session_status,omitempty"`
+}
+
+//go:generate go run github.com/vektra/mockery/v3 --name=RefreshTokenStorage
+type RefreshTokenStorage interface {
+	GetRefreshToken(ctx context.Context, bindKey string) (database.RefreshClaims, error)
+}
+
+//go:generate go run github.com/vektra/mockery/v3 --name=UserDirectory
+type UserDirectory interface {
+	IsUserDisabled(ctx context.Context, userGUID uuid.UUID) (bool, error)
+}
+
+// New introspects an access token as described in RFC 7662. A token is
+// active if it is correctly signed, not expired, the refresh token it is
+// bound to is neither revoked nor expired, and its user is not disabled.
+func New(log *slog.Logger, refreshTokenStorage RefreshTokenStorage, users UserDirectory,
+	keys *tokens.KeySet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.auth.introspect.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		err := r.ParseForm()
+		if err != nil {
+			log.Error("Failed to parse request form", sl.Err(err))
+			render.Status(r, 400)
+			render.JSON(w, r, resp.Error(ErrInvalidRequest))
+			return
+		}
+
+		token := r.PostForm.Get("token")
+		if token == "" {
+			log.Error("Token is empty")
+			render.Status(r, 400)
+			render.JSON(w, r, resp.Error(ErrInvalidRequest))
+			return
+		}
+
+		claims, err := tokens.ValidateAccessToken(token, keys)
+		if err != nil {
+			log.Info("Token is inactive", sl.Err(err))
+			render.JSON(w, r, Response{Active: false})
+			return
+		}
+
+		bindKey, _ := claims["bind_key"].(string)
+
+		refreshClaims, err := refreshTokenStorage.GetRefreshToken(r.Context(), bindKey)
+		if errors.Is(err, database.ErrTokenNotFound) {
+			log.Info("Token session does not exist")
+			render.JSON(w, r, Response{Active: false})
+			return
+		}
+
+		if err != nil {
+			log.Error("Failed to find refresh token", sl.Err(err))
+			render.Status(r, resp.StorageStatus(err, 503))
+			render.JSON(w, r, resp.Error("Unable to introspect token"))
+			return
+		}
+
+		if refreshClaims.IsRevoked || refreshClaims.ExpiresAt.Before(time.Now()) {
+			log.Info("Token session is revoked or expired")
+			render.JSON(w, r, Response{Active: false})
+			return
+		}
+
+		disabled, err := users.IsUserDisabled(r.Context(), refreshClaims.UserGUID)
+		if err != nil {
+			log.Error("Failed to check user", sl.Err(err))
+			render.Status(r, resp.StorageStatus(err, 503))
+			render.JSON(w, r, resp.Error("Unable to introspect token"))
+			return
+		}
+
+		if disabled {
+			log.Info("Token user is disabled")
+			render.JSON(w, r, Response{Active: false})
+			return
+		}
+
+		response := Response{
+			Active:        true,
+			TokenType:     "Bearer",
+			SessionStatus: SessionActive,
+		}
+		response.Sub, _ = claims["sub"].(string)
+		response.IP, _ = claims["ip"].(string)
+		response.Scope, _ = claims["scope"].(string)
+		response.ClientID, _ = claims["client_id"].(string)
+
+		if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+			response.Exp = exp.Unix()
+		}
+		if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+			response.Iat = iat.Unix()
+		}
+
+		render.JSON(w, r, response)
+	}
+}
@@ -0,0 +1,199 @@
+package introspect_test
+
+import (
+	"auth/internal/config"
+	"auth/internal/database"
+	"auth/internal/http/handlers/introspect"
+	"auth/internal/http/handlers/introspect/mocks"
+	"auth/internal/http/middleware/clientauth"
+	sl "auth/internal/lib/logger/sl/sldiscard"
+	"auth/internal/lib/tokens"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	clients            = []config.Client{{ID: "resource-server", Secret: "client secret"}}
+	keys, _            = tokens.NewKeySet(tokens.NewHMACKey("", []byte("secretkey")))
+	goodGUID           = uuid.New()
+	goodAccessToken, _ = tokens.GenerateAccessToken(goodGUID, "172.0.0.1", "bind key", "", time.Minute, keys.SigningKey())
+	expAccessToken, _  = tokens.GenerateAccessToken(goodGUID, "172.0.0.1", "bind key", "", -time.Minute, keys.SigningKey())
+	activeClaims       = database.RefreshClaims{
+		UserGUID:  goodGUID,
+		BindKey:   "bind key",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	revokedClaims = database.RefreshClaims{
+		UserGUID:  goodGUID,
+		BindKey:   "bind key",
+		ExpiresAt: time.Now().Add(time.Hour),
+		IsRevoked: true,
+	}
+	expClaims = database.RefreshClaims{
+		UserGUID:  goodGUID,
+		BindKey:   "bind key",
+		ExpiresAt: time.Now(),
+	}
+)
+
+func TestIntrospectHandler(t *testing.T) {
+	cases := []struct {
+		name               string
+		clientID           string
+		clientSecret       string
+		token              string
+		refreshTokenClaims database.RefreshClaims
+		getError           error
+		getMock            bool
+		disabled           bool
+		userError          error
+		respError          string
+		active             bool
+		code               int
+	}{
+		{
+			name:               "Active",
+			token:              goodAccessToken,
+			refreshTokenClaims: activeClaims,
+			getMock:            true,
+			active:             true,
+			code:               200,
+		},
+		{
+			name:         "Unauthenticated client",
+			clientSecret: "some string",
+			token:        goodAccessToken,
+			respError:    clientauth.ErrInvalidClient,
+			code:         401,
+		},
+		{
+			name:      "Empty token",
+			respError: introspect.ErrInvalidRequest,
+			code:      400,
+		},
+		{
+			name:  "Expired access token",
+			token: expAccessToken,
+			code:  200,
+		},
+		{
+			name:  "Invalid access token",
+			token: "some string",
+			code:  200,
+		},
+		{
+			name:               "Revoked session",
+			token:              goodAccessToken,
+			refreshTokenClaims: revokedClaims,
+			getMock:            true,
+			code:               200,
+		},
+		{
+			name:               "Expired session",
+			token:              goodAccessToken,
+			refreshTokenClaims: expClaims,
+			getMock:            true,
+			code:               200,
+		},
+		{
+			name:     "Session does not exist",
+			token:    goodAccessToken,
+			getError: database.ErrTokenNotFound,
+			getMock:  true,
+			code:     200,
+		},
+		{
+			name:      "Failed to find refresh token",
+			token:     goodAccessToken,
+			getError:  errors.New("some error"),
+			getMock:   true,
+			respError: "Unable to introspect token",
+			code:      503,
+		},
+		{
+			name:               "Disabled user",
+			token:              goodAccessToken,
+			refreshTokenClaims: activeClaims,
+			getMock:            true,
+			disabled:           true,
+			code:               200,
+		},
+		{
+			name:               "Failed to check user",
+			token:              goodAccessToken,
+			refreshTokenClaims: activeClaims,
+			getMock:            true,
+			userError:          errors.New("some error"),
+			respError:          "Unable to introspect token",
+			code:               503,
+		},
+	}
+
+	for _, tc := range cases {
+		RefreshTokenStorageMock := mocks.NewRefreshTokenStorage(t)
+
+		UserDirectoryMock := mocks.NewUserDirectory(t)
+		if tc.active || tc.disabled || tc.userError != nil {
+			UserDirectoryMock.On("IsUserDisabled", mock.Anything, goodGUID).
+				Return(tc.disabled, tc.userError).
+				Once()
+		}
+
+		if tc.getMock {
+			RefreshTokenStorageMock.On("GetRefreshToken", mock.Anything, "bind key").
+				Return(tc.refreshTokenClaims, tc.getError).
+				Once()
+		}
+
+		form := url.Values{"token": {tc.token}}
+
+		req, err := http.NewRequest(http.MethodPost, "/introspect", strings.NewReader(form.Encode()))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		if tc.clientSecret == "" {
+			req.SetBasicAuth(clients[0].ID, clients[0].Secret)
+		} else {
+			req.SetBasicAuth(clients[0].ID, tc.clientSecret)
+		}
+
+		rr := httptest.NewRecorder()
+
+		handler := introspect.New(sl.NewDiscardLogger(), RefreshTokenStorageMock, UserDirectoryMock, keys)
+		router := chi.NewRouter()
+		router.With(clientauth.New(sl.NewDiscardLogger(), "introspection", clients)).Post("/introspect", handler)
+
+		router.ServeHTTP(rr, req)
+
+		require.Equal(t, tc.code, rr.Code, "Case: %s", tc.name)
+
+		var resp struct {
+			introspect.Response
+			Error string `json:"error"`
+		}
+
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+		require.Equal(t, tc.respError, resp.Error, "Case: %s", tc.name)
+		require.Equal(t, tc.active, resp.Active, "Case: %s", tc.name)
+
+		if tc.active {
+			require.Equal(t, goodGUID.String(), resp.Sub, "Case: %s", tc.name)
+			require.Equal(t, "172.0.0.1", resp.IP, "Case: %s", tc.name)
+			require.Equal(t, introspect.SessionActive, resp.SessionStatus, "Case: %s", tc.name)
+			require.NotZero(t, resp.Exp, "Case: %s", tc.name)
+			require.NotZero(t, resp.Iat, "Case: %s", tc.name)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package jwks
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/go-chi/render"
+
+	"auth/internal/lib/tokens"
+)
+
+const cacheControl = "public, max-age=300"
+
+func New(log *slog.Logger, keys *tokens.KeySet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.auth.jwks.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		jwks := keys.JWKS()
+		log.Debug("Serving JWK set", slog.Int("keys", len(jwks.Keys)))
+
+		w.Header().Set("Cache-Control", cacheControl)
+		render.JSON(w, r, jwks)
+	}
+}
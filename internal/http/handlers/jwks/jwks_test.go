@@ -0,0 +1,70 @@
+package jwks_test
+
+import (
+	"auth/internal/http/handlers/jwks"
+	sl "auth/internal/lib/logger/sl/sldiscard"
+	"auth/internal/lib/tokens"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/stretchr/testify/require"
+)
+
+func pemKey(t *testing.T, private interface{}) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestJWKSHandler(t *testing.T) {
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ecPrivate, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	edKey, err := tokens.ParsePrivateKey("ed-key", "", pemKey(t, edPrivate))
+	require.NoError(t, err)
+	ecKey, err := tokens.ParsePrivateKey("ec-key", "", pemKey(t, ecPrivate))
+	require.NoError(t, err)
+
+	keys, err := tokens.NewKeySet(edKey, ecKey, tokens.NewHMACKey("", []byte("secretkey")))
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Use(middleware.URLFormat)
+	router.Get("/.well-known/jwks", jwks.New(sl.NewDiscardLogger(), keys))
+
+	req, err := http.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	require.Equal(t, 200, rr.Code)
+
+	var resp tokens.JWKS
+
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Len(t, resp.Keys, 2)
+
+	require.Equal(t, "ec-key", resp.Keys[0].Kid)
+	require.Equal(t, "EC", resp.Keys[0].Kty)
+	require.Equal(t, "P-256", resp.Keys[0].Crv)
+	require.Equal(t, "ES256", resp.Keys[0].Alg)
+	require.Len(t, resp.Keys[0].X, 43)
+
+	require.Equal(t, "ed-key", resp.Keys[1].Kid)
+	require.Equal(t, "OKP", resp.Keys[1].Kty)
+	require.Equal(t, "EdDSA", resp.Keys[1].Alg)
+}
@@ -0,0 +1,56 @@
+package logout
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+
+	"auth/internal/http/middleware/bearer"
+	resp "auth/internal/lib/api/response"
+	"auth/internal/lib/logger/sl"
+)
+
+//go:generate go run github.com/vektra/mockery/v3 --name=RefreshTokenStorage
+type RefreshTokenStorage interface {
+	RevokeUserRefreshTokens(ctx context.Context, userGUID uuid.UUID) error
+}
+
+// New revokes every refresh token of the user authenticated by the bearer
+// middleware, ending all of their sessions.
+func New(log *slog.Logger, refreshTokenStorage RefreshTokenStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.auth.logout.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		claims, _ := bearer.Claims(r.Context())
+		sub, _ := claims["sub"].(string)
+
+		userGUID, err := uuid.Parse(sub)
+		if err != nil {
+			log.Error("Failed to parse user GUID", sl.Err(err))
+			render.Status(r, 401)
+			render.JSON(w, r, resp.Error("Invalid user GUID"))
+			return
+		}
+
+		err = refreshTokenStorage.RevokeUserRefreshTokens(r.Context(), userGUID)
+		if err != nil {
+			log.Error("Failed to revoke user refresh tokens", sl.Err(err))
+			render.Status(r, resp.StorageStatus(err, 503))
+			render.JSON(w, r, resp.Error("Unable to revoke tokens"))
+			return
+		}
+
+		log.Info("User logged out everywhere", slog.String("user_guid", userGUID.String()))
+
+		render.JSON(w, r, resp.OK())
+	}
+}
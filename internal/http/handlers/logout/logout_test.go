@@ -0,0 +1,120 @@
+package logout_test
+
+import (
+	"auth/internal/http/handlers/logout"
+	"auth/internal/http/handlers/logout/mocks"
+	"auth/internal/http/middleware/bearer"
+	sl "auth/internal/lib/logger/sl/sldiscard"
+	"auth/internal/lib/tokens"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	keys, _               = tokens.NewKeySet(tokens.NewHMACKey("", []byte("secretkey")))
+	goodGUID              = uuid.New()
+	goodAccessToken, _    = tokens.GenerateAccessToken(goodGUID, "172.0.0.1", "bind key", "", time.Minute, keys.SigningKey())
+	expAccessToken, _     = tokens.GenerateAccessToken(goodGUID, "172.0.0.1", "bind key", "", -time.Minute, keys.SigningKey())
+	badGuidAccessToken, _ = jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
+		"sub":      "bad guid",
+		"exp":      time.Now().Add(time.Minute).Unix(),
+		"bind_key": "bind key",
+	}).SignedString([]byte("secretkey"))
+)
+
+func TestLogoutHandler(t *testing.T) {
+	cases := []struct {
+		name          string
+		authorization string
+		respError     string
+		revokeError   error
+		revokeMock    bool
+		code          int
+	}{
+		{
+			name:          "Success",
+			authorization: "Bearer " + goodAccessToken,
+			revokeMock:    true,
+			code:          200,
+		},
+		{
+			name:      "Access token is empty",
+			respError: "Access token is empty",
+			code:      401,
+		},
+		{
+			name:          "Expired access token",
+			authorization: "Bearer " + expAccessToken,
+			respError:     "Invalid access token",
+			code:          401,
+		},
+		{
+			name:          "Invalid GUID",
+			authorization: "Bearer " + badGuidAccessToken,
+			respError:     "Invalid user GUID",
+			code:          401,
+		},
+		{
+			name:          "Failed to revoke tokens",
+			authorization: "Bearer " + goodAccessToken,
+			revokeError:   errors.New("some error"),
+			revokeMock:    true,
+			respError:     "Unable to revoke tokens",
+			code:          503,
+		},
+		{
+			name:          "Revoking tokens timed out",
+			authorization: "Bearer " + goodAccessToken,
+			revokeError:   fmt.Errorf("some error: %w", context.DeadlineExceeded),
+			revokeMock:    true,
+			respError:     "Unable to revoke tokens",
+			code:          504,
+		},
+	}
+
+	for _, tc := range cases {
+		RefreshTokenStorageMock := mocks.NewRefreshTokenStorage(t)
+
+		if tc.revokeMock {
+			RefreshTokenStorageMock.On("RevokeUserRefreshTokens", mock.Anything, goodGUID).
+				Return(tc.revokeError).
+				Once()
+		}
+
+		req, err := http.NewRequest(http.MethodPost, "/revoke/all", nil)
+		require.NoError(t, err)
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+
+		rr := httptest.NewRecorder()
+
+		handler := logout.New(sl.NewDiscardLogger(), RefreshTokenStorageMock)
+		router := chi.NewRouter()
+		router.With(bearer.New(sl.NewDiscardLogger(), keys)).Post("/revoke/all", handler)
+
+		router.ServeHTTP(rr, req)
+
+		require.Equal(t, tc.code, rr.Code, "Case: %s", tc.name)
+
+		var resp struct {
+			Error string `json:"error"`
+		}
+
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+		require.Equal(t, tc.respError, resp.Error, "Case: %s", tc.name)
+	}
+}
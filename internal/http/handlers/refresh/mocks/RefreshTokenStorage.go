@@ -3,6 +3,8 @@
 package mocks
 
 import (
+	context "context"
+
 	config "auth/internal/config"
 	database "auth/internal/database"
 
@@ -16,20 +18,41 @@ type RefreshTokenStorage struct {
 	mock.Mock
 }
 
-// GetRefreshToken provides a mock function with given fields: bindKey
-func (_m *RefreshTokenStorage) GetRefreshToken(bindKey string) (database.RefreshClaims, error) {
-	ret := _m.Called(bindKey)
+// GetRefreshToken provides a mock function with given fields: ctx, bindKey
+func (_m *RefreshTokenStorage) GetRefreshToken(ctx context.Context, bindKey string) (database.RefreshClaims, error) {
+	ret := _m.Called(ctx, bindKey)
+
+	var r0 database.RefreshClaims
+	if rf, ok := ret.Get(0).(func(context.Context, string) database.RefreshClaims); ok {
+		r0 = rf(ctx, bindKey)
+	} else {
+		r0 = ret.Get(0).(database.RefreshClaims)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, bindKey)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetRefreshTokenByHash provides a mock function with given fields: ctx, hash
+func (_m *RefreshTokenStorage) GetRefreshTokenByHash(ctx context.Context, hash string) (database.RefreshClaims, error) {
+	ret := _m.Called(ctx, hash)
 
 	var r0 database.RefreshClaims
-	if rf, ok := ret.Get(0).(func(string) database.RefreshClaims); ok {
-		r0 = rf(bindKey)
+	if rf, ok := ret.Get(0).(func(context.Context, string) database.RefreshClaims); ok {
+		r0 = rf(ctx, hash)
 	} else {
 		r0 = ret.Get(0).(database.RefreshClaims)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(bindKey)
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, hash)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -37,13 +60,27 @@ func (_m *RefreshTokenStorage) GetRefreshToken(bindKey string) (database.Refresh
 	return r0, r1
 }
 
-// RevokeRefreshToken provides a mock function with given fields: bindKey
-func (_m *RefreshTokenStorage) RevokeRefreshToken(bindKey string) error {
-	ret := _m.Called(bindKey)
+// RevokeRefreshToken provides a mock function with given fields: ctx, bindKey
+func (_m *RefreshTokenStorage) RevokeRefreshToken(ctx context.Context, bindKey string) error {
+	ret := _m.Called(ctx, bindKey)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, bindKey)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RevokeRefreshTokenFamily provides a mock function with given fields: ctx, familyID
+func (_m *RefreshTokenStorage) RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	ret := _m.Called(ctx, familyID)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string) error); ok {
-		r0 = rf(bindKey)
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) error); ok {
+		r0 = rf(ctx, familyID)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -51,20 +88,20 @@ func (_m *RefreshTokenStorage) RevokeRefreshToken(bindKey string) error {
 	return r0
 }
 
-// SaveRefreshToken provides a mock function with given fields: user_GUID, token, jwtConfig
-func (_m *RefreshTokenStorage) SaveRefreshToken(user_GUID uuid.UUID, token string, jwtConfig config.JWT) (string, error) {
-	ret := _m.Called(user_GUID, token, jwtConfig)
+// RotateRefreshToken provides a mock function with given fields: ctx, bindKey, token, client, jwtConfig, emails
+func (_m *RefreshTokenStorage) RotateRefreshToken(ctx context.Context, bindKey string, token string, client database.Client, jwtConfig config.JWT, emails []database.Email) (string, error) {
+	ret := _m.Called(ctx, bindKey, token, client, jwtConfig, emails)
 
 	var r0 string
-	if rf, ok := ret.Get(0).(func(uuid.UUID, string, config.JWT) string); ok {
-		r0 = rf(user_GUID, token, jwtConfig)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, database.Client, config.JWT, []database.Email) string); ok {
+		r0 = rf(ctx, bindKey, token, client, jwtConfig, emails)
 	} else {
 		r0 = ret.Get(0).(string)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(uuid.UUID, string, config.JWT) error); ok {
-		r1 = rf(user_GUID, token, jwtConfig)
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, database.Client, config.JWT, []database.Email) error); ok {
+		r1 = rf(ctx, bindKey, token, client, jwtConfig, emails)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -1,10 +1,10 @@
 package refresh
 
 import (
+	"context"
 	"errors"
 	"io"
 	"log/slog"
-	"net"
 	"net/http"
 	"time"
 
@@ -15,10 +15,19 @@ import (
 	"auth/internal/config"
 	"auth/internal/database"
 	resp "auth/internal/lib/api/response"
+	"auth/internal/lib/clientip"
+	"auth/internal/lib/ipbinding"
 	"auth/internal/lib/logger/sl"
+	"auth/internal/lib/ratelimit"
 	"auth/internal/lib/tokens"
 )
 
+// Codes of the responses rejecting a refresh from another IP.
+const (
+	CodeIPMismatch     = "ip_mismatch"
+	CodeSubnetMismatch = "subnet_mismatch"
+)
+
 type Request struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
@@ -32,45 +41,53 @@ type Response struct {
 
 //go:generate go run github.com/vektra/mockery/v3 --name=RefreshTokenStorage
 type RefreshTokenStorage interface {
-	SaveRefreshToken(userGUID uuid.UUID, token string, jwtConfig config.JWT) (string, error)
-	RevokeRefreshToken(bindKey string) error
-	GetRefreshToken(bindKey string) (database.RefreshClaims, error)
+	RotateRefreshToken(ctx context.Context, bindKey string, token string, client database.Client,
+		jwtConfig config.JWT, emails []database.Email) (string, error)
+	RevokeRefreshToken(ctx context.Context, bindKey string) error
+	RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error
+	GetRefreshToken(ctx context.Context, bindKey string) (database.RefreshClaims, error)
+	GetRefreshTokenByHash(ctx context.Context, hash string) (database.RefreshClaims, error)
 }
 
-//go:generate go run github.com/vektra/mockery/v3 --name=EmailSender
-type EmailSender interface {
-	SendIpWarnig(to, ip string) error
+// UserDirectory tells where and whether to notify the user of a token.
+//
+//go:generate go run github.com/vektra/mockery/v3 --name=UserDirectory
+type UserDirectory interface {
+	GetUser(ctx context.Context, userGUID uuid.UUID) (database.User, error)
+	IsUserDisabled(ctx context.Context, userGUID uuid.UUID) (bool, error)
 }
 
-func New(log *slog.Logger, refreshTokenStorage RefreshTokenStorage, emailSender EmailSender, jwtConfig config.JWT) http.HandlerFunc {
+// New rotates the refresh token. A refresh from another IP than the one the
+// access token was issued to is allowed, warned of or rejected by the IP
+// binding policy of the client of the token. The limiter, if any, limits
+// the refreshes of every token and locks the client IP and the token out
+// after repeated invalid refreshes. The tokens of disabled users are
+// rejected.
+func New(log *slog.Logger, refreshTokenStorage RefreshTokenStorage, users UserDirectory, keys *tokens.KeySet,
+	policies *ipbinding.Policies, limiter *ratelimit.Limiter, jwtConfig config.JWT) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.auth.refresh.New"
 
-		log = log.With(
+		log := log.With(
 			slog.String("op", op),
 			slog.String("request_id", middleware.GetReqID(r.Context())),
 		)
 
-		userIp := r.RemoteAddr
-		if userIp == "" {
-			log.Error("Remote address is empty")
+		userIp, err := clientip.FromRequest(r)
+		if err != nil {
+			log.Error("Failed to get client IP", sl.Err(err))
 			render.Status(r, 401)
-			render.JSON(w, r, resp.Error("Unable to get client IP"))
-			return
-		} else {
-			var err error
-			userIp, _, err = net.SplitHostPort(userIp)
-			if err != nil {
-				log.Error("Failed to parse client IP", sl.Err(err))
-				render.Status(r, 401)
+			if errors.Is(err, clientip.ErrNoClientIP) {
+				render.JSON(w, r, resp.Error("Unable to get client IP"))
+			} else {
 				render.JSON(w, r, resp.Error("Invalid client IP"))
-				return
 			}
+			return
 		}
 
 		var req Request
 
-		err := render.DecodeJSON(r.Body, &req)
+		err = render.DecodeJSON(r.Body, &req)
 		if errors.Is(err, io.EOF) {
 			log.Error("Request body is empty")
 			render.Status(r, 400)
@@ -101,9 +118,10 @@ func New(log *slog.Logger, refreshTokenStorage RefreshTokenStorage, emailSender
 			return
 		}
 
-		accessClaims, err := tokens.ValidateAccessToken(accessToken, []byte(jwtConfig.SecretKey))
+		accessClaims, err := tokens.ValidateAccessToken(accessToken, keys)
 		if err != nil && !errors.Is(err, tokens.ErrAccessTokenExpired) {
 			log.Error("Failed to validate access token", sl.Err(err))
+			failed(r.Context(), log, limiter, userIp, "")
 			render.Status(r, 401)
 			render.JSON(w, r, resp.Error("Invalid access token"))
 			return
@@ -111,12 +129,11 @@ func New(log *slog.Logger, refreshTokenStorage RefreshTokenStorage, emailSender
 
 		bindKey := accessClaims["bind_key"].(string)
 		previousIP := accessClaims["ip"].(string)
-
-		if previousIP != userIp {
-			err = emailSender.SendIpWarnig("user@mail", userIp)
-			if err != nil {
-				log.Error("Failed to send IP warning", sl.Err(err))
-			}
+		clientID, _ := accessClaims["client_id"].(string)
+		binding := ipBinding{
+			policy:     policies.For(clientID),
+			clientID:   clientID,
+			previousIP: previousIP,
 		}
 
 		userGUID, err := uuid.Parse(accessClaims["sub"].(string))
@@ -127,47 +144,84 @@ func New(log *slog.Logger, refreshTokenStorage RefreshTokenStorage, emailSender
 			return
 		}
 
-		refreshClaims, err := refreshTokenStorage.GetRefreshToken(bindKey)
+		result, err := limiter.Allow(r.Context(), ratelimit.KindBindKey, bindKey)
+		if err != nil {
+			log.Error("Failed to take rate limit token", sl.Err(err))
+		} else if !result.Allowed {
+			log.Warn("Refresh is rate limited", sl.Event("rate_limited"),
+				slog.String("kind", ratelimit.KindBindKey),
+				slog.String("user_guid", userGUID.String()),
+				slog.Bool("locked", result.Locked))
+			ratelimit.Deny(w, r, result)
+			return
+		}
+		ratelimit.WriteHeaders(w, result)
+
+		refreshClaims, err := refreshTokenStorage.GetRefreshToken(r.Context(), bindKey)
 		if err != nil {
 			log.Error("Failed to find refresh token", sl.Err(err))
 			if errors.Is(err, database.ErrTokenNotFound) {
+				failed(r.Context(), log, limiter, userIp, "")
 				render.Status(r, 401)
 				render.JSON(w, r, resp.Error("Refresh token does not exist"))
 			} else {
-				render.Status(r, 500)
+				render.Status(r, resp.StorageStatus(err, 500))
 				render.JSON(w, r, resp.Error("Unable to find refresh token"))
 			}
 
 			return
 		}
 
-		err = tokens.ValidateRefreshToken(refreshToken, refreshClaims.Hash)
+		err = tokens.ValidateRefreshToken(refreshToken, refreshClaims.Hash, jwtConfig.RefreshTokenPepper)
 		if err != nil {
 			log.Error("Failed to validate refresh token", sl.Err(err))
+			failed(r.Context(), log, limiter, userIp, bindKey)
 			render.Status(r, 401)
 			render.JSON(w, r, resp.Error("Invalid refresh token"))
 			return
 		}
 
-		if refreshClaims.IsRevoked {
-			log.Error("Refresh token is revoked")
-			render.Status(r, 401)
-			render.JSON(w, r, resp.Error("Refresh token is revoked"))
+		disabled, err := users.IsUserDisabled(r.Context(), userGUID)
+		if err != nil {
+			log.Error("Failed to check user", sl.Err(err))
+			render.Status(r, resp.StorageStatus(err, 500))
+			render.JSON(w, r, resp.Error("Unable to check user"))
 			return
-		} else if refreshClaims.ExpiresAt.Before(time.Now()) {
+		}
+
+		if disabled {
+			log.Warn("Refresh of disabled user", sl.Event("disabled_user_refresh"),
+				slog.String("user_guid", userGUID.String()))
+			render.Status(r, 403)
+			render.JSON(w, r, resp.ErrorCode(resp.CodeUserDisabled, "User is disabled"))
+			return
+		}
+
+		if refreshClaims.ExpiresAt.Before(time.Now()) {
 			log.Error("Refresh token has expired")
 
-			err = refreshTokenStorage.RevokeRefreshToken(bindKey)
-			if err != nil {
-				log.Error("Failed to revoke expired refresh token", sl.Err(err))
+			if !refreshClaims.IsRevoked {
+				err = refreshTokenStorage.RevokeRefreshToken(r.Context(), bindKey)
+				if err != nil {
+					log.Error("Failed to revoke expired refresh token", sl.Err(err))
+				}
 			}
 
 			render.Status(r, 401)
 			render.JSON(w, r, resp.Error("Refresh token has expired"))
 			return
+		} else if refreshClaims.IsRevoked {
+			revoked(w, r, log, refreshTokenStorage, keys, jwtConfig, refreshToken, refreshClaims, userIp, binding)
+			return
+		}
+
+		decision := binding.check(r.Context(), log, userGUID, userIp)
+		if decision == ipbinding.DecisionReject {
+			binding.reject(w, r)
+			return
 		}
 
-		newRefreshToken, err := tokens.GenerateRefreshToken()
+		newRefreshToken, err := successorToken(refreshToken, jwtConfig)
 		if err != nil {
 			log.Error("Failed to generate new refresh token", sl.Err(err))
 			render.Status(r, 500)
@@ -175,37 +229,231 @@ func New(log *slog.Logger, refreshTokenStorage RefreshTokenStorage, emailSender
 			return
 		}
 
-		usedBindKey := bindKey
-		newBindKey, err := refreshTokenStorage.SaveRefreshToken(userGUID, newRefreshToken, jwtConfig)
+		// The warning is queued along with the rotation, so it is sent even
+		// if the mail server is down and never for a failed refresh.
+		client := database.Client{IP: userIp, UserAgent: r.UserAgent()}
+
+		var emails []database.Email
+		if decision == ipbinding.DecisionWarn {
+			emails = ipWarning(r.Context(), log, users, userGUID, refreshClaims.FamilyID, client, previousIP)
+		}
+
+		newBindKey, err := refreshTokenStorage.RotateRefreshToken(r.Context(), bindKey, newRefreshToken,
+			client, jwtConfig, emails)
+		if errors.Is(err, database.ErrTokenRevoked) {
+			// A concurrent refresh with the same token has rotated it first.
+			refreshClaims, err = refreshTokenStorage.GetRefreshToken(r.Context(), bindKey)
+			if err == nil {
+				revoked(w, r, log, refreshTokenStorage, keys, jwtConfig, refreshToken, refreshClaims, userIp,
+					binding)
+				return
+			}
+		}
+
 		if err != nil {
 			log.Error("Failed to save new refresh token", sl.Err(err))
-			render.Status(r, 500)
+			render.Status(r, resp.StorageStatus(err, 500))
 			render.JSON(w, r, resp.Error("Failed to save new refresh token"))
 			return
 		}
 
-		newAccessToken, err := tokens.GenerateAccessToken(userGUID, userIp, newBindKey,
-			jwtConfig.AccessExpires, jwtConfig.SecretKey)
+		// The used token is revoked already, so the new one is kept even if
+		// no access token can be issued: a retry within the grace period
+		// still gets it.
+		newAccessToken, err := tokens.GenerateAccessToken(userGUID, userIp, newBindKey, clientID,
+			jwtConfig.AccessExpires, keys.SigningKey())
 		if err != nil {
 			log.Error("Failed to generate access token", sl.Err(err))
-			err = refreshTokenStorage.RevokeRefreshToken(newBindKey)
-			if err != nil {
-				log.Error("Failed to revoke refresh token", sl.Err(err))
-			}
 			render.Status(r, 500)
 			render.JSON(w, r, resp.Error("Failed to generate new access token"))
 			return
 		}
 
-		err = refreshTokenStorage.RevokeRefreshToken(usedBindKey)
+		responseOK(w, r, newAccessToken, newRefreshToken)
+	}
+}
+
+// successorToken returns the refresh token that replaces the used one. With
+// a grace period it is derived from the used token, so a retry gets it again.
+func successorToken(refreshToken string, jwtConfig config.JWT) (string, error) {
+	if jwtConfig.RefreshGracePeriod > 0 {
+		return tokens.DeriveRefreshToken(refreshToken, jwtConfig.RefreshTokenPepper), nil
+	}
+
+	return tokens.GenerateRefreshToken()
+}
+
+// revoked answers a refresh with a valid but revoked token. Within the grace
+// period after its rotation, the retry gets the successor again as long as
+// the successor is unused and the IP binding policy allows the IP. Otherwise
+// the token has already been rotated or logged out, so whoever presents it
+// may hold a stolen copy: the whole family is revoked.
+func revoked(w http.ResponseWriter, r *http.Request, log *slog.Logger, refreshTokenStorage RefreshTokenStorage,
+	keys *tokens.KeySet, jwtConfig config.JWT, refreshToken string, refreshClaims database.RefreshClaims,
+	userIp string, binding ipBinding) {
+	if jwtConfig.RefreshGracePeriod > 0 && !refreshClaims.RotatedAt.IsZero() &&
+		time.Since(refreshClaims.RotatedAt) <= jwtConfig.RefreshGracePeriod {
+		newRefreshToken := tokens.DeriveRefreshToken(refreshToken, jwtConfig.RefreshTokenPepper)
+
+		successor, err := refreshTokenStorage.GetRefreshTokenByHash(r.Context(),
+			tokens.HashRefreshToken(newRefreshToken, jwtConfig.RefreshTokenPepper))
+		if err != nil && !errors.Is(err, database.ErrTokenNotFound) {
+			log.Error("Failed to find successor refresh token", sl.Err(err))
+			render.Status(r, resp.StorageStatus(err, 500))
+			render.JSON(w, r, resp.Error("Unable to find refresh token"))
+			return
+		}
+
+		if err == nil && successor.ParentBindKey == refreshClaims.BindKey && !successor.IsRevoked &&
+			successor.ExpiresAt.After(time.Now()) {
+			if binding.check(r.Context(), log, successor.UserGUID, userIp) == ipbinding.DecisionReject {
+				binding.reject(w, r)
+				return
+			}
+
+			newAccessToken, err := tokens.GenerateAccessToken(successor.UserGUID, userIp, successor.BindKey,
+				binding.clientID,
+				jwtConfig.AccessExpires, keys.SigningKey())
+			if err != nil {
+				log.Error("Failed to generate access token", sl.Err(err))
+				render.Status(r, 500)
+				render.JSON(w, r, resp.Error("Failed to generate new access token"))
+				return
+			}
+
+			log.Info("Refresh retried within grace period",
+				slog.String("user_guid", successor.UserGUID.String()),
+				slog.String("family_id", successor.FamilyID.String()))
+
+			responseOK(w, r, newAccessToken, newRefreshToken)
+			return
+		}
+	}
+
+	log.Warn("Refresh token reuse detected", sl.Event("refresh_token_reuse"),
+		slog.String("user_guid", refreshClaims.UserGUID.String()),
+		slog.String("family_id", refreshClaims.FamilyID.String()),
+		slog.String("ip", userIp))
+
+	err := refreshTokenStorage.RevokeRefreshTokenFamily(r.Context(), refreshClaims.FamilyID)
+	if err != nil {
+		log.Error("Failed to revoke refresh token family", sl.Err(err))
+	}
+
+	render.Status(r, 401)
+	render.JSON(w, r, resp.Error("Refresh token is revoked"))
+}
+
+// failed records an invalid refresh from the client IP and, if the access
+// token is valid, of its refresh token, so guessing either is locked out.
+func failed(ctx context.Context, log *slog.Logger, limiter *ratelimit.Limiter, userIp string, bindKey string) {
+	keys := map[string]string{ratelimit.KindIP: userIp}
+	if bindKey != "" {
+		keys[ratelimit.KindBindKey] = bindKey
+	}
+
+	for kind, key := range keys {
+		lockedUntil, err := limiter.Fail(ctx, kind, key)
 		if err != nil {
-			log.Error("Failed to revoke used refresh token", sl.Err(err))
+			log.Error("Failed to record invalid refresh", sl.Err(err))
+			continue
 		}
 
-		responseOK(w, r, newAccessToken, newRefreshToken)
+		if !lockedUntil.IsZero() {
+			log.Warn("Locked out after invalid refreshes", sl.Event("refresh_lockout"),
+				slog.String("kind", kind),
+				slog.String("ip", userIp),
+				slog.Time("locked_until", lockedUntil))
+		}
 	}
 }
 
+// ipBinding applies the IP binding policy to the refresh of an access token
+// issued to the client at the previous IP.
+type ipBinding struct {
+	policy     ipbinding.Policy
+	clientID   string
+	previousIP string
+}
+
+// check decides on the refresh from the IP and logs the decision as a
+// security event if the IP has changed.
+func (b ipBinding) check(ctx context.Context, log *slog.Logger, userGUID uuid.UUID, userIp string) string {
+	decision := b.policy.Check(b.previousIP, userIp)
+
+	if b.previousIP != userIp {
+		level := slog.LevelInfo
+		if decision == ipbinding.DecisionReject {
+			level = slog.LevelWarn
+		}
+
+		log.Log(ctx, level, "Refresh from another IP", sl.Event("ip_binding"),
+			slog.String("policy", b.policy.Mode),
+			slog.String("decision", decision),
+			slog.String("client_id", b.clientID),
+			slog.String("user_guid", userGUID.String()),
+			slog.String("ip", userIp),
+			slog.String("previous_ip", b.previousIP))
+	}
+
+	return decision
+}
+
+// reject answers a refresh the policy rejects. The tokens are kept, the user
+// still refreshes them from the IP they were issued to.
+func (b ipBinding) reject(w http.ResponseWriter, r *http.Request) {
+	render.Status(r, 401)
+
+	if b.policy.Mode == ipbinding.ModeSameSubnet {
+		render.JSON(w, r, resp.ErrorCode(CodeSubnetMismatch, "Refresh from another subnet is not allowed"))
+	} else {
+		render.JSON(w, r, resp.ErrorCode(CodeIPMismatch, "Refresh from another IP is not allowed"))
+	}
+}
+
+// ipWarning returns the email warning the user about a refresh from a new
+// IP, none if the user has no verified email or opted out of the warnings.
+// The params carry what the templates show and the family the "this wasn't
+// me" link revokes.
+func ipWarning(ctx context.Context, log *slog.Logger, users UserDirectory, userGUID uuid.UUID, familyID uuid.UUID,
+	client database.Client, previousIP string) []database.Email {
+	log = log.With(slog.String("user_guid", userGUID.String()))
+
+	user, err := users.GetUser(ctx, userGUID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		log.Debug("IP warning skipped, user is not in the directory")
+		return nil
+	}
+
+	if err != nil {
+		log.Error("Failed to find user", sl.Err(err))
+		return nil
+	}
+
+	if user.Email == "" || !user.EmailVerified {
+		log.Debug("IP warning skipped, user has no verified email")
+		return nil
+	}
+
+	if !user.NotifyIPChange {
+		log.Debug("IP warning skipped, user opted out")
+		return nil
+	}
+
+	return []database.Email{{
+		Kind: database.EmailIPWarning,
+		To:   user.Email,
+		Params: map[string]string{
+			"ip":          client.IP,
+			"previous_ip": previousIP,
+			"user_agent":  client.UserAgent,
+			"locale":      user.Locale,
+			"user_guid":   userGUID.String(),
+			"family_id":   familyID.String(),
+		},
+	}}
+}
+
 func responseOK(w http.ResponseWriter, r *http.Request, accessToken string, refreshToken string) {
 	render.JSON(w, r, Response{
 		Response:     resp.OK(),
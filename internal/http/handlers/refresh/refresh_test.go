@@ -5,13 +5,16 @@ import (
 	"auth/internal/database"
 	"auth/internal/http/handlers/refresh"
 	"auth/internal/http/handlers/refresh/mocks"
+	"auth/internal/lib/ipbinding"
 	sl "auth/internal/lib/logger/sl/sldiscard"
+	"auth/internal/lib/ratelimit"
 	"auth/internal/lib/tokens"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
-
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -27,16 +30,19 @@ import (
 
 var (
 	jwtCfg = config.JWT{
-		SecretKey:      "secretkey",
-		AccessExpires:  300 * time.Second,
-		RefreshExpires: 3600 * time.Second,
+		SecretKey:          "secretkey",
+		AccessExpires:      300 * time.Second,
+		RefreshExpires:     3600 * time.Second,
+		RefreshTokenPepper: "pepper",
 	}
+	policies              = ipbinding.New(config.IPBinding{})
+	keys, _               = tokens.NewKeySet(tokens.NewHMACKey("", []byte(jwtCfg.SecretKey)))
 	goodGUID, _           = uuid.Parse("d952af16-4251-4ab8-818f-3f3aca064256")
 	goodIP                = "172.0.0.1"
 	anotherIp             = "192.168.0.1"
 	badIP                 = "some string"
-	goodAccessToken, _    = tokens.GenerateAccessToken(goodGUID, goodIP, "bind key", jwtCfg.AccessExpires, jwtCfg.SecretKey)
-	invalidAccessToken, _ = tokens.GenerateAccessToken(goodGUID, goodIP, "bind key", time.Duration(0*time.Second), "some string")
+	goodAccessToken, _    = tokens.GenerateAccessToken(goodGUID, goodIP, "bind key", "", jwtCfg.AccessExpires, keys.SigningKey())
+	invalidAccessToken, _ = tokens.GenerateAccessToken(goodGUID, goodIP, "bind key", "", time.Duration(0*time.Second), tokens.NewHMACKey("", []byte("some string")))
 	badGuidAccessToken, _ = jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
 		"sub":      "bad guid",
 		"ip":       goodIP,
@@ -45,25 +51,57 @@ var (
 	}).SignedString([]byte(jwtCfg.SecretKey))
 	goodRefreshToken       = "RefreshToken"
 	badRefreshToken        = "some string"
-	goodHash, _            = bcrypt.GenerateFromPassword([]byte(goodRefreshToken), 10)
+	goodHash               = tokens.HashRefreshToken(goodRefreshToken, jwtCfg.RefreshTokenPepper)
+	legacyHash, _          = bcrypt.GenerateFromPassword([]byte(goodRefreshToken), 10)
 	goodRefreshTokenClaims = database.RefreshClaims{
-		Hash:      string(goodHash[:]),
+		Hash:      goodHash,
+		ExpiresAt: time.Now().Add(time.Duration(jwtCfg.RefreshExpires)),
+		BindKey:   "bind key",
+		IsRevoked: false,
+	}
+	legacyRefreshTokenClaims = database.RefreshClaims{
+		Hash:      string(legacyHash),
 		ExpiresAt: time.Now().Add(time.Duration(jwtCfg.RefreshExpires)),
 		BindKey:   "bind key",
 		IsRevoked: false,
 	}
+	familyID                  = uuid.New()
 	revokedRefreshTokenClaims = database.RefreshClaims{
-		Hash:      string(goodHash[:]),
+		Hash:      goodHash,
 		ExpiresAt: time.Now().Add(time.Duration(jwtCfg.RefreshExpires)),
 		BindKey:   "bind key",
+		FamilyID:  familyID,
 		IsRevoked: true,
 	}
 	expRefreshTokenClaims = database.RefreshClaims{
-		Hash:      string(goodHash[:]),
+		Hash:      goodHash,
 		ExpiresAt: time.Now(),
 		BindKey:   "bind key",
 		IsRevoked: false,
 	}
+	expRevokedRefreshTokenClaims = database.RefreshClaims{
+		Hash:      goodHash,
+		ExpiresAt: time.Now(),
+		BindKey:   "bind key",
+		IsRevoked: true,
+	}
+	goodUser = database.User{
+		GUID:           goodGUID,
+		Email:          "user@mail",
+		EmailVerified:  true,
+		Locale:         "ru",
+		NotifyIPChange: true,
+	}
+	unverifiedUser = database.User{
+		GUID:           goodGUID,
+		Email:          "user@mail",
+		NotifyIPChange: true,
+	}
+	optedOutUser = database.User{
+		GUID:          goodGUID,
+		Email:         "user@mail",
+		EmailVerified: true,
+	}
 )
 
 func TestRefreshHandler(t *testing.T) {
@@ -77,7 +115,13 @@ func TestRefreshHandler(t *testing.T) {
 		saveError          error
 		revokeError        error
 		getError           error
-		emailError         error
+		familyError        error
+		user               database.User
+		userError          error
+		disabled           bool
+		disabledError      error
+		warned             bool
+		familyMock         bool
 		saveMock           bool
 		revokeMock         bool
 		getMock            bool
@@ -91,6 +135,14 @@ func TestRefreshHandler(t *testing.T) {
 			refreshTokenClaims: goodRefreshTokenClaims,
 			code:               200,
 		},
+		{
+			name:               "Legacy bcrypt refresh token",
+			userIP:             goodIP,
+			accessToken:        goodAccessToken,
+			refreshToken:       goodRefreshToken,
+			refreshTokenClaims: legacyRefreshTokenClaims,
+			code:               200,
+		},
 		{
 			name:         "Empty IP",
 			userIP:       "",
@@ -133,12 +185,49 @@ func TestRefreshHandler(t *testing.T) {
 			code:         401,
 		},
 		{
-			name:               "Failed to send IP warning",
+			name:               "IP warning queued",
 			userIP:             anotherIp,
 			accessToken:        goodAccessToken,
 			refreshToken:       goodRefreshToken,
 			refreshTokenClaims: goodRefreshTokenClaims,
-			emailError:         errors.New("some error"),
+			user:               goodUser,
+			warned:             true,
+			code:               200,
+		},
+		{
+			name:               "IP warning to user without verified email",
+			userIP:             anotherIp,
+			accessToken:        goodAccessToken,
+			refreshToken:       goodRefreshToken,
+			refreshTokenClaims: goodRefreshTokenClaims,
+			user:               unverifiedUser,
+			code:               200,
+		},
+		{
+			name:               "IP warning to user who opted out",
+			userIP:             anotherIp,
+			accessToken:        goodAccessToken,
+			refreshToken:       goodRefreshToken,
+			refreshTokenClaims: goodRefreshTokenClaims,
+			user:               optedOutUser,
+			code:               200,
+		},
+		{
+			name:               "IP warning to user not in directory",
+			userIP:             anotherIp,
+			accessToken:        goodAccessToken,
+			refreshToken:       goodRefreshToken,
+			refreshTokenClaims: goodRefreshTokenClaims,
+			userError:          database.ErrUserNotFound,
+			code:               200,
+		},
+		{
+			name:               "Failed to find user",
+			userIP:             anotherIp,
+			accessToken:        goodAccessToken,
+			refreshToken:       goodRefreshToken,
+			refreshTokenClaims: goodRefreshTokenClaims,
+			userError:          errors.New("some error"),
 			code:               200,
 		},
 		{
@@ -185,6 +274,28 @@ func TestRefreshHandler(t *testing.T) {
 			refreshTokenClaims: revokedRefreshTokenClaims,
 			respError:          "Refresh token is revoked",
 			getMock:            true,
+			familyMock:         true,
+			code:               401,
+		},
+		{
+			name:               "Failed to revoke refresh token family",
+			userIP:             goodIP,
+			accessToken:        goodAccessToken,
+			refreshToken:       goodRefreshToken,
+			refreshTokenClaims: revokedRefreshTokenClaims,
+			familyError:        errors.New("some error"),
+			respError:          "Refresh token is revoked",
+			getMock:            true,
+			code:               401,
+		},
+		{
+			name:               "Refresh token has expired and is revoked",
+			userIP:             goodIP,
+			accessToken:        goodAccessToken,
+			refreshToken:       goodRefreshToken,
+			refreshTokenClaims: expRevokedRefreshTokenClaims,
+			respError:          "Refresh token has expired",
+			getMock:            true,
 			code:               401,
 		},
 		{
@@ -221,43 +332,96 @@ func TestRefreshHandler(t *testing.T) {
 			code:               500,
 		},
 		{
-			name:               "Failed to revoke used refresh token",
+			name:               "User is disabled",
 			userIP:             goodIP,
 			accessToken:        goodAccessToken,
 			refreshToken:       goodRefreshToken,
 			refreshTokenClaims: goodRefreshTokenClaims,
-			revokeError:        errors.New("some error"),
+			disabled:           true,
+			respError:          "User is disabled",
 			getMock:            true,
-			saveMock:           true,
-			code:               200,
+			code:               403,
+		},
+		{
+			name:               "Failed to check user",
+			userIP:             goodIP,
+			accessToken:        goodAccessToken,
+			refreshToken:       goodRefreshToken,
+			refreshTokenClaims: goodRefreshTokenClaims,
+			disabledError:      errors.New("some error"),
+			respError:          "Unable to check user",
+			getMock:            true,
+			code:               500,
+		},
+		{
+			name:               "Saving new refresh token timed out",
+			userIP:             goodIP,
+			accessToken:        goodAccessToken,
+			refreshToken:       goodRefreshToken,
+			refreshTokenClaims: goodRefreshTokenClaims,
+			respError:          "Failed to save new refresh token",
+			saveError:          fmt.Errorf("some error: %w", context.DeadlineExceeded),
+			getMock:            true,
+			code:               504,
 		},
 	}
 
 	for _, tc := range cases {
 		RefreshTokenStorageMock := mocks.NewRefreshTokenStorage(t)
 
+		var emails []database.Email
+		if tc.warned {
+			emails = []database.Email{{
+				Kind: database.EmailIPWarning,
+				To:   goodUser.Email,
+				Params: map[string]string{
+					"ip":          tc.userIP,
+					"previous_ip": goodIP,
+					"user_agent":  "test agent",
+					"locale":      goodUser.Locale,
+					"user_guid":   goodGUID.String(),
+					"family_id":   tc.refreshTokenClaims.FamilyID.String(),
+				},
+			}}
+		}
+
 		if tc.respError == "" || tc.saveError != nil || tc.saveMock {
-			RefreshTokenStorageMock.On("SaveRefreshToken", mock.AnythingOfType("uuid.UUID"), mock.AnythingOfType("string"), jwtCfg).
-				Return(string("bind key"), tc.saveError).
+			RefreshTokenStorageMock.On("RotateRefreshToken", mock.Anything, "bind key", mock.AnythingOfType("string"),
+				mock.AnythingOfType("database.Client"), jwtCfg, emails).
+				Return(string("new bind key"), tc.saveError).
 				Once()
 		}
 
-		if tc.respError == "" || tc.revokeError != nil || tc.revokeMock {
-			RefreshTokenStorageMock.On("RevokeRefreshToken", mock.AnythingOfType("string")).
+		if tc.revokeError != nil || tc.revokeMock {
+			RefreshTokenStorageMock.On("RevokeRefreshToken", mock.Anything, mock.AnythingOfType("string")).
 				Return(tc.revokeError).
 				Once()
 		}
 
+		if tc.familyError != nil || tc.familyMock {
+			RefreshTokenStorageMock.On("RevokeRefreshTokenFamily", mock.Anything, familyID).
+				Return(tc.familyError).
+				Once()
+		}
+
 		if tc.respError == "" || tc.getError != nil || tc.getMock {
-			RefreshTokenStorageMock.On("GetRefreshToken", mock.AnythingOfType("string")).
+			RefreshTokenStorageMock.On("GetRefreshToken", mock.Anything, mock.AnythingOfType("string")).
 				Return(tc.refreshTokenClaims, tc.getError).
 				Once()
 		}
 
-		EmailSenderMock := mocks.NewEmailSender(t)
-		if tc.emailError != nil {
-			EmailSenderMock.On("SendIpWarnig", mock.AnythingOfType("string"), tc.userIP).
-				Return(tc.emailError).
+		UserDirectoryMock := mocks.NewUserDirectory(t)
+		if tc.user.GUID != uuid.Nil || tc.userError != nil {
+			UserDirectoryMock.On("GetUser", mock.Anything, goodGUID).
+				Return(tc.user, tc.userError).
+				Once()
+		}
+
+		// Users are checked once the refresh token is valid.
+		validated := (tc.respError == "" || tc.getMock) && tc.getError == nil && tc.refreshToken == goodRefreshToken
+		if validated {
+			UserDirectoryMock.On("IsUserDisabled", mock.Anything, goodGUID).
+				Return(tc.disabled, tc.disabledError).
 				Once()
 		}
 
@@ -265,6 +429,7 @@ func TestRefreshHandler(t *testing.T) {
 
 		req, err := http.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte(reqBody)))
 		require.NoError(t, err)
+		req.Header.Set("User-Agent", "test agent")
 
 		if tc.userIP == "" || tc.userIP == badIP {
 			req.RemoteAddr = tc.userIP
@@ -274,7 +439,7 @@ func TestRefreshHandler(t *testing.T) {
 
 		rr := httptest.NewRecorder()
 
-		handler := refresh.New(sl.NewDiscardLogger(), RefreshTokenStorageMock, EmailSenderMock, jwtCfg)
+		handler := refresh.New(sl.NewDiscardLogger(), RefreshTokenStorageMock, UserDirectoryMock, keys, policies, nil, jwtCfg)
 		router := chi.NewRouter()
 		router.Post("/", handler)
 
@@ -291,3 +456,430 @@ func TestRefreshHandler(t *testing.T) {
 		require.Equal(t, tc.respError, resp.Error, "Case: %s", tc.name)
 	}
 }
+
+func TestRefreshGracePeriod(t *testing.T) {
+	graceCfg := jwtCfg
+	graceCfg.RefreshGracePeriod = 10 * time.Second
+
+	derivedRefreshToken := tokens.DeriveRefreshToken(goodRefreshToken, graceCfg.RefreshTokenPepper)
+	derivedHash := tokens.HashRefreshToken(derivedRefreshToken, graceCfg.RefreshTokenPepper)
+
+	rotatedClaims := revokedRefreshTokenClaims
+	rotatedClaims.RotatedAt = time.Now()
+
+	staleClaims := revokedRefreshTokenClaims
+	staleClaims.RotatedAt = time.Now().Add(-time.Minute)
+
+	successorClaims := database.RefreshClaims{
+		Hash:          derivedHash,
+		ExpiresAt:     time.Now().Add(graceCfg.RefreshExpires),
+		UserGUID:      goodGUID,
+		BindKey:       "new bind key",
+		ParentBindKey: "bind key",
+		FamilyID:      familyID,
+	}
+
+	usedSuccessorClaims := successorClaims
+	usedSuccessorClaims.IsRevoked = true
+
+	cases := []struct {
+		name            string
+		cfg             config.JWT
+		claims          []database.RefreshClaims
+		rotateError     error
+		successor       *database.RefreshClaims
+		successorError  error
+		familyMock      bool
+		respError       string
+		newRefreshToken string
+		code            int
+	}{
+		{
+			name:            "Successor is derived from the used token",
+			cfg:             graceCfg,
+			claims:          []database.RefreshClaims{goodRefreshTokenClaims},
+			newRefreshToken: derivedRefreshToken,
+			code:            200,
+		},
+		{
+			name:            "Retry within grace period",
+			cfg:             graceCfg,
+			claims:          []database.RefreshClaims{rotatedClaims},
+			successor:       &successorClaims,
+			newRefreshToken: derivedRefreshToken,
+			code:            200,
+		},
+		{
+			name:            "Concurrent refresh within grace period",
+			cfg:             graceCfg,
+			claims:          []database.RefreshClaims{goodRefreshTokenClaims, rotatedClaims},
+			rotateError:     database.ErrTokenRevoked,
+			successor:       &successorClaims,
+			newRefreshToken: derivedRefreshToken,
+			code:            200,
+		},
+		{
+			name:       "Retry after grace period",
+			cfg:        graceCfg,
+			claims:     []database.RefreshClaims{staleClaims},
+			familyMock: true,
+			respError:  "Refresh token is revoked",
+			code:       401,
+		},
+		{
+			name:       "Successor is already used",
+			cfg:        graceCfg,
+			claims:     []database.RefreshClaims{rotatedClaims},
+			successor:  &usedSuccessorClaims,
+			familyMock: true,
+			respError:  "Refresh token is revoked",
+			code:       401,
+		},
+		{
+			name:           "Successor is missing",
+			cfg:            graceCfg,
+			claims:         []database.RefreshClaims{rotatedClaims},
+			successorError: database.ErrTokenNotFound,
+			familyMock:     true,
+			respError:      "Refresh token is revoked",
+			code:           401,
+		},
+		{
+			name:           "Failed to find successor",
+			cfg:            graceCfg,
+			claims:         []database.RefreshClaims{rotatedClaims},
+			successorError: errors.New("some error"),
+			respError:      "Unable to find refresh token",
+			code:           500,
+		},
+		{
+			name:        "Concurrent refresh without grace period",
+			cfg:         jwtCfg,
+			claims:      []database.RefreshClaims{goodRefreshTokenClaims, rotatedClaims},
+			rotateError: database.ErrTokenRevoked,
+			familyMock:  true,
+			respError:   "Refresh token is revoked",
+			code:        401,
+		},
+	}
+
+	for _, tc := range cases {
+		RefreshTokenStorageMock := mocks.NewRefreshTokenStorage(t)
+
+		for _, claims := range tc.claims {
+			RefreshTokenStorageMock.On("GetRefreshToken", mock.Anything, "bind key").
+				Return(claims, nil).
+				Once()
+		}
+
+		if !tc.claims[0].IsRevoked {
+			RefreshTokenStorageMock.On("RotateRefreshToken", mock.Anything, "bind key", mock.AnythingOfType("string"),
+				mock.AnythingOfType("database.Client"), tc.cfg, []database.Email(nil)).
+				Return(string("new bind key"), tc.rotateError).
+				Once()
+		}
+
+		if tc.successor != nil || tc.successorError != nil {
+			successor := database.RefreshClaims{}
+			if tc.successor != nil {
+				successor = *tc.successor
+			}
+
+			RefreshTokenStorageMock.On("GetRefreshTokenByHash", mock.Anything, derivedHash).
+				Return(successor, tc.successorError).
+				Once()
+		}
+
+		if tc.familyMock {
+			RefreshTokenStorageMock.On("RevokeRefreshTokenFamily", mock.Anything, familyID).
+				Return(nil).
+				Once()
+		}
+
+		UserDirectoryMock := mocks.NewUserDirectory(t)
+		UserDirectoryMock.On("IsUserDisabled", mock.Anything, goodGUID).
+			Return(false, nil).
+			Once()
+
+		reqBody := fmt.Sprintf(`{"access_token": "%s", "refresh_token": "%s"}`, goodAccessToken, goodRefreshToken)
+
+		req, err := http.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte(reqBody)))
+		require.NoError(t, err)
+
+		req.RemoteAddr = goodIP + ":8080"
+
+		rr := httptest.NewRecorder()
+
+		handler := refresh.New(sl.NewDiscardLogger(), RefreshTokenStorageMock, UserDirectoryMock, keys, policies, nil, tc.cfg)
+		router := chi.NewRouter()
+		router.Post("/", handler)
+
+		router.ServeHTTP(rr, req)
+
+		require.Equal(t, tc.code, rr.Code, "Case: %s", tc.name)
+
+		var resp refresh.Response
+
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+		require.Equal(t, tc.respError, resp.Error, "Case: %s", tc.name)
+		require.Equal(t, tc.newRefreshToken, resp.RefreshToken, "Case: %s", tc.name)
+	}
+}
+
+func TestIPBinding(t *testing.T) {
+	bindingCfg := config.IPBinding{
+		Policy:     ipbinding.ModeReject,
+		IPv4Prefix: 24,
+		IPv6Prefix: 64,
+		Clients: []config.IPBindingClient{
+			{ID: "mobile", Policy: ipbinding.ModeSameSubnet},
+			{ID: "desktop", Policy: ipbinding.ModeIgnore},
+			{ID: "web", Policy: ipbinding.ModeWarn},
+		},
+	}
+	bindingPolicies := ipbinding.New(bindingCfg)
+
+	graceCfg := jwtCfg
+	graceCfg.RefreshGracePeriod = 10 * time.Second
+
+	derivedRefreshToken := tokens.DeriveRefreshToken(goodRefreshToken, graceCfg.RefreshTokenPepper)
+
+	rotatedClaims := revokedRefreshTokenClaims
+	rotatedClaims.RotatedAt = time.Now()
+
+	successorClaims := database.RefreshClaims{
+		Hash:          tokens.HashRefreshToken(derivedRefreshToken, graceCfg.RefreshTokenPepper),
+		ExpiresAt:     time.Now().Add(graceCfg.RefreshExpires),
+		UserGUID:      goodGUID,
+		BindKey:       "new bind key",
+		ParentBindKey: "bind key",
+		FamilyID:      familyID,
+	}
+
+	cases := []struct {
+		name      string
+		clientID  string
+		userIP    string
+		claims    database.RefreshClaims
+		successor bool
+		warned    bool
+		respError string
+		respCode  string
+		code      int
+	}{
+		{
+			name:   "Same IP",
+			userIP: goodIP,
+			claims: goodRefreshTokenClaims,
+			code:   200,
+		},
+		{
+			name:      "Rejected by default policy",
+			userIP:    anotherIp,
+			claims:    goodRefreshTokenClaims,
+			respError: "Refresh from another IP is not allowed",
+			respCode:  refresh.CodeIPMismatch,
+			code:      401,
+		},
+		{
+			name:     "Same subnet",
+			clientID: "mobile",
+			userIP:   "172.0.0.200",
+			claims:   goodRefreshTokenClaims,
+			code:     200,
+		},
+		{
+			name:      "Another subnet",
+			clientID:  "mobile",
+			userIP:    "172.0.1.1",
+			claims:    goodRefreshTokenClaims,
+			respError: "Refresh from another subnet is not allowed",
+			respCode:  refresh.CodeSubnetMismatch,
+			code:      401,
+		},
+		{
+			name:      "Another family",
+			clientID:  "mobile",
+			userIP:    "2001:db8::1",
+			claims:    goodRefreshTokenClaims,
+			respError: "Refresh from another subnet is not allowed",
+			respCode:  refresh.CodeSubnetMismatch,
+			code:      401,
+		},
+		{
+			name:     "Ignored",
+			clientID: "desktop",
+			userIP:   anotherIp,
+			claims:   goodRefreshTokenClaims,
+			code:     200,
+		},
+		{
+			name:     "Warned",
+			clientID: "web",
+			userIP:   anotherIp,
+			claims:   goodRefreshTokenClaims,
+			warned:   true,
+			code:     200,
+		},
+		{
+			name:      "Rejected retry within grace period",
+			userIP:    anotherIp,
+			claims:    rotatedClaims,
+			successor: true,
+			respError: "Refresh from another IP is not allowed",
+			respCode:  refresh.CodeIPMismatch,
+			code:      401,
+		},
+	}
+
+	for _, tc := range cases {
+		RefreshTokenStorageMock := mocks.NewRefreshTokenStorage(t)
+
+		RefreshTokenStorageMock.On("GetRefreshToken", mock.Anything, "bind key").
+			Return(tc.claims, nil).
+			Once()
+
+		if tc.successor {
+			RefreshTokenStorageMock.On("GetRefreshTokenByHash", mock.Anything, successorClaims.Hash).
+				Return(successorClaims, nil).
+				Once()
+		}
+
+		UserDirectoryMock := mocks.NewUserDirectory(t)
+		UserDirectoryMock.On("IsUserDisabled", mock.Anything, goodGUID).
+			Return(false, nil).
+			Once()
+
+		if tc.warned {
+			UserDirectoryMock.On("GetUser", mock.Anything, goodGUID).
+				Return(optedOutUser, nil).
+				Once()
+		}
+
+		if tc.code == 200 {
+			RefreshTokenStorageMock.On("RotateRefreshToken", mock.Anything, "bind key", mock.AnythingOfType("string"),
+				mock.AnythingOfType("database.Client"), graceCfg, []database.Email(nil)).
+				Return(string("new bind key"), nil).
+				Once()
+		}
+
+		accessToken, err := tokens.GenerateAccessToken(goodGUID, goodIP, "bind key", tc.clientID,
+			jwtCfg.AccessExpires, keys.SigningKey())
+		require.NoError(t, err)
+
+		reqBody := fmt.Sprintf(`{"access_token": "%s", "refresh_token": "%s"}`, accessToken, goodRefreshToken)
+
+		req, err := http.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte(reqBody)))
+		require.NoError(t, err)
+
+		req.RemoteAddr = net.JoinHostPort(tc.userIP, "8080")
+
+		rr := httptest.NewRecorder()
+
+		handler := refresh.New(sl.NewDiscardLogger(), RefreshTokenStorageMock, UserDirectoryMock, keys,
+			bindingPolicies, nil, graceCfg)
+		router := chi.NewRouter()
+		router.Post("/", handler)
+
+		router.ServeHTTP(rr, req)
+
+		require.Equal(t, tc.code, rr.Code, "Case: %s", tc.name)
+
+		var resp refresh.Response
+
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+		require.Equal(t, tc.respError, resp.Error, "Case: %s", tc.name)
+		require.Equal(t, tc.respCode, resp.Code, "Case: %s", tc.name)
+
+		if tc.code == 200 {
+			claims, err := tokens.ValidateAccessToken(resp.AccessToken, keys)
+			require.NoError(t, err)
+
+			clientID, _ := claims["client_id"].(string)
+			require.Equal(t, tc.clientID, clientID, "Client is kept, case: %s", tc.name)
+		}
+	}
+}
+
+func TestLockout(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.NewMemoryStore(), config.RateLimit{
+		BindKey: config.Limit{Requests: 100, Period: time.Minute},
+		Lockout: config.Lockout{
+			MaxFailures: 2,
+			Window:      time.Hour,
+			Duration:    time.Hour,
+		},
+	})
+
+	RefreshTokenStorageMock := mocks.NewRefreshTokenStorage(t)
+	RefreshTokenStorageMock.On("GetRefreshToken", mock.Anything, "bind key").
+		Return(goodRefreshTokenClaims, nil).
+		Times(3)
+
+	handler := refresh.New(sl.NewDiscardLogger(), RefreshTokenStorageMock, mocks.NewUserDirectory(t), keys,
+		policies, limiter, jwtCfg)
+	router := chi.NewRouter()
+	router.Post("/", handler)
+
+	cases := []struct {
+		name         string
+		refreshToken string
+		respError    string
+		respCode     string
+		code         int
+	}{
+		{
+			name:         "First invalid refresh",
+			refreshToken: badRefreshToken,
+			respError:    "Invalid refresh token",
+			code:         401,
+		},
+		{
+			name:         "Second invalid refresh",
+			refreshToken: badRefreshToken,
+			respError:    "Invalid refresh token",
+			code:         401,
+		},
+		{
+			name:         "Third invalid refresh locks out",
+			refreshToken: badRefreshToken,
+			respError:    "Invalid refresh token",
+			code:         401,
+		},
+		{
+			name:         "Valid refresh is locked out",
+			refreshToken: goodRefreshToken,
+			respError:    "Too many invalid attempts, try again later",
+			respCode:     ratelimit.CodeLockedOut,
+			code:         429,
+		},
+	}
+
+	for _, tc := range cases {
+		reqBody := fmt.Sprintf(`{"access_token": "%s", "refresh_token": "%s"}`, goodAccessToken, tc.refreshToken)
+
+		req, err := http.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte(reqBody)))
+		require.NoError(t, err)
+
+		req.RemoteAddr = net.JoinHostPort(goodIP, "8080")
+
+		rr := httptest.NewRecorder()
+
+		router.ServeHTTP(rr, req)
+
+		require.Equal(t, tc.code, rr.Code, "Case: %s", tc.name)
+
+		var resp refresh.Response
+
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+		require.Equal(t, tc.respError, resp.Error, "Case: %s", tc.name)
+		require.Equal(t, tc.respCode, resp.Code, "Case: %s", tc.name)
+
+		if tc.code == 429 {
+			require.NotEmpty(t, rr.Header().Get("Retry-After"), "Case: %s", tc.name)
+		}
+	}
+}
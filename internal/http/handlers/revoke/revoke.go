@@ -0,0 +1,153 @@
+package revoke
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/go-chi/render"
+
+	"auth/internal/config"
+	"auth/internal/database"
+	resp "auth/internal/lib/api/response"
+	"auth/internal/lib/logger/sl"
+	"auth/internal/lib/tokens"
+)
+
+// Token type hints and error codes defined by RFC 7009.
+const (
+	HintAccessToken  = "access_token"
+	HintRefreshToken = "refresh_token"
+
+	ErrInvalidRequest = "invalid_request"
+)
+
+//go:generate go run github.com/vektra/mockery/v3 --name=RefreshTokenStorage
+type RefreshTokenStorage interface {
+	GetRefreshToken(ctx context.Context, bindKey string) (database.RefreshClaims, error)
+	GetRefreshTokenByHash(ctx context.Context, hash string) (database.RefreshClaims, error)
+	RevokeRefreshToken(ctx context.Context, bindKey string) error
+}
+
+// New revokes a token as described in RFC 7009. The token is sent as the
+// "token" form parameter. Revoking an access token ends the session it is
+// bound to. A refresh token is found by its hash, or, if it was issued before
+// keyed hashing, through the access token it was issued with, sent as the
+// "access_token" form parameter.
+//
+// Unknown, invalid and already revoked tokens are not an error, so the
+// endpoint answers 200 unless the request is malformed or storage fails.
+func New(log *slog.Logger, refreshTokenStorage RefreshTokenStorage, keys *tokens.KeySet,
+	jwtConfig config.JWT) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.auth.revoke.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		err := r.ParseForm()
+		if err != nil {
+			log.Error("Failed to parse request form", sl.Err(err))
+			render.Status(r, 400)
+			render.JSON(w, r, resp.Error(ErrInvalidRequest))
+			return
+		}
+
+		token := r.PostForm.Get("token")
+		if token == "" {
+			log.Error("Token is empty")
+			render.Status(r, 400)
+			render.JSON(w, r, resp.Error(ErrInvalidRequest))
+			return
+		}
+
+		var bindKey string
+
+		hint := r.PostForm.Get("token_type_hint")
+		if hint != HintRefreshToken {
+			bindKey = accessBindKey(token, keys)
+		}
+
+		if bindKey == "" && hint != HintAccessToken {
+			bindKey, err = refreshBindKey(r.Context(), token, r.PostForm.Get("access_token"),
+				refreshTokenStorage, keys, jwtConfig)
+			if err != nil {
+				log.Error("Failed to find refresh token", sl.Err(err))
+				render.Status(r, resp.StorageStatus(err, 503))
+				render.JSON(w, r, resp.Error("Unable to revoke token"))
+				return
+			}
+		}
+
+		if bindKey == "" {
+			log.Info("Token is invalid or unknown, nothing to revoke")
+			render.JSON(w, r, resp.OK())
+			return
+		}
+
+		err = refreshTokenStorage.RevokeRefreshToken(r.Context(), bindKey)
+		if err != nil && !errors.Is(err, database.ErrTokenNotFound) {
+			log.Error("Failed to revoke refresh token", sl.Err(err))
+			render.Status(r, resp.StorageStatus(err, 503))
+			render.JSON(w, r, resp.Error("Unable to revoke token"))
+			return
+		}
+
+		log.Info("Token revoked")
+
+		render.JSON(w, r, resp.OK())
+	}
+}
+
+// accessBindKey returns the bind key of a correctly signed, possibly
+// expired access token, or an empty string.
+func accessBindKey(accessToken string, keys *tokens.KeySet) string {
+	claims, err := tokens.ValidateAccessToken(accessToken, keys)
+	if err != nil && !errors.Is(err, tokens.ErrAccessTokenExpired) {
+		return ""
+	}
+
+	bindKey, _ := claims["bind_key"].(string)
+
+	return bindKey
+}
+
+// refreshBindKey returns the bind key of the refresh token, or an empty
+// string if it is unknown. Refresh tokens with legacy bcrypt hashes are
+// only found if they match the session of the access token.
+func refreshBindKey(ctx context.Context, refreshToken string, accessToken string,
+	refreshTokenStorage RefreshTokenStorage, keys *tokens.KeySet, jwtConfig config.JWT) (string, error) {
+	refreshClaims, err := refreshTokenStorage.GetRefreshTokenByHash(ctx,
+		tokens.HashRefreshToken(refreshToken, jwtConfig.RefreshTokenPepper))
+	if err == nil {
+		return refreshClaims.BindKey, nil
+	}
+
+	if !errors.Is(err, database.ErrTokenNotFound) {
+		return "", err
+	}
+
+	bindKey := accessBindKey(accessToken, keys)
+	if bindKey == "" {
+		return "", nil
+	}
+
+	refreshClaims, err = refreshTokenStorage.GetRefreshToken(ctx, bindKey)
+	if errors.Is(err, database.ErrTokenNotFound) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if tokens.ValidateRefreshToken(refreshToken, refreshClaims.Hash, jwtConfig.RefreshTokenPepper) != nil {
+		return "", nil
+	}
+
+	return bindKey, nil
+}
@@ -0,0 +1,212 @@
+package revoke_test
+
+import (
+	"auth/internal/config"
+	"auth/internal/database"
+	"auth/internal/http/handlers/revoke"
+	"auth/internal/http/handlers/revoke/mocks"
+	sl "auth/internal/lib/logger/sl/sldiscard"
+	"auth/internal/lib/tokens"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	keys, _               = tokens.NewKeySet(tokens.NewHMACKey("", []byte("secretkey")))
+	goodGUID              = uuid.New()
+	goodAccessToken, _    = tokens.GenerateAccessToken(goodGUID, "172.0.0.1", "bind key", "", time.Minute, keys.SigningKey())
+	expAccessToken, _     = tokens.GenerateAccessToken(goodGUID, "172.0.0.1", "bind key", "", -time.Minute, keys.SigningKey())
+	invalidAccessToken, _ = tokens.GenerateAccessToken(goodGUID, "172.0.0.1", "bind key", "", time.Minute,
+		tokens.NewHMACKey("", []byte("some string")))
+	jwtCfg             = config.JWT{RefreshTokenPepper: "pepper"}
+	goodRefreshToken   = "RefreshToken"
+	goodHash           = tokens.HashRefreshToken(goodRefreshToken, jwtCfg.RefreshTokenPepper)
+	legacyHash, _      = bcrypt.GenerateFromPassword([]byte(goodRefreshToken), 10)
+	refreshTokenClaims = database.RefreshClaims{
+		UserGUID:  goodGUID,
+		BindKey:   "bind key",
+		Hash:      goodHash,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	legacyRefreshTokenClaims = database.RefreshClaims{
+		UserGUID:  goodGUID,
+		BindKey:   "bind key",
+		Hash:      string(legacyHash),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+)
+
+func TestRevokeHandler(t *testing.T) {
+	cases := []struct {
+		name        string
+		form        url.Values
+		respError   string
+		hashError   error
+		getClaims   database.RefreshClaims
+		getError    error
+		revokeError error
+		hashMock    bool
+		getMock     bool
+		revokeMock  bool
+		code        int
+	}{
+		{
+			name:      "Empty token",
+			form:      url.Values{"token_type_hint": {"access_token"}},
+			respError: revoke.ErrInvalidRequest,
+			code:      400,
+		},
+		{
+			name:       "Access token",
+			form:       url.Values{"token": {goodAccessToken}},
+			revokeMock: true,
+			code:       200,
+		},
+		{
+			name:       "Expired access token",
+			form:       url.Values{"token": {expAccessToken}, "token_type_hint": {"access_token"}},
+			revokeMock: true,
+			code:       200,
+		},
+		{
+			name: "Invalid access token",
+			form: url.Values{"token": {invalidAccessToken}, "token_type_hint": {"access_token"}},
+			code: 200,
+		},
+		{
+			name:       "Refresh token",
+			form:       url.Values{"token": {goodRefreshToken}},
+			hashMock:   true,
+			revokeMock: true,
+			code:       200,
+		},
+		{
+			name:       "Legacy refresh token",
+			form:       url.Values{"token": {goodRefreshToken}, "access_token": {goodAccessToken}},
+			hashError:  database.ErrTokenNotFound,
+			hashMock:   true,
+			getClaims:  legacyRefreshTokenClaims,
+			getMock:    true,
+			revokeMock: true,
+			code:       200,
+		},
+		{
+			name:      "Legacy refresh token without access token",
+			form:      url.Values{"token": {goodRefreshToken}, "token_type_hint": {"refresh_token"}},
+			hashError: database.ErrTokenNotFound,
+			hashMock:  true,
+			code:      200,
+		},
+		{
+			name:      "Invalid refresh token",
+			form:      url.Values{"token": {"some string"}, "access_token": {goodAccessToken}},
+			hashError: database.ErrTokenNotFound,
+			hashMock:  true,
+			getClaims: refreshTokenClaims,
+			getMock:   true,
+			code:      200,
+		},
+		{
+			name:      "Refresh token does not exist",
+			form:      url.Values{"token": {goodRefreshToken}, "access_token": {expAccessToken}},
+			hashError: database.ErrTokenNotFound,
+			hashMock:  true,
+			getError:  database.ErrTokenNotFound,
+			getMock:   true,
+			code:      200,
+		},
+		{
+			name:      "Failed to find refresh token",
+			form:      url.Values{"token": {goodRefreshToken}},
+			hashError: errors.New("some error"),
+			hashMock:  true,
+			respError: "Unable to revoke token",
+			code:      503,
+		},
+		{
+			name:      "Finding refresh token timed out",
+			form:      url.Values{"token": {goodRefreshToken}},
+			hashError: fmt.Errorf("some error: %w", context.DeadlineExceeded),
+			hashMock:  true,
+			respError: "Unable to revoke token",
+			code:      504,
+		},
+		{
+			name:      "Failed to find legacy refresh token",
+			form:      url.Values{"token": {goodRefreshToken}, "access_token": {goodAccessToken}},
+			hashError: database.ErrTokenNotFound,
+			hashMock:  true,
+			getError:  errors.New("some error"),
+			getMock:   true,
+			respError: "Unable to revoke token",
+			code:      503,
+		},
+		{
+			name:        "Failed to revoke refresh token",
+			form:        url.Values{"token": {goodAccessToken}},
+			revokeError: errors.New("some error"),
+			revokeMock:  true,
+			respError:   "Unable to revoke token",
+			code:        503,
+		},
+	}
+
+	for _, tc := range cases {
+		RefreshTokenStorageMock := mocks.NewRefreshTokenStorage(t)
+
+		if tc.hashMock {
+			hash := tokens.HashRefreshToken(tc.form.Get("token"), jwtCfg.RefreshTokenPepper)
+			RefreshTokenStorageMock.On("GetRefreshTokenByHash", mock.Anything, hash).
+				Return(refreshTokenClaims, tc.hashError).
+				Once()
+		}
+
+		if tc.getMock {
+			RefreshTokenStorageMock.On("GetRefreshToken", mock.Anything, "bind key").
+				Return(tc.getClaims, tc.getError).
+				Once()
+		}
+
+		if tc.revokeMock {
+			RefreshTokenStorageMock.On("RevokeRefreshToken", mock.Anything, "bind key").
+				Return(tc.revokeError).
+				Once()
+		}
+
+		req, err := http.NewRequest(http.MethodPost, "/revoke", strings.NewReader(tc.form.Encode()))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := revoke.New(sl.NewDiscardLogger(), RefreshTokenStorageMock, keys, jwtCfg)
+		router := chi.NewRouter()
+		router.Post("/revoke", handler)
+
+		router.ServeHTTP(rr, req)
+
+		require.Equal(t, tc.code, rr.Code, "Case: %s", tc.name)
+
+		var resp struct {
+			Error string `json:"error"`
+		}
+
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+		require.Equal(t, tc.respError, resp.Error, "Case: %s", tc.name)
+	}
+}
@@ -0,0 +1,150 @@
+package revokelink
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+
+	"auth/internal/lib/logger/sl"
+	"auth/internal/lib/tokens"
+)
+
+//go:embed page.html.tmpl
+var pageSource string
+
+var page = template.Must(template.New("page").Parse(pageSource))
+
+//go:generate go run github.com/vektra/mockery/v3 --name=RefreshTokenStorage
+type RefreshTokenStorage interface {
+	RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error
+}
+
+type pageData struct {
+	Title   string
+	Message string
+	// Token is set on the confirmation page, which posts it back.
+	Token string
+}
+
+// NewForm serves the "this wasn't me" link of the IP warning email. It only
+// asks to confirm, so mail scanners following links revoke nothing.
+func NewForm(log *slog.Logger, linkSecret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.auth.revokelink.NewForm"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		token := r.URL.Query().Get("token")
+
+		if _, _, err := verify(linkSecret, token); err != nil {
+			log.Info("Invalid revoke link", sl.Err(err))
+			invalid(w, r, err)
+			return
+		}
+
+		html(w, r, 200, pageData{
+			Title:   "Sign out everywhere",
+			Message: "The sign-in from the email will be ended along with every session it started.",
+			Token:   token,
+		})
+	}
+}
+
+// NewConfirm revokes the token family of the link posted from the form.
+func NewConfirm(log *slog.Logger, refreshTokenStorage RefreshTokenStorage, linkSecret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.auth.revokelink.NewConfirm"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		err := r.ParseForm()
+		if err != nil {
+			log.Error("Failed to parse request form", sl.Err(err))
+			invalid(w, r, tokens.ErrInvalidLink)
+			return
+		}
+
+		familyID, userGUID, err := verify(linkSecret, r.PostForm.Get("token"))
+		if err != nil {
+			log.Info("Invalid revoke link", sl.Err(err))
+			invalid(w, r, err)
+			return
+		}
+
+		err = refreshTokenStorage.RevokeRefreshTokenFamily(r.Context(), familyID)
+		if err != nil {
+			log.Error("Failed to revoke refresh token family", sl.Err(err))
+			html(w, r, 503, pageData{
+				Title:   "Something went wrong",
+				Message: "The sessions could not be ended. Please try again later.",
+			})
+			return
+		}
+
+		log.Warn("Refresh token family revoked by email link", sl.Event("revoked_by_email_link"),
+			slog.String("user_guid", userGUID),
+			slog.String("family_id", familyID.String()))
+
+		html(w, r, 200, pageData{
+			Title:   "Signed out",
+			Message: "The sessions were ended. Sign in again and change your password if you use one.",
+		})
+	}
+}
+
+// verify returns the family and the user the link was signed for.
+func verify(linkSecret string, token string) (uuid.UUID, string, error) {
+	if linkSecret == "" || token == "" {
+		return uuid.Nil, "", tokens.ErrInvalidLink
+	}
+
+	values, err := tokens.VerifyLink(linkSecret, tokens.LinkRevokeFamily, token)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+
+	if len(values) != 2 {
+		return uuid.Nil, "", tokens.ErrInvalidLink
+	}
+
+	familyID, err := uuid.Parse(values[0])
+	if err != nil {
+		return uuid.Nil, "", tokens.ErrInvalidLink
+	}
+
+	return familyID, values[1], nil
+}
+
+func invalid(w http.ResponseWriter, r *http.Request, err error) {
+	message := "The link is invalid. Copy the whole link from the email."
+	if errors.Is(err, tokens.ErrLinkExpired) {
+		message = "The link has expired. Sign out of your sessions from your account instead."
+	}
+
+	html(w, r, 400, pageData{Title: "Invalid link", Message: message})
+}
+
+func html(w http.ResponseWriter, r *http.Request, status int, data pageData) {
+	var buf bytes.Buffer
+	if err := page.Execute(&buf, data); err != nil {
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	render.Status(r, status)
+	render.HTML(w, r, buf.String())
+}
@@ -0,0 +1,133 @@
+package revokelink_test
+
+import (
+	"auth/internal/http/handlers/revokelink"
+	"auth/internal/http/handlers/revokelink/mocks"
+	sl "auth/internal/lib/logger/sl/sldiscard"
+	"auth/internal/lib/tokens"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	linkSecret    = "link secret"
+	familyID      = uuid.New()
+	goodLink      = tokens.SignLink(linkSecret, tokens.LinkRevokeFamily, time.Now().Add(time.Hour), familyID.String(), "user")
+	expLink       = tokens.SignLink(linkSecret, tokens.LinkRevokeFamily, time.Now().Add(-time.Hour), familyID.String(), "user")
+	forgedLink    = tokens.SignLink("some string", tokens.LinkRevokeFamily, time.Now().Add(time.Hour), familyID.String(), "user")
+	otherLink     = tokens.SignLink(linkSecret, "some string", time.Now().Add(time.Hour), familyID.String(), "user")
+	malformedLink = tokens.SignLink(linkSecret, tokens.LinkRevokeFamily, time.Now().Add(time.Hour), "some string", "user")
+)
+
+func TestFormHandler(t *testing.T) {
+	cases := []struct {
+		name  string
+		token string
+		form  bool
+		code  int
+	}{
+		{name: "Valid link", token: goodLink, form: true, code: 200},
+		{name: "Empty token", token: "", code: 400},
+		{name: "Expired link", token: expLink, code: 400},
+		{name: "Forged link", token: forgedLink, code: 400},
+		{name: "Link of other purpose", token: otherLink, code: 400},
+	}
+
+	for _, tc := range cases {
+		req, err := http.NewRequest(http.MethodGet, "/revoke/link?token="+url.QueryEscape(tc.token), nil)
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+
+		router := chi.NewRouter()
+		router.Get("/revoke/link", revokelink.NewForm(sl.NewDiscardLogger(), linkSecret))
+		router.ServeHTTP(rr, req)
+
+		require.Equal(t, tc.code, rr.Code, "Case: %s", tc.name)
+		require.Contains(t, rr.Header().Get("Content-Type"), "text/html", "Case: %s", tc.name)
+		require.Equal(t, tc.form, strings.Contains(rr.Body.String(), "<form"), "Case: %s", tc.name)
+	}
+}
+
+func TestConfirmHandler(t *testing.T) {
+	cases := []struct {
+		name        string
+		token       string
+		linkSecret  string
+		revokeError error
+		revokeMock  bool
+		code        int
+	}{
+		{
+			name:       "Revoked",
+			token:      goodLink,
+			linkSecret: linkSecret,
+			revokeMock: true,
+			code:       200,
+		},
+		{
+			name:       "Expired link",
+			token:      expLink,
+			linkSecret: linkSecret,
+			code:       400,
+		},
+		{
+			name:       "Forged link",
+			token:      forgedLink,
+			linkSecret: linkSecret,
+			code:       400,
+		},
+		{
+			name:       "Invalid family ID",
+			token:      malformedLink,
+			linkSecret: linkSecret,
+			code:       400,
+		},
+		{
+			name:  "Links are disabled",
+			token: goodLink,
+			code:  400,
+		},
+		{
+			name:        "Failed to revoke refresh token family",
+			token:       goodLink,
+			linkSecret:  linkSecret,
+			revokeError: errors.New("some error"),
+			code:        503,
+		},
+	}
+
+	for _, tc := range cases {
+		RefreshTokenStorageMock := mocks.NewRefreshTokenStorage(t)
+
+		if tc.revokeMock || tc.revokeError != nil {
+			RefreshTokenStorageMock.On("RevokeRefreshTokenFamily", mock.Anything, familyID).
+				Return(tc.revokeError).
+				Once()
+		}
+
+		body := url.Values{"token": {tc.token}}.Encode()
+
+		req, err := http.NewRequest(http.MethodPost, "/revoke/link", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		router := chi.NewRouter()
+		router.Post("/revoke/link", revokelink.NewConfirm(sl.NewDiscardLogger(), RefreshTokenStorageMock, tc.linkSecret))
+		router.ServeHTTP(rr, req)
+
+		require.Equal(t, tc.code, rr.Code, "Case: %s", tc.name)
+	}
+}
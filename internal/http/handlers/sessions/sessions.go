@@ -0,0 +1,147 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+
+	"auth/internal/database"
+	"auth/internal/http/middleware/bearer"
+	resp "auth/internal/lib/api/response"
+	"auth/internal/lib/logger/sl"
+)
+
+type Session struct {
+	ID        int64     `json:"id"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Current   bool      `json:"current"`
+}
+
+type Response struct {
+	resp.Response
+	Sessions []Session `json:"sessions"`
+}
+
+//go:generate go run github.com/vektra/mockery/v3 --name=SessionStorage
+type SessionStorage interface {
+	ListSessions(ctx context.Context, userGUID uuid.UUID) ([]database.Session, error)
+	RevokeSession(ctx context.Context, userGUID uuid.UUID, id int64) error
+}
+
+// NewList lists the active sessions of the user authenticated by the bearer
+// middleware. The session of the presented access token is marked current.
+func NewList(log *slog.Logger, sessionStorage SessionStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.auth.sessions.NewList"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		userGUID, bindKey, err := caller(r)
+		if err != nil {
+			log.Error("Failed to parse user GUID", sl.Err(err))
+			render.Status(r, 401)
+			render.JSON(w, r, resp.Error("Invalid user GUID"))
+			return
+		}
+
+		sessions, err := sessionStorage.ListSessions(r.Context(), userGUID)
+		if err != nil {
+			log.Error("Failed to list sessions", sl.Err(err))
+			render.Status(r, resp.StorageStatus(err, 500))
+			render.JSON(w, r, resp.Error("Unable to list sessions"))
+			return
+		}
+
+		response := Response{
+			Response: resp.OK(),
+			Sessions: make([]Session, 0, len(sessions)),
+		}
+
+		for _, session := range sessions {
+			response.Sessions = append(response.Sessions, Session{
+				ID:        session.ID,
+				IP:        session.Client.IP,
+				UserAgent: session.Client.UserAgent,
+				CreatedAt: session.CreatedAt,
+				ExpiresAt: session.ExpiresAt,
+				Current:   session.BindKey == bindKey,
+			})
+		}
+
+		render.JSON(w, r, response)
+	}
+}
+
+// NewDelete revokes one session of the user authenticated by the bearer
+// middleware. Revoking an already revoked session succeeds.
+func NewDelete(log *slog.Logger, sessionStorage SessionStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.auth.sessions.NewDelete"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		userGUID, _, err := caller(r)
+		if err != nil {
+			log.Error("Failed to parse user GUID", sl.Err(err))
+			render.Status(r, 401)
+			render.JSON(w, r, resp.Error("Invalid user GUID"))
+			return
+		}
+
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			log.Error("Failed to parse session ID", sl.Err(err))
+			render.Status(r, 400)
+			render.JSON(w, r, resp.Error("Invalid session ID"))
+			return
+		}
+
+		err = sessionStorage.RevokeSession(r.Context(), userGUID, id)
+		if err != nil {
+			log.Error("Failed to revoke session", sl.Err(err))
+			if errors.Is(err, database.ErrTokenNotFound) {
+				render.Status(r, 404)
+				render.JSON(w, r, resp.Error("Session does not exist"))
+			} else {
+				render.Status(r, resp.StorageStatus(err, 500))
+				render.JSON(w, r, resp.Error("Unable to revoke session"))
+			}
+
+			return
+		}
+
+		log.Info("Session revoked", slog.Int64("session_id", id))
+
+		render.JSON(w, r, resp.OK())
+	}
+}
+
+// caller returns the user GUID and the bind key of the access token
+// authenticated by the bearer middleware.
+func caller(r *http.Request) (uuid.UUID, string, error) {
+	claims, _ := bearer.Claims(r.Context())
+
+	sub, _ := claims["sub"].(string)
+	bindKey, _ := claims["bind_key"].(string)
+
+	userGUID, err := uuid.Parse(sub)
+
+	return userGUID, bindKey, err
+}
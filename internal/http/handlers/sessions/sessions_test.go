@@ -0,0 +1,180 @@
+package sessions_test
+
+import (
+	"auth/internal/database"
+	"auth/internal/http/handlers/sessions"
+	"auth/internal/http/handlers/sessions/mocks"
+	"auth/internal/http/middleware/bearer"
+	sl "auth/internal/lib/logger/sl/sldiscard"
+	"auth/internal/lib/tokens"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	keys, _            = tokens.NewKeySet(tokens.NewHMACKey("", []byte("secretkey")))
+	goodGUID           = uuid.New()
+	goodAccessToken, _ = tokens.GenerateAccessToken(goodGUID, "172.0.0.1", "bind key", "", time.Minute, keys.SigningKey())
+	userSessions       = []database.Session{
+		{
+			ID:        2,
+			BindKey:   "bind key",
+			Client:    database.Client{IP: "172.0.0.1", UserAgent: "curl/8.0"},
+			CreatedAt: time.Now(),
+			ExpiresAt: time.Now().Add(time.Hour),
+		},
+		{
+			ID:        1,
+			BindKey:   "another bind key",
+			Client:    database.Client{IP: "192.168.0.1", UserAgent: "Mozilla/5.0"},
+			CreatedAt: time.Now().Add(-time.Hour),
+			ExpiresAt: time.Now().Add(time.Minute),
+		},
+	}
+)
+
+func newRouter(sessionStorage sessions.SessionStorage) http.Handler {
+	router := chi.NewRouter()
+	router.Route("/sessions", func(r chi.Router) {
+		r.Use(bearer.New(sl.NewDiscardLogger(), keys))
+		r.Get("/", sessions.NewList(sl.NewDiscardLogger(), sessionStorage))
+		r.Delete("/{id}", sessions.NewDelete(sl.NewDiscardLogger(), sessionStorage))
+	})
+
+	return router
+}
+
+func TestListHandler(t *testing.T) {
+	cases := []struct {
+		name      string
+		sessions  []database.Session
+		listError error
+		respError string
+		code      int
+	}{
+		{
+			name:     "Success",
+			sessions: userSessions,
+			code:     200,
+		},
+		{
+			name:      "Failed to list sessions",
+			listError: errors.New("some error"),
+			respError: "Unable to list sessions",
+			code:      500,
+		},
+		{
+			name:      "Storage timeout",
+			listError: fmt.Errorf("some error: %w", context.DeadlineExceeded),
+			respError: "Unable to list sessions",
+			code:      504,
+		},
+	}
+
+	for _, tc := range cases {
+		SessionStorageMock := mocks.NewSessionStorage(t)
+		SessionStorageMock.On("ListSessions", mock.Anything, goodGUID).
+			Return(tc.sessions, tc.listError).
+			Once()
+
+		req, err := http.NewRequest(http.MethodGet, "/sessions", nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+goodAccessToken)
+
+		rr := httptest.NewRecorder()
+
+		newRouter(SessionStorageMock).ServeHTTP(rr, req)
+
+		require.Equal(t, tc.code, rr.Code, "Case: %s", tc.name)
+
+		var resp sessions.Response
+
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+		require.Equal(t, tc.respError, resp.Error, "Case: %s", tc.name)
+		require.Len(t, resp.Sessions, len(tc.sessions), "Case: %s", tc.name)
+
+		for i, session := range tc.sessions {
+			require.Equal(t, session.ID, resp.Sessions[i].ID, "Case: %s", tc.name)
+			require.Equal(t, session.Client.UserAgent, resp.Sessions[i].UserAgent, "Case: %s", tc.name)
+			require.Equal(t, session.BindKey == "bind key", resp.Sessions[i].Current, "Case: %s", tc.name)
+		}
+	}
+}
+
+func TestDeleteHandler(t *testing.T) {
+	cases := []struct {
+		name        string
+		id          string
+		revokeError error
+		revokeMock  bool
+		respError   string
+		code        int
+	}{
+		{
+			name:       "Success",
+			id:         "1",
+			revokeMock: true,
+			code:       200,
+		},
+		{
+			name:      "Invalid session ID",
+			id:        "some string",
+			respError: "Invalid session ID",
+			code:      400,
+		},
+		{
+			name:        "Session does not exist",
+			id:          "1",
+			revokeError: database.ErrTokenNotFound,
+			revokeMock:  true,
+			respError:   "Session does not exist",
+			code:        404,
+		},
+		{
+			name:        "Failed to revoke session",
+			id:          "1",
+			revokeError: errors.New("some error"),
+			revokeMock:  true,
+			respError:   "Unable to revoke session",
+			code:        500,
+		},
+	}
+
+	for _, tc := range cases {
+		SessionStorageMock := mocks.NewSessionStorage(t)
+
+		if tc.revokeMock {
+			SessionStorageMock.On("RevokeSession", mock.Anything, goodGUID, int64(1)).
+				Return(tc.revokeError).
+				Once()
+		}
+
+		req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("/sessions/%s", tc.id), nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+goodAccessToken)
+
+		rr := httptest.NewRecorder()
+
+		newRouter(SessionStorageMock).ServeHTTP(rr, req)
+
+		require.Equal(t, tc.code, rr.Code, "Case: %s", tc.name)
+
+		var resp sessions.Response
+
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+		require.Equal(t, tc.respError, resp.Error, "Case: %s", tc.name)
+	}
+}
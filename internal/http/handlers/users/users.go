@@ -0,0 +1,394 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+
+	"auth/internal/config"
+	"auth/internal/database"
+	"auth/internal/http/middleware/bearer"
+	"auth/internal/http/middleware/clientauth"
+	resp "auth/internal/lib/api/response"
+	"auth/internal/lib/clientip"
+	"auth/internal/lib/logger/sl"
+	"auth/internal/lib/password"
+	"auth/internal/lib/ratelimit"
+)
+
+const (
+	// maxPasswordLength bounds the passwords hashed per request.
+	maxPasswordLength = 1024
+	maxLocaleLength   = 35
+)
+
+type RegisterRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Locale   string `json:"locale"`
+}
+
+type RegisterResponse struct {
+	resp.Response
+	UserGUID string `json:"user_guid"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+//go:generate go run github.com/vektra/mockery/v3 --name=UserStorage
+type UserStorage interface {
+	GetUser(ctx context.Context, userGUID uuid.UUID) (database.User, error)
+	CreateUser(ctx context.Context, user database.User, emails []database.Email) error
+	SetPasswordHash(ctx context.Context, userGUID uuid.UUID, oldHash string, newHash string, revokeTokens bool) error
+	SetUserDisabled(ctx context.Context, userGUID uuid.UUID, disabled bool) error
+}
+
+// NewRegister creates a user with the email, lowercased, as the username
+// and queues the email verification. The user gets tokens with the password
+// once the email is verified.
+func NewRegister(log *slog.Logger, userStorage UserStorage, hasher *password.Hasher,
+	accounts config.Accounts) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.auth.users.NewRegister"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req RegisterRequest
+
+		if !decode(w, r, log, &req) {
+			return
+		}
+
+		address, err := mail.ParseAddress(req.Email)
+		if err != nil || address.Address != strings.TrimSpace(req.Email) {
+			log.Error("Invalid email", slog.String("email", req.Email))
+			render.Status(r, 400)
+			render.JSON(w, r, resp.Error("Invalid email"))
+			return
+		}
+
+		if len(req.Locale) > maxLocaleLength {
+			log.Error("Invalid locale", slog.String("locale", req.Locale))
+			render.Status(r, 400)
+			render.JSON(w, r, resp.Error("Invalid locale"))
+			return
+		}
+
+		if message := checkPassword(req.Password, accounts.MinPasswordLength); message != "" {
+			log.Error("Invalid password", slog.String("reason", message))
+			render.Status(r, 400)
+			render.JSON(w, r, resp.Error(message))
+			return
+		}
+
+		hash, err := hasher.Hash(req.Password)
+		if err != nil {
+			log.Error("Failed to hash password", sl.Err(err))
+			hashFailed(w, r, err, "Failed to hash password")
+			return
+		}
+
+		// Addresses differing in case reach the same mailbox, so they are
+		// registered once.
+		email := strings.ToLower(address.Address)
+
+		user := database.User{
+			GUID:           uuid.New(),
+			Email:          email,
+			Locale:         req.Locale,
+			NotifyIPChange: true,
+			Username:       email,
+			PasswordHash:   hash,
+		}
+
+		err = userStorage.CreateUser(r.Context(), user, []database.Email{{
+			Kind: database.EmailVerifyEmail,
+			To:   user.Email,
+			Params: map[string]string{
+				"user_guid": user.GUID.String(),
+				"locale":    user.Locale,
+			},
+		}})
+		if err != nil {
+			log.Error("Failed to create user", sl.Err(err))
+			if errors.Is(err, database.ErrUsernameTaken) {
+				render.Status(r, 409)
+				render.JSON(w, r, resp.Error("Email is already registered"))
+			} else {
+				render.Status(r, resp.StorageStatus(err, 500))
+				render.JSON(w, r, resp.Error("Unable to create user"))
+			}
+
+			return
+		}
+
+		log.Info("User registered", slog.String("user_guid", user.GUID.String()))
+
+		render.Status(r, 201)
+		render.JSON(w, r, RegisterResponse{
+			Response: resp.OK(),
+			UserGUID: user.GUID.String(),
+		})
+	}
+}
+
+// NewChangePassword changes the password of the user authenticated by the
+// bearer middleware and revokes every refresh token of the user, this
+// session's included, so stolen sessions end with the old password. Wrong
+// current passwords count as failures of the client IP and of the bind_key
+// of the access token for the limiter, if any, so a stolen access token can
+// not be used to guess the password. Disabled users can not change it.
+func NewChangePassword(log *slog.Logger, userStorage UserStorage, hasher *password.Hasher,
+	limiter *ratelimit.Limiter, accounts config.Accounts) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.auth.users.NewChangePassword"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		claims, _ := bearer.Claims(r.Context())
+		sub, _ := claims["sub"].(string)
+		bindKey, _ := claims["bind_key"].(string)
+
+		userGUID, err := uuid.Parse(sub)
+		if err != nil {
+			log.Error("Failed to parse user GUID", sl.Err(err))
+			render.Status(r, 401)
+			render.JSON(w, r, resp.Error("Invalid user GUID"))
+			return
+		}
+
+		if bindKey != "" {
+			result, err := limiter.Allow(r.Context(), ratelimit.KindBindKey, bindKey)
+			if err != nil {
+				log.Error("Failed to take rate limit token", sl.Err(err))
+			} else if !result.Allowed {
+				log.Warn("Password change is rate limited", sl.Event("rate_limited"),
+					slog.String("kind", ratelimit.KindBindKey),
+					slog.String("user_guid", userGUID.String()),
+					slog.Bool("locked", result.Locked))
+				ratelimit.Deny(w, r, result)
+				return
+			}
+			ratelimit.WriteHeaders(w, result)
+		}
+
+		var req ChangePasswordRequest
+
+		if !decode(w, r, log, &req) {
+			return
+		}
+
+		if message := checkPassword(req.NewPassword, accounts.MinPasswordLength); message != "" {
+			log.Error("Invalid new password", slog.String("reason", message))
+			render.Status(r, 400)
+			render.JSON(w, r, resp.Error(message))
+			return
+		}
+
+		user, err := userStorage.GetUser(r.Context(), userGUID)
+		if errors.Is(err, database.ErrUserNotFound) {
+			log.Error("User does not exist", slog.String("user_guid", userGUID.String()))
+			render.Status(r, 404)
+			render.JSON(w, r, resp.Error("User does not exist"))
+			return
+		}
+
+		if err != nil {
+			log.Error("Failed to find user", sl.Err(err))
+			render.Status(r, resp.StorageStatus(err, 500))
+			render.JSON(w, r, resp.Error("Unable to find user"))
+			return
+		}
+
+		if user.Disabled {
+			log.Warn("Password change of disabled user", sl.Event("disabled_user_password_change"),
+				slog.String("user_guid", userGUID.String()))
+			render.Status(r, 403)
+			render.JSON(w, r, resp.ErrorCode(resp.CodeUserDisabled, "User is disabled"))
+			return
+		}
+
+		match := false
+		if user.PasswordHash != "" && len(req.CurrentPassword) <= maxPasswordLength {
+			match, err = hasher.Verify(req.CurrentPassword, user.PasswordHash)
+			if err != nil {
+				log.Error("Failed to verify password", sl.Err(err))
+				hashFailed(w, r, err, "Unable to verify password")
+				return
+			}
+		}
+
+		if !match {
+			log.Warn("Password change with wrong password", sl.Event("password_change_failed"),
+				slog.String("user_guid", userGUID.String()))
+			failed(r.Context(), log, limiter, r, bindKey)
+			render.Status(r, 403)
+			render.JSON(w, r, resp.Error("Invalid current password"))
+			return
+		}
+
+		hash, err := hasher.Hash(req.NewPassword)
+		if err != nil {
+			log.Error("Failed to hash password", sl.Err(err))
+			hashFailed(w, r, err, "Failed to hash password")
+			return
+		}
+
+		err = userStorage.SetPasswordHash(r.Context(), userGUID, user.PasswordHash, hash, true)
+		if err != nil {
+			log.Error("Failed to change password", sl.Err(err))
+			if errors.Is(err, database.ErrUserNotFound) {
+				render.Status(r, 409)
+				render.JSON(w, r, resp.Error("Password was changed meanwhile, try again"))
+			} else {
+				render.Status(r, resp.StorageStatus(err, 500))
+				render.JSON(w, r, resp.Error("Unable to change password"))
+			}
+
+			return
+		}
+
+		log.Warn("Password changed", sl.Event("password_changed"), slog.String("user_guid", userGUID.String()))
+
+		render.JSON(w, r, resp.OK())
+	}
+}
+
+// NewSetDisabled disables or enables the user of the user_guid URL
+// parameter for the client authenticated by the clientauth middleware.
+// Disabled users get no tokens and can not refresh the ones they have,
+// enabling them makes the tokens they have usable again.
+func NewSetDisabled(log *slog.Logger, userStorage UserStorage, disabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.auth.users.NewSetDisabled"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		userGUID, err := uuid.Parse(chi.URLParam(r, "user_guid"))
+		if err != nil {
+			log.Error("Failed to parse user_guid", sl.Err(err))
+			render.Status(r, 400)
+			render.JSON(w, r, resp.Error("Invalid user GUID"))
+			return
+		}
+
+		err = userStorage.SetUserDisabled(r.Context(), userGUID, disabled)
+		if err != nil {
+			log.Error("Failed to update user", sl.Err(err))
+			if errors.Is(err, database.ErrUserNotFound) {
+				render.Status(r, 404)
+				render.JSON(w, r, resp.Error("User does not exist"))
+			} else {
+				render.Status(r, resp.StorageStatus(err, 500))
+				render.JSON(w, r, resp.Error("Unable to update user"))
+			}
+
+			return
+		}
+
+		event := "user_enabled"
+		if disabled {
+			event = "user_disabled"
+		}
+
+		log.Warn("User updated", sl.Event(event), slog.String("user_guid", userGUID.String()),
+			slog.String("client_id", clientauth.ClientID(r.Context())))
+
+		render.JSON(w, r, resp.OK())
+	}
+}
+
+// decode decodes the JSON body into req and answers bad requests itself.
+func decode(w http.ResponseWriter, r *http.Request, log *slog.Logger, req any) bool {
+	err := render.DecodeJSON(r.Body, req)
+	if errors.Is(err, io.EOF) {
+		log.Error("Request body is empty")
+		render.Status(r, 400)
+		render.JSON(w, r, resp.Error("Empty request"))
+		return false
+	}
+
+	if err != nil {
+		log.Error("Failed to decode request body", sl.Err(err))
+		render.Status(r, 400)
+		render.JSON(w, r, resp.Error("Failed to decode request"))
+		return false
+	}
+
+	return true
+}
+
+// failed records a wrong current password from the client IP and of the
+// bind_key of the access token, so guessing the password is locked out.
+func failed(ctx context.Context, log *slog.Logger, limiter *ratelimit.Limiter, r *http.Request, bindKey string) {
+	keys := make(map[string]string)
+	if userIp, err := clientip.FromRequest(r); err == nil {
+		keys[ratelimit.KindIP] = userIp
+	}
+	if bindKey != "" {
+		keys[ratelimit.KindBindKey] = bindKey
+	}
+
+	for kind, key := range keys {
+		lockedUntil, err := limiter.Fail(ctx, kind, key)
+		if err != nil {
+			log.Error("Failed to record wrong password", sl.Err(err))
+			continue
+		}
+
+		if !lockedUntil.IsZero() {
+			log.Warn("Locked out after wrong passwords", sl.Event("password_change_lockout"),
+				slog.String("kind", kind),
+				slog.String("key", key),
+				slog.Time("locked_until", lockedUntil))
+		}
+	}
+}
+
+// hashFailed answers a failed hash or verification of a password. A busy
+// hasher is retried shortly, anything else is a server error.
+func hashFailed(w http.ResponseWriter, r *http.Request, err error, message string) {
+	if errors.Is(err, password.ErrBusy) {
+		w.Header().Set("Retry-After", "1")
+		render.Status(r, 503)
+		render.JSON(w, r, resp.Error("Too many passwords in progress"))
+		return
+	}
+
+	render.Status(r, 500)
+	render.JSON(w, r, resp.Error(message))
+}
+
+// checkPassword returns why the password is not acceptable, if it is not.
+func checkPassword(secret string, minLength int) string {
+	switch {
+	case utf8.RuneCountInString(secret) < minLength:
+		return "Password is too short"
+	case len(secret) > maxPasswordLength:
+		return "Password is too long"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,479 @@
+package users_test
+
+import (
+	"auth/internal/config"
+	"auth/internal/database"
+	"auth/internal/http/handlers/users"
+	"auth/internal/http/handlers/users/mocks"
+	"auth/internal/http/middleware/bearer"
+	"auth/internal/http/middleware/clientauth"
+	resp "auth/internal/lib/api/response"
+	sl "auth/internal/lib/logger/sl/sldiscard"
+	"auth/internal/lib/password"
+	"auth/internal/lib/ratelimit"
+	"auth/internal/lib/tokens"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	accounts = config.Accounts{
+		MinPasswordLength: 8,
+		PasswordHashing: config.PasswordHashing{
+			Memory:      1024,
+			Iterations:  1,
+			Parallelism: 1,
+			SaltLength:  16,
+			KeyLength:   32,
+		},
+	}
+	hasher             = password.NewHasher(password.NewParams(accounts.PasswordHashing), 4)
+	adminClients       = []config.Client{{ID: "admin", Secret: "admin client secret"}}
+	keys, _            = tokens.NewKeySet(tokens.NewHMACKey("", []byte("secretkey")))
+	goodGUID           = uuid.New()
+	goodAccessToken, _ = tokens.GenerateAccessToken(goodGUID, "172.0.0.1", "bind key", "", time.Minute, keys.SigningKey())
+)
+
+func newRouter(userStorage users.UserStorage, limiter *ratelimit.Limiter) http.Handler {
+	log := sl.NewDiscardLogger()
+
+	router := chi.NewRouter()
+	router.Route("/users", func(r chi.Router) {
+		r.Post("/", users.NewRegister(log, userStorage, hasher, accounts))
+		r.With(bearer.New(log, keys)).Post("/password", users.NewChangePassword(log, userStorage, hasher, limiter,
+			accounts))
+		r.Group(func(r chi.Router) {
+			r.Use(clientauth.New(log, "accounts", adminClients))
+			r.Post("/{user_guid}/disable", users.NewSetDisabled(log, userStorage, true))
+			r.Post("/{user_guid}/enable", users.NewSetDisabled(log, userStorage, false))
+		})
+	})
+
+	return router
+}
+
+func TestRegisterHandler(t *testing.T) {
+	cases := []struct {
+		name        string
+		body        string
+		email       string
+		locale      string
+		createMock  bool
+		createError error
+		respError   string
+		code        int
+	}{
+		{
+			name:       "Registered",
+			body:       `{"email": "user@mail.example", "password": "long enough", "locale": "ru"}`,
+			email:      "user@mail.example",
+			locale:     "ru",
+			createMock: true,
+			code:       201,
+		},
+		{
+			name:       "Email in upper case",
+			body:       `{"email": "User@Mail.Example", "password": "long enough"}`,
+			email:      "user@mail.example",
+			createMock: true,
+			code:       201,
+		},
+		{
+			name:        "Email is taken",
+			body:        `{"email": "user@mail.example", "password": "long enough"}`,
+			email:       "user@mail.example",
+			createError: fmt.Errorf("some error: %w", database.ErrUsernameTaken),
+			respError:   "Email is already registered",
+			code:        409,
+		},
+		{
+			name:        "Failed to create user",
+			body:        `{"email": "user@mail.example", "password": "long enough"}`,
+			email:       "user@mail.example",
+			createError: errors.New("some error"),
+			respError:   "Unable to create user",
+			code:        500,
+		},
+		{
+			name:      "Empty request",
+			body:      "",
+			respError: "Empty request",
+			code:      400,
+		},
+		{
+			name:      "Invalid email",
+			body:      `{"email": "some string", "password": "long enough"}`,
+			respError: "Invalid email",
+			code:      400,
+		},
+		{
+			name:      "Email with display name",
+			body:      `{"email": "User <user@mail.example>", "password": "long enough"}`,
+			respError: "Invalid email",
+			code:      400,
+		},
+		{
+			name:      "Short password",
+			body:      `{"email": "user@mail.example", "password": "short"}`,
+			respError: "Password is too short",
+			code:      400,
+		},
+		{
+			name:      "Long password",
+			body:      `{"email": "user@mail.example", "password": "` + strings.Repeat("a", 1025) + `"}`,
+			respError: "Password is too long",
+			code:      400,
+		},
+		{
+			name:      "Long locale",
+			body:      `{"email": "user@mail.example", "password": "long enough", "locale": "` + strings.Repeat("a", 36) + `"}`,
+			respError: "Invalid locale",
+			code:      400,
+		},
+	}
+
+	for _, tc := range cases {
+		UserStorageMock := mocks.NewUserStorage(t)
+
+		if tc.createMock || tc.createError != nil {
+			UserStorageMock.On("CreateUser", mock.Anything, mock.MatchedBy(func(user database.User) bool {
+				match, err := password.Verify("long enough", user.PasswordHash)
+
+				return err == nil && match && user.GUID != uuid.Nil && user.Email == tc.email &&
+					user.Username == tc.email && user.Locale == tc.locale && !user.EmailVerified
+			}), mock.MatchedBy(func(emails []database.Email) bool {
+				return len(emails) == 1 && emails[0].Kind == database.EmailVerifyEmail &&
+					emails[0].To == tc.email && emails[0].Params["user_guid"] != ""
+			})).
+				Return(tc.createError).
+				Once()
+		}
+
+		req, err := http.NewRequest(http.MethodPost, "/users", strings.NewReader(tc.body))
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		newRouter(UserStorageMock, nil).ServeHTTP(rr, req)
+
+		require.Equal(t, tc.code, rr.Code, "Case: %s", tc.name)
+
+		var resp users.RegisterResponse
+
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+		require.Equal(t, tc.respError, resp.Error, "Case: %s", tc.name)
+
+		if tc.code == 201 {
+			_, err := uuid.Parse(resp.UserGUID)
+			require.NoError(t, err, "Case: %s", tc.name)
+		}
+	}
+}
+
+func TestChangePasswordHandler(t *testing.T) {
+	hash, err := password.Hash("current password", password.NewParams(accounts.PasswordHashing))
+	require.NoError(t, err)
+
+	user := database.User{GUID: goodGUID, Username: "user@mail.example", PasswordHash: hash}
+
+	cases := []struct {
+		name        string
+		accessToken string
+		body        string
+		user        database.User
+		getError    error
+		getMock     bool
+		setMock     bool
+		setError    error
+		respError   string
+		respCode    string
+		code        int
+	}{
+		{
+			name:        "Changed",
+			accessToken: goodAccessToken,
+			body:        `{"current_password": "current password", "new_password": "new password"}`,
+			user:        user,
+			getMock:     true,
+			setMock:     true,
+			code:        200,
+		},
+		{
+			name:      "Unauthenticated",
+			body:      `{"current_password": "current password", "new_password": "new password"}`,
+			respError: "Access token is empty",
+			code:      401,
+		},
+		{
+			name:        "Wrong current password",
+			accessToken: goodAccessToken,
+			body:        `{"current_password": "Current password", "new_password": "new password"}`,
+			user:        user,
+			getMock:     true,
+			respError:   "Invalid current password",
+			code:        403,
+		},
+		{
+			name:        "User without password",
+			accessToken: goodAccessToken,
+			body:        `{"current_password": "", "new_password": "new password"}`,
+			user:        database.User{GUID: goodGUID},
+			getMock:     true,
+			respError:   "Invalid current password",
+			code:        403,
+		},
+		{
+			name:        "Disabled user",
+			accessToken: goodAccessToken,
+			body:        `{"current_password": "current password", "new_password": "new password"}`,
+			user:        database.User{GUID: goodGUID, Username: "user@mail.example", PasswordHash: hash, Disabled: true},
+			getMock:     true,
+			respError:   "User is disabled",
+			respCode:    resp.CodeUserDisabled,
+			code:        403,
+		},
+		{
+			name:        "Short new password",
+			accessToken: goodAccessToken,
+			body:        `{"current_password": "current password", "new_password": "short"}`,
+			respError:   "Password is too short",
+			code:        400,
+		},
+		{
+			name:        "User does not exist",
+			accessToken: goodAccessToken,
+			body:        `{"current_password": "current password", "new_password": "new password"}`,
+			getError:    database.ErrUserNotFound,
+			respError:   "User does not exist",
+			code:        404,
+		},
+		{
+			name:        "Failed to find user",
+			accessToken: goodAccessToken,
+			body:        `{"current_password": "current password", "new_password": "new password"}`,
+			getError:    fmt.Errorf("some error: %w", context.DeadlineExceeded),
+			respError:   "Unable to find user",
+			code:        504,
+		},
+		{
+			name:        "Changed meanwhile",
+			accessToken: goodAccessToken,
+			body:        `{"current_password": "current password", "new_password": "new password"}`,
+			user:        user,
+			getMock:     true,
+			setError:    database.ErrUserNotFound,
+			respError:   "Password was changed meanwhile, try again",
+			code:        409,
+		},
+		{
+			name:        "Failed to change password",
+			accessToken: goodAccessToken,
+			body:        `{"current_password": "current password", "new_password": "new password"}`,
+			user:        user,
+			getMock:     true,
+			setError:    errors.New("some error"),
+			respError:   "Unable to change password",
+			code:        500,
+		},
+	}
+
+	for _, tc := range cases {
+		UserStorageMock := mocks.NewUserStorage(t)
+
+		if tc.getMock || tc.getError != nil {
+			UserStorageMock.On("GetUser", mock.Anything, goodGUID).
+				Return(tc.user, tc.getError).
+				Once()
+		}
+
+		if tc.setMock || tc.setError != nil {
+			UserStorageMock.On("SetPasswordHash", mock.Anything, goodGUID, hash,
+				mock.MatchedBy(func(newHash string) bool {
+					match, err := password.Verify("new password", newHash)
+					return err == nil && match
+				}), true).
+				Return(tc.setError).
+				Once()
+		}
+
+		req, err := http.NewRequest(http.MethodPost, "/users/password", strings.NewReader(tc.body))
+		require.NoError(t, err)
+		if tc.accessToken != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.accessToken)
+		}
+
+		rr := httptest.NewRecorder()
+		newRouter(UserStorageMock, nil).ServeHTTP(rr, req)
+
+		require.Equal(t, tc.code, rr.Code, "Case: %s", tc.name)
+
+		var body struct {
+			Error string `json:"error"`
+			Code  string `json:"code"`
+		}
+
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+
+		require.Equal(t, tc.respError, body.Error, "Case: %s", tc.name)
+		require.Equal(t, tc.respCode, body.Code, "Case: %s", tc.name)
+	}
+}
+
+// TestChangePasswordLockout guesses the current password with an access
+// token until the token is locked out, the right password included.
+func TestChangePasswordLockout(t *testing.T) {
+	hash, err := password.Hash("current password", password.NewParams(accounts.PasswordHashing))
+	require.NoError(t, err)
+
+	limiter := ratelimit.New(ratelimit.NewMemoryStore(), config.RateLimit{
+		BindKey: config.Limit{Requests: 100, Period: time.Hour},
+		Lockout: config.Lockout{MaxFailures: 2, Window: time.Hour, Duration: time.Hour},
+	})
+
+	UserStorageMock := mocks.NewUserStorage(t)
+	UserStorageMock.On("GetUser", mock.Anything, goodGUID).
+		Return(database.User{GUID: goodGUID, PasswordHash: hash}, nil).
+		Times(3)
+
+	router := newRouter(UserStorageMock, limiter)
+
+	cases := []struct {
+		name     string
+		password string
+		code     int
+		respCode string
+	}{
+		{name: "First wrong password", password: "wrong password", code: 403},
+		{name: "Second wrong password", password: "wrong password", code: 403},
+		{name: "Third wrong password", password: "wrong password", code: 403},
+		{name: "Right password of locked out token", password: "current password", code: 429,
+			respCode: ratelimit.CodeLockedOut},
+	}
+
+	for _, tc := range cases {
+		body := fmt.Sprintf(`{"current_password": %q, "new_password": "new password"}`, tc.password)
+
+		req, err := http.NewRequest(http.MethodPost, "/users/password", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+goodAccessToken)
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		require.Equal(t, tc.code, rr.Code, "Case: %s", tc.name)
+
+		var resp struct {
+			Code string `json:"code"`
+		}
+
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+		require.Equal(t, tc.respCode, resp.Code, "Case: %s", tc.name)
+	}
+}
+
+func TestSetDisabledHandler(t *testing.T) {
+	cases := []struct {
+		name         string
+		action       string
+		userGUID     string
+		clientSecret string
+		disabled     bool
+		setMock      bool
+		setError     error
+		respError    string
+		code         int
+	}{
+		{
+			name:     "Disabled",
+			action:   "disable",
+			userGUID: goodGUID.String(),
+			disabled: true,
+			setMock:  true,
+			code:     200,
+		},
+		{
+			name:     "Enabled",
+			action:   "enable",
+			userGUID: goodGUID.String(),
+			setMock:  true,
+			code:     200,
+		},
+		{
+			name:         "Unauthenticated client",
+			action:       "disable",
+			userGUID:     goodGUID.String(),
+			clientSecret: "some string",
+			respError:    clientauth.ErrInvalidClient,
+			code:         401,
+		},
+		{
+			name:      "Invalid GUID",
+			action:    "disable",
+			userGUID:  "some string",
+			respError: "Invalid user GUID",
+			code:      400,
+		},
+		{
+			name:      "User does not exist",
+			action:    "disable",
+			userGUID:  goodGUID.String(),
+			disabled:  true,
+			setError:  fmt.Errorf("some error: %w", database.ErrUserNotFound),
+			respError: "User does not exist",
+			code:      404,
+		},
+		{
+			name:      "Failed to update user",
+			action:    "enable",
+			userGUID:  goodGUID.String(),
+			setError:  errors.New("some error"),
+			respError: "Unable to update user",
+			code:      500,
+		},
+	}
+
+	for _, tc := range cases {
+		UserStorageMock := mocks.NewUserStorage(t)
+
+		if tc.setMock || tc.setError != nil {
+			UserStorageMock.On("SetUserDisabled", mock.Anything, goodGUID, tc.disabled).
+				Return(tc.setError).
+				Once()
+		}
+
+		req, err := http.NewRequest(http.MethodPost, "/users/"+tc.userGUID+"/"+tc.action, nil)
+		require.NoError(t, err)
+
+		if tc.clientSecret == "" {
+			req.SetBasicAuth(adminClients[0].ID, adminClients[0].Secret)
+		} else {
+			req.SetBasicAuth(adminClients[0].ID, tc.clientSecret)
+		}
+
+		rr := httptest.NewRecorder()
+		newRouter(UserStorageMock, nil).ServeHTTP(rr, req)
+
+		require.Equal(t, tc.code, rr.Code, "Case: %s", tc.name)
+
+		var resp struct {
+			Error string `json:"error"`
+		}
+
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+		require.Equal(t, tc.respError, resp.Error, "Case: %s", tc.name)
+	}
+}
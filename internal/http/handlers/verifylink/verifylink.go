@@ -0,0 +1,160 @@
+package verifylink
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+
+	"auth/internal/database"
+	"auth/internal/lib/logger/sl"
+	"auth/internal/lib/tokens"
+)
+
+//go:embed page.html.tmpl
+var pageSource string
+
+var page = template.Must(template.New("page").Parse(pageSource))
+
+//go:generate go run github.com/vektra/mockery/v3 --name=UserStorage
+type UserStorage interface {
+	VerifyEmail(ctx context.Context, userGUID uuid.UUID, email string) error
+}
+
+type pageData struct {
+	Title   string
+	Message string
+	// Token is set on the confirmation page, which posts it back.
+	Token string
+}
+
+// NewForm serves the link of the email verification. It only asks to
+// confirm, so mail scanners following links verify nothing.
+func NewForm(log *slog.Logger, linkSecret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.auth.verifylink.NewForm"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		token := r.URL.Query().Get("token")
+
+		if _, _, err := verify(linkSecret, token); err != nil {
+			log.Info("Invalid verification link", sl.Err(err))
+			invalid(w, r, err)
+			return
+		}
+
+		html(w, r, 200, pageData{
+			Title:   "Confirm your email",
+			Message: "Confirm the email address you signed up with.",
+			Token:   token,
+		})
+	}
+}
+
+// NewConfirm verifies the email of the link posted from the form. A link
+// verifies once: the email of the user must still be the one it was sent
+// to and not yet verified.
+func NewConfirm(log *slog.Logger, userStorage UserStorage, linkSecret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.auth.verifylink.NewConfirm"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		err := r.ParseForm()
+		if err != nil {
+			log.Error("Failed to parse request form", sl.Err(err))
+			invalid(w, r, tokens.ErrInvalidLink)
+			return
+		}
+
+		userGUID, address, err := verify(linkSecret, r.PostForm.Get("token"))
+		if err != nil {
+			log.Info("Invalid verification link", sl.Err(err))
+			invalid(w, r, err)
+			return
+		}
+
+		err = userStorage.VerifyEmail(r.Context(), userGUID, address)
+		if errors.Is(err, database.ErrUserNotFound) {
+			log.Info("Verification link is used", slog.String("user_guid", userGUID.String()))
+			html(w, r, 400, pageData{
+				Title:   "Invalid link",
+				Message: "The link was already used or the email address has changed since.",
+			})
+			return
+		}
+
+		if err != nil {
+			log.Error("Failed to verify email", sl.Err(err))
+			html(w, r, 503, pageData{
+				Title:   "Something went wrong",
+				Message: "The email could not be confirmed. Please try again later.",
+			})
+			return
+		}
+
+		log.Info("Email verified", slog.String("user_guid", userGUID.String()))
+
+		html(w, r, 200, pageData{
+			Title:   "Email confirmed",
+			Message: "Your email address is confirmed, you can close this page.",
+		})
+	}
+}
+
+// verify returns the user and the email the link was signed for.
+func verify(linkSecret string, token string) (uuid.UUID, string, error) {
+	if linkSecret == "" || token == "" {
+		return uuid.Nil, "", tokens.ErrInvalidLink
+	}
+
+	values, err := tokens.VerifyLink(linkSecret, tokens.LinkVerifyEmail, token)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+
+	if len(values) != 2 {
+		return uuid.Nil, "", tokens.ErrInvalidLink
+	}
+
+	userGUID, err := uuid.Parse(values[0])
+	if err != nil {
+		return uuid.Nil, "", tokens.ErrInvalidLink
+	}
+
+	return userGUID, values[1], nil
+}
+
+func invalid(w http.ResponseWriter, r *http.Request, err error) {
+	message := "The link is invalid. Copy the whole link from the email."
+	if errors.Is(err, tokens.ErrLinkExpired) {
+		message = "The link has expired."
+	}
+
+	html(w, r, 400, pageData{Title: "Invalid link", Message: message})
+}
+
+func html(w http.ResponseWriter, r *http.Request, status int, data pageData) {
+	var buf bytes.Buffer
+	if err := page.Execute(&buf, data); err != nil {
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	render.Status(r, status)
+	render.HTML(w, r, buf.String())
+}
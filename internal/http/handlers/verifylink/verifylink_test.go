@@ -0,0 +1,142 @@
+package verifylink_test
+
+import (
+	"auth/internal/database"
+	"auth/internal/http/handlers/verifylink"
+	"auth/internal/http/handlers/verifylink/mocks"
+	sl "auth/internal/lib/logger/sl/sldiscard"
+	"auth/internal/lib/tokens"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	linkSecret    = "link secret"
+	userGUID      = uuid.New()
+	goodLink      = tokens.SignLink(linkSecret, tokens.LinkVerifyEmail, time.Now().Add(time.Hour), userGUID.String(), "user@mail")
+	expLink       = tokens.SignLink(linkSecret, tokens.LinkVerifyEmail, time.Now().Add(-time.Hour), userGUID.String(), "user@mail")
+	forgedLink    = tokens.SignLink("some string", tokens.LinkVerifyEmail, time.Now().Add(time.Hour), userGUID.String(), "user@mail")
+	revokeLink    = tokens.SignLink(linkSecret, tokens.LinkRevokeFamily, time.Now().Add(time.Hour), userGUID.String(), "user@mail")
+	malformedLink = tokens.SignLink(linkSecret, tokens.LinkVerifyEmail, time.Now().Add(time.Hour), "some string", "user@mail")
+)
+
+func TestFormHandler(t *testing.T) {
+	cases := []struct {
+		name  string
+		token string
+		form  bool
+		code  int
+	}{
+		{name: "Valid link", token: goodLink, form: true, code: 200},
+		{name: "Empty token", token: "", code: 400},
+		{name: "Expired link", token: expLink, code: 400},
+		{name: "Forged link", token: forgedLink, code: 400},
+		{name: "Revoke link", token: revokeLink, code: 400},
+	}
+
+	for _, tc := range cases {
+		req, err := http.NewRequest(http.MethodGet, "/users/verify?token="+url.QueryEscape(tc.token), nil)
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+
+		router := chi.NewRouter()
+		router.Get("/users/verify", verifylink.NewForm(sl.NewDiscardLogger(), linkSecret))
+		router.ServeHTTP(rr, req)
+
+		require.Equal(t, tc.code, rr.Code, "Case: %s", tc.name)
+		require.Contains(t, rr.Header().Get("Content-Type"), "text/html", "Case: %s", tc.name)
+		require.Equal(t, tc.form, strings.Contains(rr.Body.String(), "<form"), "Case: %s", tc.name)
+	}
+}
+
+func TestConfirmHandler(t *testing.T) {
+	cases := []struct {
+		name        string
+		token       string
+		linkSecret  string
+		verifyError error
+		verifyMock  bool
+		code        int
+	}{
+		{
+			name:       "Verified",
+			token:      goodLink,
+			linkSecret: linkSecret,
+			verifyMock: true,
+			code:       200,
+		},
+		{
+			name:        "Link is used",
+			token:       goodLink,
+			linkSecret:  linkSecret,
+			verifyError: fmt.Errorf("some error: %w", database.ErrUserNotFound),
+			code:        400,
+		},
+		{
+			name:       "Expired link",
+			token:      expLink,
+			linkSecret: linkSecret,
+			code:       400,
+		},
+		{
+			name:       "Forged link",
+			token:      forgedLink,
+			linkSecret: linkSecret,
+			code:       400,
+		},
+		{
+			name:       "Invalid user GUID",
+			token:      malformedLink,
+			linkSecret: linkSecret,
+			code:       400,
+		},
+		{
+			name:  "Links are disabled",
+			token: goodLink,
+			code:  400,
+		},
+		{
+			name:        "Failed to verify email",
+			token:       goodLink,
+			linkSecret:  linkSecret,
+			verifyError: errors.New("some error"),
+			code:        503,
+		},
+	}
+
+	for _, tc := range cases {
+		UserStorageMock := mocks.NewUserStorage(t)
+
+		if tc.verifyMock || tc.verifyError != nil {
+			UserStorageMock.On("VerifyEmail", mock.Anything, userGUID, "user@mail").
+				Return(tc.verifyError).
+				Once()
+		}
+
+		body := url.Values{"token": {tc.token}}.Encode()
+
+		req, err := http.NewRequest(http.MethodPost, "/users/verify", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		router := chi.NewRouter()
+		router.Post("/users/verify", verifylink.NewConfirm(sl.NewDiscardLogger(), UserStorageMock, tc.linkSecret))
+		router.ServeHTTP(rr, req)
+
+		require.Equal(t, tc.code, rr.Code, "Case: %s", tc.name)
+	}
+}
@@ -0,0 +1,61 @@
+package bearer
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/go-chi/render"
+	"github.com/golang-jwt/jwt/v5"
+
+	resp "auth/internal/lib/api/response"
+	"auth/internal/lib/logger/sl"
+	"auth/internal/lib/tokens"
+)
+
+type ctxKey struct{}
+
+// New authenticates requests by the access token in the Authorization
+// header and stores its claims in the request context.
+func New(log *slog.Logger, keys *tokens.KeySet) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			const op = "middleware.bearer.New"
+
+			log := log.With(
+				slog.String("op", op),
+				slog.String("request_id", middleware.GetReqID(r.Context())),
+			)
+
+			scheme, accessToken, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+			if !strings.EqualFold(scheme, "Bearer") || accessToken == "" {
+				log.Error("Access token is empty")
+				w.Header().Set("WWW-Authenticate", `Bearer`)
+				render.Status(r, 401)
+				render.JSON(w, r, resp.Error("Access token is empty"))
+				return
+			}
+
+			claims, err := tokens.ValidateAccessToken(accessToken, keys)
+			if err != nil {
+				log.Error("Failed to validate access token", sl.Err(err))
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				render.Status(r, 401)
+				render.JSON(w, r, resp.Error("Invalid access token"))
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKey{}, claims)))
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// Claims returns the access token claims stored by the middleware.
+func Claims(ctx context.Context) (jwt.MapClaims, bool) {
+	claims, ok := ctx.Value(ctxKey{}).(jwt.MapClaims)
+	return claims, ok
+}
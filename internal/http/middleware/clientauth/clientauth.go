@@ -0,0 +1,72 @@
+package clientauth
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/go-chi/render"
+
+	"auth/internal/config"
+	resp "auth/internal/lib/api/response"
+)
+
+const ErrInvalidClient = "invalid_client"
+
+type ctxKey struct{}
+
+// New authenticates clients by HTTP Basic credentials against the configured
+// clients and stores the client ID in the request context. Requests are
+// rejected if no clients are configured.
+func New(log *slog.Logger, realm string, clients []config.Client) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			const op = "middleware.clientauth.New"
+
+			log := log.With(
+				slog.String("op", op),
+				slog.String("request_id", middleware.GetReqID(r.Context())),
+			)
+
+			id, secret, ok := r.BasicAuth()
+			if !ok || !authenticate(clients, id, secret) {
+				log.Error("Client authentication failed", slog.String("client_id", id))
+				w.Header().Set("WWW-Authenticate", `Basic realm="`+realm+`"`)
+				render.Status(r, 401)
+				render.JSON(w, r, resp.Error(ErrInvalidClient))
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKey{}, id)))
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// authenticate checks every client, so the time taken does not reveal
+// which client IDs exist.
+func authenticate(clients []config.Client, id, secret string) bool {
+	found := 0
+
+	for _, client := range clients {
+		if client.Secret == "" {
+			continue
+		}
+
+		idMatch := subtle.ConstantTimeCompare([]byte(client.ID), []byte(id))
+		secretMatch := subtle.ConstantTimeCompare([]byte(client.Secret), []byte(secret))
+
+		found |= idMatch & secretMatch
+	}
+
+	return found == 1
+}
+
+// ClientID returns the ID of the client authenticated by the middleware.
+func ClientID(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
@@ -0,0 +1,34 @@
+package realip
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/middleware"
+
+	"auth/internal/lib/clientip"
+	"auth/internal/lib/logger/sl"
+)
+
+// New resolves the client IP behind trusted proxies and stores it in the
+// request context, where clientip.FromRequest finds it. Requests with an
+// unusable remote address pass through, the handlers reject them.
+func New(log *slog.Logger, resolver *clientip.Resolver) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			const op = "middleware.realip.New"
+
+			addr, err := resolver.Resolve(r)
+			if err != nil {
+				log.Warn("Failed to resolve client IP", slog.String("op", op),
+					slog.String("request_id", middleware.GetReqID(r.Context())), sl.Err(err))
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(clientip.WithIP(r.Context(), addr)))
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
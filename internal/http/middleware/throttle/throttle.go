@@ -0,0 +1,61 @@
+package throttle
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/middleware"
+
+	"auth/internal/lib/clientip"
+	"auth/internal/lib/logger/sl"
+	"auth/internal/lib/ratelimit"
+)
+
+// New takes a token from the bucket of the key of the request and answers
+// 429 once the bucket is empty or the key is locked out. Requests without a
+// key and requests the store fails for pass, limits are not worth an outage.
+func New(log *slog.Logger, limiter *ratelimit.Limiter, kind string,
+	key func(r *http.Request) string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			const op = "middleware.throttle.New"
+
+			k := key(r)
+			if k == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			log := log.With(
+				slog.String("op", op),
+				slog.String("request_id", middleware.GetReqID(r.Context())),
+			)
+
+			result, err := limiter.Allow(r.Context(), kind, k)
+			if err != nil {
+				log.Error("Failed to take rate limit token", sl.Err(err))
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !result.Allowed {
+				log.Warn("Request is rate limited", sl.Event("rate_limited"),
+					slog.String("kind", kind), slog.String("key", k), slog.Bool("locked", result.Locked))
+				ratelimit.Deny(w, r, result)
+				return
+			}
+
+			ratelimit.WriteHeaders(w, result)
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// ClientIP keys requests by the client IP.
+func ClientIP(r *http.Request) string {
+	ip, _ := clientip.FromRequest(r)
+
+	return ip
+}
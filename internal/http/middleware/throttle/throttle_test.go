@@ -0,0 +1,104 @@
+package throttle_test
+
+import (
+	"auth/internal/config"
+	"auth/internal/http/middleware/throttle"
+	"auth/internal/lib/clientip"
+	sl "auth/internal/lib/logger/sl/sldiscard"
+	"auth/internal/lib/ratelimit"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// brokenStore fails every update, as a database that is down does.
+type brokenStore struct{}
+
+func (brokenStore) UpdateRateLimit(ctx context.Context, key string,
+	fn func(ratelimit.State) ratelimit.State) (ratelimit.State, error) {
+	return ratelimit.State{}, errors.New("Some error")
+}
+
+func (brokenStore) PurgeRateLimits(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	return 0, errors.New("Some error")
+}
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		name      string
+		store     ratelimit.Store
+		key       string
+		requests  int
+		code      int
+		remaining string
+	}{
+		{
+			name:      "Under limit",
+			store:     ratelimit.NewMemoryStore(),
+			key:       "10.0.0.1",
+			requests:  2,
+			code:      200,
+			remaining: "0",
+		},
+		{
+			name:      "Over limit",
+			store:     ratelimit.NewMemoryStore(),
+			key:       "10.0.0.1",
+			requests:  3,
+			code:      429,
+			remaining: "0",
+		},
+		{
+			name:     "Request without key",
+			store:    ratelimit.NewMemoryStore(),
+			requests: 3,
+			code:     200,
+		},
+		{
+			name:     "Store error",
+			store:    brokenStore{},
+			key:      "10.0.0.1",
+			requests: 3,
+			code:     200,
+		},
+	}
+
+	for _, tc := range cases {
+		limiter := ratelimit.New(tc.store, config.RateLimit{
+			IP: config.Limit{Requests: 2, Period: time.Hour},
+		})
+
+		handler := throttle.New(sl.NewDiscardLogger(), limiter, ratelimit.KindIP, func(r *http.Request) string {
+			return tc.key
+		})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(200)
+		}))
+
+		var rr *httptest.ResponseRecorder
+		for i := 0; i < tc.requests; i++ {
+			rr = httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		}
+
+		require.Equal(t, tc.code, rr.Code, "Case: %s", tc.name)
+		require.Equal(t, tc.remaining, rr.Header().Get("RateLimit-Remaining"), "Case: %s", tc.name)
+
+		if tc.code == 429 {
+			require.NotEmpty(t, rr.Header().Get("Retry-After"), "Case: %s", tc.name)
+			require.Contains(t, rr.Body.String(), ratelimit.CodeRateLimited, "Case: %s", tc.name)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r = r.WithContext(clientip.WithIP(r.Context(), netip.MustParseAddr("10.0.0.1")))
+
+	require.Equal(t, "10.0.0.1", throttle.ClientIP(r))
+}
@@ -1,8 +1,15 @@
 package response
 
+import (
+	"context"
+	"errors"
+)
+
 type Response struct {
 	Status string `json:"status"`
 	Error  string `json:"error,omitempty"`
+	// Code tells errors that clients handle apart from the others.
+	Code string `json:"code,omitempty"`
 }
 
 const (
@@ -10,6 +17,13 @@ const (
 	StatusError = "Error"
 )
 
+// CodeUserDisabled rejects the requests for tokens of disabled users.
+const CodeUserDisabled = "user_disabled"
+
+// CodeEmailUnverified rejects the password logins of users who did not
+// verify their email yet.
+const CodeEmailUnverified = "email_unverified"
+
 func Error(msg string) Response {
 	return Response{
 		Status: StatusError,
@@ -17,8 +31,31 @@ func Error(msg string) Response {
 	}
 }
 
+// ErrorCode returns an error with a code clients can rely on, unlike the
+// message.
+func ErrorCode(code string, msg string) Response {
+	return Response{
+		Status: StatusError,
+		Error:  msg,
+		Code:   code,
+	}
+}
+
 func OK() Response {
 	return Response{
 		Status: StatusOK,
 	}
 }
+
+// StorageStatus returns the status code of a failed storage call: 504 if
+// its deadline expired, 503 if the request was canceled, fallback otherwise.
+func StorageStatus(err error, fallback int) int {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return 504
+	case errors.Is(err, context.Canceled):
+		return 503
+	default:
+		return fallback
+	}
+}
@@ -0,0 +1,274 @@
+package authn
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+
+	"auth/internal/config"
+	"auth/internal/database"
+	"auth/internal/lib/clientip"
+	"auth/internal/lib/logger/sl"
+	"auth/internal/lib/password"
+)
+
+var (
+	// ErrNoCredentials tells the request carries no credentials of the
+	// method, the next method is tried.
+	ErrNoCredentials      = errors.New("no credentials")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrEmailUnverified tells the password is right, but the user has to
+	// verify the email first.
+	ErrEmailUnverified = errors.New("email is not verified")
+)
+
+// APIKeyHeader carries the API keys of service accounts.
+const APIKeyHeader = "X-API-Key"
+
+// Authenticator tells which user sent the request.
+type Authenticator interface {
+	Authenticate(r *http.Request) (uuid.UUID, error)
+}
+
+type UserDirectory interface {
+	GetUserByUsername(ctx context.Context, username string) (database.User, error)
+	SetPasswordHash(ctx context.Context, userGUID uuid.UUID, oldHash string, newHash string, revokeTokens bool) error
+}
+
+// New returns the methods of the config chained in order, followed by the
+// insecure one if it is enabled. Passwords are verified and rehashed by
+// hasher.
+func New(log *slog.Logger, cfg config.Authentication, hasher *password.Hasher,
+	users UserDirectory) (Authenticator, error) {
+	const op = "lib.authn.New"
+
+	var chain Chain
+
+	for _, method := range cfg.Methods {
+		switch method {
+		case config.AuthPassword:
+			chain = append(chain, NewPassword(log, users, hasher))
+		case config.AuthAPIKey:
+			apiKeys, err := NewAPIKeys(cfg.APIKeys)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", op, err)
+			}
+			chain = append(chain, apiKeys)
+		case config.AuthHeader:
+			proxies, err := clientip.NewResolver(cfg.HeaderProxies, "")
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", op, err)
+			}
+			chain = append(chain, NewTrustedHeader(proxies, cfg.Header))
+		default:
+			return nil, fmt.Errorf("%s: Unknown authentication method: %q", op, method)
+		}
+	}
+
+	if cfg.Insecure {
+		chain = append(chain, Insecure{})
+	}
+
+	return chain, nil
+}
+
+// Chain tries the authenticators in order until one finds credentials.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request) (uuid.UUID, error) {
+	for _, authenticator := range c {
+		userGUID, err := authenticator.Authenticate(r)
+		if !errors.Is(err, ErrNoCredentials) {
+			return userGUID, err
+		}
+	}
+
+	return uuid.Nil, ErrNoCredentials
+}
+
+// Password checks HTTP Basic credentials against the usernames and the
+// password hashes of the user directory. Hashes made with other parameters
+// are replaced on a successful login, while the password is at hand. The
+// errors of a busy hasher wrap password.ErrBusy.
+//
+// Users with an email log in once it is verified, so an address registered
+// by someone else than its owner is of no use to them. The check follows
+// the password, so it reveals nothing about the users to others.
+type Password struct {
+	log    *slog.Logger
+	users  UserDirectory
+	hasher *password.Hasher
+}
+
+func NewPassword(log *slog.Logger, users UserDirectory, hasher *password.Hasher) *Password {
+	return &Password{log: log, users: users, hasher: hasher}
+}
+
+func (p *Password) Authenticate(r *http.Request) (uuid.UUID, error) {
+	const op = "lib.authn.Password.Authenticate"
+
+	username, secret, ok := r.BasicAuth()
+	if !ok {
+		return uuid.Nil, ErrNoCredentials
+	}
+
+	user, err := p.users.GetUserByUsername(r.Context(), username)
+	if errors.Is(err, database.ErrUserNotFound) || err == nil && user.PasswordHash == "" {
+		if err := p.hasher.Dummy(secret); err != nil {
+			return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+		}
+		return uuid.Nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	match, err := p.hasher.Verify(secret, user.PasswordHash)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if !match {
+		return uuid.Nil, ErrInvalidCredentials
+	}
+
+	if user.Email != "" && !user.EmailVerified {
+		return uuid.Nil, ErrEmailUnverified
+	}
+
+	if p.hasher.NeedsRehash(user.PasswordHash) {
+		p.rehash(r.Context(), user, secret)
+	}
+
+	return user.GUID, nil
+}
+
+// rehash replaces the hash of the user, failures only delay it to the next
+// login.
+func (p *Password) rehash(ctx context.Context, user database.User, secret string) {
+	const op = "lib.authn.Password.rehash"
+
+	log := p.log.With(slog.String("op", op), slog.String("user_guid", user.GUID.String()))
+
+	hash, err := p.hasher.Hash(secret)
+	if err != nil {
+		log.Warn("Failed to rehash password", sl.Err(err))
+		return
+	}
+
+	// The hash changed meanwhile if the user is not found, the password
+	// was changed or rehashed by another login.
+	err = p.users.SetPasswordHash(ctx, user.GUID, user.PasswordHash, hash, false)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return
+	}
+	if err != nil {
+		log.Warn("Failed to save rehashed password", sl.Err(err))
+		return
+	}
+
+	log.Debug("Rehashed password")
+}
+
+// APIKeys checks the X-API-Key header against the static keys of service
+// accounts.
+type APIKeys struct {
+	keys []apiKey
+}
+
+type apiKey struct {
+	digest   [sha256.Size]byte
+	userGUID uuid.UUID
+}
+
+func NewAPIKeys(keys []config.APIKey) (*APIKeys, error) {
+	const op = "lib.authn.NewAPIKeys"
+
+	a := &APIKeys{keys: make([]apiKey, 0, len(keys))}
+
+	for _, key := range keys {
+		userGUID, err := uuid.Parse(key.UserGUID)
+		if err != nil {
+			return nil, fmt.Errorf("%s: Invalid user GUID of API key %q: %w", op, key.Name, err)
+		}
+
+		a.keys = append(a.keys, apiKey{digest: sha256.Sum256([]byte(key.Key)), userGUID: userGUID})
+	}
+
+	return a, nil
+}
+
+// Authenticate compares digests of the keys, so the time taken reveals
+// neither the length of the keys nor which of them is close.
+func (a *APIKeys) Authenticate(r *http.Request) (uuid.UUID, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		return uuid.Nil, ErrNoCredentials
+	}
+
+	digest := sha256.Sum256([]byte(key))
+	userGUID := uuid.Nil
+
+	for _, k := range a.keys {
+		if subtle.ConstantTimeCompare(k.digest[:], digest[:]) == 1 {
+			userGUID = k.userGUID
+		}
+	}
+
+	if userGUID == uuid.Nil {
+		return uuid.Nil, ErrInvalidCredentials
+	}
+
+	return userGUID, nil
+}
+
+// TrustedHeader takes the user GUID from a header set by a proxy, such as an
+// SSO proxy, that authenticated the user. The header of a request sent by
+// anyone but the proxies is rejected. The proxies are their own list: the
+// ones trusted with the client IP are not trusted with identities.
+type TrustedHeader struct {
+	proxies *clientip.Resolver
+	header  string
+}
+
+func NewTrustedHeader(proxies *clientip.Resolver, header string) *TrustedHeader {
+	return &TrustedHeader{proxies: proxies, header: header}
+}
+
+func (t *TrustedHeader) Authenticate(r *http.Request) (uuid.UUID, error) {
+	value := strings.TrimSpace(r.Header.Get(t.header))
+	if value == "" {
+		return uuid.Nil, ErrNoCredentials
+	}
+
+	if !t.proxies.FromTrustedProxy(r) {
+		return uuid.Nil, fmt.Errorf("%w: %s set by an untrusted client", ErrInvalidCredentials, t.header)
+	}
+
+	userGUID, err := uuid.Parse(value)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%w: %s is not a GUID", ErrInvalidCredentials, t.header)
+	}
+
+	return userGUID, nil
+}
+
+// Insecure authenticates anyone as the user of the user_guid URL parameter,
+// it is meant for development only.
+type Insecure struct{}
+
+func (Insecure) Authenticate(r *http.Request) (uuid.UUID, error) {
+	userGUID, err := uuid.Parse(chi.URLParam(r, "user_guid"))
+	if err != nil {
+		return uuid.Nil, ErrNoCredentials
+	}
+
+	return userGUID, nil
+}
@@ -0,0 +1,297 @@
+package authn_test
+
+import (
+	"auth/internal/config"
+	"auth/internal/database"
+	"auth/internal/lib/authn"
+	sl "auth/internal/lib/logger/sl/sldiscard"
+	"auth/internal/lib/password"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	goodGUID    = uuid.MustParse("d952af16-4251-4ab8-818f-3f3aca064256")
+	serviceGUID = uuid.MustParse("0b7d4a7e-3f43-4c2c-9e64-1d5a4bd1e6c2")
+	params      = password.Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	hasher      = password.NewHasher(params, 4)
+	apiKeys     = []config.APIKey{
+		{Name: "billing", Key: "verysecretbillingkey", UserGUID: serviceGUID.String()},
+	}
+)
+
+// users is a user directory keyed by username.
+type users map[string]database.User
+
+func (u users) GetUserByUsername(ctx context.Context, username string) (database.User, error) {
+	if username == "broken" {
+		return database.User{}, errors.New("Some error")
+	}
+
+	user, ok := u[username]
+	if !ok {
+		return database.User{}, database.ErrUserNotFound
+	}
+
+	return user, nil
+}
+
+func (u users) SetPasswordHash(ctx context.Context, userGUID uuid.UUID, oldHash string, newHash string,
+	revokeTokens bool) error {
+	for username, user := range u {
+		if user.GUID == userGUID && user.PasswordHash == oldHash {
+			user.PasswordHash = newHash
+			u[username] = user
+
+			return nil
+		}
+	}
+
+	return database.ErrUserNotFound
+}
+
+func newUsers(t *testing.T) users {
+	hash, err := password.Hash("secret", params)
+	require.NoError(t, err)
+
+	return users{
+		"user":     {GUID: goodGUID, Username: "user", PasswordHash: hash},
+		"new@mail": {GUID: uuid.New(), Username: "new@mail", Email: "new@mail", PasswordHash: hash},
+		"old@mail": {
+			GUID: serviceGUID, Username: "old@mail", Email: "old@mail", EmailVerified: true, PasswordHash: hash,
+		},
+		"nohash":  {GUID: uuid.New(), Username: "nohash"},
+		"badhash": {GUID: uuid.New(), Username: "badhash", PasswordHash: "some string"},
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	authenticator, err := authn.New(sl.NewDiscardLogger(), config.Authentication{
+		Methods:       []string{config.AuthPassword, config.AuthAPIKey, config.AuthHeader},
+		APIKeys:       apiKeys,
+		Header:        "X-Authenticated-User",
+		HeaderProxies: []string{"10.0.0.1"},
+	}, hasher, newUsers(t))
+	require.NoError(t, err)
+
+	cases := []struct {
+		name       string
+		remoteAddr string
+		username   string
+		password   string
+		headers    map[string]string
+		userGUID   uuid.UUID
+		err        error
+	}{
+		{
+			name:     "Password",
+			username: "user",
+			password: "secret",
+			userGUID: goodGUID,
+		},
+		{
+			name:     "Wrong password",
+			username: "user",
+			password: "Secret",
+			err:      authn.ErrInvalidCredentials,
+		},
+		{
+			name:     "Unknown username",
+			username: "nobody",
+			password: "secret",
+			err:      authn.ErrInvalidCredentials,
+		},
+		{
+			name:     "Verified email",
+			username: "old@mail",
+			password: "secret",
+			userGUID: serviceGUID,
+		},
+		{
+			name:     "Unverified email",
+			username: "new@mail",
+			password: "secret",
+			err:      authn.ErrEmailUnverified,
+		},
+		{
+			name:     "Unverified email with wrong password",
+			username: "new@mail",
+			password: "Secret",
+			err:      authn.ErrInvalidCredentials,
+		},
+		{
+			name:     "User without password",
+			username: "nohash",
+			password: "",
+			err:      authn.ErrInvalidCredentials,
+		},
+		{
+			name:     "API key",
+			headers:  map[string]string{authn.APIKeyHeader: "verysecretbillingkey"},
+			userGUID: serviceGUID,
+		},
+		{
+			name:    "Wrong API key",
+			headers: map[string]string{authn.APIKeyHeader: "verysecretbillingkez"},
+			err:     authn.ErrInvalidCredentials,
+		},
+		{
+			name:       "Header of trusted proxy",
+			remoteAddr: "10.0.0.1:8080",
+			headers:    map[string]string{"X-Authenticated-User": goodGUID.String()},
+			userGUID:   goodGUID,
+		},
+		{
+			name:       "Header of untrusted client",
+			remoteAddr: "192.168.0.1:8080",
+			headers:    map[string]string{"X-Authenticated-User": goodGUID.String()},
+			err:        authn.ErrInvalidCredentials,
+		},
+		{
+			name:       "Header of another proxy",
+			remoteAddr: "10.0.0.2:8080",
+			headers:    map[string]string{"X-Authenticated-User": goodGUID.String()},
+			err:        authn.ErrInvalidCredentials,
+		},
+		{
+			name:       "Header forwarded for a proxy",
+			remoteAddr: "192.168.0.1:8080",
+			headers: map[string]string{
+				"X-Authenticated-User": goodGUID.String(),
+				"X-Forwarded-For":      "10.0.0.1",
+			},
+			err: authn.ErrInvalidCredentials,
+		},
+		{
+			name:       "Header is not a GUID",
+			remoteAddr: "10.0.0.1:8080",
+			headers:    map[string]string{"X-Authenticated-User": "user"},
+			err:        authn.ErrInvalidCredentials,
+		},
+		{
+			name:     "First method with credentials wins",
+			username: "user",
+			password: "Secret",
+			headers:  map[string]string{authn.APIKeyHeader: "verysecretbillingkey"},
+			err:      authn.ErrInvalidCredentials,
+		},
+		{
+			name: "No credentials",
+			err:  authn.ErrNoCredentials,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/"+goodGUID.String(), nil)
+			if tc.remoteAddr != "" {
+				r.RemoteAddr = tc.remoteAddr
+			}
+			if tc.username != "" {
+				r.SetBasicAuth(tc.username, tc.password)
+			}
+			for name, value := range tc.headers {
+				r.Header.Set(name, value)
+			}
+
+			userGUID, err := authenticator.Authenticate(r)
+			require.ErrorIs(t, err, tc.err, "Case: %s", tc.name)
+			require.Equal(t, tc.userGUID, userGUID, "Case: %s", tc.name)
+		})
+	}
+}
+
+func TestPasswordErrors(t *testing.T) {
+	authenticator := authn.NewPassword(sl.NewDiscardLogger(), newUsers(t), hasher)
+
+	for _, username := range []string{"broken", "badhash"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.SetBasicAuth(username, "secret")
+
+		_, err := authenticator.Authenticate(r)
+		require.Error(t, err, "Case: %s", username)
+		require.NotErrorIs(t, err, authn.ErrInvalidCredentials, "Case: %s", username)
+		require.NotErrorIs(t, err, authn.ErrNoCredentials, "Case: %s", username)
+	}
+}
+
+func TestRehash(t *testing.T) {
+	directory := newUsers(t)
+	oldHash := directory["user"].PasswordHash
+
+	stronger := params
+	stronger.Iterations = 2
+
+	authenticator := authn.NewPassword(sl.NewDiscardLogger(), directory, password.NewHasher(stronger, 1))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetBasicAuth("user", "Secret")
+
+	_, err := authenticator.Authenticate(r)
+	require.ErrorIs(t, err, authn.ErrInvalidCredentials)
+	require.Equal(t, oldHash, directory["user"].PasswordHash, "Failed logins do not rehash")
+
+	r.SetBasicAuth("user", "secret")
+
+	userGUID, err := authenticator.Authenticate(r)
+	require.NoError(t, err)
+	require.Equal(t, goodGUID, userGUID)
+	require.NotEqual(t, oldHash, directory["user"].PasswordHash)
+	require.False(t, password.NeedsRehash(directory["user"].PasswordHash, stronger))
+
+	newHash := directory["user"].PasswordHash
+
+	_, err = authenticator.Authenticate(r)
+	require.NoError(t, err)
+	require.Equal(t, newHash, directory["user"].PasswordHash, "Hashes with the parameters are kept")
+}
+
+func TestInsecure(t *testing.T) {
+	authenticator, err := authn.New(sl.NewDiscardLogger(), config.Authentication{
+		Methods:  []string{config.AuthAPIKey},
+		APIKeys:  apiKeys,
+		Insecure: true,
+	}, hasher, newUsers(t))
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Get("/{user_guid}", func(w http.ResponseWriter, r *http.Request) {
+		userGUID, err := authenticator.Authenticate(r)
+		require.NoError(t, err)
+
+		w.Write([]byte(userGUID.String()))
+	})
+
+	cases := []struct {
+		name     string
+		apiKey   string
+		userGUID uuid.UUID
+	}{
+		{name: "Any GUID", userGUID: goodGUID},
+		{name: "Credentials are still checked first", apiKey: "verysecretbillingkey", userGUID: serviceGUID},
+	}
+
+	for _, tc := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/"+goodGUID.String(), nil)
+		if tc.apiKey != "" {
+			r.Header.Set(authn.APIKeyHeader, tc.apiKey)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, r)
+
+		require.Equal(t, tc.userGUID.String(), rr.Body.String(), "Case: %s", tc.name)
+	}
+}
+
+func TestNewAPIKeys(t *testing.T) {
+	_, err := authn.NewAPIKeys([]config.APIKey{{Name: "billing", Key: "verysecretbillingkey", UserGUID: "some string"}})
+	require.Error(t, err)
+}
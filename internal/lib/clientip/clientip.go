@@ -0,0 +1,250 @@
+package clientip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+var (
+	ErrNoClientIP      = errors.New("client IP is empty")
+	ErrInvalidClientIP = errors.New("client IP is invalid")
+)
+
+type ctxKey struct{}
+
+// Forwarding headers a trusted proxy may set.
+const (
+	HeaderForwarded     = "Forwarded"
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderXRealIP       = "X-Real-IP"
+)
+
+// Resolver finds the IP of the client behind trusted proxies. Only the one
+// header the proxies set is read, and only as far as it was added by trusted
+// proxies: the address list is walked right to left, from the proxy closest
+// to the service, and the first address that is not a trusted proxy is the
+// client.
+type Resolver struct {
+	trusted []netip.Prefix
+	header  string
+}
+
+// NewResolver trusts the proxies in the CIDRs, a bare IP is a single proxy,
+// to set the header. The other forwarding headers are ignored, a proxy
+// passes them on from the client as they were sent. Without trusted proxies
+// or a header the headers are ignored.
+func NewResolver(cidrs []string, header string) (*Resolver, error) {
+	const op = "lib.clientip.NewResolver"
+
+	switch header {
+	case "", HeaderForwarded, HeaderXForwardedFor, HeaderXRealIP:
+	default:
+		return nil, fmt.Errorf("%s: Unknown client IP header: %q", op, header)
+	}
+
+	trusted := make([]netip.Prefix, 0, len(cidrs))
+
+	for _, cidr := range cidrs {
+		prefix, err := ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		trusted = append(trusted, prefix)
+	}
+
+	return &Resolver{trusted: trusted, header: header}, nil
+}
+
+// ParsePrefix parses a CIDR or a bare IP, IPv4-mapped IPv6 prefixes are
+// turned into IPv4 ones.
+func ParsePrefix(cidr string) (netip.Prefix, error) {
+	if !strings.Contains(cidr, "/") {
+		addr, err := netip.ParseAddr(cidr)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("Invalid trusted proxy %q: %w", cidr, err)
+		}
+
+		addr = normalize(addr)
+
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("Invalid trusted proxy %q: %w", cidr, err)
+	}
+
+	if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+
+	return prefix.Masked(), nil
+}
+
+// Resolve returns the IP of the client that sent the request.
+func (res *Resolver) Resolve(r *http.Request) (netip.Addr, error) {
+	remote, err := remoteAddr(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	if !res.isTrusted(remote) {
+		return remote, nil
+	}
+
+	var hops []string
+
+	switch res.header {
+	case HeaderForwarded:
+		hops = forwardedFor(r.Header.Values(HeaderForwarded))
+	case HeaderXForwardedFor:
+		hops = splitList(r.Header.Values(HeaderXForwardedFor))
+	case HeaderXRealIP:
+		if value := r.Header.Get(HeaderXRealIP); value != "" {
+			hops = []string{strings.TrimSpace(value)}
+		}
+	}
+
+	client := remote
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := parseHop(hops[i])
+		if err != nil {
+			// An address hidden by a trusted proxy ends the trust, the
+			// proxy is the closest known hop to the client.
+			break
+		}
+
+		client = addr
+
+		if !res.isTrusted(addr) {
+			break
+		}
+	}
+
+	return client, nil
+}
+
+// FromTrustedProxy tells whether the request was sent by a trusted proxy,
+// the only ones whose headers are believed.
+func (res *Resolver) FromTrustedProxy(r *http.Request) bool {
+	remote, err := remoteAddr(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+
+	return res.isTrusted(remote)
+}
+
+func (res *Resolver) isTrusted(addr netip.Addr) bool {
+	for _, prefix := range res.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WithIP stores the client IP resolved by the middleware in the context.
+func WithIP(ctx context.Context, addr netip.Addr) context.Context {
+	return context.WithValue(ctx, ctxKey{}, addr)
+}
+
+// FromRequest returns the client IP stored by the middleware, or the remote
+// address of the request if there is none.
+func FromRequest(r *http.Request) (string, error) {
+	if addr, ok := r.Context().Value(ctxKey{}).(netip.Addr); ok {
+		return addr.String(), nil
+	}
+
+	addr, err := remoteAddr(r.RemoteAddr)
+	if err != nil {
+		return "", err
+	}
+
+	return addr.String(), nil
+}
+
+func remoteAddr(remote string) (netip.Addr, error) {
+	const op = "lib.clientip.remoteAddr"
+
+	if remote == "" {
+		return netip.Addr{}, fmt.Errorf("%s: %w", op, ErrNoClientIP)
+	}
+
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("%s: %w: %w", op, ErrInvalidClientIP, err)
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("%s: %w: %w", op, ErrInvalidClientIP, err)
+	}
+
+	return normalize(addr), nil
+}
+
+// parseHop parses an address of a forwarding header, which may carry a
+// port and, in Forwarded, brackets around IPv6.
+func parseHop(hop string) (netip.Addr, error) {
+	hop = strings.TrimSpace(hop)
+
+	if addrPort, err := netip.ParseAddrPort(hop); err == nil {
+		return normalize(addrPort.Addr()), nil
+	}
+
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	return normalize(addr), nil
+}
+
+// forwardedFor returns the for parameters of the Forwarded headers, as
+// defined by RFC 7239, in order.
+func forwardedFor(values []string) []string {
+	var hops []string
+
+	for _, element := range splitList(values) {
+		hop := ""
+
+		for _, pair := range strings.Split(element, ";") {
+			name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(name, "for") {
+				hop = strings.Trim(value, `"`)
+			}
+		}
+
+		// An element without for still is a hop, of an unknown address.
+		hops = append(hops, hop)
+	}
+
+	return hops
+}
+
+func splitList(values []string) []string {
+	var items []string
+
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			items = append(items, strings.TrimSpace(item))
+		}
+	}
+
+	return items
+}
+
+// normalize turns IPv4-mapped IPv6 addresses into IPv4 and drops zones, so
+// an address has one form whichever way it arrived.
+func normalize(addr netip.Addr) netip.Addr {
+	return addr.Unmap().WithZone("")
+}
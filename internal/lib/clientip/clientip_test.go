@@ -0,0 +1,237 @@
+package clientip_test
+
+import (
+	"auth/internal/lib/clientip"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve(t *testing.T) {
+	cases := []struct {
+		name    string
+		header  string
+		remote  string
+		headers map[string]string
+		ip      string
+		err     error
+	}{
+		{
+			name:   "Direct client",
+			remote: "203.0.113.1:1234",
+			ip:     "203.0.113.1",
+		},
+		{
+			name:    "Headers of untrusted client",
+			remote:  "203.0.113.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Real-IP": "198.51.100.1"},
+			ip:      "203.0.113.1",
+		},
+		{
+			name:    "X-Forwarded-For",
+			remote:  "10.0.0.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			ip:      "198.51.100.1",
+		},
+		{
+			name:    "X-Forwarded-For through trusted proxies",
+			remote:  "10.0.0.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1, 203.0.113.1, 192.168.0.1, 10.0.0.2"},
+			ip:      "203.0.113.1",
+		},
+		{
+			name:    "All hops trusted",
+			remote:  "10.0.0.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"},
+			ip:      "10.0.0.3",
+		},
+		{
+			name:    "Unknown hop",
+			remote:  "10.0.0.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1, unknown, 10.0.0.2"},
+			ip:      "10.0.0.2",
+		},
+		{
+			name:   "Spoofed Forwarded next to X-Forwarded-For",
+			remote: "10.0.0.1:1234",
+			headers: map[string]string{
+				"Forwarded":       "for=1.2.3.4",
+				"X-Forwarded-For": "203.0.113.7",
+			},
+			ip: "203.0.113.7",
+		},
+		{
+			name:   "Spoofed X-Real-IP next to X-Forwarded-For",
+			remote: "10.0.0.1:1234",
+			headers: map[string]string{
+				"X-Forwarded-For": "198.51.100.1",
+				"X-Real-IP":       "1.2.3.4",
+			},
+			ip: "198.51.100.1",
+		},
+		{
+			name:    "X-Real-IP",
+			header:  clientip.HeaderXRealIP,
+			remote:  "10.0.0.1:1234",
+			headers: map[string]string{"X-Real-IP": "198.51.100.1"},
+			ip:      "198.51.100.1",
+		},
+		{
+			name:   "Spoofed X-Forwarded-For next to X-Real-IP",
+			header: clientip.HeaderXRealIP,
+			remote: "10.0.0.1:1234",
+			headers: map[string]string{
+				"X-Forwarded-For": "1.2.3.4",
+				"X-Real-IP":       "203.0.113.7",
+			},
+			ip: "203.0.113.7",
+		},
+		{
+			name:   "Forwarded",
+			header: clientip.HeaderForwarded,
+			remote: "10.0.0.1:1234",
+			headers: map[string]string{
+				"Forwarded":       `for=198.51.100.1;proto=https, for="[2001:db8::1]:4711";by=10.0.0.2, for=10.0.0.2`,
+				"X-Forwarded-For": "198.51.100.2",
+			},
+			ip: "2001:db8::1",
+		},
+		{
+			name:    "Forwarded obfuscated",
+			header:  clientip.HeaderForwarded,
+			remote:  "10.0.0.1:1234",
+			headers: map[string]string{"Forwarded": "for=_hidden, for=10.0.0.2"},
+			ip:      "10.0.0.2",
+		},
+		{
+			name:    "Spoofed X-Forwarded-For next to Forwarded",
+			header:  clientip.HeaderForwarded,
+			remote:  "10.0.0.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "1.2.3.4"},
+			ip:      "10.0.0.1",
+		},
+		{
+			name:    "IPv4-mapped IPv6",
+			remote:  "[::ffff:10.0.0.1]:1234",
+			headers: map[string]string{"X-Forwarded-For": "::ffff:198.51.100.1"},
+			ip:      "198.51.100.1",
+		},
+		{
+			name:    "IPv6 proxy",
+			remote:  "[fd00::1]:1234",
+			headers: map[string]string{"X-Forwarded-For": "2001:db8::1"},
+			ip:      "2001:db8::1",
+		},
+		{
+			name:   "Empty remote address",
+			remote: "",
+			err:    clientip.ErrNoClientIP,
+		},
+		{
+			name:   "Invalid remote address",
+			remote: "some string",
+			err:    clientip.ErrInvalidClientIP,
+		},
+	}
+
+	for _, tc := range cases {
+		header := tc.header
+		if header == "" {
+			header = clientip.HeaderXForwardedFor
+		}
+
+		resolver, err := clientip.NewResolver([]string{"10.0.0.0/8", "192.168.0.1", "fd00::/8"}, header)
+		require.NoError(t, err, "Case: %s", tc.name)
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tc.remote
+		for name, value := range tc.headers {
+			r.Header.Set(name, value)
+		}
+
+		ip, err := resolver.Resolve(r)
+		if tc.err != nil {
+			require.ErrorIs(t, err, tc.err, "Case: %s", tc.name)
+			continue
+		}
+
+		require.NoError(t, err, "Case: %s", tc.name)
+		require.Equal(t, tc.ip, ip.String(), "Case: %s", tc.name)
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	resolver, err := clientip.NewResolver([]string{"10.0.0.0/8"}, clientip.HeaderXForwardedFor)
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+
+	ip, err := clientip.FromRequest(r)
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.1", ip, "Remote address without the middleware")
+
+	addr, err := resolver.Resolve(r)
+	require.NoError(t, err)
+
+	ip, err = clientip.FromRequest(r.WithContext(clientip.WithIP(r.Context(), addr)))
+	require.NoError(t, err)
+	require.Equal(t, "198.51.100.1", ip)
+}
+
+func TestParsePrefix(t *testing.T) {
+	cases := []struct {
+		cidr   string
+		prefix string
+	}{
+		{cidr: "10.0.0.1", prefix: "10.0.0.1/32"},
+		{cidr: "10.1.2.3/8", prefix: "10.0.0.0/8"},
+		{cidr: "::ffff:10.0.0.0/104", prefix: "10.0.0.0/8"},
+		{cidr: "2001:db8::1", prefix: "2001:db8::1/128"},
+	}
+
+	for _, tc := range cases {
+		prefix, err := clientip.ParsePrefix(tc.cidr)
+		require.NoError(t, err, "Case: %s", tc.cidr)
+		require.Equal(t, tc.prefix, prefix.String(), "Case: %s", tc.cidr)
+	}
+
+	for _, cidr := range []string{"", "10.0.0.0/33", "some string"} {
+		_, err := clientip.ParsePrefix(cidr)
+		require.Error(t, err, "Case: %q", cidr)
+	}
+
+	_, err := clientip.NewResolver([]string{"10.0.0.0/8", "some string"}, clientip.HeaderXForwardedFor)
+	require.Error(t, err)
+
+	_, err = clientip.NewResolver([]string{"10.0.0.0/8"}, "X-Client-IP")
+	require.Error(t, err)
+}
+
+func TestFromTrustedProxy(t *testing.T) {
+	resolver, err := clientip.NewResolver([]string{"10.0.0.0/8", "::1"}, "")
+	require.NoError(t, err)
+
+	cases := []struct {
+		remoteAddr string
+		trusted    bool
+	}{
+		{remoteAddr: "10.1.2.3:8080", trusted: true},
+		{remoteAddr: "[::1]:8080", trusted: true},
+		{remoteAddr: "[::ffff:10.0.0.1]:8080", trusted: true},
+		{remoteAddr: "192.168.0.1:8080", trusted: false},
+		{remoteAddr: "", trusted: false},
+		{remoteAddr: "some string", trusted: false},
+	}
+
+	for _, tc := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tc.remoteAddr
+		r.Header.Set("X-Forwarded-For", "10.0.0.2")
+
+		require.Equal(t, tc.trusted, resolver.FromTrustedProxy(r), "Case: %s", tc.remoteAddr)
+	}
+}
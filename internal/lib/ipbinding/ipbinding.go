@@ -0,0 +1,160 @@
+package ipbinding
+
+import (
+	"net/netip"
+
+	"auth/internal/config"
+)
+
+// Modes of the policy, what a refresh from another IP than the one the
+// access token was issued to does.
+const (
+	// ModeIgnore allows the refresh silently.
+	ModeIgnore = "ignore"
+	// ModeWarn allows the refresh and emails the user.
+	ModeWarn = "warn"
+	// ModeSameSubnet allows the refresh from the same subnet and rejects
+	// it from any other, so the churn of carrier NAT does not log users out.
+	ModeSameSubnet = "require-same-subnet"
+	// ModeReject rejects the refresh.
+	ModeReject = "reject"
+)
+
+// Decisions on a refresh.
+const (
+	DecisionAllow  = "allow"
+	DecisionWarn   = "warn"
+	DecisionReject = "reject"
+)
+
+const (
+	defaultIPv4Prefix = 24
+	defaultIPv6Prefix = 64
+)
+
+// Policy decides on refreshes of the tokens of a client.
+type Policy struct {
+	Mode string
+	// IPv4Prefix and IPv6Prefix are the lengths of the subnets of
+	// ModeSameSubnet.
+	IPv4Prefix int
+	IPv6Prefix int
+}
+
+// Policies holds the default policy and the ones of the clients overriding
+// it.
+type Policies struct {
+	def     Policy
+	clients map[string]Policy
+}
+
+// New returns the policies of the config. The fields a client leaves zero
+// are taken from the default policy.
+func New(cfg config.IPBinding) *Policies {
+	def := Policy{
+		Mode:       cfg.Policy,
+		IPv4Prefix: cfg.IPv4Prefix,
+		IPv6Prefix: cfg.IPv6Prefix,
+	}
+	if def.Mode == "" {
+		def.Mode = ModeWarn
+	}
+	if def.IPv4Prefix <= 0 {
+		def.IPv4Prefix = defaultIPv4Prefix
+	}
+	if def.IPv6Prefix <= 0 {
+		def.IPv6Prefix = defaultIPv6Prefix
+	}
+
+	p := &Policies{
+		def:     def,
+		clients: make(map[string]Policy, len(cfg.Clients)),
+	}
+
+	for _, client := range cfg.Clients {
+		policy := def
+		if client.Policy != "" {
+			policy.Mode = client.Policy
+		}
+		if client.IPv4Prefix > 0 {
+			policy.IPv4Prefix = client.IPv4Prefix
+		}
+		if client.IPv6Prefix > 0 {
+			policy.IPv6Prefix = client.IPv6Prefix
+		}
+
+		p.clients[client.ID] = policy
+	}
+
+	return p
+}
+
+// Known reports whether the client has a policy of its own.
+func (p *Policies) Known(clientID string) bool {
+	_, ok := p.clients[clientID]
+
+	return ok
+}
+
+// For returns the policy of the client, the default one for unknown
+// clients and tokens issued without a client.
+func (p *Policies) For(clientID string) Policy {
+	if policy, ok := p.clients[clientID]; ok {
+		return policy
+	}
+
+	return p.def
+}
+
+// Check decides on a refresh from the current IP of a token issued to the
+// previous one. The same IP is always allowed.
+func (p Policy) Check(previousIP string, currentIP string) string {
+	if previousIP == currentIP {
+		return DecisionAllow
+	}
+
+	switch p.Mode {
+	case ModeIgnore:
+		return DecisionAllow
+	case ModeSameSubnet:
+		if p.SameSubnet(previousIP, currentIP) {
+			return DecisionAllow
+		}
+		return DecisionReject
+	case ModeReject:
+		return DecisionReject
+	default:
+		return DecisionWarn
+	}
+}
+
+// SameSubnet reports whether both IPs are in one subnet of the prefix
+// length of their family. IPs of different families never are.
+func (p Policy) SameSubnet(a string, b string) bool {
+	addrA, err := netip.ParseAddr(a)
+	if err != nil {
+		return false
+	}
+
+	addrB, err := netip.ParseAddr(b)
+	if err != nil {
+		return false
+	}
+
+	addrA, addrB = addrA.Unmap(), addrB.Unmap()
+	if addrA.Is4() != addrB.Is4() {
+		return false
+	}
+
+	bits := p.IPv6Prefix
+	if addrA.Is4() {
+		bits = p.IPv4Prefix
+	}
+
+	prefix, err := addrA.Prefix(min(bits, addrA.BitLen()))
+	if err != nil {
+		return false
+	}
+
+	return prefix.Contains(addrB)
+}
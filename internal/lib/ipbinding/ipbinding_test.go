@@ -0,0 +1,126 @@
+package ipbinding_test
+
+import (
+	"auth/internal/config"
+	"auth/internal/lib/ipbinding"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	subnet := ipbinding.Policy{Mode: ipbinding.ModeSameSubnet, IPv4Prefix: 24, IPv6Prefix: 48}
+
+	cases := []struct {
+		name       string
+		policy     ipbinding.Policy
+		previousIP string
+		ip         string
+		decision   string
+	}{
+		{
+			name:       "Same IP is rejected by nothing",
+			policy:     ipbinding.Policy{Mode: ipbinding.ModeReject},
+			previousIP: "10.0.0.1",
+			ip:         "10.0.0.1",
+			decision:   ipbinding.DecisionAllow,
+		},
+		{
+			name:       "Ignore",
+			policy:     ipbinding.Policy{Mode: ipbinding.ModeIgnore},
+			previousIP: "10.0.0.1",
+			ip:         "10.0.1.1",
+			decision:   ipbinding.DecisionAllow,
+		},
+		{
+			name:       "Warn",
+			policy:     ipbinding.Policy{Mode: ipbinding.ModeWarn},
+			previousIP: "10.0.0.1",
+			ip:         "10.0.1.1",
+			decision:   ipbinding.DecisionWarn,
+		},
+		{
+			name:       "Reject",
+			policy:     ipbinding.Policy{Mode: ipbinding.ModeReject},
+			previousIP: "10.0.0.1",
+			ip:         "10.0.0.2",
+			decision:   ipbinding.DecisionReject,
+		},
+		{
+			name:       "Same IPv4 subnet",
+			policy:     subnet,
+			previousIP: "10.0.0.1",
+			ip:         "10.0.0.254",
+			decision:   ipbinding.DecisionAllow,
+		},
+		{
+			name:       "Another IPv4 subnet",
+			policy:     subnet,
+			previousIP: "10.0.0.1",
+			ip:         "10.0.1.1",
+			decision:   ipbinding.DecisionReject,
+		},
+		{
+			name:       "Same IPv6 subnet",
+			policy:     subnet,
+			previousIP: "2001:db8:1:1::1",
+			ip:         "2001:db8:1:2::1",
+			decision:   ipbinding.DecisionAllow,
+		},
+		{
+			name:       "Another IPv6 subnet",
+			policy:     subnet,
+			previousIP: "2001:db8:1::1",
+			ip:         "2001:db8:2::1",
+			decision:   ipbinding.DecisionReject,
+		},
+		{
+			name:       "IPv4-mapped IPv6",
+			policy:     subnet,
+			previousIP: "::ffff:10.0.0.1",
+			ip:         "10.0.0.2",
+			decision:   ipbinding.DecisionAllow,
+		},
+		{
+			name:       "Another family",
+			policy:     subnet,
+			previousIP: "10.0.0.1",
+			ip:         "2001:db8::1",
+			decision:   ipbinding.DecisionReject,
+		},
+		{
+			name:       "Invalid previous IP",
+			policy:     subnet,
+			previousIP: "some string",
+			ip:         "10.0.0.1",
+			decision:   ipbinding.DecisionReject,
+		},
+	}
+
+	for _, tc := range cases {
+		require.Equal(t, tc.decision, tc.policy.Check(tc.previousIP, tc.ip), "Case: %s", tc.name)
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	policies := ipbinding.New(config.IPBinding{
+		Policy: ipbinding.ModeSameSubnet,
+		Clients: []config.IPBindingClient{
+			{ID: "mobile", IPv4Prefix: 16},
+			{ID: "desktop", Policy: ipbinding.ModeReject},
+		},
+	})
+
+	require.Equal(t, ipbinding.Policy{Mode: ipbinding.ModeSameSubnet, IPv4Prefix: 24, IPv6Prefix: 64},
+		policies.For(""), "Default prefixes")
+	require.Equal(t, ipbinding.Policy{Mode: ipbinding.ModeSameSubnet, IPv4Prefix: 16, IPv6Prefix: 64},
+		policies.For("mobile"))
+	require.Equal(t, ipbinding.Policy{Mode: ipbinding.ModeReject, IPv4Prefix: 24, IPv6Prefix: 64},
+		policies.For("desktop"))
+	require.Equal(t, policies.For(""), policies.For("web"), "Unknown client")
+
+	require.True(t, policies.Known("mobile"))
+	require.False(t, policies.Known("web"))
+
+	require.Equal(t, ipbinding.ModeWarn, ipbinding.New(config.IPBinding{}).For("").Mode)
+}
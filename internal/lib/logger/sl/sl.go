@@ -10,3 +10,8 @@ func Err(err error) slog.Attr {
 		Value: slog.StringValue(err.Error()),
 	}
 }
+
+// Event marks a record as a security event, so it can be routed to alerting.
+func Event(name string) slog.Attr {
+	return slog.String("security_event", name)
+}
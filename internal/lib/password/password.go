@@ -0,0 +1,223 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+
+	"auth/internal/config"
+)
+
+var ErrInvalidHash = errors.New("invalid password hash")
+
+// Params are the Argon2id parameters of a hash. Memory is in KiB.
+type Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultParams follow the second recommended option of RFC 9106 with the
+// memory lowered to 64 MiB.
+var DefaultParams = Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func NewParams(cfg config.PasswordHashing) Params {
+	return Params{
+		Memory:      cfg.Memory,
+		Iterations:  cfg.Iterations,
+		Parallelism: cfg.Parallelism,
+		SaltLength:  cfg.SaltLength,
+		KeyLength:   cfg.KeyLength,
+	}
+}
+
+var encoding = base64.RawStdEncoding
+
+// Hash hashes the password with a random salt. The hash is encoded in the
+// PHC string format, $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>, so it
+// carries its parameters.
+func Hash(password string, params Params) (string, error) {
+	const op = "lib.password.Hash"
+
+	salt := make([]byte, params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("%s: Generating salt error: %w", op, err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+		params.Memory, params.Iterations, params.Parallelism,
+		encoding.EncodeToString(salt), encoding.EncodeToString(key)), nil
+}
+
+// Verify tells whether the password matches the hash, with the parameters
+// of the hash.
+func Verify(password string, hash string) (bool, error) {
+	const op = "lib.password.Verify"
+
+	params, salt, key, err := decode(hash)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+// NeedsRehash tells whether the hash was made with other parameters, so it
+// should be replaced by a hash with params on the next login. Hashes that
+// can not be decoded need it too.
+func NeedsRehash(hash string, params Params) bool {
+	hashParams, _, _, err := decode(hash)
+	if err != nil {
+		return true
+	}
+
+	return hashParams != params
+}
+
+// ErrBusy tells every hashing slot of a Hasher is taken.
+var ErrBusy = errors.New("password hashing is busy")
+
+const defaultMaxConcurrent = 4
+
+// Hasher hashes and verifies passwords with at most maxConcurrent Argon2id
+// runs at a time. Every run takes the memory of its parameters, so bursts of
+// logins would otherwise exhaust the memory of the server. Calls finding no
+// free slot fail with ErrBusy at once instead of queueing.
+type Hasher struct {
+	params Params
+	slots  chan struct{}
+	// dummy is a hash of nothing with the parameters of the hasher, it is
+	// hashed on first use.
+	dummy func() string
+}
+
+func NewHasher(params Params, maxConcurrent int) *Hasher {
+	if maxConcurrent <= 0 {
+		maxConcurrent = defaultMaxConcurrent
+	}
+
+	return &Hasher{
+		params: params,
+		slots:  make(chan struct{}, maxConcurrent),
+		dummy: sync.OnceValue(func() string {
+			hash, _ := Hash("", params)
+			return hash
+		}),
+	}
+}
+
+// Hash hashes the password with the parameters of the hasher.
+func (h *Hasher) Hash(password string) (string, error) {
+	const op = "lib.password.Hasher.Hash"
+
+	if !h.acquire() {
+		return "", fmt.Errorf("%s: %w", op, ErrBusy)
+	}
+	defer h.release()
+
+	return Hash(password, h.params)
+}
+
+// Verify tells whether the password matches the hash.
+func (h *Hasher) Verify(password string, hash string) (bool, error) {
+	const op = "lib.password.Hasher.Verify"
+
+	if !h.acquire() {
+		return false, fmt.Errorf("%s: %w", op, ErrBusy)
+	}
+	defer h.release()
+
+	return Verify(password, hash)
+}
+
+// Dummy verifies the password against a hash of nothing, it is called when
+// a user is not found to take as long as Verify, so the time taken does not
+// reveal which usernames exist. The hash has the parameters of the hasher,
+// the ones of the hashes of existing users. Dummy takes a slot like Verify,
+// so a busy hasher fails unknown usernames the same way as known ones.
+func (h *Hasher) Dummy(password string) error {
+	const op = "lib.password.Hasher.Dummy"
+
+	if !h.acquire() {
+		return fmt.Errorf("%s: %w", op, ErrBusy)
+	}
+	defer h.release()
+
+	Verify(password, h.dummy())
+
+	return nil
+}
+
+// NeedsRehash tells whether the hash was made with other parameters than
+// the ones of the hasher.
+func (h *Hasher) NeedsRehash(hash string) bool {
+	return NeedsRehash(hash, h.params)
+}
+
+func (h *Hasher) acquire() bool {
+	select {
+	case h.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (h *Hasher) release() {
+	<-h.slots
+}
+
+func decode(hash string) (Params, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Params{}, nil, nil, fmt.Errorf("%w: unsupported version %q", ErrInvalidHash, parts[2])
+	}
+
+	var params Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations,
+		&params.Parallelism); err != nil {
+		return Params{}, nil, nil, fmt.Errorf("%w: %w", ErrInvalidHash, err)
+	}
+
+	if params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 {
+		return Params{}, nil, nil, fmt.Errorf("%w: zero parameter", ErrInvalidHash)
+	}
+
+	salt, err := encoding.DecodeString(parts[4])
+	if err != nil {
+		return Params{}, nil, nil, fmt.Errorf("%w: %w", ErrInvalidHash, err)
+	}
+
+	key, err := encoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Params{}, nil, nil, fmt.Errorf("%w: invalid key", ErrInvalidHash)
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
@@ -0,0 +1,173 @@
+package password_test
+
+import (
+	"auth/internal/lib/password"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var params = password.Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestHash(t *testing.T) {
+	hash, err := password.Hash("secret", params)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"), hash)
+
+	other, err := password.Hash("secret", params)
+	require.NoError(t, err)
+	require.NotEqual(t, hash, other, "Every hash has a salt of its own")
+}
+
+func TestVerify(t *testing.T) {
+	hash, err := password.Hash("secret", params)
+	require.NoError(t, err)
+
+	cases := []struct {
+		name     string
+		password string
+		hash     string
+		match    bool
+		err      error
+	}{
+		{
+			name:     "Match",
+			password: "secret",
+			hash:     hash,
+			match:    true,
+		},
+		{
+			name:     "Wrong password",
+			password: "Secret",
+			hash:     hash,
+		},
+		{
+			name:     "Empty password",
+			password: "",
+			hash:     hash,
+		},
+		{
+			name:     "Empty hash",
+			password: "secret",
+			hash:     "",
+			err:      password.ErrInvalidHash,
+		},
+		{
+			name:     "Another algorithm",
+			password: "secret",
+			hash:     strings.Replace(hash, "argon2id", "argon2i", 1),
+			err:      password.ErrInvalidHash,
+		},
+		{
+			name:     "Another version",
+			password: "secret",
+			hash:     strings.Replace(hash, "v=19", "v=16", 1),
+			err:      password.ErrInvalidHash,
+		},
+		{
+			name:     "Invalid parameters",
+			password: "secret",
+			hash:     strings.Replace(hash, "m=1024,t=1,p=1", "m=1024,t=0,p=1", 1),
+			err:      password.ErrInvalidHash,
+		},
+		{
+			name:     "Bcrypt hash",
+			password: "secret",
+			hash:     "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy",
+			err:      password.ErrInvalidHash,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			match, err := password.Verify(tc.password, tc.hash)
+			require.ErrorIs(t, err, tc.err, "Case: %s", tc.name)
+			require.Equal(t, tc.match, match, "Case: %s", tc.name)
+		})
+	}
+}
+
+func TestVerifyParams(t *testing.T) {
+	hash, err := password.Hash("secret", password.Params{
+		Memory: 2048, Iterations: 2, Parallelism: 2, SaltLength: 8, KeyLength: 16,
+	})
+	require.NoError(t, err)
+
+	match, err := password.Verify("secret", hash)
+	require.NoError(t, err)
+	require.True(t, match, "Hashes are verified with their own parameters")
+}
+
+func TestNeedsRehash(t *testing.T) {
+	hash, err := password.Hash("secret", params)
+	require.NoError(t, err)
+
+	stronger := params
+	stronger.Iterations = 2
+
+	longer := params
+	longer.KeyLength = 64
+
+	cases := []struct {
+		name   string
+		hash   string
+		params password.Params
+		rehash bool
+	}{
+		{name: "Same parameters", hash: hash, params: params},
+		{name: "More iterations", hash: hash, params: stronger, rehash: true},
+		{name: "Longer key", hash: hash, params: longer, rehash: true},
+		{name: "Invalid hash", hash: "some string", params: params, rehash: true},
+	}
+
+	for _, tc := range cases {
+		require.Equal(t, tc.rehash, password.NeedsRehash(tc.hash, tc.params), "Case: %s", tc.name)
+	}
+}
+
+func TestHasherBusy(t *testing.T) {
+	// Hashes of these parameters take long enough for the calls started
+	// together to overlap.
+	hasher := password.NewHasher(password.Params{
+		Memory: 32 * 1024, Iterations: 4, Parallelism: 1, SaltLength: 16, KeyLength: 32,
+	}, 1)
+
+	start := make(chan struct{})
+	errs := make(chan error, 4)
+
+	var wg sync.WaitGroup
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, err := hasher.Hash("secret")
+			errs <- err
+		}()
+	}
+
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	var hashed, busy int
+	for err := range errs {
+		switch {
+		case err == nil:
+			hashed++
+		case errors.Is(err, password.ErrBusy):
+			busy++
+		default:
+			require.NoError(t, err)
+		}
+	}
+
+	require.Positive(t, hashed)
+	require.Positive(t, busy, "Calls finding no free slot fail")
+
+	_, err := hasher.Hash("secret")
+	require.NoError(t, err, "Slots are released")
+}
@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/render"
+
+	resp "auth/internal/lib/api/response"
+)
+
+// Codes of the responses to limited requests.
+const (
+	CodeRateLimited = "rate_limited"
+	CodeLockedOut   = "locked_out"
+)
+
+// WriteHeaders sets the RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers of the result, unless a bucket with fewer
+// remaining tokens has set them already for the request.
+func WriteHeaders(w http.ResponseWriter, result Result) {
+	if result.Limit == 0 {
+		return
+	}
+
+	header := w.Header()
+
+	if remaining, err := strconv.Atoi(header.Get("RateLimit-Remaining")); err == nil &&
+		remaining < result.Remaining {
+		return
+	}
+
+	header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("RateLimit-Reset", ceilSeconds(result.Reset))
+}
+
+// Deny answers a request the result does not allow with 429 and the
+// Retry-After header.
+func Deny(w http.ResponseWriter, r *http.Request, result Result) {
+	WriteHeaders(w, result)
+	w.Header().Set("Retry-After", ceilSeconds(result.RetryAfter))
+
+	render.Status(r, http.StatusTooManyRequests)
+
+	if result.Locked {
+		render.JSON(w, r, resp.ErrorCode(CodeLockedOut, "Too many invalid attempts, try again later"))
+	} else {
+		render.JSON(w, r, resp.ErrorCode(CodeRateLimited, "Too many requests"))
+	}
+}
+
+// ceilSeconds rounds up, so a client waiting that long is not limited again.
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
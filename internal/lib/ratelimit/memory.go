@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often MemoryStore deletes expired states.
+const sweepInterval = time.Minute
+
+// MemoryStore keeps the states in memory, so every replica limits requests
+// on its own. Expired states are swept along with updates, so the store
+// needs no purge job.
+type MemoryStore struct {
+	mu      sync.Mutex
+	states  map[string]State
+	sweptAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		states:  make(map[string]State),
+		sweptAt: time.Now(),
+	}
+}
+
+func (m *MemoryStore) UpdateRateLimit(ctx context.Context, key string, fn func(State) State) (State, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if now := time.Now(); now.Sub(m.sweptAt) >= sweepInterval {
+		m.deleteExpired(now)
+		m.sweptAt = now
+	}
+
+	s := fn(m.states[key])
+	m.states[key] = s
+
+	return s, nil
+}
+
+func (m *MemoryStore) PurgeRateLimits(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.deleteExpired(expiredBefore), nil
+}
+
+func (m *MemoryStore) deleteExpired(expiredBefore time.Time) int64 {
+	var deleted int64
+
+	for key, s := range m.states {
+		if s.ExpiresAt.Before(expiredBefore) {
+			delete(m.states, key)
+			deleted++
+		}
+	}
+
+	return deleted
+}
@@ -0,0 +1,43 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"auth/internal/scheduler"
+)
+
+const (
+	PurgeJobName = "purge_rate_limits"
+
+	defaultPurgeInterval = 10 * time.Minute
+)
+
+// NewPurgeJob returns the job deleting the states of full buckets whose
+// lockout is over, they are the same as missing ones.
+func NewPurgeJob(log *slog.Logger, store Store, interval time.Duration) scheduler.Job {
+	if interval <= 0 {
+		interval = defaultPurgeInterval
+	}
+
+	return scheduler.Job{
+		Name:     PurgeJobName,
+		Interval: interval,
+		Run: func(ctx context.Context) error {
+			const op = "lib.ratelimit.PurgeJob"
+
+			deleted, err := store.PurgeRateLimits(ctx, time.Now())
+			if err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+
+			if deleted > 0 {
+				log.Debug("Purged rate limits", slog.Int64("deleted", deleted))
+			}
+
+			return nil
+		},
+	}
+}
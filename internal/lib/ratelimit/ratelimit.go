@@ -0,0 +1,211 @@
+package ratelimit
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"math"
+	"time"
+
+	"auth/internal/config"
+)
+
+// Kinds of keys, a bucket is kept for every key of every kind.
+const (
+	KindIP      = "ip"
+	KindGUID    = "guid"
+	KindBindKey = "bind_key"
+)
+
+// failuresPrefix keys the buckets counting the invalid refreshes of a key.
+const failuresPrefix = "failures:"
+
+// metrics counts limited and locked out requests, they are published with
+// expvar under "ratelimit".
+var metrics = expvar.NewMap("ratelimit")
+
+// State is the stored state of the bucket of a key. The zero State is a
+// full bucket.
+type State struct {
+	Tokens    float64
+	UpdatedAt time.Time
+	// LockedUntil locks the key out, the bucket is not taken from until then.
+	LockedUntil time.Time
+	// ExpiresAt is when the bucket is full again and the lock is over, from
+	// then on the State may be deleted.
+	ExpiresAt time.Time
+}
+
+// Store keeps the states of buckets.
+type Store interface {
+	// UpdateRateLimit replaces the state of the key with the one returned by
+	// fn, atomically, and returns it. fn gets the zero State for a new key.
+	UpdateRateLimit(ctx context.Context, key string, fn func(State) State) (State, error)
+	// PurgeRateLimits deletes the states that expired before the time.
+	PurgeRateLimits(ctx context.Context, expiredBefore time.Time) (int64, error)
+}
+
+// Result is the outcome of a request taking a token.
+type Result struct {
+	Allowed bool
+	// Locked tells the key is locked out after invalid refreshes.
+	Locked bool
+	// Limit is the size of the bucket and Remaining the tokens left in it.
+	Limit     int
+	Remaining int
+	// Reset is when the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is when the next request is allowed, if this one is not.
+	RetryAfter time.Duration
+}
+
+// Limiter takes tokens from the buckets of the keys of requests. A nil
+// Limiter allows every request.
+type Limiter struct {
+	store   Store
+	limits  map[string]config.Limit
+	lockout config.Lockout
+	now     func() time.Time
+}
+
+func New(store Store, cfg config.RateLimit) *Limiter {
+	return &Limiter{
+		store: store,
+		limits: map[string]config.Limit{
+			KindIP:      cfg.IP,
+			KindGUID:    cfg.GUID,
+			KindBindKey: cfg.BindKey,
+		},
+		lockout: cfg.Lockout,
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from the bucket of the key of the kind.
+func (l *Limiter) Allow(ctx context.Context, kind string, key string) (Result, error) {
+	const op = "lib.ratelimit.Allow"
+
+	if l == nil {
+		return Result{Allowed: true}, nil
+	}
+
+	limit := l.limits[kind]
+	now := l.now()
+
+	var result Result
+
+	_, err := l.store.UpdateRateLimit(ctx, kind+":"+key, func(s State) State {
+		s, result = take(s, limit, now)
+		return s
+	})
+	if err != nil {
+		return Result{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	switch {
+	case result.Locked:
+		metrics.Add("locked."+kind, 1)
+	case !result.Allowed:
+		metrics.Add("limited."+kind, 1)
+	}
+
+	return result, nil
+}
+
+// Fail records an invalid refresh of the key of the kind. The key is locked
+// out once it fails more than the lockout allows within its window, and
+// Fail then returns when the lockout ends.
+func (l *Limiter) Fail(ctx context.Context, kind string, key string) (time.Time, error) {
+	const op = "lib.ratelimit.Fail"
+
+	if l == nil {
+		return time.Time{}, nil
+	}
+
+	key = kind + ":" + key
+	now := l.now()
+
+	failures := config.Limit{
+		Requests: l.lockout.MaxFailures,
+		Period:   l.lockout.Window,
+	}
+
+	var result Result
+
+	_, err := l.store.UpdateRateLimit(ctx, failuresPrefix+key, func(s State) State {
+		s, result = take(s, failures, now)
+		return s
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if result.Allowed {
+		return time.Time{}, nil
+	}
+
+	lockedUntil := now.Add(l.lockout.Duration)
+
+	_, err = l.store.UpdateRateLimit(ctx, key, func(s State) State {
+		s.LockedUntil = lockedUntil
+		s.ExpiresAt = maxTime(s.ExpiresAt, lockedUntil)
+		return s
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return lockedUntil, nil
+}
+
+// take refills the bucket for the time passed since its last update and
+// takes a token from it, unless the key is locked out.
+func take(s State, limit config.Limit, now time.Time) (State, Result) {
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = limit.Requests
+	}
+
+	capacity := float64(burst)
+	// rate is the number of tokens refilled per second.
+	rate := float64(limit.Requests) / limit.Period.Seconds()
+
+	if s.UpdatedAt.IsZero() {
+		s.Tokens = capacity
+	} else if elapsed := now.Sub(s.UpdatedAt).Seconds(); elapsed > 0 {
+		s.Tokens = math.Min(capacity, s.Tokens+elapsed*rate)
+	}
+	s.UpdatedAt = now
+
+	result := Result{Limit: burst}
+
+	switch {
+	case now.Before(s.LockedUntil):
+		result.Locked = true
+		result.RetryAfter = s.LockedUntil.Sub(now)
+	case s.Tokens >= 1:
+		s.Tokens--
+		result.Allowed = true
+	default:
+		result.RetryAfter = seconds((1 - s.Tokens) / rate)
+	}
+
+	result.Remaining = int(s.Tokens)
+	result.Reset = seconds((capacity - s.Tokens) / rate)
+
+	s.ExpiresAt = maxTime(now.Add(result.Reset), s.LockedUntil)
+
+	return s, result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func maxTime(a time.Time, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+
+	return b
+}
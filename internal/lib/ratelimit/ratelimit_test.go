@@ -0,0 +1,235 @@
+package ratelimit_test
+
+import (
+	"auth/internal/config"
+	"auth/internal/database/storagetest"
+	"auth/internal/lib/ratelimit"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllow(t *testing.T) {
+	cases := []struct {
+		name     string
+		limit    config.Limit
+		requests int
+		allowed  int
+	}{
+		{
+			name:     "Under limit",
+			limit:    config.Limit{Requests: 5, Period: time.Hour},
+			requests: 3,
+			allowed:  3,
+		},
+		{
+			name:     "Over limit",
+			limit:    config.Limit{Requests: 5, Period: time.Hour},
+			requests: 8,
+			allowed:  5,
+		},
+		{
+			name:     "Burst over requests",
+			limit:    config.Limit{Requests: 2, Period: time.Hour, Burst: 4},
+			requests: 6,
+			allowed:  4,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			limiter := ratelimit.New(ratelimit.NewMemoryStore(), config.RateLimit{IP: tc.limit})
+
+			var allowed int
+			var last ratelimit.Result
+
+			for i := 0; i < tc.requests; i++ {
+				result, err := limiter.Allow(context.Background(), ratelimit.KindIP, "10.0.0.1")
+				require.NoError(t, err, "Case: %s", tc.name)
+
+				if result.Allowed {
+					allowed++
+				}
+				last = result
+			}
+
+			require.Equal(t, tc.allowed, allowed, "Case: %s", tc.name)
+			require.Equal(t, tc.requests == tc.allowed, last.Allowed, "Case: %s", tc.name)
+			require.False(t, last.Locked, "Case: %s", tc.name)
+
+			if !last.Allowed {
+				require.Equal(t, 0, last.Remaining, "Case: %s", tc.name)
+				require.Positive(t, last.RetryAfter, "Case: %s", tc.name)
+			}
+		})
+	}
+}
+
+func TestAllowKeys(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.NewMemoryStore(), config.RateLimit{
+		IP:   config.Limit{Requests: 1, Period: time.Hour},
+		GUID: config.Limit{Requests: 1, Period: time.Hour},
+	})
+
+	for _, kind := range []string{ratelimit.KindIP, ratelimit.KindGUID} {
+		for _, key := range []string{"first", "second"} {
+			result, err := limiter.Allow(context.Background(), kind, key)
+			require.NoError(t, err)
+			require.True(t, result.Allowed, "Every key of every kind has a bucket of its own")
+		}
+	}
+}
+
+func TestAllowRefill(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.NewMemoryStore(), config.RateLimit{
+		IP: config.Limit{Requests: 1, Period: 50 * time.Millisecond},
+	})
+
+	result, err := limiter.Allow(context.Background(), ratelimit.KindIP, "10.0.0.1")
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+
+	result, err = limiter.Allow(context.Background(), ratelimit.KindIP, "10.0.0.1")
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+
+	time.Sleep(result.RetryAfter)
+
+	result, err = limiter.Allow(context.Background(), ratelimit.KindIP, "10.0.0.1")
+	require.NoError(t, err)
+	require.True(t, result.Allowed, "The bucket is refilled")
+}
+
+func TestFail(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.NewMemoryStore(), config.RateLimit{
+		IP: config.Limit{Requests: 100, Period: time.Minute},
+		Lockout: config.Lockout{
+			MaxFailures: 3,
+			Window:      time.Hour,
+			Duration:    time.Hour,
+		},
+	})
+
+	for i := 0; i < 3; i++ {
+		lockedUntil, err := limiter.Fail(context.Background(), ratelimit.KindIP, "10.0.0.1")
+		require.NoError(t, err)
+		require.True(t, lockedUntil.IsZero(), "Failure %d is under the lockout", i+1)
+	}
+
+	lockedUntil, err := limiter.Fail(context.Background(), ratelimit.KindIP, "10.0.0.1")
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(time.Hour), lockedUntil, time.Minute)
+
+	result, err := limiter.Allow(context.Background(), ratelimit.KindIP, "10.0.0.1")
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+	require.True(t, result.Locked)
+	require.InDelta(t, time.Hour, result.RetryAfter, float64(time.Minute))
+
+	result, err = limiter.Allow(context.Background(), ratelimit.KindIP, "10.0.0.2")
+	require.NoError(t, err)
+	require.True(t, result.Allowed, "Other keys are not locked out")
+}
+
+func TestNilLimiter(t *testing.T) {
+	var limiter *ratelimit.Limiter
+
+	result, err := limiter.Allow(context.Background(), ratelimit.KindIP, "10.0.0.1")
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+
+	lockedUntil, err := limiter.Fail(context.Background(), ratelimit.KindIP, "10.0.0.1")
+	require.NoError(t, err)
+	require.True(t, lockedUntil.IsZero())
+}
+
+func TestWriteHeaders(t *testing.T) {
+	cases := []struct {
+		name      string
+		results   []ratelimit.Result
+		limit     string
+		remaining string
+		reset     string
+	}{
+		{
+			name:    "No limit",
+			results: []ratelimit.Result{{Allowed: true}},
+		},
+		{
+			name:      "Single bucket",
+			results:   []ratelimit.Result{{Allowed: true, Limit: 60, Remaining: 59, Reset: 1500 * time.Millisecond}},
+			limit:     "60",
+			remaining: "59",
+			reset:     "2",
+		},
+		{
+			name: "Bucket with fewer remaining wins",
+			results: []ratelimit.Result{
+				{Allowed: true, Limit: 60, Remaining: 10, Reset: 50 * time.Second},
+				{Allowed: true, Limit: 20, Remaining: 19, Reset: 3 * time.Second},
+			},
+			limit:     "60",
+			remaining: "10",
+			reset:     "50",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+
+			for _, result := range tc.results {
+				ratelimit.WriteHeaders(w, result)
+			}
+
+			require.Equal(t, tc.limit, w.Header().Get("RateLimit-Limit"), "Case: %s", tc.name)
+			require.Equal(t, tc.remaining, w.Header().Get("RateLimit-Remaining"), "Case: %s", tc.name)
+			require.Equal(t, tc.reset, w.Header().Get("RateLimit-Reset"), "Case: %s", tc.name)
+		})
+	}
+}
+
+func TestDeny(t *testing.T) {
+	cases := []struct {
+		name       string
+		result     ratelimit.Result
+		retryAfter int
+		code       string
+	}{
+		{
+			name:       "Rate limited",
+			result:     ratelimit.Result{Limit: 60, RetryAfter: 900 * time.Millisecond, Reset: time.Minute},
+			retryAfter: 1,
+			code:       ratelimit.CodeRateLimited,
+		},
+		{
+			name:       "Locked out",
+			result:     ratelimit.Result{Locked: true, Limit: 60, RetryAfter: 15 * time.Minute},
+			retryAfter: 900,
+			code:       ratelimit.CodeLockedOut,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+
+			ratelimit.Deny(w, r, tc.result)
+
+			require.Equal(t, http.StatusTooManyRequests, w.Code, "Case: %s", tc.name)
+			require.Equal(t, strconv.Itoa(tc.retryAfter), w.Header().Get("Retry-After"), "Case: %s", tc.name)
+			require.Equal(t, "60", w.Header().Get("RateLimit-Limit"), "Case: %s", tc.name)
+			require.Contains(t, w.Body.String(), `"code":"`+tc.code+`"`, "Case: %s", tc.name)
+		})
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	storagetest.RunRateLimits(t, ratelimit.NewMemoryStore())
+}
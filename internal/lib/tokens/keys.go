@@ -0,0 +1,389 @@
+package tokens
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"auth/internal/config"
+)
+
+var (
+	ErrKeyNotFound       = errors.New("signing key not found")
+	ErrNoSigningKey      = errors.New("no signing key configured")
+	ErrUnsupportedKey    = errors.New("unsupported key type")
+	ErrAlgorithmMismatch = errors.New("algorithm does not match key type")
+)
+
+// Key is a named key used to sign and verify access tokens.
+// Keys without ID are legacy HMAC keys: tokens signed with them carry no kid.
+type Key struct {
+	ID         string
+	Method     jwt.SigningMethod
+	ActiveFrom time.Time
+	RetireAt   time.Time
+
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+func NewHMACKey(id string, secret []byte) *Key {
+	return &Key{
+		ID:        id,
+		Method:    jwt.SigningMethodHS512,
+		signKey:   secret,
+		verifyKey: secret,
+	}
+}
+
+const (
+	KeyPending = "pending"
+	KeyActive  = "active"
+	KeySigning = "signing"
+	KeyRetired = "retired"
+)
+
+type KeyStatus struct {
+	ID         string
+	Algorithm  string
+	ActiveFrom time.Time
+	RetireAt   time.Time
+	State      string
+}
+
+// State reports whether the key is pending, active or retired at the given time.
+func (k *Key) State(now time.Time) string {
+	switch {
+	case !k.RetireAt.IsZero() && !now.Before(k.RetireAt):
+		return KeyRetired
+	case now.Before(k.ActiveFrom):
+		return KeyPending
+	default:
+		return KeyActive
+	}
+}
+
+// IsPublic reports whether the key can be published in a JWK set.
+func (k *Key) IsPublic() bool {
+	_, ok := k.Method.(*jwt.SigningMethodHMAC)
+	return !ok
+}
+
+func LoadPrivateKey(id, algorithm, path string) (*Key, error) {
+	const op = "lib.auth.token.LoadPrivateKey"
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: Reading key file error: %w", op, err)
+	}
+
+	key, err := ParsePrivateKey(id, algorithm, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s: %w", op, path, err)
+	}
+
+	return key, nil
+}
+
+// ParsePrivateKey parses a PEM encoded RSA, ECDSA or Ed25519 private key.
+// If algorithm is empty it is derived from the key type.
+func ParsePrivateKey(id, algorithm string, data []byte) (*Key, error) {
+	const op = "lib.auth.token.ParsePrivateKey"
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: Key is not PEM encoded", op)
+	}
+
+	var private interface{}
+	var err error
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: Parsing private key error: %w", op, err)
+	}
+
+	method, err := signingMethod(private, algorithm)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Key{
+		ID:        id,
+		Method:    method,
+		signKey:   private,
+		verifyKey: private.(crypto.Signer).Public(),
+	}, nil
+}
+
+func signingMethod(private interface{}, algorithm string) (jwt.SigningMethod, error) {
+	switch key := private.(type) {
+	case *rsa.PrivateKey:
+		if algorithm == "" {
+			algorithm = jwt.SigningMethodRS256.Alg()
+		}
+
+		switch method := jwt.GetSigningMethod(algorithm).(type) {
+		case *jwt.SigningMethodRSA:
+			return method, nil
+		case *jwt.SigningMethodRSAPSS:
+			return method, nil
+		}
+	case *ecdsa.PrivateKey:
+		var method *jwt.SigningMethodECDSA
+
+		switch key.Curve {
+		case elliptic.P256():
+			method = jwt.SigningMethodES256
+		case elliptic.P384():
+			method = jwt.SigningMethodES384
+		case elliptic.P521():
+			method = jwt.SigningMethodES512
+		default:
+			return nil, ErrUnsupportedKey
+		}
+
+		if algorithm == "" || algorithm == method.Alg() {
+			return method, nil
+		}
+	case ed25519.PrivateKey:
+		if algorithm == "" || algorithm == jwt.SigningMethodEdDSA.Alg() {
+			return jwt.SigningMethodEdDSA, nil
+		}
+	default:
+		return nil, ErrUnsupportedKey
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrAlgorithmMismatch, algorithm)
+}
+
+// KeySet is a key ring trusted for access token verification. Each key is
+// valid from ActiveFrom until RetireAt; a zero time leaves that end open.
+// Tokens are signed with the most recently activated valid key, and keys
+// that are not retired yet keep verifying the tokens they have signed.
+type KeySet struct {
+	mu   sync.RWMutex
+	keys []*Key
+}
+
+func NewKeySet(keys ...*Key) (*KeySet, error) {
+	const op = "lib.auth.token.NewKeySet"
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: %w", op, ErrNoSigningKey)
+	}
+
+	ids := make(map[string]struct{}, len(keys))
+
+	for _, key := range keys {
+		if _, ok := ids[key.ID]; ok {
+			return nil, fmt.Errorf("%s: Duplicate key ID: %q", op, key.ID)
+		}
+		ids[key.ID] = struct{}{}
+
+		if !key.RetireAt.IsZero() && !key.RetireAt.After(key.ActiveFrom) {
+			return nil, fmt.Errorf("%s: Key %q retires before it is activated", op, key.ID)
+		}
+	}
+
+	return &KeySet{keys: keys}, nil
+}
+
+// LoadKeySet builds a key ring from the configured keys. The legacy secret
+// key, if set, is added last without kid, so it verifies old tokens and
+// signs only while no other key is active.
+func LoadKeySet(jwtConfig config.JWT) (*KeySet, error) {
+	const op = "lib.auth.token.LoadKeySet"
+
+	keys := make([]*Key, 0, len(jwtConfig.Keys)+1)
+
+	for _, keyConfig := range jwtConfig.Keys {
+		key, err := loadKey(keyConfig)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	if jwtConfig.SecretKey != "" {
+		keys = append(keys, NewHMACKey("", []byte(jwtConfig.SecretKey)))
+	}
+
+	set, err := NewKeySet(keys...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if set.SigningKey() == nil {
+		return nil, fmt.Errorf("%s: %w", op, ErrNoSigningKey)
+	}
+
+	return set, nil
+}
+
+func loadKey(keyConfig config.JWTKey) (*Key, error) {
+	const op = "lib.auth.token.loadKey"
+
+	if keyConfig.ID == "" {
+		return nil, fmt.Errorf("%s: Key ID is empty", op)
+	}
+
+	var key *Key
+
+	switch {
+	case keyConfig.Secret != "" && keyConfig.PrivateKeyPath != "":
+		return nil, fmt.Errorf("%s: Key %q has both secret and private key", op, keyConfig.ID)
+	case keyConfig.Secret != "":
+		key = NewHMACKey(keyConfig.ID, []byte(keyConfig.Secret))
+
+		if keyConfig.Algorithm != "" {
+			method, ok := jwt.GetSigningMethod(keyConfig.Algorithm).(*jwt.SigningMethodHMAC)
+			if !ok {
+				return nil, fmt.Errorf("%s: Key %q: %w: %s", op, keyConfig.ID,
+					ErrAlgorithmMismatch, keyConfig.Algorithm)
+			}
+			key.Method = method
+		}
+	default:
+		var err error
+
+		key, err = LoadPrivateKey(keyConfig.ID, keyConfig.Algorithm, keyConfig.PrivateKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	key.ActiveFrom = keyConfig.ActiveFrom
+	key.RetireAt = keyConfig.RetireAt
+
+	return key, nil
+}
+
+// Reload replaces the key ring with the configured keys. The current ring
+// is kept if the new one fails to load.
+func (s *KeySet) Reload(jwtConfig config.JWT) error {
+	const op = "lib.auth.token.KeySet.Reload"
+
+	set, err := LoadKeySet(jwtConfig)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.mu.Lock()
+	s.keys = set.keys
+	s.mu.Unlock()
+
+	return nil
+}
+
+// SigningKey returns the most recently activated key that is valid now,
+// or nil if there is none.
+func (s *KeySet) SigningKey() *Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.signingKey(time.Now())
+}
+
+func (s *KeySet) signingKey(now time.Time) *Key {
+	var signing *Key
+
+	for _, key := range s.keys {
+		if key.State(now) != KeyActive {
+			continue
+		}
+
+		if signing == nil || key.ActiveFrom.After(signing.ActiveFrom) {
+			signing = key
+		}
+	}
+
+	return signing
+}
+
+// Key returns the key with the given ID if it is not retired.
+func (s *KeySet) Key(id string) (*Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+
+	for _, key := range s.keys {
+		if key.ID == id && key.State(now) != KeyRetired {
+			return key, nil
+		}
+	}
+
+	return nil, ErrKeyNotFound
+}
+
+// Status describes every key of the ring at the current time.
+func (s *KeySet) Status() []KeyStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	signing := s.signingKey(now)
+
+	status := make([]KeyStatus, 0, len(s.keys))
+
+	for _, key := range s.keys {
+		state := key.State(now)
+		if key == signing {
+			state = KeySigning
+		}
+
+		status = append(status, KeyStatus{
+			ID:         key.ID,
+			Algorithm:  key.Method.Alg(),
+			ActiveFrom: key.ActiveFrom,
+			RetireAt:   key.RetireAt,
+			State:      state,
+		})
+	}
+
+	return status
+}
+
+// JWKS returns the public keys that are not retired, including the ones
+// pending activation, so verifiers can cache them before first use.
+func (s *KeySet) JWKS() JWKS {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+
+	jwks := JWKS{Keys: make([]JWK, 0, len(s.keys))}
+
+	for _, key := range s.keys {
+		if key.IsPublic() && key.State(now) != KeyRetired {
+			jwks.Keys = append(jwks.Keys, key.JWK())
+		}
+	}
+
+	sort.Slice(jwks.Keys, func(i, j int) bool {
+		return jwks.Keys[i].Kid < jwks.Keys[j].Kid
+	})
+
+	return jwks
+}
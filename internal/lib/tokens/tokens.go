@@ -1,10 +1,14 @@
 package tokens
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -13,25 +17,41 @@ import (
 )
 
 var (
-	ErrAccessTokenExpired = errors.New("access token is expired")
+	ErrAccessTokenExpired  = errors.New("access token is expired")
+	ErrInvalidRefreshToken = errors.New("refresh token does not match")
 )
 
-func GenerateAccessToken(userGUID uuid.UUID, userIp string, bind_key string,
-	timeExpires time.Duration, key string) (string, error) {
+// GenerateAccessToken signs an access token bound to the IP and the refresh
+// token of the bind key. The client ID, if any, picks the IP binding policy
+// of the refreshes.
+func GenerateAccessToken(userGUID uuid.UUID, userIp string, bind_key string, clientID string,
+	timeExpires time.Duration, key *Key) (string, error) {
 	const op = "lib.auth.token.GenerateAccessToken"
 
-	accessExpires := time.Now().Add(timeExpires)
+	if key == nil {
+		return "", fmt.Errorf("%s: %w", op, ErrNoSigningKey)
+	}
+
+	issuedAt := time.Now()
+	accessExpires := issuedAt.Add(timeExpires)
 
 	accessPayload := jwt.MapClaims{
 		"sub":      userGUID,
 		"ip":       userIp,
 		"exp":      accessExpires.Unix(),
+		"iat":      issuedAt.Unix(),
 		"bind_key": bind_key,
 	}
+	if clientID != "" {
+		accessPayload["client_id"] = clientID
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS512, accessPayload)
+	token := jwt.NewWithClaims(key.Method, accessPayload)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
 
-	accessToken, err := token.SignedString([]byte(key))
+	accessToken, err := token.SignedString(key.signKey)
 	if err != nil {
 		return "", fmt.Errorf("%s: Signing token error: %w", op, err)
 	}
@@ -53,25 +73,72 @@ func GenerateRefreshToken() (string, error) {
 	return refreshToken, nil
 }
 
-func ValidateRefreshToken(refreshToken string, hash string) error {
+// DeriveRefreshToken returns the successor of the refresh token. Unlike a
+// generated one, it is the same on every call, so a retried rotation can
+// answer with the successor issued before. The pepper must be secret, or
+// the successor could be derived by whoever holds the refresh token.
+func DeriveRefreshToken(refreshToken string, pepper string) string {
+	mac := hmac.New(sha256.New, []byte(pepper))
+	// The prefix keeps successors apart from the hashes stored in the database.
+	mac.Write([]byte("successor:"))
+	mac.Write([]byte(refreshToken))
+
+	return base64.URLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// HashRefreshToken returns the HMAC-SHA256 digest of the refresh token keyed
+// with the pepper. Refresh tokens are random, so a fast hash is as strong as
+// a slow one, and being deterministic it can be looked up in an index.
+func HashRefreshToken(refreshToken string, pepper string) string {
+	mac := hmac.New(sha256.New, []byte(pepper))
+	mac.Write([]byte(refreshToken))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidateRefreshToken checks the refresh token against its stored hash.
+// Tokens issued before keyed hashing have bcrypt hashes, which are still
+// accepted until these tokens expire.
+func ValidateRefreshToken(refreshToken string, hash string, pepper string) error {
 	const op = "lib.auth.token.ValidateRefreshToken"
 
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(refreshToken))
-	if err != nil {
-		return fmt.Errorf("%s: Failed to validate refresh token: %v", op, err)
+	if IsLegacyHash(hash) {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(refreshToken))
+		if err != nil {
+			return fmt.Errorf("%s: Failed to validate refresh token: %v", op, err)
+		}
+
+		return nil
+	}
+
+	if !hmac.Equal([]byte(HashRefreshToken(refreshToken, pepper)), []byte(hash)) {
+		return fmt.Errorf("%s: Failed to validate refresh token: %w", op, ErrInvalidRefreshToken)
 	}
 
 	return nil
 }
 
-func ValidateAccessToken(accessToken string, key []byte) (jwt.MapClaims, error) {
+// IsLegacyHash reports whether the hash is a bcrypt hash, which can not be
+// looked up by the refresh token.
+func IsLegacyHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2")
+}
+
+func ValidateAccessToken(accessToken string, keys *KeySet) (jwt.MapClaims, error) {
 	const op = "lib.auth.token.ValidateAccessToken"
 
 	token, err := jwt.Parse(accessToken, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		kid, _ := token.Header["kid"].(string)
+
+		key, err := keys.Key(kid)
+		if err != nil {
+			return nil, fmt.Errorf("%s: Unknown key ID %q: %w", op, kid, err)
+		}
+
+		if token.Method.Alg() != key.Method.Alg() {
 			return nil, fmt.Errorf("%s: Unexpected signing method: %v", op, token.Header["alg"])
 		}
-		return key, nil
+		return key.verifyKey, nil
 	})
 
 	if err != nil {
@@ -0,0 +1,313 @@
+package tokens_test
+
+import (
+	"auth/internal/config"
+	"auth/internal/lib/tokens"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func pemKey(t testing.TB, private interface{}) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestAccessTokenSigning(t *testing.T) {
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecPrivate, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	cases := []struct {
+		name      string
+		private   interface{}
+		algorithm string
+		method    string
+	}{
+		{name: "RSA", private: rsaPrivate, method: "RS256"},
+		{name: "RSA-PSS", private: rsaPrivate, algorithm: "PS512", method: "PS512"},
+		{name: "ECDSA", private: ecPrivate, method: "ES384"},
+		{name: "Ed25519", private: edPrivate, method: "EdDSA"},
+	}
+
+	userGUID := uuid.New()
+
+	for _, tc := range cases {
+		key, err := tokens.ParsePrivateKey("key-"+tc.name, tc.algorithm, pemKey(t, tc.private))
+		require.NoError(t, err, "Case: %s", tc.name)
+		require.Equal(t, tc.method, key.Method.Alg(), "Case: %s", tc.name)
+
+		keys, err := tokens.NewKeySet(key)
+		require.NoError(t, err, "Case: %s", tc.name)
+
+		accessToken, err := tokens.GenerateAccessToken(userGUID, "127.0.0.1", "bind key", "", time.Minute, keys.SigningKey())
+		require.NoError(t, err, "Case: %s", tc.name)
+
+		token, _, err := jwt.NewParser().ParseUnverified(accessToken, jwt.MapClaims{})
+		require.NoError(t, err, "Case: %s", tc.name)
+		require.Equal(t, key.ID, token.Header["kid"], "Case: %s", tc.name)
+
+		claims, err := tokens.ValidateAccessToken(accessToken, keys)
+		require.NoError(t, err, "Case: %s", tc.name)
+		require.Equal(t, userGUID.String(), claims["sub"], "Case: %s", tc.name)
+	}
+}
+
+func TestAccessTokenValidation(t *testing.T) {
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	edKey, err := tokens.ParsePrivateKey("ed-key", "", pemKey(t, edPrivate))
+	require.NoError(t, err)
+
+	_, err = tokens.ParsePrivateKey("ed-key", "RS256", pemKey(t, edPrivate))
+	require.ErrorIs(t, err, tokens.ErrAlgorithmMismatch)
+
+	keys, err := tokens.NewKeySet(edKey)
+	require.NoError(t, err)
+
+	// A token signed with a foreign key under a trusted kid must be rejected.
+	_, foreignPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	foreignKey, err := tokens.ParsePrivateKey("ed-key", "", pemKey(t, foreignPrivate))
+	require.NoError(t, err)
+
+	accessToken, err := tokens.GenerateAccessToken(uuid.New(), "127.0.0.1", "bind key", "", time.Minute, foreignKey)
+	require.NoError(t, err)
+	_, err = tokens.ValidateAccessToken(accessToken, keys)
+	require.Error(t, err)
+
+	// HMAC tokens must not be accepted when only asymmetric keys are trusted.
+	accessToken, err = tokens.GenerateAccessToken(uuid.New(), "127.0.0.1", "bind key", "", time.Minute,
+		tokens.NewHMACKey("ed-key", []byte("secretkey")))
+	require.NoError(t, err)
+	_, err = tokens.ValidateAccessToken(accessToken, keys)
+	require.Error(t, err)
+
+	accessToken, err = tokens.GenerateAccessToken(uuid.New(), "127.0.0.1", "bind key", "", -time.Minute, edKey)
+	require.NoError(t, err)
+	claims, err := tokens.ValidateAccessToken(accessToken, keys)
+	require.ErrorIs(t, err, tokens.ErrAccessTokenExpired)
+	require.Equal(t, "bind key", claims["bind_key"])
+}
+
+func TestKeyRotation(t *testing.T) {
+	newKey := func(id string, activeFrom, retireAt time.Time) *tokens.Key {
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+
+		key, err := tokens.ParsePrivateKey(id, "", pemKey(t, private))
+		require.NoError(t, err)
+		key.ActiveFrom = activeFrom
+		key.RetireAt = retireAt
+
+		return key
+	}
+
+	now := time.Now()
+	previous := newKey("previous", now.Add(-2*time.Hour), now.Add(time.Hour))
+	current := newKey("current", now.Add(-time.Hour), time.Time{})
+	next := newKey("next", now.Add(time.Hour), time.Time{})
+	retired := newKey("retired", now.Add(-3*time.Hour), now.Add(-time.Minute))
+
+	keys, err := tokens.NewKeySet(previous, next, current, retired)
+	require.NoError(t, err)
+
+	require.Equal(t, "current", keys.SigningKey().ID)
+
+	for _, key := range []*tokens.Key{previous, current, next} {
+		accessToken, err := tokens.GenerateAccessToken(uuid.New(), "127.0.0.1", "bind key", "", time.Minute, key)
+		require.NoError(t, err)
+
+		_, err = tokens.ValidateAccessToken(accessToken, keys)
+		require.NoError(t, err, "Key: %s", key.ID)
+	}
+
+	accessToken, err := tokens.GenerateAccessToken(uuid.New(), "127.0.0.1", "bind key", "", time.Minute, retired)
+	require.NoError(t, err)
+	_, err = tokens.ValidateAccessToken(accessToken, keys)
+	require.Error(t, err)
+
+	jwks := keys.JWKS()
+	require.Len(t, jwks.Keys, 3)
+	require.Equal(t, []string{"current", "next", "previous"},
+		[]string{jwks.Keys[0].Kid, jwks.Keys[1].Kid, jwks.Keys[2].Kid})
+
+	states := map[string]string{}
+	for _, status := range keys.Status() {
+		states[status.ID] = status.State
+	}
+	require.Equal(t, map[string]string{
+		"previous": tokens.KeyActive,
+		"current":  tokens.KeySigning,
+		"next":     tokens.KeyPending,
+		"retired":  tokens.KeyRetired,
+	}, states)
+
+	_, err = tokens.NewKeySet(newKey("invalid", now, now.Add(-time.Hour)))
+	require.Error(t, err)
+}
+
+func TestKeySetReload(t *testing.T) {
+	keys, err := tokens.LoadKeySet(config.JWT{
+		Keys: []config.JWTKey{{ID: "first", Secret: "first secret"}},
+	})
+	require.NoError(t, err)
+
+	accessToken, err := tokens.GenerateAccessToken(uuid.New(), "127.0.0.1", "bind key", "", time.Minute, keys.SigningKey())
+	require.NoError(t, err)
+
+	err = keys.Reload(config.JWT{
+		Keys: []config.JWTKey{
+			{ID: "second", Secret: "second secret", ActiveFrom: time.Now().Add(-time.Second)},
+			{ID: "first", Secret: "first secret", RetireAt: time.Now().Add(time.Hour)},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "second", keys.SigningKey().ID)
+
+	_, err = tokens.ValidateAccessToken(accessToken, keys)
+	require.NoError(t, err)
+
+	err = keys.Reload(config.JWT{
+		Keys: []config.JWTKey{{ID: "third", Secret: "third secret", ActiveFrom: time.Now().Add(time.Hour)}},
+	})
+	require.ErrorIs(t, err, tokens.ErrNoSigningKey)
+	require.Equal(t, "second", keys.SigningKey().ID)
+}
+
+func TestRefreshTokenValidation(t *testing.T) {
+	refreshToken, err := tokens.GenerateRefreshToken()
+	require.NoError(t, err)
+
+	hash := tokens.HashRefreshToken(refreshToken, "pepper")
+	require.Equal(t, hash, tokens.HashRefreshToken(refreshToken, "pepper"))
+	require.NotEqual(t, hash, tokens.HashRefreshToken(refreshToken, "another pepper"))
+	require.False(t, tokens.IsLegacyHash(hash))
+
+	require.NoError(t, tokens.ValidateRefreshToken(refreshToken, hash, "pepper"))
+	require.ErrorIs(t, tokens.ValidateRefreshToken("some string", hash, "pepper"), tokens.ErrInvalidRefreshToken)
+	require.ErrorIs(t, tokens.ValidateRefreshToken(refreshToken, hash, "another pepper"), tokens.ErrInvalidRefreshToken)
+
+	legacyHash, err := bcrypt.GenerateFromPassword([]byte(refreshToken), 10)
+	require.NoError(t, err)
+	require.True(t, tokens.IsLegacyHash(string(legacyHash)))
+
+	require.NoError(t, tokens.ValidateRefreshToken(refreshToken, string(legacyHash), "pepper"))
+	require.Error(t, tokens.ValidateRefreshToken("some string", string(legacyHash), "pepper"))
+}
+
+func BenchmarkHashRefreshToken(b *testing.B) {
+	refreshToken, err := tokens.GenerateRefreshToken()
+	require.NoError(b, err)
+
+	b.Run("bcrypt", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := bcrypt.GenerateFromPassword([]byte(refreshToken), 10)
+			require.NoError(b, err)
+		}
+	})
+
+	b.Run("hmac-sha256", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			tokens.HashRefreshToken(refreshToken, "pepper")
+		}
+	})
+}
+
+func BenchmarkValidateRefreshToken(b *testing.B) {
+	refreshToken, err := tokens.GenerateRefreshToken()
+	require.NoError(b, err)
+
+	legacyHash, err := bcrypt.GenerateFromPassword([]byte(refreshToken), 10)
+	require.NoError(b, err)
+
+	hash := tokens.HashRefreshToken(refreshToken, "pepper")
+
+	b.Run("bcrypt", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			require.NoError(b, tokens.ValidateRefreshToken(refreshToken, string(legacyHash), "pepper"))
+		}
+	})
+
+	b.Run("hmac-sha256", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			require.NoError(b, tokens.ValidateRefreshToken(refreshToken, hash, "pepper"))
+		}
+	})
+}
+
+func TestLinkVerification(t *testing.T) {
+	secret := "link secret"
+	expiresAt := time.Now().Add(time.Hour)
+	goodLink := tokens.SignLink(secret, tokens.LinkRevokeFamily, expiresAt, "family", "user")
+	otherLink := tokens.SignLink(secret, tokens.LinkRevokeFamily, expiresAt, "other family", "user")
+
+	cases := []struct {
+		name    string
+		token   string
+		purpose string
+		values  []string
+		err     error
+	}{
+		{
+			name:    "Valid link",
+			token:   goodLink,
+			purpose: tokens.LinkRevokeFamily,
+			values:  []string{"family", "user"},
+		},
+		{
+			name:    "Expired link",
+			token:   tokens.SignLink(secret, tokens.LinkRevokeFamily, time.Now().Add(-time.Second), "family"),
+			purpose: tokens.LinkRevokeFamily,
+			err:     tokens.ErrLinkExpired,
+		},
+		{
+			name:    "Other purpose",
+			token:   goodLink,
+			purpose: "some string",
+			err:     tokens.ErrInvalidLink,
+		},
+		{
+			name:    "Other secret",
+			token:   tokens.SignLink("some string", tokens.LinkRevokeFamily, expiresAt, "family", "user"),
+			purpose: tokens.LinkRevokeFamily,
+			err:     tokens.ErrInvalidLink,
+		},
+		{
+			name:    "Tampered values",
+			token:   strings.Split(otherLink, ".")[0] + "." + strings.Split(goodLink, ".")[1],
+			purpose: tokens.LinkRevokeFamily,
+			err:     tokens.ErrInvalidLink,
+		},
+		{
+			name:    "Malformed token",
+			token:   "some string",
+			purpose: tokens.LinkRevokeFamily,
+			err:     tokens.ErrInvalidLink,
+		},
+	}
+
+	for _, tc := range cases {
+		values, err := tokens.VerifyLink(secret, tc.purpose, tc.token)
+		require.ErrorIs(t, err, tc.err, "Case: %s", tc.name)
+		require.Equal(t, tc.values, values, "Case: %s", tc.name)
+	}
+}
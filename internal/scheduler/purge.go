@@ -0,0 +1,69 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"auth/internal/config"
+)
+
+const (
+	PurgeJobName = "purge_refresh_tokens"
+
+	defaultPurgeInterval  = time.Hour
+	defaultPurgeBatchSize = 1000
+)
+
+//go:generate go run github.com/vektra/mockery/v3 --name=RefreshTokenPurger
+type RefreshTokenPurger interface {
+	PurgeRefreshTokens(ctx context.Context, expiredBefore time.Time, limit int) (int64, error)
+}
+
+// NewPurgeJob returns the job deleting refresh tokens that expired longer
+// than the retention period ago. Rows are deleted in batches, so no single
+// statement locks the table for long.
+func NewPurgeJob(log *slog.Logger, storage RefreshTokenPurger, configPurge config.Purge) Job {
+	interval := configPurge.Interval
+	if interval <= 0 {
+		interval = defaultPurgeInterval
+	}
+
+	batchSize := configPurge.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultPurgeBatchSize
+	}
+
+	return Job{
+		Name:     PurgeJobName,
+		Interval: interval,
+		Run: func(ctx context.Context) error {
+			const op = "scheduler.PurgeJob"
+
+			expiredBefore := time.Now().Add(-configPurge.Retention)
+
+			var total int64
+			defer func() {
+				metrics.Add(PurgeJobName+".deleted", total)
+
+				if total > 0 {
+					log.Info("Purged refresh tokens", slog.Int64("deleted", total),
+						slog.Time("expired_before", expiredBefore))
+				}
+			}()
+
+			for {
+				deleted, err := storage.PurgeRefreshTokens(ctx, expiredBefore, batchSize)
+				total += deleted
+				if err != nil {
+					return fmt.Errorf("%s: %w", op, err)
+				}
+
+				if deleted < int64(batchSize) {
+					return nil
+				}
+			}
+		},
+	}
+}
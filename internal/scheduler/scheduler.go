@@ -0,0 +1,130 @@
+package scheduler
+
+import (
+	"context"
+	"expvar"
+	"log/slog"
+	"sync"
+	"time"
+
+	"auth/internal/lib/logger/sl"
+)
+
+// metrics counts job runs, failures and skips by job name, they are
+// published with expvar under "scheduler".
+var metrics = expvar.NewMap("scheduler")
+
+// Job is run by the Scheduler every Interval.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// Locker takes locks shared by all replicas of the service, so every run of
+// a job happens on one replica only.
+//
+//go:generate go run github.com/vektra/mockery/v3 --name=Locker
+type Locker interface {
+	TryLock(ctx context.Context, name string) (unlock func(), locked bool, err error)
+}
+
+type Scheduler struct {
+	log    *slog.Logger
+	locker Locker
+	jobs   []Job
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New returns a scheduler taking locks from locker before every run. A nil
+// locker runs jobs unconditionally, which suits single-node storages.
+func New(log *slog.Logger, locker Locker) *Scheduler {
+	return &Scheduler{
+		log:    log,
+		locker: locker,
+	}
+}
+
+// Add registers a job, jobs added after Start are not run.
+func (s *Scheduler) Add(job Job) {
+	s.jobs = append(s.jobs, job)
+}
+
+// Start runs every job in its own goroutine until Stop is called.
+func (s *Scheduler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+
+		go func() {
+			defer s.wg.Done()
+			s.loop(ctx, job)
+		}()
+	}
+}
+
+// Stop cancels running jobs and waits for them to return.
+func (s *Scheduler) Stop() {
+	if s.cancel == nil {
+		return
+	}
+
+	s.cancel()
+	s.wg.Wait()
+}
+
+// loop runs the job at once and then every interval, so jobs with long
+// intervals still run on replicas that are restarted often.
+func (s *Scheduler) loop(ctx context.Context, job Job) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	s.run(ctx, job)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.run(ctx, job)
+		}
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, job Job) {
+	log := s.log.With(slog.String("job", job.Name))
+
+	if s.locker != nil {
+		unlock, locked, err := s.locker.TryLock(ctx, job.Name)
+		if err != nil {
+			log.Error("Failed to lock job", sl.Err(err))
+			metrics.Add(job.Name+".failures", 1)
+			return
+		}
+
+		if !locked {
+			log.Debug("Job is running on another replica")
+			metrics.Add(job.Name+".skipped", 1)
+			return
+		}
+		defer unlock()
+	}
+
+	start := time.Now()
+
+	err := job.Run(ctx)
+
+	metrics.Add(job.Name+".runs", 1)
+
+	if err != nil {
+		log.Error("Job failed", sl.Err(err), slog.Duration("duration", time.Since(start)))
+		metrics.Add(job.Name+".failures", 1)
+		return
+	}
+
+	log.Debug("Job finished", slog.Duration("duration", time.Since(start)))
+}
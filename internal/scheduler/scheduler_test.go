@@ -0,0 +1,127 @@
+package scheduler_test
+
+import (
+	"auth/internal/config"
+	sl "auth/internal/lib/logger/sl/sldiscard"
+	"auth/internal/scheduler"
+	"auth/internal/scheduler/mocks"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPurgeJob(t *testing.T) {
+	cases := []struct {
+		name      string
+		batches   []int64
+		lastError error
+		wantError bool
+	}{
+		{
+			name:    "Nothing to purge",
+			batches: []int64{0},
+		},
+		{
+			name:    "Single batch",
+			batches: []int64{1},
+		},
+		{
+			name:    "Several batches",
+			batches: []int64{2, 2, 0},
+		},
+		{
+			name:      "Failed to purge",
+			batches:   []int64{2, 0},
+			lastError: errors.New("some error"),
+			wantError: true,
+		},
+	}
+
+	for _, tc := range cases {
+		PurgerMock := mocks.NewRefreshTokenPurger(t)
+
+		for i, deleted := range tc.batches {
+			var err error
+			if i == len(tc.batches)-1 {
+				err = tc.lastError
+			}
+
+			PurgerMock.On("PurgeRefreshTokens", mock.Anything, mock.AnythingOfType("time.Time"), 2).
+				Return(deleted, err).
+				Once()
+		}
+
+		job := scheduler.NewPurgeJob(sl.NewDiscardLogger(), PurgerMock, config.Purge{
+			Retention: time.Hour,
+			BatchSize: 2,
+		})
+
+		err := job.Run(context.Background())
+		require.Equal(t, tc.wantError, err != nil, "Case: %s", tc.name)
+	}
+}
+
+func TestSchedulerLocks(t *testing.T) {
+	cases := []struct {
+		name    string
+		locked  bool
+		lockErr error
+		runs    bool
+	}{
+		{
+			name:   "Lock taken",
+			locked: true,
+			runs:   true,
+		},
+		{
+			name:   "Locked by another replica",
+			locked: false,
+		},
+		{
+			name:    "Failed to lock",
+			lockErr: errors.New("some error"),
+		},
+	}
+
+	for _, tc := range cases {
+		LockerMock := mocks.NewLocker(t)
+
+		tried := make(chan struct{})
+		unlocked := make(chan struct{})
+
+		var unlock func()
+		if tc.locked {
+			unlock = func() { close(unlocked) }
+		}
+
+		LockerMock.On("TryLock", mock.Anything, "job").
+			Return(unlock, tc.locked, tc.lockErr).
+			Run(func(mock.Arguments) { close(tried) }).
+			Once()
+
+		ran := make(chan struct{}, 1)
+
+		s := scheduler.New(sl.NewDiscardLogger(), LockerMock)
+		s.Add(scheduler.Job{
+			Name:     "job",
+			Interval: time.Hour,
+			Run: func(ctx context.Context) error {
+				ran <- struct{}{}
+				return nil
+			},
+		})
+
+		s.Start()
+		<-tried
+		if tc.locked {
+			<-unlocked
+		}
+		s.Stop()
+
+		require.Equal(t, tc.runs, len(ran) == 1, "Case: %s", tc.name)
+	}
+}
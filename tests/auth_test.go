@@ -1,29 +1,71 @@
 package auth_test
 
 import (
+	"auth/internal/app"
+	"auth/internal/config"
+	"auth/internal/database"
+	"auth/internal/email/mockmail"
 	"auth/internal/http/handlers/refresh"
+	sl "auth/internal/lib/logger/sl/sldiscard"
+	"auth/internal/lib/password"
+	"auth/internal/lib/tokens"
+	"context"
 	"encoding/json"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"regexp"
 	"testing"
+	"time"
 
 	"github.com/gavv/httpexpect"
 	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
 )
 
 var (
-	host = "localhost:8080"
-	guid = uuid.New().String()
+	// host of a running service, e.g. "localhost:8080"; if empty, the
+	// service is started in-process with the in-memory database.
+	host = os.Getenv("AUTH_TEST_HOST")
+	// apiKey authenticates guid, a running service must have the key of
+	// AUTH_TEST_API_KEY for the user of AUTH_TEST_USER_GUID.
+	apiKey = getenv("AUTH_TEST_API_KEY", "verysecrettestapikey")
+	guid   = getenv("AUTH_TEST_USER_GUID", uuid.New().String())
+	// adminSecret authenticates the admin client of the in-process service.
+	adminSecret = "verysecretsupportsecret"
 )
 
+func getenv(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+
+	return fallback
+}
+
 func TestAuth(t *testing.T) {
 	url := url.URL{
 		Scheme: "http",
 		Host:   host,
 	}
 
+	if host == "" {
+		url.Host, _ = startServer(t)
+	}
+
 	httpExpect := httpexpect.New(t, url.String())
 
-	requestExpect := httpExpect.GET("/" + guid)
+	httpExpect.GET("/" + guid).
+		Expect().
+		Status(401)
+
+	httpExpect.GET("/"+guid).
+		WithHeader("X-API-Key", "wrong"+apiKey).
+		Expect().
+		Status(401)
+
+	requestExpect := httpExpect.GET("/"+guid).
+		WithHeader("X-API-Key", apiKey)
 
 	responseExpect := requestExpect.Expect()
 	responseExpect.Status(200).
@@ -36,11 +78,354 @@ func TestAuth(t *testing.T) {
 	data := refresh.Request{}
 	json.Unmarshal([]byte(getResponse), &data)
 
-	httpExpect.POST("/").
+	refreshResponse := httpExpect.POST("/").
 		WithJSON(data).
 		Expect().
 		Status(200).
 		JSON().Object().
 		ContainsKey("access_token").
 		ContainsKey("refresh_token")
+
+	accessToken := refreshResponse.Value("access_token").String().Raw()
+
+	httpExpect.GET("/sessions").
+		WithHeader("Authorization", "Bearer "+accessToken).
+		Expect().
+		Status(200).
+		JSON().Object().
+		Value("sessions").Array().
+		Length().Equal(1)
+
+	httpExpect.POST("/").
+		WithJSON(data).
+		Expect().
+		Status(401)
+
+	httpExpect.GET("/sessions").
+		WithHeader("Authorization", "Bearer "+accessToken).
+		Expect().
+		Status(200).
+		JSON().Object().
+		Value("sessions").Array().
+		Empty()
+}
+
+// TestRefreshGracePeriod retries a refresh within the grace period and gets
+// the same successor, until the successor is used.
+func TestRefreshGracePeriod(t *testing.T) {
+	if host != "" {
+		t.Skip("The grace period is only controlled in-process")
+	}
+
+	serverHost, _ := startServer(t, func(cfg *config.Config) {
+		cfg.JWT.RefreshGracePeriod = time.Minute
+	})
+
+	httpExpect := httpexpect.New(t, "http://"+serverHost)
+
+	getResponse := httpExpect.GET("/"+guid).
+		WithHeader("X-API-Key", apiKey).
+		Expect().
+		Status(200).
+		Body().Raw()
+
+	data := refresh.Request{}
+	require.NoError(t, json.Unmarshal([]byte(getResponse), &data))
+
+	successor := httpExpect.POST("/").
+		WithJSON(data).
+		Expect().
+		Status(200).
+		JSON().Object()
+
+	retried := httpExpect.POST("/").
+		WithJSON(data).
+		Expect().
+		Status(200).
+		JSON().Object()
+
+	retried.Value("refresh_token").Equal(successor.Value("refresh_token").Raw())
+
+	next := httpExpect.POST("/").
+		WithJSON(refresh.Request{
+			AccessToken:  retried.Value("access_token").String().Raw(),
+			RefreshToken: retried.Value("refresh_token").String().Raw(),
+		}).
+		Expect().
+		Status(200).
+		JSON().Object()
+
+	// The successor is used, so a retry now is reuse and revokes the family.
+	httpExpect.POST("/").
+		WithJSON(data).
+		Expect().
+		Status(401)
+
+	httpExpect.GET("/sessions").
+		WithHeader("Authorization", "Bearer "+next.Value("access_token").String().Raw()).
+		Expect().
+		Status(200).
+		JSON().Object().
+		Value("sessions").Array().
+		Empty()
+}
+
+// TestIPWarningEmail refreshes from a new IP and finds the warning in the
+// inbox of the development mailer.
+func TestIPWarningEmail(t *testing.T) {
+	if host != "" {
+		t.Skip("The user directory and the client IP are only controlled in-process")
+	}
+
+	serverHost, storage := startServer(t)
+	userGUID := uuid.New()
+
+	users, ok := storage.(interface {
+		SaveUser(ctx context.Context, user database.User) error
+	})
+	require.True(t, ok)
+	passwordHash, err := password.Hash("secret", password.DefaultParams)
+	require.NoError(t, err)
+	require.NoError(t, users.SaveUser(context.Background(), database.User{
+		GUID:           userGUID,
+		Email:          "warned@mail",
+		EmailVerified:  true,
+		Locale:         "en",
+		NotifyIPChange: true,
+		Username:       "warned",
+		PasswordHash:   passwordHash,
+	}))
+
+	httpExpect := httpexpect.New(t, "http://"+serverHost)
+
+	getResponse := httpExpect.GET("/"+userGUID.String()).
+		WithBasicAuth("warned", "secret").
+		WithHeader("X-Forwarded-For", "192.168.0.1").
+		Expect().
+		Status(200).
+		Body().Raw()
+
+	data := refresh.Request{}
+	json.Unmarshal([]byte(getResponse), &data)
+
+	httpExpect.POST("/").
+		WithHeader("X-Forwarded-For", "192.168.0.2").
+		WithJSON(data).
+		Expect().
+		Status(200)
+
+	// The warning is delivered by the outbox dispatcher in the background.
+	require.Eventually(t, func() bool {
+		return httpExpect.GET("/dev/mail/api/messages").
+			WithQuery("to", "warned@mail").
+			Expect().
+			Status(200).
+			JSON().Object().
+			Value("messages").Array().
+			Length().Raw() == 1
+	}, 5*time.Second, 50*time.Millisecond)
+
+	message := httpExpect.GET("/dev/mail/api/messages").
+		WithQuery("to", "warned@mail").
+		Expect().
+		JSON().Object().
+		Value("messages").Array().
+		First().Object()
+
+	message.Value("subject").String().Contains("192.168.0.2")
+	message.Value("text").String().Contains("192.168.0.1")
+	message.Value("html").String().Contains("/revoke/link?token=")
+
+	httpExpect.DELETE("/dev/mail/api/messages").
+		Expect().
+		Status(200)
+
+	httpExpect.GET("/dev/mail/api/messages").
+		Expect().
+		Status(200).
+		JSON().Object().
+		Value("messages").Array().
+		Empty()
+}
+
+// TestAccounts registers a user, who logs in once the email is verified
+// with the link of the verification email, changes the password and
+// disables the user.
+func TestAccounts(t *testing.T) {
+	if host != "" {
+		t.Skip("The inbox and the admin client are only controlled in-process")
+	}
+
+	serverHost, _ := startServer(t)
+	httpExpect := httpexpect.New(t, "http://"+serverHost)
+
+	userGUID := httpExpect.POST("/users").
+		WithJSON(map[string]string{"email": "new@mail", "password": "first secret"}).
+		Expect().
+		Status(201).
+		JSON().Object().
+		Value("user_guid").String().Raw()
+
+	httpExpect.POST("/users").
+		WithJSON(map[string]string{"email": "NEW@mail", "password": "other secret"}).
+		Expect().
+		Status(409)
+
+	httpExpect.GET("/"+userGUID).
+		WithBasicAuth("new@mail", "first secret").
+		Expect().
+		Status(403).
+		JSON().Object().
+		ValueEqual("code", "email_unverified")
+
+	require.Eventually(t, func() bool {
+		return httpExpect.GET("/dev/mail/api/messages").
+			WithQuery("to", "new@mail").
+			Expect().
+			JSON().Object().
+			Value("messages").Array().
+			Length().Raw() == 1
+	}, 5*time.Second, 50*time.Millisecond)
+
+	text := httpExpect.GET("/dev/mail/api/messages").
+		WithQuery("to", "new@mail").
+		Expect().
+		JSON().Object().
+		Value("messages").Array().
+		First().Object().
+		Value("text").String().Raw()
+
+	match := regexp.MustCompile(`/users/verify\?token=(\S+)`).FindStringSubmatch(text)
+	require.NotNil(t, match, text)
+
+	httpExpect.POST("/users/verify").
+		WithFormField("token", match[1]).
+		Expect().
+		Status(200)
+
+	httpExpect.POST("/users/verify").
+		WithFormField("token", match[1]).
+		Expect().
+		Status(400)
+
+	getResponse := httpExpect.GET("/"+userGUID).
+		WithBasicAuth("new@mail", "first secret").
+		Expect().
+		Status(200).
+		Body().Raw()
+
+	data := refresh.Request{}
+	json.Unmarshal([]byte(getResponse), &data)
+
+	httpExpect.POST("/users/password").
+		WithHeader("Authorization", "Bearer "+data.AccessToken).
+		WithJSON(map[string]string{"current_password": "first secret", "new_password": "second secret"}).
+		Expect().
+		Status(200)
+
+	httpExpect.POST("/").
+		WithJSON(data).
+		Expect().
+		Status(401)
+
+	httpExpect.GET("/"+userGUID).
+		WithBasicAuth("new@mail", "first secret").
+		Expect().
+		Status(401)
+
+	httpExpect.GET("/"+userGUID).
+		WithBasicAuth("new@mail", "second secret").
+		Expect().
+		Status(200)
+
+	httpExpect.POST("/users/"+userGUID+"/disable").
+		WithBasicAuth("support", adminSecret).
+		Expect().
+		Status(200)
+
+	httpExpect.GET("/"+userGUID).
+		WithBasicAuth("new@mail", "second secret").
+		Expect().
+		Status(403).
+		JSON().Object().
+		ValueEqual("code", "user_disabled")
+
+	httpExpect.POST("/users/"+userGUID+"/enable").
+		WithBasicAuth("support", adminSecret).
+		Expect().
+		Status(200)
+
+	httpExpect.GET("/"+userGUID).
+		WithBasicAuth("new@mail", "second secret").
+		Expect().
+		Status(200)
+}
+
+// startServer starts the service in-process, the options change its config.
+func startServer(t *testing.T, options ...func(cfg *config.Config)) (string, app.Storage) {
+	cfg := &config.Config{
+		Env:    "Development",
+		Server: config.Server{PublicURL: "http://auth.test"},
+		// The test client is a trusted proxy, so tests can refresh from
+		// another IP by setting X-Forwarded-For.
+		HTTP: config.HTTP{
+			TrustedProxies: []string{"127.0.0.1", "::1"},
+			ClientIPHeader: "X-Forwarded-For",
+		},
+		Database: config.Database{Driver: app.DriverMemory},
+		Authentication: config.Authentication{
+			Methods: []string{config.AuthPassword, config.AuthAPIKey},
+			APIKeys: []config.APIKey{{Name: "test", Key: apiKey, UserGUID: guid}},
+		},
+		JWT: config.JWT{
+			SecretKey:          "verysecretkey",
+			AccessExpires:      300 * time.Second,
+			RefreshExpires:     3600 * time.Second,
+			RefreshTokenPepper: "verysecretpepper",
+		},
+		Email: config.Email{
+			LinkSecret: "verysecretlinksecret",
+			Outbox:     config.Outbox{Interval: 50 * time.Millisecond},
+		},
+		Accounts: config.Accounts{
+			MinPasswordLength: config.MinPasswordLength,
+			PasswordHashing: config.PasswordHashing{
+				Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32,
+			},
+			AdminClients: []config.Client{{ID: "support", Secret: adminSecret}},
+		},
+	}
+
+	for _, option := range options {
+		option(cfg)
+	}
+
+	log := sl.NewDiscardLogger()
+
+	storage, err := app.NewStorage(cfg.Database)
+	require.NoError(t, err)
+
+	keys, err := tokens.LoadKeySet(cfg.JWT)
+	require.NoError(t, err)
+
+	mailer := mockmail.New(cfg.Email, log)
+
+	jobs, err := app.NewScheduler(log, cfg, storage, mailer)
+	require.NoError(t, err)
+	jobs.Start()
+
+	router, err := app.NewRouter(log, cfg, storage, keys, mailer)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(router)
+	t.Cleanup(func() {
+		server.Close()
+		jobs.Stop()
+		storage.Close()
+	})
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	return serverURL.Host, storage
 }